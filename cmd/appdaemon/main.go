@@ -222,6 +222,8 @@ func main() {
 	output, _ = exec.Command("/bin/ip", "a", "s").Output()
 	fmt.Println(string(output))
 
+	cleanupNAT()
+
 	fmt.Printf("Exiting appdaemon %v\n", exitCode)
 	os.Exit(exitCode)
 }
@@ -495,10 +497,6 @@ func startPassing(recvLinkName string, sendLinkName string, recvIsDevice bool) {
 		if ethernetPacket.DstMAC.String() == ovsInfo.OvsExtHWAddr || ethernetPacket.SrcMAC.String() == ovsInfo.OvsExtHWAddr {
 			continue
 		}
-		ipv6Layer := packet.Layer(layers.LayerTypeIPv6)
-		if ipv6Layer != nil {
-			continue
-		}
 		udpLayer := packet.Layer(layers.LayerTypeUDP)
 		if udpLayer != nil {
 			udpPacket, _ := udpLayer.(*layers.UDP)
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/naoki9911/CREBAS/pkg/nat"
+	"github.com/vishvananda/netlink"
+)
+
+var natTable *nat.NAT
+
+// configureNAT masquerades the network of childLink to dgwLink.
+// The table is set up on the first call replacing the one left by previous run.
+func configureNAT(dgwLink netlink.Link, childLink netlink.Link) error {
+	childAddrs, err := netlink.AddrList(childLink, netlink.FAMILY_V4)
+	if err != nil {
+		return err
+	}
+
+	if len(childAddrs) != 1 {
+		return fmt.Errorf("invalid childLink %v", len(childAddrs))
+	}
+
+	if natTable == nil {
+		table := nat.NewNAT(nil)
+		err = table.Setup()
+		if err != nil {
+			return err
+		}
+		natTable = table
+	}
+
+	childAddr := childAddrs[0]
+	err = natTable.Add(&nat.Forwarding{
+		InboundLink:  childLink.Attrs().Name,
+		Source:       childAddr.IPNet,
+		OutboundLink: dgwLink.Attrs().Name,
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("info: Successfully configured NAT")
+
+	return nil
+}
+
+// cleanupNAT deletes the table configured by configureNAT
+func cleanupNAT() {
+	if natTable == nil {
+		return
+	}
+
+	err := natTable.Close()
+	if err != nil {
+		log.Printf("error: Failed to clean up NAT %v", err)
+		return
+	}
+	natTable = nil
+	log.Printf("info: Successfully cleaned up NAT")
+}
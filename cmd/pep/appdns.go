@@ -5,34 +5,63 @@ import (
 	"log"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
-	"github.com/naoki9911/CREBAS/pkg/app"
 	"github.com/naoki9911/CREBAS/pkg/capability"
 	"github.com/naoki9911/CREBAS/pkg/netlinkext"
 	"github.com/naoki9911/CREBAS/pkg/ofswitch"
 )
 
 func getQueryResultFromServer(r *dns.Msg) (*dns.Msg, error) {
-	dnsClient := new(dns.Client)
-	dnsClient.Net = "udp"
-	response, _, err := dnsClient.Exchange(r, dnsServer)
-	if err != nil {
-		return nil, err
-	}
-
-	return response, nil
+	return dnsResolver.Exchange(r)
 }
 
 func isDomainAllowed(caps capability.CapabilitySlice, domain string) bool {
+	return getDomainCapability(caps, domain) != nil
+}
+
+// getDomainCapability returns the capability allowing domain
+func getDomainCapability(caps capability.CapabilitySlice, domain string) *capability.Capability {
 	for _, cap := range caps {
 		if cap.IsDomainAllowed(domain) {
-			return true
+			return cap
 		}
+	}
+
+	return nil
+}
 
+// getAnswerAddress returns the address and TTL of A or AAAA record
+func getAnswerAddress(rr dns.RR) (net.IP, uint32) {
+	switch record := rr.(type) {
+	case *dns.A:
+		return record.A, record.Hdr.Ttl
+	case *dns.AAAA:
+		return record.AAAA, record.Hdr.Ttl
 	}
+	return nil, 0
+}
 
-	return false
+// allowAnswers allows client link to reach the addresses answered for the domain allowed by cap
+// while the records live
+func allowAnswers(link *netlinkext.LinkExt, cap *capability.Capability, answers []dns.RR) {
+	for _, rr := range answers {
+		ip, ttl := getAnswerAddress(rr)
+		if ip == nil {
+			continue
+		}
+		// the link without IPv6 address can't reach AAAA answers
+		if ip.To4() == nil && link.Addr6 == nil {
+			continue
+		}
+		err := aclOfs.AddEgressFlow(link, ip, ttl, capabilityCookie(cap))
+		if err != nil {
+			log.Printf("error: Failed to add egress flow to %v for %v %v", ip, link.Addr.IP, err)
+			continue
+		}
+		log.Printf("info: %v is allowed to reach %v(%v) for %vs", link.Addr.IP, ip, rr.Header().Name, ttl)
+	}
 }
 
 func dnsHandler(w dns.ResponseWriter, r *dns.Msg) {
@@ -43,75 +72,215 @@ func dnsHandler(w dns.ResponseWriter, r *dns.Msg) {
 
 }
 
-func handleDnsQuery(w dns.ResponseWriter, r *dns.Msg) {
-	clientIP := net.ParseIP(strings.Split(w.RemoteAddr().String(), ":")[0])
+// Responses for the names denied by policy
+const (
+	DNSDenyNXDomain = "nxdomain"
+	DNSDenyRefused  = "refused"
+	DNSDenySinkhole = "sinkhole"
+)
+
+// newErrorResponse creates reply with rcode keeping EDNS0 of request
+func newErrorResponse(r *dns.Msg, rcode int) *dns.Msg {
+	response := &dns.Msg{}
+	response.SetRcode(r, rcode)
+	if opt := r.IsEdns0(); opt != nil {
+		response.SetEdns0(opt.UDPSize(), opt.Do())
+	}
+	return response
+}
 
-	log.Printf("info: Client is %v", clientIP.String())
+// newDeniedResponse creates reply for denied names configured by policy
+func newDeniedResponse(r *dns.Msg, policy string) *dns.Msg {
+	switch policy {
+	case DNSDenyRefused:
+		return newErrorResponse(r, dns.RcodeRefused)
+	case DNSDenySinkhole:
+		response := newErrorResponse(r, dns.RcodeSuccess)
+		for _, question := range r.Question {
+			header := dns.RR_Header{
+				Name:   question.Name,
+				Rrtype: question.Qtype,
+				Class:  dns.ClassINET,
+				Ttl:    pepConfig.dnsSinkholeTTL,
+			}
+			switch question.Qtype {
+			case dns.TypeA:
+				response.Answer = append(response.Answer, &dns.A{Hdr: header, A: net.ParseIP(pepConfig.dnsSinkholeAddr)})
+			case dns.TypeAAAA:
+				response.Answer = append(response.Answer, &dns.AAAA{Hdr: header, AAAA: net.ParseIP(pepConfig.dnsSinkholeAddr6)})
+			}
+		}
+		return response
+	}
 
-	selectedApps := apps.Where(func(a app.AppInterface) bool {
-		links := a.Links().Where(func(l *netlinkext.LinkExt) bool {
-			return l.Addr.IP.String() == clientIP.String()
-		})
+	return newErrorResponse(r, dns.RcodeNameError)
+}
 
-		return len(links) != 0
-	})
+func trimDomain(name string) string {
+	return strings.TrimSuffix(name, ".")
+}
 
-	if len(selectedApps) != 1 {
-		log.Printf("info: Client %v not found", clientIP.String())
-		return
+// filterAnswerChain returns the records of the chain from qname.
+// It returns the name denied by caps if the chain aliases into it.
+func filterAnswerChain(qname string, answers []dns.RR, caps capability.CapabilitySlice) ([]dns.RR, string) {
+	chain := map[string]bool{
+		strings.ToLower(qname): true,
+	}
+	filtered := []dns.RR{}
+
+	// CNAME records are ordered along the chain but some servers don't keep the order
+	for added := true; added; {
+		added = false
+		for _, rr := range answers {
+			cname, ok := rr.(*dns.CNAME)
+			if !ok || !chain[strings.ToLower(rr.Header().Name)] || chain[strings.ToLower(cname.Target)] {
+				continue
+			}
+			if getDomainCapability(caps, trimDomain(cname.Target)) == nil {
+				log.Printf("info: %v is an alias of denied %v", rr.Header().Name, cname.Target)
+				return nil, trimDomain(cname.Target)
+			}
+			chain[strings.ToLower(cname.Target)] = true
+			added = true
+		}
 	}
 
-	selectedApp := selectedApps[0]
-	caps := selectedApp.Capabilities().Where(func(c *capability.Capability) bool {
-		return c.CapabilityName == capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION
-	})
+	for _, rr := range answers {
+		if chain[strings.ToLower(rr.Header().Name)] {
+			filtered = append(filtered, rr)
+		}
+	}
 
-	var queryToServer []dns.Question
-	var RRs []dns.RR
-	for _, question := range r.Question {
+	return filtered, ""
+}
 
+// resolveQuery resolves query allowed by caps and returns the reply, the capability
+// allowing the answered addresses and the name denied by caps
+func resolveQuery(r *dns.Msg, caps capability.CapabilitySlice) (*dns.Msg, *capability.Capability, string) {
+	// the capability allowing A or AAAA query
+	var allowingCap *capability.Capability
+	for _, question := range r.Question {
 		// Checks only A or AAAA Record
 		if question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA {
-			queryToServer = append(queryToServer, question)
 			continue
 		}
-		domain := question.Name[:len(question.Name)-1]
-		if isDomainAllowed(caps, domain) {
-			queryToServer = append(queryToServer, question)
+		domain := trimDomain(question.Name)
+		cap := getDomainCapability(caps, domain)
+		if cap == nil {
+			log.Printf("info: query for %v is denied", domain)
+			return newDeniedResponse(r, pepConfig.dnsDenyResponse), nil, domain
 		}
+		allowingCap = cap
 	}
 
-	if len(queryToServer) != 0 {
-		r.Question = queryToServer
-		resFromServer, err := getQueryResultFromServer(r)
-		if err != nil {
-			log.Printf("error: Failed to lookup %v", err.Error())
+	response, err := getQueryResultFromServer(r)
+	if err != nil {
+		log.Printf("error: Failed to lookup %v", err.Error())
+		return newErrorResponse(r, dns.RcodeServerFailure), nil, ""
+	}
+	response.Id = r.Id
+
+	if allowingCap == nil {
+		return response, nil, ""
+	}
+	// answers on the chains of any question are kept in the order of the reply
+	answered := map[dns.RR]bool{}
+	for _, question := range r.Question {
+		if question.Qtype != dns.TypeA && question.Qtype != dns.TypeAAAA {
+			continue
+		}
+		answers, denied := filterAnswerChain(question.Name, response.Answer, caps)
+		if denied != "" {
+			return newDeniedResponse(r, pepConfig.dnsDenyResponse), nil, denied
+		}
+		for _, rr := range answers {
+			answered[rr] = true
 		}
-		RRs = append(RRs, resFromServer.Answer...)
 	}
+	answers := []dns.RR{}
+	for _, rr := range response.Answer {
+		if answered[rr] {
+			answers = append(answers, rr)
+		}
+	}
+	response.Answer = answers
 
-	response := &dns.Msg{}
-	response.SetReply(r)
-	response.Answer = RRs
+	return response, allowingCap, ""
+}
+
+func handleDnsQuery(w dns.ResponseWriter, r *dns.Msg) {
+	clientIP := getRemoteIP(w.RemoteAddr())
+	client := dnsClients.Lookup(clientIP)
+	if client == nil {
+		log.Printf("warning: query from unknown client %v is refused", w.RemoteAddr())
+		w.WriteMsg(newErrorResponse(r, dns.RcodeRefused))
+		return
+	}
+	log.Printf("info: Client is %v", clientIP)
+
+	// devices without app have no capabilities
+	caps := capability.CapabilitySlice{}
+	if client.App != nil {
+		caps = client.App.Capabilities().Where(func(c *capability.Capability) bool {
+			return c.CapabilityName == capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION
+		})
+	}
+
+	response, allowingCap, denied := resolveQuery(r, caps)
+	if client.App != nil {
+		for _, query := range newDNSQueries(r, response, allowingCap, time.Now()) {
+			dnsQueries.Add(client.App.ID(), query)
+		}
+		if denied != "" {
+			handleDeniedQuery(client, denied)
+		}
+	}
+
+	// answered addresses are reachable only through the flows
+	if allowingCap != nil && client.Link != nil {
+		allowAnswers(client.Link, allowingCap, response.Answer)
+	}
+	// the proxy accepts server names only for the addresses answered for them
+	if allowingCap != nil && client.App != nil {
+		dnsAnswers.Add(client.App.ID(), response.Answer, time.Now())
+	}
 
 	for _, res := range response.Answer {
 		fmt.Println(res)
 	}
 
+	// upstream responses may exceed the buffer of client over UDP
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if opt := r.IsEdns0(); opt != nil {
+			size = int(opt.UDPSize())
+		}
+		response.Truncate(size)
+	}
+
 	w.WriteMsg(response)
 }
 
-func startDNSServer(s *ofswitch.OFSwitch) {
-
-	interfaceIP := s.Link.Addr.IP.String()
-	host := interfaceIP + ":53"
+func serveDNS(host string, network string) {
+	server := &dns.Server{Addr: host, Net: network}
+	log.Printf("info: Starting at %s %s\n", network, host)
+	err := server.ListenAndServe()
+	if err != nil {
+		log.Printf("error: Failed to start %s server: %s\n ", network, err.Error())
+	}
+}
 
+func startDNSServer(s *ofswitch.OFSwitch) {
 	dns.HandleFunc(".", dnsHandler)
 
-	server := &dns.Server{Addr: host, Net: "udp"}
-	log.Printf("info: Starting at %s\n", host)
-	err := server.ListenAndServe()
-	if err != nil {
-		log.Printf("error: Failed to start server: %s\n ", err.Error())
+	hosts := []string{net.JoinHostPort(s.Link.Addr.IP.String(), "53")}
+	if s.Link.Addr6 != nil {
+		hosts = append(hosts, net.JoinHostPort(s.Link.Addr6.IP.String(), "53"))
+	}
+
+	// responses not fitting in UDP are truncated and retried by clients over TCP
+	for _, host := range hosts {
+		go serveDNS(host, "udp")
+		go serveDNS(host, "tcp")
 	}
 }
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/resolver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetAnswerAddress(t *testing.T) {
+	rr, err := dns.NewRR("example.com. 300 IN A 93.184.216.34")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	ip, ttl := getAnswerAddress(rr)
+	assert.True(t, ip.Equal(net.ParseIP("93.184.216.34")))
+	assert.Equal(t, uint32(300), ttl)
+
+	rr, err = dns.NewRR("example.com. 60 IN AAAA 2606:2800:220:1::1")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	ip, ttl = getAnswerAddress(rr)
+	assert.True(t, ip.Equal(net.ParseIP("2606:2800:220:1::1")))
+	assert.Equal(t, uint32(60), ttl)
+
+	rr, err = dns.NewRR("www.example.com. 60 IN CNAME example.com.")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	ip, _ = getAnswerAddress(rr)
+	assert.Nil(t, ip)
+}
+
+func TestGetDomainCapability(t *testing.T) {
+	cap := capability.NewCreateSkeltonCapability()
+	cap.CapabilityName = capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION
+	cap.CapabilityValue = "*.example.com"
+	caps := capability.CapabilitySlice{cap}
+
+	assert.Equal(t, cap, getDomainCapability(caps, "www.example.com"))
+	assert.Nil(t, getDomainCapability(caps, "www.example.org"))
+	assert.False(t, isDomainAllowed(caps, "www.example.org"))
+}
+
+func startTestDNSServer(t *testing.T, records map[string][]string) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		response := &dns.Msg{}
+		response.SetReply(r)
+		for _, record := range records[r.Question[0].Name] {
+			rr, err := dns.NewRR(record)
+			if err != nil {
+				t.Fatalf("Failed %v", err)
+			}
+			response.Answer = append(response.Answer, rr)
+		}
+		if opt := r.IsEdns0(); opt != nil {
+			response.SetEdns0(opt.UDPSize(), opt.Do())
+		}
+		w.WriteMsg(response)
+	})
+	// accepts queries with multiple questions
+	acceptAll := func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }
+	server := &dns.Server{PacketConn: conn, Handler: handler, MsgAcceptFunc: acceptAll}
+	go server.ActivateAndServe()
+
+	return conn.LocalAddr().String(), func() { server.Shutdown() }
+}
+
+func TestResolveQuery(t *testing.T) {
+	addr, shutdown := startTestDNSServer(t, map[string][]string{
+		"www.example.com.": {
+			"www.example.com. 300 IN CNAME cdn.example.com.",
+			"cdn.example.com. 300 IN A 93.184.216.34",
+			"other.example.net. 300 IN A 192.0.2.1",
+		},
+		"api.example.com.": {
+			"api.example.com. 300 IN A 93.184.216.35",
+			"www.example.com. 300 IN CNAME cdn.example.com.",
+			"cdn.example.com. 300 IN A 93.184.216.34",
+			"other.example.net. 300 IN A 192.0.2.1",
+		},
+		"alias.example.com.": {
+			"alias.example.com. 300 IN CNAME tracker.example.org.",
+			"tracker.example.org. 300 IN A 192.0.2.2",
+		},
+	})
+	defer shutdown()
+	savedResolver := dnsResolver
+	dnsResolver, _ = resolver.NewResolverFromURLs([]string{addr}, nil, time.Second)
+	defer func() { dnsResolver = savedResolver }()
+
+	cap := capability.NewCreateSkeltonCapability()
+	cap.CapabilityName = capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION
+	cap.CapabilityValue = "*.example.com"
+	caps := capability.CapabilitySlice{cap}
+
+	query := &dns.Msg{}
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	query.SetEdns0(4096, false)
+	response, allowingCap, denied := resolveQuery(query, caps)
+	assert.Equal(t, cap, allowingCap)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+	assert.Equal(t, query.Id, response.Id)
+	// the record out of the chain is removed
+	assert.Equal(t, 2, len(response.Answer))
+	assert.NotNil(t, response.IsEdns0())
+	assert.Equal(t, "", denied)
+
+	// answers for all questions are kept
+	query.SetQuestion("api.example.com.", dns.TypeA)
+	query.Question = append(query.Question, dns.Question{Name: "www.example.com.", Qtype: dns.TypeA, Qclass: dns.ClassINET})
+	response, allowingCap, denied = resolveQuery(query, caps)
+	assert.Equal(t, cap, allowingCap)
+	assert.Equal(t, 3, len(response.Answer))
+	assert.Equal(t, "", denied)
+
+	// allowed name aliases into denied one
+	query.SetQuestion("alias.example.com.", dns.TypeA)
+	response, allowingCap, denied = resolveQuery(query, caps)
+	assert.Nil(t, allowingCap)
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+	assert.Equal(t, 0, len(response.Answer))
+	assert.Equal(t, "tracker.example.org", denied)
+
+	query.SetQuestion("www.example.org.", dns.TypeA)
+	response, allowingCap, denied = resolveQuery(query, caps)
+	assert.Nil(t, allowingCap)
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+	assert.NotNil(t, response.IsEdns0())
+	assert.Equal(t, "www.example.org", denied)
+
+	// upstream is not reachable
+	shutdown()
+	dnsResolver, _ = resolver.NewResolverFromURLs([]string{"127.0.0.1:1"}, nil, time.Second)
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	response, _, _ = resolveQuery(query, caps)
+	assert.Equal(t, dns.RcodeServerFailure, response.Rcode)
+}
+
+func TestNewDeniedResponse(t *testing.T) {
+	query := &dns.Msg{}
+	query.SetQuestion("www.example.org.", dns.TypeA)
+
+	response := newDeniedResponse(query, DNSDenyRefused)
+	assert.Equal(t, dns.RcodeRefused, response.Rcode)
+
+	response = newDeniedResponse(query, DNSDenySinkhole)
+	assert.Equal(t, dns.RcodeSuccess, response.Rcode)
+	assert.Equal(t, 1, len(response.Answer))
+	ip, _ := getAnswerAddress(response.Answer[0])
+	assert.True(t, ip.Equal(net.IPv4zero))
+
+	response = newDeniedResponse(query, DNSDenyNXDomain)
+	assert.Equal(t, dns.RcodeNameError, response.Rcode)
+}
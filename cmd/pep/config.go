@@ -1,7 +1,10 @@
 package main
 
 import (
+	"time"
+
 	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/naoki9911/CREBAS/pkg/resolver"
 )
 
 type Config struct {
@@ -10,15 +13,128 @@ type Config struct {
 	extOfsName    string
 	extOfsAddr    string
 	extOfsAppAddr string
-	wifiLink      *netlinkext.LinkExt
+	// IPv6 addresses of the external switch and the apps
+	extOfsAddr6    string
+	extOfsAppAddr6 string
+	wifiLink       *netlinkext.LinkExt
+	statsInterval  time.Duration
+	statsHistory   int
+	// number of spoofing alerts kept in memory
+	spoofingAlerts int
+	// TCP ports of the PEP and CP APIs apps reach on the ACL switch
+	servicePorts []uint16
+	// rate limit(kbps) of packets denied by policy and sent to controller
+	puntRate uint32
+	// rate limit(kbps) of mDNS and SSDP sent to controller to be relayed between apps
+	discoveryRate uint32
+	// number of denied accesses kept in memory
+	deniedHistory int
+	// number of denied packets waiting for analysis, more are dropped
+	deniedQueueSize int
+	// request missing capabilities to CP on behalf of apps
+	autoCapabilityRequest bool
+	// control socket of hostapd serving the Wi-Fi link
+	hostapdCtrlPath string
+	// wpa_psk_file of hostapd holding a passphrase per device
+	wpaPSKFile string
+	// RADIUS server authenticating stations by EAP-TLS for hostapd
+	radiusAddr        string
+	radiusSecret      string
+	eapServerCertPath string
+	eapServerKeyPath  string
+	eapCACertPath     string
+	// duration keeping devices of disconnected stations before teardown
+	stationTeardownDelay time.Duration
+	// file persisting devices registered through API
+	deviceRegistryPath string
+	// file persisting identities of EAP-TLS devices registered through API
+	identityRegistryPath string
+	// directory holding packages bound to devices
+	pkgDir string
+	// response for the names denied by policy, nxdomain, refused or sinkhole
+	dnsDenyResponse string
+	// addresses and TTL answered for denied names by sinkhole
+	dnsSinkholeAddr  string
+	dnsSinkholeAddr6 string
+	dnsSinkholeTTL   uint32
+	// upstreams tried in order, host:port, udp://, tcp://, tls:// or https:// URL
+	dnsUpstreams       []string
+	dnsUpstreamTimeout time.Duration
+	// number of responses cached by the DNS proxy
+	dnsCacheSize int
+	// number of DNS queries kept in memory per app
+	dnsQueryHistory int
+	// file persisting rules mapping DHCP fingerprints to vendor and package
+	fingerprintRulesPath string
+	// file persisting DHCP leases and reservations
+	leaseDBPath string
+	// duration of DHCP leases and interval releasing expired ones
+	dhcpLeaseTime       time.Duration
+	leaseExpiryInterval time.Duration
+	// interval of Router Advertisements announcing the default route and DHCPv6
+	routerAdvInterval time.Duration
+	// routes, domain search list and NTP servers served to every device and app
+	networkConfig *NetworkConfig
+	// file persisting network configs of devices and apps
+	networkConfigPath string
+	// steer HTTP and TLS of apps to the transparent proxy checking Host and SNI
+	egressProxy bool
+	// ports steered to the proxy and the port it listens on
+	egressProxyPorts []uint16
+	egressProxyPort  uint16
+	// timeout reading Host or SNI and connecting to the destination
+	egressProxyTimeout time.Duration
+	// number of proxied connections kept in memory per app
+	egressProxyHistory int
 }
 
 func NewConfig() *Config {
 	return &Config{
-		aclOfsName:    "crebas-acl-ofs",
-		aclOfsAddr:    "192.168.10.1/24",
-		extOfsName:    "crebas-ext-ofs",
-		extOfsAddr:    "192.168.20.254/24",
-		extOfsAppAddr: "192.168.20.1/24",
+		aclOfsName:           "crebas-acl-ofs",
+		aclOfsAddr:           "192.168.10.1/24",
+		extOfsName:           "crebas-ext-ofs",
+		extOfsAddr:           "192.168.20.254/24",
+		extOfsAppAddr:        "192.168.20.1/24",
+		extOfsAddr6:          "fd00:20::fe/64",
+		extOfsAppAddr6:       "fd00:20::1/64",
+		statsInterval:        5 * time.Second,
+		statsHistory:         120,
+		spoofingAlerts:       100,
+		servicePorts:         []uint16{8080, 8081},
+		puntRate:             64,
+		discoveryRate:        256,
+		deniedHistory:        100,
+		deniedQueueSize:      64,
+		hostapdCtrlPath:      "/var/run/hostapd/wlp4s0",
+		wpaPSKFile:           "/etc/hostapd/hostapd.wpa_psk",
+		radiusAddr:           "127.0.0.1:1812",
+		radiusSecret:         "crebas-radius",
+		eapServerCertPath:    "/home/naoki/CREBAS/test/keys/pep/test-pep.crt",
+		eapServerKeyPath:     "/home/naoki/CREBAS/test/keys/pep/test-pep.key",
+		eapCACertPath:        "/home/naoki/CREBAS/test/keys/ca/test-ca.crt",
+		stationTeardownDelay: 5 * time.Minute,
+		deviceRegistryPath:   "/var/lib/crebas/devices.json",
+		identityRegistryPath: "/var/lib/crebas/identities.json",
+		pkgDir:               "/home/naoki/CREBAS/pkgs",
+		dnsDenyResponse:      DNSDenyNXDomain,
+		dnsSinkholeAddr:      "0.0.0.0",
+		dnsSinkholeAddr6:     "::",
+		dnsSinkholeTTL:       60,
+		dnsUpstreams:         []string{"tls://1.1.1.1:853", "8.8.8.8:53"},
+		dnsUpstreamTimeout:   resolver.DefaultTimeout,
+		dnsCacheSize:         1024,
+		dnsQueryHistory:      100,
+		fingerprintRulesPath: "/var/lib/crebas/fingerprint_rules.json",
+		leaseDBPath:          "/var/lib/crebas/leases.json",
+		dhcpLeaseTime:        60 * time.Second,
+		leaseExpiryInterval:  10 * time.Second,
+		routerAdvInterval:    30 * time.Second,
+		networkConfig:        &NetworkConfig{},
+		networkConfigPath:    "/var/lib/crebas/network_configs.json",
+		egressProxy:          false,
+		egressProxyPorts:     []uint16{80, 443},
+		egressProxyPort:      3129,
+		egressProxyTimeout:   10 * time.Second,
+		egressProxyHistory:   100,
 	}
 }
@@ -4,7 +4,9 @@ import (
 	"crypto/rsa"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -72,27 +74,47 @@ func startAppFromPkg(c *gin.Context) {
 		return
 	}
 
-	err = aclOfs.AddHostRestrictedFlow(link)
+	err = aclOfs.AddEgressBaseFlow(link)
+	if err != nil {
+		log.Printf("error: Failed to add flow %v", err)
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	err = addEgressProxyFlow(link)
 	if err != nil {
 		log.Printf("error: Failed to add flow %v", err)
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
 
+	err = applyAppNetworkConfig(proc)
+	if err != nil {
+		log.Printf("error: Failed to apply network config %v", err)
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
 	extAddr, err := netlink.ParseAddr("192.168.20.1/24")
 	if err != nil {
 		log.Printf("error: Failed to parse %v", err)
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
-	_, err = proc.AddLinkWithAddr(extOfs, netlinkext.ExternalOFSwitch, extAddr)
+	extLink, err := proc.AddLinkWithAddr(extOfs, netlinkext.ExternalOFSwitch, extAddr)
 	if err != nil {
 		log.Printf("error: Failed to parse %v", err)
 		c.JSON(http.StatusInternalServerError, err)
 		return
 	}
+	err = extOfs.AddLinkAdmissionFlow(extLink)
+	if err != nil {
+		log.Printf("error: Failed to add flow %v", err)
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
 
 	apps.Add(proc)
+	dnsClients.AddApp(proc)
 
 	c.JSON(http.StatusOK, proc.GetAppInfo())
 }
@@ -124,6 +146,16 @@ func stopApp(c *gin.Context) {
 		return
 	}
 
+	for _, cap := range app.Capabilities().GetAll() {
+		err = revokeCapability(cap)
+		if err != nil {
+			log.Printf("error: Failed to revoke cap(%v) %v", cap.CapabilityID, err)
+		}
+	}
+	deleteLinkAdmissionFlows(app)
+
+	dnsClients.RemoveApp(app)
+	dnsAnswers.RemoveApp(app.ID())
 	err = apps.Remove(app)
 	if err != nil {
 		log.Printf("error: Failed to remove app(%v) %v", appID, err)
@@ -263,6 +295,52 @@ func postAppCap(c *gin.Context) {
 	c.JSON(http.StatusOK, nil)
 }
 
+func deleteAppCap(c *gin.Context) {
+	id := c.Param("id")
+	appID, err := uuid.Parse(id)
+	if err != nil {
+		log.Printf("error: invalid id %v", id)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+	capIDStr := c.Param("capID")
+	capID, err := uuid.Parse(capIDStr)
+	if err != nil {
+		log.Printf("error: invalid id %v", capIDStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	app := getAppFromID(appID)
+	if app == nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+
+	cap := app.Capabilities().GetByID(capID)
+	if cap == nil {
+		log.Printf("error: cap %v not found", capID)
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+
+	err = revokeCapability(cap)
+	if err != nil {
+		log.Printf("error: Failed to revoke cap(%v) %v", capID, err)
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	err = app.Capabilities().Remove(cap)
+	if err != nil {
+		log.Printf("error: Failed to remove cap(%v) %v", capID, err)
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, capID)
+}
+
 func getOvsInfo(c *gin.Context) {
 	ovsInfo := ofswitch.OvsInfo{
 		OvsACLHWAddr: aclOfs.Link.GetHWAddress().String(),
@@ -272,6 +350,614 @@ func getOvsInfo(c *gin.Context) {
 	c.JSON(http.StatusOK, ovsInfo)
 }
 
+func getAllMeters(c *gin.Context) {
+	meters := []*ofswitch.Meter{}
+	for _, ofs := range []*ofswitch.OFSwitch{extOfs, aclOfs} {
+		meters = append(meters, ofs.GetMeters()...)
+	}
+	c.JSON(http.StatusOK, meters)
+}
+
+// deleteMeter deletes meter of id on the switch named by query, the external switch by default.
+// The meters of the same capability on both switches are deleted together.
+func deleteMeter(c *gin.Context) {
+	id := c.Param("id")
+	meterID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		log.Printf("error: invalid id %v", id)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	ofs := extOfs
+	if name := c.Query("switch"); name != "" && name != extOfs.Name {
+		if name != aclOfs.Name {
+			log.Printf("error: invalid switch %v", name)
+			c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid switch %v", name))
+			return
+		}
+		ofs = aclOfs
+	}
+	meter := ofs.GetMeter(uint32(meterID))
+	if meter == nil {
+		log.Printf("error: meter(%v) of switch(%v) not found", meterID, ofs.Name)
+		c.JSON(http.StatusNotFound, fmt.Sprintf("meter %v not found", meterID))
+		return
+	}
+
+	for _, s := range []*ofswitch.OFSwitch{extOfs, aclOfs} {
+		err = s.DeleteMeterByCookie(meter.Cookie)
+		if err != nil {
+			log.Printf("error: Failed to delete meter(%v) of switch(%v) %v", meterID, s.Name, err)
+			c.JSON(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, meterID)
+}
+
+func getAllGroups(c *gin.Context) {
+	c.JSON(http.StatusOK, extOfs.GetGroups())
+}
+
+func getSpoofingCounters(c *gin.Context) {
+	c.JSON(http.StatusOK, spoofing.GetCounters())
+}
+
+func getSpoofingAlerts(c *gin.Context) {
+	c.JSON(http.StatusOK, spoofing.GetAlerts())
+}
+
+// getAppDNSQueries returns the queries of app and the capabilities allowing them
+func getAppDNSQueries(c *gin.Context) {
+	id := c.Param("id")
+	appID, err := uuid.Parse(id)
+	if err != nil {
+		log.Printf("error: invalid id %v", id)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	queries := dnsQueries.Get(appID)
+	if queries == nil {
+		if getAppFromID(appID) == nil {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+		queries = []*DNSQuery{}
+	}
+	c.JSON(http.StatusOK, queries)
+}
+
+func getAppProxyConnections(c *gin.Context) {
+	id := c.Param("id")
+	appID, err := uuid.Parse(id)
+	if err != nil {
+		log.Printf("error: invalid id %v", id)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	connections := proxyConnections.Get(appID)
+	if connections == nil {
+		if getAppFromID(appID) == nil {
+			c.JSON(http.StatusNotFound, nil)
+			return
+		}
+		connections = []*ProxyConnection{}
+	}
+	c.JSON(http.StatusOK, connections)
+}
+
+func getDeniedAccesses(c *gin.Context) {
+	c.JSON(http.StatusOK, deniedAccesses.GetAll())
+}
+
+func getAllPorts(c *gin.Context) {
+	ports := append(aclOfs.GetPortStatuses(), extOfs.GetPortStatuses()...)
+	c.JSON(http.StatusOK, ports)
+}
+
+func getAllDevices(c *gin.Context) {
+	infos := []*DeviceInfo{}
+	for _, device := range devices.GetAll() {
+		infos = append(infos, getDeviceInfo(device))
+	}
+	c.JSON(http.StatusOK, infos)
+}
+
+// postDevice registers device binding its hwaddr to package
+func postDevice(c *gin.Context) {
+	var req DeviceRecord
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hwAddr, err := net.ParseMAC(req.HWAddress)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", req.HWAddress)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+	req.HWAddress = hwAddr.String()
+	if registry.Get(hwAddr) != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "device is already registered"})
+		return
+	}
+
+	device, err := applyDeviceRecord(&req)
+	if err != nil {
+		log.Printf("error: Failed to register device %v %v", hwAddr, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = registry.Put(&req)
+	if err != nil {
+		log.Printf("error: Failed to save device %v %v", hwAddr, err)
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	// the station joins Wi-Fi by its own passphrase
+	psk, err := psks.IssueMissing(device)
+	if err != nil {
+		log.Printf("error: Failed to issue psk for %v %v", hwAddr, err)
+	} else if psk != nil {
+		log.Printf("info: issued psk %v to device %v", psk.KeyID, hwAddr)
+		err = reloadDevicePSK(hwAddr)
+		if err != nil {
+			log.Printf("error: Failed to reload psk of hostapd %v", err)
+		}
+	}
+
+	c.JSON(http.StatusOK, getDeviceInfo(device))
+}
+
+func patchDevice(c *gin.Context) {
+	hwAddrStr := c.Param("hwaddr")
+	hwAddr, err := net.ParseMAC(hwAddrStr)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", hwAddrStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	var req DeviceUpdate
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record := registry.Get(hwAddr)
+	if record == nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	if req.Name != nil {
+		record.Name = *req.Name
+	}
+	if req.PkgID != nil {
+		record.PkgID = *req.PkgID
+	}
+
+	device, err := applyDeviceRecord(record)
+	if err != nil {
+		log.Printf("error: Failed to update device %v %v", hwAddr, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = registry.Put(record)
+	if err != nil {
+		log.Printf("error: Failed to save device %v %v", hwAddr, err)
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, getDeviceInfo(device))
+}
+
+func deleteDevice(c *gin.Context) {
+	hwAddrStr := c.Param("hwaddr")
+	hwAddr, err := net.ParseMAC(hwAddrStr)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", hwAddrStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	device := getDeviceByHWAddr(hwAddr)
+	if device == nil && registry.Get(hwAddr) == nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	if registry.Get(hwAddr) != nil {
+		err = registry.Remove(hwAddr)
+		if err != nil {
+			log.Printf("error: Failed to save device %v %v", hwAddr, err)
+			c.JSON(http.StatusInternalServerError, err)
+			return
+		}
+	}
+	if device != nil {
+		removeDevice(device)
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func getAllFingerprints(c *gin.Context) {
+	fingerprints := map[string]*app.Fingerprint{}
+	for _, device := range devices.GetAll() {
+		if device.Fingerprint != nil {
+			fingerprints[device.HWAddress.String()] = device.Fingerprint
+		}
+	}
+	c.JSON(http.StatusOK, fingerprints)
+}
+
+func getAllFingerprintRules(c *gin.Context) {
+	c.JSON(http.StatusOK, fingerprintRules.GetAll())
+}
+
+func postFingerprintRule(c *gin.Context) {
+	var req FingerprintRule
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.ID = uuid.New()
+
+	err := fingerprintRules.Add(&req)
+	if err != nil {
+		log.Printf("error: Failed to save fingerprint rule %v", err)
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+func deleteFingerprintRule(c *gin.Context) {
+	id := c.Param("id")
+	ruleID, err := uuid.Parse(id)
+	if err != nil {
+		log.Printf("error: invalid id %v", id)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	err = fingerprintRules.Remove(ruleID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+func getAllLeases(c *gin.Context) {
+	c.JSON(http.StatusOK, leases.GetAll())
+}
+
+func getAllReservations(c *gin.Context) {
+	c.JSON(http.StatusOK, leases.GetReservations())
+}
+
+func postReservation(c *gin.Context) {
+	var req Reservation
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	hwAddr, err := net.ParseMAC(req.HWAddress)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", req.HWAddress)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+	ip := net.ParseIP(req.IPAddress)
+	if ip == nil || ip.To4() == nil {
+		log.Printf("error: invalid address %v", req.IPAddress)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid address"})
+		return
+	}
+
+	err = leases.Reserve(hwAddr, ip.To4())
+	if err != nil {
+		log.Printf("error: Failed to reserve %v for %v %v", ip, hwAddr, err)
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, req)
+}
+
+func deleteReservation(c *gin.Context) {
+	hwAddrStr := c.Param("hwaddr")
+	hwAddr, err := net.ParseMAC(hwAddrStr)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", hwAddrStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	err = leases.Unreserve(hwAddr)
+	if err != nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+func getAllNetworkConfigs(c *gin.Context) {
+	c.JSON(http.StatusOK, networkConfigs.GetAll())
+}
+
+func postDeviceNetworkConfig(c *gin.Context) {
+	hwAddrStr := c.Param("hwaddr")
+	hwAddr, err := net.ParseMAC(hwAddrStr)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", hwAddrStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+	var req NetworkConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// served when the device renews its lease
+	err = networkConfigs.SetDevice(hwAddr, &req)
+	if err != nil {
+		log.Printf("error: invalid network config of %v %v", hwAddr, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, networkConfigs.Get(hwAddr, uuid.Nil))
+}
+
+func deleteDeviceNetworkConfig(c *gin.Context) {
+	hwAddrStr := c.Param("hwaddr")
+	hwAddr, err := net.ParseMAC(hwAddrStr)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", hwAddrStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	err = networkConfigs.RemoveDevice(hwAddr)
+	if err != nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+func postAppNetworkConfig(c *gin.Context) {
+	id := c.Param("id")
+	appID, err := uuid.Parse(id)
+	if err != nil {
+		log.Printf("error: invalid id %v", id)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+	var req NetworkConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = networkConfigs.SetApp(appID, &req)
+	if err != nil {
+		log.Printf("error: invalid network config of app %v %v", appID, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// routes removed from the config are left until the app is started again
+	if proc, ok := getAppFromID(appID).(*app.LinuxProcess); ok && proc.IsRunning() {
+		err = applyAppNetworkConfig(proc)
+		if err != nil {
+			log.Printf("error: Failed to apply network config of app %v %v", appID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, networkConfigs.Get(nil, appID))
+}
+
+func deleteAppNetworkConfig(c *gin.Context) {
+	id := c.Param("id")
+	appID, err := uuid.Parse(id)
+	if err != nil {
+		log.Printf("error: invalid id %v", id)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	err = networkConfigs.RemoveApp(appID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+func getAllPSKs(c *gin.Context) {
+	c.JSON(http.StatusOK, psks.GetAll())
+}
+
+func getDevicePSK(c *gin.Context) {
+	hwAddrStr := c.Param("hwaddr")
+	hwAddr, err := net.ParseMAC(hwAddrStr)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", hwAddrStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	psk := psks.Get(hwAddr)
+	if psk == nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	c.JSON(http.StatusOK, psk)
+}
+
+// issueDevicePSK issues or rotates passphrase of registered device
+func issueDevicePSK(c *gin.Context) {
+	hwAddrStr := c.Param("hwaddr")
+	hwAddr, err := net.ParseMAC(hwAddrStr)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", hwAddrStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	device := getDeviceByHWAddr(hwAddr)
+	if device == nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+
+	psk, err := psks.Issue(device)
+	if err != nil {
+		log.Printf("error: Failed to issue psk for %v %v", hwAddr, err)
+		c.JSON(http.StatusInternalServerError, err)
+		return
+	}
+	log.Printf("info: issued psk %v to device %v", psk.KeyID, hwAddr)
+
+	err = reloadDevicePSK(hwAddr)
+	if err != nil {
+		log.Printf("error: Failed to reload psk of hostapd %v", err)
+	}
+
+	c.JSON(http.StatusOK, psk)
+}
+
+func revokeDevicePSK(c *gin.Context) {
+	hwAddrStr := c.Param("hwaddr")
+	hwAddr, err := net.ParseMAC(hwAddrStr)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", hwAddrStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	err = psks.Revoke(hwAddr)
+	if err != nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+
+	err = reloadDevicePSK(hwAddr)
+	if err != nil {
+		log.Printf("error: Failed to reload psk of hostapd %v", err)
+	}
+
+	c.JSON(http.StatusOK, nil)
+}
+
+func getAllIdentities(c *gin.Context) {
+	c.JSON(http.StatusOK, identities.GetAll())
+}
+
+func postIdentity(c *gin.Context) {
+	var req DeviceIdentity
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.PkgID != uuid.Nil && !isPkgLoaded(req.PkgID) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown package ID %v", req.PkgID)})
+		return
+	}
+
+	err := identities.Add(&req)
+	if err != nil {
+		log.Printf("error: Failed to add identity %v %v", req.Identity, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, req)
+}
+
+func deleteIdentity(c *gin.Context) {
+	err := identities.Remove(c.Param("name"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	c.JSON(http.StatusOK, nil)
+}
+
+func getAllAppTraffic(c *gin.Context) {
+	c.JSON(http.StatusOK, traffic.GetAppReports())
+}
+
+func getAppTraffic(c *gin.Context) {
+	id := c.Param("id")
+	appID, err := uuid.Parse(id)
+	if err != nil {
+		log.Printf("error: invalid id %v", id)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	report := traffic.GetAppReport(appID)
+	if report == nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func getAllDeviceTraffic(c *gin.Context) {
+	c.JSON(http.StatusOK, traffic.GetDeviceReports())
+}
+
+func getDeviceTraffic(c *gin.Context) {
+	hwAddrStr := c.Param("hwaddr")
+	hwAddr, err := net.ParseMAC(hwAddrStr)
+	if err != nil {
+		log.Printf("error: invalid hwaddr %v", hwAddrStr)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	report := traffic.GetDeviceReport(hwAddr)
+	if report == nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func getAllCapTraffic(c *gin.Context) {
+	c.JSON(http.StatusOK, traffic.GetCapabilityReports())
+}
+
+func getCapTraffic(c *gin.Context) {
+	id := c.Param("id")
+	capID, err := uuid.Parse(id)
+	if err != nil {
+		log.Printf("error: invalid id %v", id)
+		c.JSON(http.StatusBadRequest, err)
+		return
+	}
+
+	report := traffic.GetCapabilityReport(capID)
+	if report == nil {
+		c.JSON(http.StatusNotFound, nil)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
 func StartAPIServer() error {
 	return setupRouter().Run("0.0.0.0:8080")
 }
@@ -290,14 +976,87 @@ func setupRouter() *gin.Engine {
 	r.POST("/app/:id/device", setDevice)
 	r.GET("/app/:id/device", getDevice)
 	r.POST("/app/:id/cap", postAppCap)
+	r.DELETE("/app/:id/cap/:capID", deleteAppCap)
+	r.GET("/app/:id/dns", getAppDNSQueries)
+	r.GET("/app/:id/proxy", getAppProxyConnections)
 	r.GET("/ovs", getOvsInfo)
+	r.GET("/meters", getAllMeters)
+	r.DELETE("/meter/:id", deleteMeter)
+	r.GET("/groups", getAllGroups)
+	r.GET("/spoofing", getSpoofingCounters)
+	r.GET("/spoofing/alerts", getSpoofingAlerts)
+	r.GET("/denied", getDeniedAccesses)
+	r.GET("/ports", getAllPorts)
+	r.GET("/devices", getAllDevices)
+	r.POST("/device", postDevice)
+	r.PATCH("/device/:hwaddr", patchDevice)
+	r.DELETE("/device/:hwaddr", deleteDevice)
+	r.GET("/fingerprints", getAllFingerprints)
+	r.GET("/fingerprint/rules", getAllFingerprintRules)
+	r.POST("/fingerprint/rule", postFingerprintRule)
+	r.DELETE("/fingerprint/rule/:id", deleteFingerprintRule)
+	r.GET("/network", getAllNetworkConfigs)
+	r.POST("/network/device/:hwaddr", postDeviceNetworkConfig)
+	r.DELETE("/network/device/:hwaddr", deleteDeviceNetworkConfig)
+	r.POST("/network/app/:id", postAppNetworkConfig)
+	r.DELETE("/network/app/:id", deleteAppNetworkConfig)
+	r.GET("/leases", getAllLeases)
+	r.GET("/reservations", getAllReservations)
+	r.POST("/reservation", postReservation)
+	r.DELETE("/reservation/:hwaddr", deleteReservation)
+	r.GET("/psks", getAllPSKs)
+	r.GET("/device/:hwaddr/psk", getDevicePSK)
+	r.POST("/device/:hwaddr/psk", issueDevicePSK)
+	r.DELETE("/device/:hwaddr/psk", revokeDevicePSK)
+	r.GET("/identities", getAllIdentities)
+	r.POST("/identity", postIdentity)
+	r.DELETE("/identity/:name", deleteIdentity)
+	r.GET("/traffic/apps", getAllAppTraffic)
+	r.GET("/traffic/app/:id", getAppTraffic)
+	r.GET("/traffic/devices", getAllDeviceTraffic)
+	r.GET("/traffic/device/:hwaddr", getDeviceTraffic)
+	r.GET("/traffic/caps", getAllCapTraffic)
+	r.GET("/traffic/cap/:id", getCapTraffic)
 
 	return r
 }
 
+func getCapabilityTransport(cap *capability.Capability) (uint8, uint16, error) {
+	transport, err := cap.GetTransportValue()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return getTransportProto(transport)
+}
+
+func getTransportProto(transport *capability.TransportValue) (uint8, uint16, error) {
+	switch transport.Protocol {
+	case capability.TransportProtocolTCP:
+		return ofswitch.IPProtoTCP, transport.Port, nil
+	case capability.TransportProtocolUDP:
+		return ofswitch.IPProtoUDP, transport.Port, nil
+	case capability.TransportProtocolSCTP:
+		return ofswitch.IPProtoSCTP, transport.Port, nil
+	}
+
+	return 0, 0, fmt.Errorf("unsupported protocol %v", transport.Protocol)
+}
+
 func enforceCapability(cap *capability.Capability) error {
 	log.Printf("info: Enforcing cap %v", cap)
 
+	if cap.MaxRate != 0 {
+		// egress flows of ExternalCommunication on ACL switch carry the same cookie
+		for _, ofs := range []*ofswitch.OFSwitch{extOfs, aclOfs} {
+			_, err := ofs.SetMeter(capabilityCookie(cap), cap.MaxRate, 0)
+			if err != nil {
+				return err
+			}
+		}
+		log.Printf("info: cap %v is limited to %v kbps", cap.CapabilityID, cap.MaxRate)
+	}
+
 	clientApp := getAppFromID(cap.AssigneeID)
 	if clientApp == nil {
 		log.Printf("error: clientApp %v not found", cap.AssigneeID)
@@ -322,16 +1081,35 @@ func enforceCapability(cap *capability.Capability) error {
 	if err != nil {
 		return err
 	}
+	err = extOfs.AddAppsNDPFlow(serverProc.GetDevice(), serverProc.ACLLink, clientProc.GetDevice(), clientProc.ACLLink)
+	if err != nil {
+		return err
+	}
 
 	if cap.CapabilityName == capability.CAPABILITY_NAME_NEIGHBOR_DISCOVERY {
-		err = extOfs.AddAppsBroadcastUDPDstFlow(clientProc.GetDevice(), clientProc.ACLLink, serverProc.GetDevice(), serverProc.ACLLink, 8000)
+		// mDNS and SSDP of the service types are relayed by controller
+		discovery, err := cap.GetDiscoveryValue()
 		if err != nil {
 			return err
 		}
+		for _, transport := range discovery.Transports {
+			protoType, port, err := getTransportProto(transport)
+			if err != nil {
+				return err
+			}
+			err = extOfs.AddAppsBroadcastTransportFlow(clientProc.GetDevice(), clientProc.ACLLink, serverProc.GetDevice(), serverProc.ACLLink, protoType, port, capabilityCookie(cap))
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	if cap.CapabilityName == capability.CAPABILITY_NAME_TEMPERATURE || cap.CapabilityName == capability.CAPABILITY_NAME_HUMIDITY {
-		err = extOfs.AddAppsUnicastUDPDstFlow(clientProc.GetDevice(), clientProc.ACLLink, serverProc.GetDevice(), serverProc.ACLLink, 8000)
+		protoType, port, err := getCapabilityTransport(cap)
+		if err != nil {
+			return err
+		}
+		err = extOfs.AddAppsUnicastTransportFlow(clientProc.GetDevice(), clientProc.ACLLink, serverProc.GetDevice(), serverProc.ACLLink, protoType, port, capabilityCookie(cap))
 		if err != nil {
 			return err
 		}
@@ -340,3 +1118,52 @@ func enforceCapability(cap *capability.Capability) error {
 	log.Printf("info: Successfully enforced cap")
 	return nil
 }
+
+func revokeCapability(cap *capability.Capability) error {
+	log.Printf("info: Revoking cap %v", cap)
+
+	for _, ofs := range []*ofswitch.OFSwitch{extOfs, aclOfs} {
+		err := ofs.DeleteMeterByCookie(capabilityCookie(cap))
+		if err != nil {
+			return err
+		}
+	}
+
+	if cap.CapabilityName == capability.CAPABILITY_NAME_NEIGHBOR_DISCOVERY {
+		clientApp := getAppFromID(cap.AssigneeID)
+		serverApp := getAppFromID(cap.AppID)
+		if clientApp != nil && serverApp != nil {
+			clientProc := clientApp.(*app.LinuxProcess)
+			serverProc := serverApp.(*app.LinuxProcess)
+			discovery, err := cap.GetDiscoveryValue()
+			if err != nil {
+				return err
+			}
+			for _, transport := range discovery.Transports {
+				protoType, port, err := getTransportProto(transport)
+				if err != nil {
+					return err
+				}
+				// links of failed apps are already deleted and their buckets purged
+				if clientProc.ACLLink == nil || serverProc.ACLLink == nil {
+					return extOfs.DeleteFlowsByCookie(capabilityCookie(cap))
+				}
+				err = extOfs.DeleteAppsBroadcastTransportFlow(clientProc.GetDevice(), clientProc.ACLLink, serverProc.GetDevice(), serverProc.ACLLink, protoType, port, capabilityCookie(cap))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	if cap.CapabilityName == capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION {
+		// egress flows added for DNS answers
+		err := aclOfs.DeleteFlowsByCookie(capabilityCookie(cap))
+		if err != nil {
+			return err
+		}
+	}
+
+	return extOfs.DeleteFlowsByCookie(capabilityCookie(cap))
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+)
+
+// DeniedAccess is a packet denied by policy table or a domain denied by the DNS proxy
+// and the capability missing for it
+type DeniedAccess struct {
+	Timestamp       time.Time            `json:"timestamp"`
+	DeviceHWAddress string               `json:"deviceHWAddress,omitempty"`
+	AppID           uuid.UUID            `json:"appID"`
+	DstAppID        uuid.UUID            `json:"dstAppID,omitempty"`
+	Packet          *ofswitch.PacketInfo `json:"packet,omitempty"`
+	Domain          string               `json:"domain,omitempty"`
+	CapabilityName  string               `json:"capabilityName,omitempty"`
+	CapabilityValue string               `json:"capabilityValue,omitempty"`
+	RequestID       uuid.UUID            `json:"requestID,omitempty"`
+}
+
+func (d *DeniedAccess) key() string {
+	if d.Packet == nil {
+		return fmt.Sprintf("%v/%v", d.AppID, d.Domain)
+	}
+	return fmt.Sprintf("%v/%v/%v/%v", d.AppID, d.Packet.IPDst, d.Packet.IPProto, d.Packet.DstPort)
+}
+
+// DeniedAccessLog holds denied accesses suppressing the same ones within interval
+type DeniedAccessLog struct {
+	mu         sync.Mutex
+	accesses   []*DeniedAccess
+	lastSeen   map[string]time.Time
+	maxHistory int
+	interval   time.Duration
+}
+
+// NewDeniedAccessLog creates log holding the latest maxHistory accesses
+func NewDeniedAccessLog(maxHistory int, interval time.Duration) *DeniedAccessLog {
+	return &DeniedAccessLog{
+		accesses:   []*DeniedAccess{},
+		lastSeen:   map[string]time.Time{},
+		maxHistory: maxHistory,
+		interval:   interval,
+	}
+}
+
+func (l *DeniedAccessLog) isRecent(access *DeniedAccess) bool {
+	lastSeen, ok := l.lastSeen[access.key()]
+	return ok && access.Timestamp.Sub(lastSeen) < l.interval
+}
+
+// IsRecent returns true if the access to the same destination is recorded within interval
+func (l *DeniedAccessLog) IsRecent(access *DeniedAccess) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.isRecent(access)
+}
+
+// Add records access and returns false if the access to the same destination is recorded within interval
+func (l *DeniedAccessLog) Add(access *DeniedAccess) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.isRecent(access) {
+		return false
+	}
+	l.lastSeen[access.key()] = access.Timestamp
+
+	l.accesses = append(l.accesses, access)
+	if len(l.accesses) > l.maxHistory {
+		l.accesses = l.accesses[len(l.accesses)-l.maxHistory:]
+	}
+
+	return true
+}
+
+// GetAll returns accesses ordered from the oldest
+func (l *DeniedAccessLog) GetAll() []*DeniedAccess {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	accesses := make([]*DeniedAccess, len(l.accesses))
+	copy(accesses, l.accesses)
+	return accesses
+}
+
+func getAppByACLPort(ofPort uint32) *app.LinuxProcess {
+	for _, a := range apps.GetAll() {
+		proc, ok := a.(*app.LinuxProcess)
+		if !ok || proc.ACLLink == nil {
+			continue
+		}
+		if proc.ACLLink.GetOfPort() == ofPort {
+			return proc
+		}
+	}
+	return nil
+}
+
+// getDeniedPacketSource returns the app and the device sending the packet.
+// Packets come from the ACL link of the app or from the device itself.
+func getDeniedPacketSource(info *ofswitch.PacketInfo) (app.AppInterface, *app.Device) {
+	if proc := getAppByACLPort(info.InPort); proc != nil {
+		return proc, proc.GetDevice()
+	}
+	if info.EthSrc == nil {
+		return nil, nil
+	}
+	device := getDeviceByHWAddr(info.EthSrc)
+	if device == nil || device.App == nil {
+		return nil, device
+	}
+	return device.App, device
+}
+
+func getDeviceByIP(ip net.IP) *app.Device {
+	if ip == nil {
+		return nil
+	}
+	selectedDevices := devices.Where(func(d *app.Device) bool {
+		if d.IPAddress != nil && d.IPAddress.IP.Equal(ip) {
+			return true
+		}
+		return d.IP6Address != nil && d.IP6Address.IP.Equal(ip)
+	})
+	if len(selectedDevices) == 0 {
+		return nil
+	}
+	return selectedDevices[0]
+}
+
+func isBroadcastPacket(info *ofswitch.PacketInfo) bool {
+	if info.EthDst.String() == "ff:ff:ff:ff:ff:ff" {
+		return true
+	}
+	return info.IPDst != nil && (info.IPDst.IsMulticast() || info.IPDst.Equal(net.IPv4bcast))
+}
+
+// inferMissingCapability returns the capability which would allow the packet
+// from offered capabilities and the destination.
+// External destinations are allowed by domains, so they are inferred from denied queries instead.
+func inferMissingCapability(info *ofswitch.PacketInfo, dstAppID uuid.UUID, offered capability.CapabilitySlice) (string, string) {
+	if info.IPDst == nil {
+		return "", ""
+	}
+
+	if dstAppID != uuid.Nil {
+		for _, cap := range offered {
+			if cap.AppID != dstAppID {
+				continue
+			}
+			protoType, port, err := getCapabilityTransport(cap)
+			if err != nil {
+				continue
+			}
+			if protoType == info.IPProto && (port == ofswitch.AnyPort || port == info.DstPort) {
+				return cap.CapabilityName, cap.CapabilityValue
+			}
+		}
+		return "", ""
+	}
+
+	transportValue := fmt.Sprintf("%v/%v", info.DstPort, ipProtoName(info.IPProto))
+	if isBroadcastPacket(info) {
+		return capability.CAPABILITY_NAME_NEIGHBOR_DISCOVERY, transportValue
+	}
+
+	return "", ""
+}
+
+func ipProtoName(proto uint8) string {
+	switch proto {
+	case ofswitch.IPProtoTCP:
+		return capability.TransportProtocolTCP
+	case ofswitch.IPProtoUDP:
+		return capability.TransportProtocolUDP
+	case ofswitch.IPProtoSCTP:
+		return capability.TransportProtocolSCTP
+	}
+	return fmt.Sprintf("%v", proto)
+}
+
+func fetchOfferedCapabilities() (capability.CapabilitySlice, error) {
+	resp, err := http.Get(cpUrl + "/cap")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respByte, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	caps := capability.CapabilitySlice{}
+	err = json.Unmarshal(respByte, &caps)
+	if err != nil {
+		return nil, err
+	}
+
+	return caps, nil
+}
+
+func newMissingCapabilityRequest(access *DeniedAccess, device *app.Device) (*capability.CapabilityRequest, error) {
+	capReq := capability.NewCreateSkeltonCapabilityRequest()
+	capReq.RequesterID = pepID
+	capReq.RequesteeID = access.DstAppID
+	capReq.DeviceID = uuid.Nil
+	capReq.VendorID = uuid.Nil
+	// attributes of device authenticated by its certificate
+	if device != nil && device.DeviceID != uuid.Nil {
+		capReq.DeviceID = device.DeviceID
+	}
+	if device != nil && device.VendorID != uuid.Nil {
+		capReq.VendorID = device.VendorID
+	}
+	capReq.CapabilityID = uuid.Nil
+	capReq.RequestCapabilityName = access.CapabilityName
+	capReq.RequestCapabilityValue = access.CapabilityValue
+	err := capReq.Sign(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return capReq, nil
+}
+
+// queueDeniedPacket passes info to the worker not to block the loop reading the switch.
+// The packet is dropped if the queue is full.
+func queueDeniedPacket(info *ofswitch.PacketInfo) {
+	select {
+	case deniedPackets <- info:
+	default:
+		log.Printf("warning: dropped denied packet from port %v %v -> %v", info.InPort, info.IPSrc, info.IPDst)
+	}
+}
+
+// startDeniedPacketWorker handles queued denied packets one by one
+func startDeniedPacketWorker() {
+	for info := range deniedPackets {
+		handleDeniedPacket(info)
+	}
+}
+
+// handleDeniedPacket logs the packet missing policy table and the capability which would allow it
+func handleDeniedPacket(info *ofswitch.PacketInfo) {
+	srcApp, srcDevice := getDeniedPacketSource(info)
+	if srcApp == nil {
+		log.Printf("info: denied packet from unknown source port %v %v %v -> %v", info.InPort, info.EthSrc, info.IPSrc, info.IPDst)
+		return
+	}
+
+	access := &DeniedAccess{
+		Timestamp: time.Now(),
+		AppID:     srcApp.ID(),
+		Packet:    info,
+	}
+	if srcDevice != nil {
+		access.DeviceHWAddress = srcDevice.HWAddress.String()
+	}
+	if deniedAccesses.IsRecent(access) {
+		return
+	}
+	if dstDevice := getDeviceByIP(info.IPDst); dstDevice != nil && dstDevice.App != nil {
+		access.DstAppID = dstDevice.App.ID()
+	}
+
+	offered := capability.CapabilitySlice{}
+	if access.DstAppID != uuid.Nil {
+		caps, err := fetchOfferedCapabilities()
+		if err != nil {
+			log.Printf("error: Failed to fetch capabilities %v", err)
+		} else {
+			offered = caps
+		}
+	}
+	access.CapabilityName, access.CapabilityValue = inferMissingCapability(info, access.DstAppID, offered)
+	reportDeniedAccess(access, srcDevice)
+}
+
+// handleDeniedQuery logs the domain denied for client and the capability which would allow it
+func handleDeniedQuery(client *DNSClient, domain string) {
+	access := &DeniedAccess{
+		Timestamp:       time.Now(),
+		AppID:           client.App.ID(),
+		Domain:          domain,
+		CapabilityName:  capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION,
+		CapabilityValue: domain,
+	}
+	device := client.Device
+	if device == nil {
+		device = client.App.GetDevice()
+	}
+	if device != nil {
+		access.DeviceHWAddress = device.HWAddress.String()
+	}
+	if deniedAccesses.IsRecent(access) {
+		return
+	}
+	reportDeniedAccess(access, device)
+}
+
+// reportDeniedAccess records access and requests the missing capability to CP if enabled
+func reportDeniedAccess(access *DeniedAccess, device *app.Device) {
+	var capReq *capability.CapabilityRequest
+	if pepConfig.autoCapabilityRequest && access.CapabilityName != "" {
+		req, err := newMissingCapabilityRequest(access, device)
+		if err != nil {
+			log.Printf("error: Failed to create capability request %v", err)
+		} else {
+			capReq = req
+			access.RequestID = capReq.RequestID
+		}
+	}
+
+	if !deniedAccesses.Add(access) {
+		return
+	}
+	if access.Packet != nil {
+		log.Printf("info: app %v(device %v) tried to reach %v:%v without capability %v(%v)", access.AppID, access.DeviceHWAddress, access.Packet.IPDst, access.Packet.DstPort, access.CapabilityName, access.CapabilityValue)
+	} else {
+		log.Printf("info: app %v(device %v) tried to resolve %v without capability %v(%v)", access.AppID, access.DeviceHWAddress, access.Domain, access.CapabilityName, access.CapabilityValue)
+	}
+
+	if capReq == nil {
+		return
+	}
+	go func() {
+		_, err := capability.SendContentsToCP(cpUrl+"/capReq", capReq)
+		if err != nil {
+			log.Printf("error: Failed to request capability %v", err)
+			return
+		}
+		log.Printf("info: requested capability %v(%v) on behalf of app %v", access.CapabilityName, access.CapabilityValue, access.AppID)
+	}()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInferMissingCapability(t *testing.T) {
+	dstAppID := uuid.New()
+
+	capTemp := capability.NewCreateSkeltonCapability()
+	capTemp.AppID = dstAppID
+	capTemp.CapabilityName = capability.CAPABILITY_NAME_TEMPERATURE
+	capTemp.CapabilityValue = "8000/udp"
+	capOther := capability.NewCreateSkeltonCapability()
+	capOther.CapabilityName = capability.CAPABILITY_NAME_HUMIDITY
+	capOther.CapabilityValue = "8000/udp"
+	offered := capability.CapabilitySlice{capOther, capTemp}
+
+	info := &ofswitch.PacketInfo{
+		IPDst:   net.ParseIP("192.168.20.3"),
+		IPProto: ofswitch.IPProtoUDP,
+		DstPort: 8000,
+	}
+	name, value := inferMissingCapability(info, dstAppID, offered)
+	assert.Equal(t, capability.CAPABILITY_NAME_TEMPERATURE, name)
+	assert.Equal(t, "8000/udp", value)
+
+	info.IPProto = ofswitch.IPProtoTCP
+	name, _ = inferMissingCapability(info, dstAppID, offered)
+	assert.Equal(t, "", name)
+
+	info = &ofswitch.PacketInfo{
+		IPDst:   net.IPv4bcast,
+		IPProto: ofswitch.IPProtoUDP,
+		DstPort: 8000,
+	}
+	name, value = inferMissingCapability(info, uuid.Nil, offered)
+	assert.Equal(t, capability.CAPABILITY_NAME_NEIGHBOR_DISCOVERY, name)
+	assert.Equal(t, "8000/udp", value)
+
+	info = &ofswitch.PacketInfo{
+		IPDst:   net.ParseIP("203.0.113.1"),
+		IPProto: ofswitch.IPProtoTCP,
+		DstPort: 443,
+	}
+	// external destinations are inferred from denied queries
+	name, _ = inferMissingCapability(info, uuid.Nil, offered)
+	assert.Equal(t, "", name)
+}
+
+func TestDeniedAccessLog(t *testing.T) {
+	accessLog := NewDeniedAccessLog(2, time.Minute)
+	now := time.Now()
+	appID := uuid.New()
+	newAccess := func(dst string, timestamp time.Time) *DeniedAccess {
+		return &DeniedAccess{
+			Timestamp: timestamp,
+			AppID:     appID,
+			Packet: &ofswitch.PacketInfo{
+				IPDst:   net.ParseIP(dst),
+				DstPort: 443,
+			},
+		}
+	}
+
+	assert.True(t, accessLog.Add(newAccess("203.0.113.1", now)))
+	assert.True(t, accessLog.IsRecent(newAccess("203.0.113.1", now.Add(time.Second))))
+	assert.False(t, accessLog.Add(newAccess("203.0.113.1", now.Add(time.Second))))
+	assert.True(t, accessLog.Add(newAccess("203.0.113.1", now.Add(2*time.Minute))))
+	assert.True(t, accessLog.Add(newAccess("203.0.113.2", now)))
+
+	accesses := accessLog.GetAll()
+	assert.Equal(t, 2, len(accesses))
+	assert.True(t, accesses[1].Packet.IPDst.Equal(net.ParseIP("203.0.113.2")))
+}
+
+func TestQueueDeniedPacket(t *testing.T) {
+	origPackets := deniedPackets
+	defer func() {
+		deniedPackets = origPackets
+	}()
+	deniedPackets = make(chan *ofswitch.PacketInfo, 1)
+
+	first := &ofswitch.PacketInfo{InPort: 1}
+	queueDeniedPacket(first)
+	// the full queue drops the packet without blocking
+	queueDeniedPacket(&ofswitch.PacketInfo{InPort: 2})
+
+	assert.Equal(t, 1, len(deniedPackets))
+	assert.Equal(t, first, <-deniedPackets)
+}
+
+func TestGetDeniedPacketSource(t *testing.T) {
+	ap := newTestApp()
+	device := &app.Device{
+		HWAddress: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x33},
+		App:       ap,
+	}
+	devices.Add(device)
+	defer devices.Remove(device)
+
+	// packets sent by the device itself are from its app
+	srcApp, srcDevice := getDeniedPacketSource(&ofswitch.PacketInfo{InPort: 100, EthSrc: device.HWAddress})
+	assert.Equal(t, ap, srcApp)
+	assert.Equal(t, device, srcDevice)
+
+	srcApp, _ = getDeniedPacketSource(&ofswitch.PacketInfo{InPort: 100, EthSrc: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x34}})
+	assert.Nil(t, srcApp)
+
+	device.App = nil
+	srcApp, srcDevice = getDeniedPacketSource(&ofswitch.PacketInfo{InPort: 100, EthSrc: device.HWAddress})
+	assert.Nil(t, srcApp)
+	assert.Equal(t, device, srcDevice)
+}
+
+func TestHandleDeniedQuery(t *testing.T) {
+	savedAccesses := deniedAccesses
+	deniedAccesses = NewDeniedAccessLog(10, time.Minute)
+	defer func() { deniedAccesses = savedAccesses }()
+
+	ap := newTestApp()
+	device := &app.Device{
+		HWAddress: net.HardwareAddr{0x02, 0, 0, 0, 0, 0x33},
+		App:       ap,
+	}
+	client := &DNSClient{App: ap, Device: device}
+	handleDeniedQuery(client, "tracker.example.org")
+	// the same domain is suppressed within interval
+	handleDeniedQuery(client, "tracker.example.org")
+	handleDeniedQuery(client, "ads.example.org")
+
+	accesses := deniedAccesses.GetAll()
+	assert.Equal(t, 2, len(accesses))
+	assert.Equal(t, ap.ID(), accesses[0].AppID)
+	assert.Equal(t, device.HWAddress.String(), accesses[0].DeviceHWAddress)
+	assert.Equal(t, "tracker.example.org", accesses[0].Domain)
+	assert.Equal(t, capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION, accesses[0].CapabilityName)
+	assert.Equal(t, "tracker.example.org", accesses[0].CapabilityValue)
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"net"
 	"os"
 	"time"
@@ -13,12 +14,15 @@ import (
 	"github.com/coredhcp/coredhcp/logger"
 	"github.com/coredhcp/coredhcp/server"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/naoki9911/CREBAS/pkg/app"
 	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
 	"github.com/vishvananda/netlink"
 
 	"github.com/coredhcp/coredhcp/plugins"
 	pl_leasetime "github.com/coredhcp/coredhcp/plugins/leasetime"
+	pl_serverid "github.com/coredhcp/coredhcp/plugins/serverid"
 
 	"github.com/sirupsen/logrus"
 )
@@ -49,6 +53,7 @@ func getLogLevels() []string {
 
 var desiredPlugins = []*plugins.Plugin{
 	&pl_leasetime.Plugin,
+	&pl_serverid.Plugin,
 	&Plugin,
 }
 
@@ -98,7 +103,7 @@ func StartDHCPServer() {
 	server4Config.Plugins = []config.PluginConfig{
 		{
 			Name: "lease_time",
-			Args: []string{"60s"},
+			Args: []string{pepConfig.dhcpLeaseTime.String()},
 		},
 		{
 			Name: "externaldhcp",
@@ -107,6 +112,25 @@ func StartDHCPServer() {
 	}
 	conf.Server4 = &server4Config
 
+	server6Config := config.ServerConfig{}
+	listener6 := net.UDPAddr{
+		IP:   net.IPv6unspecified,
+		Port: dhcpv6.DefaultServerPort,
+		Zone: pepConfig.extOfsName,
+	}
+	server6Config.Addresses = []net.UDPAddr{listener6}
+	server6Config.Plugins = []config.PluginConfig{
+		{
+			Name: "server_id",
+			Args: []string{"LL", extOfs.Link.GetHWAddress().String()},
+		},
+		{
+			Name: "externaldhcp",
+			Args: []string{"60s"},
+		},
+	}
+	conf.Server6 = &server6Config
+
 	// start server
 	var err error
 	srv, err = server.Start(conf)
@@ -121,7 +145,7 @@ func StartDHCPServer() {
 
 var Plugin = plugins.Plugin{
 	Name:   "externaldhcp",
-	Setup6: nil,
+	Setup6: setup6,
 	Setup4: setup4,
 }
 
@@ -153,13 +177,25 @@ func handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 	if clientIdentifierBytes != nil {
 		log.Infof("ClientIdentifier :%v", string(clientIdentifierBytes))
 	}
+	fingerprint := newFingerprint(req)
+	log.Debugf("Fingerprint of %v: %+v", req.ClientHWAddr.String(), fingerprint)
 
 	selectedDevices := devices.Where(func(d *app.Device) bool {
 		return d.HWAddress.String() == req.ClientHWAddr.String()
 	})
 
+	if req.MessageType() == dhcpv4.MessageTypeRelease {
+		log.Infof("Release requested by %v", req.ClientHWAddr.String())
+		if len(selectedDevices) != 0 {
+			teardownDevice(selectedDevices[0])
+		} else {
+			leases.Release(req.ClientHWAddr)
+		}
+		return nil, true
+	}
+
 	if len(selectedDevices) == 0 {
-		deviceIP, err := extAddrPool.Lease()
+		deviceIP, err := leases.Acquire(req.ClientHWAddr, time.Now())
 		if err != nil {
 			log.Infof("Failed to Lease Addr for %v", req.ClientHWAddr.String())
 			return resp, true
@@ -168,15 +204,65 @@ func handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 			HWAddress: req.ClientHWAddr,
 			IPAddress: deviceIP,
 		}
+		// DHCP requests come from Wi-Fi stations
+		if pepConfig.wifiLink != nil {
+			device.OfPort = pepConfig.wifiLink.GetOfPort()
+			device.ViaWlan = true
+		}
+		applyFingerprint(&device, fingerprint)
 		devices.Add(&device)
+		dnsClients.AddDevice(&device)
 
 		resp.YourIPAddr = deviceIP.IP
 		log.Infof("Assigned IP %v for %v", deviceIP.IP.String(), req.ClientHWAddr.String())
+
+		err = bindDeviceLease(extOfs, spoofing, &device)
+		if err != nil {
+			log.Errorf("failed to bind lease %v", err)
+		}
+
+		// the package selected by fingerprint
+		if device.App != nil {
+			err = startAppWithDevice(&device)
+			if err != nil {
+				log.Errorf("failed to start app %v", err)
+				return resp, true
+			}
+			log.Infof("Starting corresponding app %v", device.App.ID())
+		}
 	} else {
 		device := selectedDevices[0]
+		applyFingerprint(device, fingerprint)
+		// stations found by hostapd and torn down devices have no address, others renew the lease
+		deviceIP, err := leases.Acquire(req.ClientHWAddr, time.Now())
+		if err != nil {
+			log.Infof("Failed to Lease Addr for %v", req.ClientHWAddr.String())
+			return resp, true
+		}
+		if device.IPAddress == nil || !device.IPAddress.IP.Equal(deviceIP.IP) {
+			device.IPAddress = deviceIP
+			dnsClients.AddDevice(device)
+			log.Infof("Assigned IP %v for %v", deviceIP.IP.String(), req.ClientHWAddr.String())
+
+			// admission of running app follows the reserved address
+			if proc, ok := device.App.(*app.LinuxProcess); ok && proc.IsRunning() && proc.ACLLink != nil {
+				err = extOfs.DeleteAdmissionFlow(device)
+				if err == nil {
+					err = extOfs.AddAdmissionFlow(proc.ACLLink, device)
+				}
+				if err != nil {
+					log.Errorf("failed to update admission flow %v", err)
+				}
+			}
+		}
 		resp.YourIPAddr = device.IPAddress.IP
 		log.Infof("found IP address %s for MAC %s", resp.YourIPAddr, req.ClientHWAddr.String())
 
+		err = bindDeviceLease(extOfs, spoofing, device)
+		if err != nil {
+			log.Errorf("failed to bind lease %v", err)
+		}
+
 		if device.App != nil {
 			if !device.App.IsRunning() {
 				err = startAppWithDevice(device)
@@ -189,6 +275,11 @@ func handler4(req, resp *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, bool) {
 		}
 	}
 
+	err = updateDHCPNetworkOptions(req, resp, getDeviceNetworkConfig(req.ClientHWAddr), ovsIP)
+	if err != nil {
+		log.Errorf("failed to add network options %v", err)
+	}
+
 	return resp, false
 }
 
@@ -196,6 +287,109 @@ func setup4(args ...string) (handler.Handler4, error) {
 	return handler4, nil
 }
 
+func handler6(req, resp dhcpv6.DHCPv6) (dhcpv6.DHCPv6, bool) {
+	log := logger.GetLogger("dhcpserver")
+	msg, err := req.GetInnerMessage()
+	if err != nil {
+		log.Errorf("Failed to decapsulate: %v", err)
+		return nil, true
+	}
+
+	if msg.Options.OneIANA() == nil {
+		log.Debug("No address requested")
+		return resp, false
+	}
+
+	mac, err := dhcpv6.ExtractMAC(req)
+	if err != nil {
+		log.Warningf("Could not find client MAC")
+		return resp, true
+	}
+
+	// devices are registered by DHCPv4 and get IPv6 address in addition
+	selectedDevices := devices.Where(func(d *app.Device) bool {
+		return d.HWAddress.String() == mac.String()
+	})
+	if len(selectedDevices) == 0 {
+		log.Infof("Unknown device %v", mac.String())
+		return resp, true
+	}
+
+	device := selectedDevices[0]
+	if device.IP6Address == nil {
+		deviceIP6, err := extAddr6Pool.Lease()
+		if err != nil {
+			log.Infof("Failed to Lease IPv6 Addr for %v", mac.String())
+			return resp, true
+		}
+		device.IP6Address = deviceIP6
+		dnsClients.AddDevice(device)
+		log.Infof("Assigned IPv6 %v for %v", deviceIP6.IP.String(), mac.String())
+
+		err = bindDeviceLease(extOfs, spoofing, device)
+		if err != nil {
+			log.Errorf("failed to bind lease %v", err)
+		}
+
+		if device.App != nil && device.App.IsRunning() {
+			proc := device.App.(*app.LinuxProcess)
+			if proc.ACLLink != nil {
+				err = extOfs.AddAdmissionFlow(proc.ACLLink, device)
+				if err != nil {
+					log.Errorf("failed to add admission flow %v", err)
+				}
+			}
+			// the app started by DHCPv4 has flows of IPv4 only
+			err = enforceDeviceIP6(device)
+			if err != nil {
+				log.Errorf("failed to enforce IPv6 of %v %v", mac.String(), err)
+			}
+		}
+	}
+
+	resp.AddOption(&dhcpv6.OptIANA{
+		IaId: msg.Options.OneIANA().IaId,
+		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{
+				IPv6Addr:          device.IP6Address.IP,
+				PreferredLifetime: pepConfig.dhcpLeaseTime,
+				ValidLifetime:     pepConfig.dhcpLeaseTime,
+			},
+		}},
+	})
+
+	return resp, false
+}
+
+func setup6(args ...string) (handler.Handler6, error) {
+	return handler6, nil
+}
+
+// enforceDeviceIP6 adds NDP flows of device and enforces again the capabilities
+// held by and granted to its app so that their flows cover the IPv6 address
+func enforceDeviceIP6(device *app.Device) error {
+	if device.GetViaWlan() {
+		err := extOfs.AddDeviceAppNDPFlow(device, extOfs.Link)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, a := range apps.GetAll() {
+		for _, cap := range a.Capabilities().GetAll() {
+			if a != device.App && cap.AppID != device.App.ID() {
+				continue
+			}
+			err := enforceCapability(cap)
+			if err != nil {
+				log.Printf("error: Failed to enforce cap(%v) %v", cap.CapabilityID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
 func startAppWithDevice(device *app.Device) error {
 	proc := device.App.(*app.LinuxProcess)
 	procAddr, err := netlink.ParseAddr(pepConfig.extOfsAppAddr)
@@ -207,14 +401,30 @@ func startAppWithDevice(device *app.Device) error {
 	if err != nil {
 		return err
 	}
-	_, err = proc.AddLinkWithAddr(aclOfs, netlinkext.ACLOFSwitch, aclAddr)
+	aclLink, err := proc.AddLinkWithAddr(aclOfs, netlinkext.ACLOFSwitch, aclAddr)
 	if err != nil {
 		return err
 	}
-	err = proc.SetDefaultRoute(aclOfs.Link.Addr.IP)
+	err = aclOfs.AddEgressBaseFlow(aclLink)
 	if err != nil {
 		return err
 	}
+	err = addEgressProxyFlow(aclLink)
+	if err != nil {
+		return err
+	}
+	err = applyAppNetworkConfig(proc)
+	if err != nil {
+		return err
+	}
+
+	if device.MaxRate != 0 {
+		_, err = extOfs.SetMeter(ofswitch.DeviceCookie(device.HWAddress), device.MaxRate, 0)
+		if err != nil {
+			return err
+		}
+		log.Printf("info: device %v is limited to %v kbps", device.HWAddress, device.MaxRate)
+	}
 
 	if device.GetViaWlan() {
 		procLink, err := proc.AddLink(extOfs, netlinkext.ExternalOFSwitch)
@@ -229,31 +439,71 @@ func startAppWithDevice(device *app.Device) error {
 			return err
 		}
 
+		err = extOfs.AddDeviceAppNDPFlow(device, extOfs.Link)
+		if err != nil {
+			return err
+		}
+
 		err = extOfs.AddDeviceAppTunnelFlow(device, procLink)
 		if err != nil {
 			return err
 		}
+
+		err = extOfs.AddAdmissionFlow(procLink, device)
+		if err != nil {
+			return err
+		}
 	} else {
 		procLink, err := proc.AddLinkWithAddr(extOfs, netlinkext.ExternalOFSwitch, procAddr)
 		if err != nil {
 			return err
 		}
+		procAddr6, err := netlink.ParseAddr(pepConfig.extOfsAppAddr6)
+		if err != nil {
+			return err
+		}
+		err = procLink.SetAddr(procAddr6)
+		if err != nil {
+			return err
+		}
 		err = extOfs.AddDeviceTunnelFlow(device, procLink)
 		if err != nil {
 			return err
 		}
 
+		// packets not tunneled between the device and the app are evaluated by policy
+		for _, link := range []ofswitch.DeviceLink{device, procLink} {
+			err = extOfs.AddLinkAdmissionFlow(link)
+			if err != nil {
+				return err
+			}
+		}
+
 		err = extOfs.DeleteHostARPFlow(device)
 		if err != nil {
 			return err
 		}
 	}
 
-	apps.Add(device.App)
+	// failed app is already registered and started again
+	registered := apps.Where(func(a app.AppInterface) bool {
+		return a == device.App
+	})
+	if len(registered) == 0 {
+		apps.Add(device.App)
+	}
+	dnsClients.AddApp(device.App)
 	err = device.App.Start()
 	if err != nil {
 		return err
 	}
+
+	for _, cap := range proc.Capabilities().GetAll() {
+		err = enforceCapability(cap)
+		if err != nil {
+			log.Printf("error: Failed to enforce cap(%v) %v", cap.CapabilityID, err)
+		}
+	}
 	fmt.Println("APP NAMESPACE:" + proc.NameSpace())
 	return nil
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+)
+
+const (
+	mdnsPort = 5353
+	ssdpPort = 1900
+)
+
+// mdnsServicesName is the meta query enumerating all service types
+const mdnsServicesName = "_services._dns-sd._udp"
+
+// ssdpAll is the search target of all devices and services
+const ssdpAll = "ssdp:all"
+
+// DiscoveryMessage is a service discovery packet to be relayed between apps
+type DiscoveryMessage struct {
+	Protocol string
+	// service types queried or announced, DiscoveryAnyService for all
+	ServiceTypes []string
+	Query        bool
+}
+
+// handlePacketIn dispatches the packets sent to controller by flow cookie
+func handlePacketIn(info *ofswitch.PacketInfo) {
+	if ofswitch.GetCookieType(info.Cookie) == ofswitch.CookieTypeDiscovery {
+		relayDiscoveryPacket(info)
+		return
+	}
+	queueDeniedPacket(info)
+}
+
+// getMDNSServiceType returns service type like "_googlecast._tcp" in name.
+// The second value is false if name is not of a service.
+func getMDNSServiceType(name string) (string, bool) {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	for i := 1; i < len(labels); i++ {
+		if labels[i] != "_tcp" && labels[i] != "_udp" {
+			continue
+		}
+		if !strings.HasPrefix(labels[i-1], "_") {
+			return "", false
+		}
+		if i >= 2 && strings.Join(labels[i-2:i+1], ".") == mdnsServicesName {
+			return capability.DiscoveryAnyService, true
+		}
+		return labels[i-1] + "." + labels[i], true
+	}
+	return "", false
+}
+
+func appendServiceType(types []string, serviceType string) []string {
+	for _, t := range types {
+		if t == serviceType {
+			return types
+		}
+	}
+	return append(types, serviceType)
+}
+
+// parseMDNSMessage returns service types in questions of query or records of response.
+// Records of hosts have no service type and are relayed with any service.
+func parseMDNSMessage(payload []byte) (*DiscoveryMessage, error) {
+	msg := &dns.Msg{}
+	err := msg.Unpack(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &DiscoveryMessage{
+		Protocol:     capability.DiscoveryProtocolMDNS,
+		ServiceTypes: []string{},
+		Query:        !msg.Response,
+	}
+	if message.Query {
+		for _, q := range msg.Question {
+			if serviceType, ok := getMDNSServiceType(q.Name); ok {
+				message.ServiceTypes = appendServiceType(message.ServiceTypes, serviceType)
+			}
+		}
+	} else {
+		for _, rrs := range [][]dns.RR{msg.Answer, msg.Ns, msg.Extra} {
+			for _, rr := range rrs {
+				if serviceType, ok := getMDNSServiceType(rr.Header().Name); ok && serviceType != capability.DiscoveryAnyService {
+					message.ServiceTypes = appendServiceType(message.ServiceTypes, serviceType)
+				}
+				// answers to the meta query point to service types
+				if ptr, ok := rr.(*dns.PTR); ok {
+					if serviceType, ok := getMDNSServiceType(ptr.Ptr); ok {
+						message.ServiceTypes = appendServiceType(message.ServiceTypes, serviceType)
+					}
+				}
+			}
+		}
+	}
+
+	if len(message.ServiceTypes) == 0 {
+		message.ServiceTypes = append(message.ServiceTypes, capability.DiscoveryAnyService)
+	}
+	return message, nil
+}
+
+// parseSSDPMessage returns search target of M-SEARCH or notification type of NOTIFY and response
+func parseSSDPMessage(payload []byte) (*DiscoveryMessage, error) {
+	reader := bufio.NewReader(bytes.NewReader(payload))
+	message := &DiscoveryMessage{
+		Protocol: capability.DiscoveryProtocolSSDP,
+	}
+
+	var header http.Header
+	if bytes.HasPrefix(payload, []byte("HTTP/")) {
+		res, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			return nil, err
+		}
+		res.Body.Close()
+		header = res.Header
+	} else {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return nil, err
+		}
+		header = req.Header
+		switch req.Method {
+		case "M-SEARCH":
+			message.Query = true
+		case "NOTIFY":
+		default:
+			return nil, fmt.Errorf("unsupported SSDP method %v", req.Method)
+		}
+	}
+
+	serviceType := header.Get("NT")
+	if message.Query || serviceType == "" {
+		serviceType = header.Get("ST")
+	}
+	serviceType = strings.TrimSpace(serviceType)
+	if serviceType == "" {
+		return nil, fmt.Errorf("SSDP message without service type")
+	}
+	if serviceType == ssdpAll {
+		serviceType = capability.DiscoveryAnyService
+	}
+	message.ServiceTypes = []string{serviceType}
+
+	return message, nil
+}
+
+// parseDiscoveryPacket parses mDNS or SSDP in ethernet frame
+func parseDiscoveryPacket(data []byte) (*DiscoveryMessage, error) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	udpLayer := packet.Layer(layers.LayerTypeUDP)
+	if udpLayer == nil {
+		return nil, fmt.Errorf("not UDP packet")
+	}
+	udp := udpLayer.(*layers.UDP)
+
+	switch {
+	case udp.DstPort == mdnsPort:
+		return parseMDNSMessage(udp.Payload)
+	case udp.DstPort == ssdpPort:
+		return parseSSDPMessage(udp.Payload)
+	}
+	return nil, fmt.Errorf("not discovery packet to port %v", udp.DstPort)
+}
+
+// isDiscoveryAllowed returns true if caps hold NeighborDiscovery to peer permitting all service types of message
+func isDiscoveryAllowed(caps capability.CapabilitySlice, peer app.AppInterface, message *DiscoveryMessage) bool {
+	for _, cap := range caps {
+		if cap.CapabilityName != capability.CAPABILITY_NAME_NEIGHBOR_DISCOVERY || cap.AppID != peer.ID() {
+			continue
+		}
+		discovery, err := cap.GetDiscoveryValue()
+		if err != nil {
+			continue
+		}
+		allows := discovery.Allows
+		if !message.Query {
+			allows = discovery.AllowsAnnouncement
+		}
+		allowed := true
+		for _, serviceType := range message.ServiceTypes {
+			if !allows(message.Protocol, serviceType) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// getDiscoveryRecipients returns apps receiving message of src.
+// Announcements reach the apps holding NeighborDiscovery to src
+// and queries reach the peers to which src holds NeighborDiscovery.
+func getDiscoveryRecipients(src app.AppInterface, message *DiscoveryMessage, candidates []app.AppInterface) []app.AppInterface {
+	recipients := []app.AppInterface{}
+	for _, candidate := range candidates {
+		if candidate.ID() == src.ID() {
+			continue
+		}
+		if message.Query {
+			if !isDiscoveryAllowed(src.Capabilities().GetAll(), candidate, message) {
+				continue
+			}
+		} else if !isDiscoveryAllowed(candidate.Capabilities().GetAll(), src, message) {
+			continue
+		}
+		recipients = append(recipients, candidate)
+	}
+	return recipients
+}
+
+// getDiscoverySource returns app sending packet from its link or its device
+func getDiscoverySource(info *ofswitch.PacketInfo) app.AppInterface {
+	if proc := getAppByACLPort(info.InPort); proc != nil {
+		return proc
+	}
+	selectedDevices := devices.Where(func(d *app.Device) bool {
+		return bytes.Equal(d.HWAddress, info.EthSrc) && d.OfPort == info.InPort
+	})
+	if len(selectedDevices) == 0 || selectedDevices[0].App == nil {
+		return nil
+	}
+	return selectedDevices[0].App
+}
+
+// relayDiscoveryPacket sends mDNS or SSDP packet to the app links and devices permitted by NeighborDiscovery
+func relayDiscoveryPacket(info *ofswitch.PacketInfo) {
+	src := getDiscoverySource(info)
+	if src == nil {
+		return
+	}
+
+	message, err := parseDiscoveryPacket(info.Data)
+	if err != nil {
+		log.Printf("warning: dropped discovery packet from app %v %v", src.ID(), err)
+		return
+	}
+
+	sent := map[string]bool{}
+	for _, recipient := range getDiscoveryRecipients(src, message, apps.GetAll()) {
+		proc, ok := recipient.(*app.LinuxProcess)
+		if !ok {
+			continue
+		}
+		if proc.ACLLink != nil {
+			sendDiscoveryPacket(info.Data, proc.ACLLink.GetOfPort(), nil, sent)
+		}
+		// the device of app receives the multicast as unicast not to reach other stations
+		if device := proc.GetDevice(); device != nil && device.HWAddress != nil && device.OfPort != 0 {
+			sendDiscoveryPacket(info.Data, device.OfPort, device.HWAddress, sent)
+		}
+	}
+}
+
+func sendDiscoveryPacket(data []byte, outport uint32, ethDst net.HardwareAddr, sent map[string]bool) {
+	key := fmt.Sprintf("%v/%v", outport, ethDst)
+	if sent[key] {
+		return
+	}
+	sent[key] = true
+
+	err := extOfs.SendPacket(data, outport, ethDst)
+	if err != nil {
+		log.Printf("error: Failed to relay discovery packet to port %v %v", outport, err)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/miekg/dns"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDiscoveryFrame(t *testing.T, dstIP string, dstPort uint16, payload []byte) []byte {
+	srcMAC, _ := net.ParseMAC("02:00:00:00:00:01")
+	dstMAC, _ := net.ParseMAC("01:00:5e:00:00:fb")
+	eth := &layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       dstMAC,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      255,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP("192.168.10.2"),
+		DstIP:    net.ParseIP(dstIP),
+	}
+	udp := &layers.UDP{
+		SrcPort: layers.UDPPort(dstPort),
+		DstPort: layers.UDPPort(dstPort),
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestGetMDNSServiceType(t *testing.T) {
+	tests := []struct {
+		name        string
+		serviceType string
+		ok          bool
+	}{
+		{"_googlecast._tcp.local.", "_googlecast._tcp", true},
+		{"Living Room._GoogleCast._tcp.local.", "_googlecast._tcp", true},
+		{"_printer._sub._http._tcp.local.", "_http._tcp", true},
+		{"_services._dns-sd._udp.local.", capability.DiscoveryAnyService, true},
+		{"living-room.local.", "", false},
+	}
+
+	for _, test := range tests {
+		serviceType, ok := getMDNSServiceType(test.name)
+		assert.Equal(t, test.ok, ok, test.name)
+		assert.Equal(t, test.serviceType, serviceType, test.name)
+	}
+}
+
+func TestParseDiscoveryPacketMDNS(t *testing.T) {
+	query := &dns.Msg{}
+	query.SetQuestion("_googlecast._tcp.local.", dns.TypePTR)
+	payload, err := query.Pack()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	message, err := parseDiscoveryPacket(newDiscoveryFrame(t, "224.0.0.251", mdnsPort, payload))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, capability.DiscoveryProtocolMDNS, message.Protocol)
+	assert.True(t, message.Query)
+	assert.Equal(t, []string{"_googlecast._tcp"}, message.ServiceTypes)
+
+	response := &dns.Msg{}
+	response.Response = true
+	for _, rrStr := range []string{
+		"_googlecast._tcp.local. 120 IN PTR Living\\ Room._googlecast._tcp.local.",
+		"Living\\ Room._googlecast._tcp.local. 120 IN SRV 0 0 8009 living-room.local.",
+		"living-room.local. 120 IN A 192.168.20.10",
+		"_services._dns-sd._udp.local. 120 IN PTR _hap._tcp.local.",
+	} {
+		rr, err := dns.NewRR(rrStr)
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		response.Answer = append(response.Answer, rr)
+	}
+	payload, err = response.Pack()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	message, err = parseDiscoveryPacket(newDiscoveryFrame(t, "224.0.0.251", mdnsPort, payload))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.False(t, message.Query)
+	assert.Equal(t, []string{"_googlecast._tcp", "_hap._tcp"}, message.ServiceTypes)
+
+	_, err = parseDiscoveryPacket(newDiscoveryFrame(t, "224.0.0.251", 8000, payload))
+	assert.NotNil(t, err)
+}
+
+func TestParseDiscoveryPacketSSDP(t *testing.T) {
+	search := "M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nMAN: \"ssdp:discover\"\r\nMX: 1\r\nST: ssdp:all\r\n\r\n"
+	message, err := parseDiscoveryPacket(newDiscoveryFrame(t, "239.255.255.250", ssdpPort, []byte(search)))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, capability.DiscoveryProtocolSSDP, message.Protocol)
+	assert.True(t, message.Query)
+	assert.Equal(t, []string{capability.DiscoveryAnyService}, message.ServiceTypes)
+
+	notify := "NOTIFY * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\nNT: urn:schemas-upnp-org:device:MediaRenderer:1\r\nNTS: ssdp:alive\r\nUSN: uuid:test\r\n\r\n"
+	message, err = parseDiscoveryPacket(newDiscoveryFrame(t, "239.255.255.250", ssdpPort, []byte(notify)))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.False(t, message.Query)
+	assert.Equal(t, []string{"urn:schemas-upnp-org:device:MediaRenderer:1"}, message.ServiceTypes)
+
+	response := "HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\nUSN: uuid:test::upnp:rootdevice\r\n\r\n"
+	message, err = parseSSDPMessage([]byte(response))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.False(t, message.Query)
+	assert.Equal(t, []string{"upnp:rootdevice"}, message.ServiceTypes)
+
+	_, err = parseSSDPMessage([]byte("M-SEARCH * HTTP/1.1\r\nHOST: 239.255.255.250:1900\r\n\r\n"))
+	assert.NotNil(t, err)
+}
+
+func TestGetDiscoveryRecipients(t *testing.T) {
+	speaker := newTestApp()
+	phone := newTestApp()
+	tv := newTestApp()
+	candidates := []app.AppInterface{speaker, phone, tv}
+
+	// phone discovers the cast service of speaker
+	cap := capability.NewCreateSkeltonCapability()
+	cap.CapabilityName = capability.CAPABILITY_NAME_NEIGHBOR_DISCOVERY
+	cap.CapabilityValue = "mdns:_googlecast._tcp"
+	cap.AssigneeID = phone.ID()
+	cap.AppID = speaker.ID()
+	phone.capabilities.Add(cap)
+
+	// tv holds the legacy value without service types
+	legacy := capability.NewCreateSkeltonCapability()
+	legacy.CapabilityName = capability.CAPABILITY_NAME_NEIGHBOR_DISCOVERY
+	legacy.CapabilityValue = "8000/udp"
+	legacy.AssigneeID = tv.ID()
+	legacy.AppID = speaker.ID()
+	tv.capabilities.Add(legacy)
+
+	announcement := &DiscoveryMessage{
+		Protocol:     capability.DiscoveryProtocolMDNS,
+		ServiceTypes: []string{"_googlecast._tcp"},
+	}
+	assert.Equal(t, []app.AppInterface{phone}, getDiscoveryRecipients(speaker, announcement, candidates))
+	assert.Equal(t, 0, len(getDiscoveryRecipients(phone, announcement, candidates)))
+
+	// every service type in the message must be permitted
+	announcement.ServiceTypes = []string{"_googlecast._tcp", "_hap._tcp"}
+	assert.Equal(t, 0, len(getDiscoveryRecipients(speaker, announcement, candidates)))
+
+	// announcement of unknown service types is not permitted by specific service types
+	announcement.ServiceTypes = []string{capability.DiscoveryAnyService}
+	assert.Equal(t, 0, len(getDiscoveryRecipients(speaker, announcement, candidates)))
+
+	query := &DiscoveryMessage{
+		Protocol:     capability.DiscoveryProtocolMDNS,
+		ServiceTypes: []string{capability.DiscoveryAnyService},
+		Query:        true,
+	}
+	assert.Equal(t, []app.AppInterface{speaker}, getDiscoveryRecipients(phone, query, candidates))
+	assert.Equal(t, 0, len(getDiscoveryRecipients(tv, query, candidates)))
+
+	query.Protocol = capability.DiscoveryProtocolSSDP
+	assert.Equal(t, 0, len(getDiscoveryRecipients(phone, query, candidates)))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/vishvananda/netlink"
+)
+
+// DNSClient is an app namespace or a device sending queries to the DNS proxy
+type DNSClient struct {
+	App    app.AppInterface
+	Device *app.Device
+	// link of the app on which addresses answered to the client are allowed
+	Link *netlinkext.LinkExt
+}
+
+// DNSClientIndex maps addresses of app links and devices to DNS clients
+type DNSClientIndex struct {
+	mu      sync.Mutex
+	clients map[string]*DNSClient
+}
+
+// NewDNSClientIndex creates empty index
+func NewDNSClientIndex() *DNSClientIndex {
+	return &DNSClientIndex{
+		clients: map[string]*DNSClient{},
+	}
+}
+
+func (i *DNSClientIndex) remove(match func(c *DNSClient) bool) {
+	for key, client := range i.clients {
+		if match(client) {
+			delete(i.clients, key)
+		}
+	}
+}
+
+// AddApp indexes the current addresses of app links replacing the old ones.
+// Only links on the ACL switch are indexed as the addresses on the external switch are shared by apps.
+func (i *DNSClientIndex) AddApp(a app.AppInterface) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(func(c *DNSClient) bool { return c.Device == nil && c.App == a })
+	links := a.Links().Where(func(l *netlinkext.LinkExt) bool {
+		return l.OfType == netlinkext.ACLOFSwitch
+	})
+	for _, link := range links {
+		for _, addr := range []*netlink.Addr{link.Addr, link.Addr6} {
+			if addr == nil || addr.IPNet == nil {
+				continue
+			}
+			i.clients[addr.IP.String()] = &DNSClient{
+				App:  a,
+				Link: link,
+			}
+		}
+	}
+}
+
+// RemoveApp removes addresses of app links
+func (i *DNSClientIndex) RemoveApp(a app.AppInterface) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(func(c *DNSClient) bool { return c.Device == nil && c.App == a })
+}
+
+// AddDevice indexes the current addresses of device replacing the old ones
+func (i *DNSClientIndex) AddDevice(device *app.Device) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(func(c *DNSClient) bool { return c.Device == device })
+	for _, addr := range []*netlink.Addr{device.IPAddress, device.IP6Address} {
+		if addr == nil || addr.IPNet == nil {
+			continue
+		}
+		i.clients[addr.IP.String()] = &DNSClient{
+			Device: device,
+		}
+	}
+}
+
+// RemoveDevice removes addresses of device
+func (i *DNSClientIndex) RemoveDevice(device *app.Device) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.remove(func(c *DNSClient) bool { return c.Device == device })
+}
+
+// Lookup returns client of address.
+// Queries of device are decided by the capabilities of its app.
+func (i *DNSClientIndex) Lookup(ip net.IP) *DNSClient {
+	if ip == nil {
+		return nil
+	}
+
+	i.mu.Lock()
+	client, ok := i.clients[ip.String()]
+	i.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	copied := *client
+	if copied.Device != nil {
+		copied.App = copied.Device.App
+		copied.Link = nil
+		if copied.App != nil {
+			links := copied.App.Links().Where(func(l *netlinkext.LinkExt) bool {
+				return l.OfType == netlinkext.ACLOFSwitch && l.Addr != nil
+			})
+			if len(links) != 0 {
+				copied.Link = links[0]
+			}
+		}
+	}
+	return &copied
+}
+
+// getRemoteIP returns IP address of UDP or TCP client
+func getRemoteIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP
+	case *net.TCPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// DNSAnswerCache holds the addresses answered to each app with the names they were answered for
+// until the records expire
+type DNSAnswerCache struct {
+	mu      sync.Mutex
+	answers map[uuid.UUID]map[string]map[string]time.Time
+}
+
+// NewDNSAnswerCache creates empty cache
+func NewDNSAnswerCache() *DNSAnswerCache {
+	return &DNSAnswerCache{
+		answers: map[uuid.UUID]map[string]map[string]time.Time{},
+	}
+}
+
+// Add records the A and AAAA records of answers to app.
+// Addresses are recorded for all names of the chain as the chain is allowed as a whole.
+func (c *DNSAnswerCache) Add(appID uuid.UUID, answers []dns.RR, now time.Time) {
+	names := []string{}
+	for _, rr := range answers {
+		names = append(names, trimDomain(strings.ToLower(rr.Header().Name)))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	addrs, ok := c.answers[appID]
+	if !ok {
+		addrs = map[string]map[string]time.Time{}
+		c.answers[appID] = addrs
+	}
+	for addr, expires := range addrs {
+		for name, expiry := range expires {
+			if !now.Before(expiry) {
+				delete(expires, name)
+			}
+		}
+		if len(expires) == 0 {
+			delete(addrs, addr)
+		}
+	}
+
+	for _, rr := range answers {
+		ip, ttl := getAnswerAddress(rr)
+		if ip == nil {
+			continue
+		}
+		expires, ok := addrs[ip.String()]
+		if !ok {
+			expires = map[string]time.Time{}
+			addrs[ip.String()] = expires
+		}
+		expiry := now.Add(time.Duration(ttl) * time.Second)
+		for _, name := range names {
+			if expiry.After(expires[name]) {
+				expires[name] = expiry
+			}
+		}
+	}
+}
+
+// IsAnswered returns true if ip was answered to app for name and the record has not expired
+func (c *DNSAnswerCache) IsAnswered(appID uuid.UUID, name string, ip net.IP, now time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiry, ok := c.answers[appID][ip.String()][name]
+	return ok && now.Before(expiry)
+}
+
+// RemoveApp removes the addresses answered to app
+func (c *DNSAnswerCache) RemoveApp(appID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.answers, appID)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+// testApp is an app with links not attached to the kernel
+type testApp struct {
+	id           uuid.UUID
+	links        *netlinkext.LinkCollection
+	capabilities *capability.CapabilityCollection
+}
+
+func newTestApp() *testApp {
+	return &testApp{
+		id:           uuid.New(),
+		links:        netlinkext.NewLinkCollection(),
+		capabilities: capability.NewCapabilityCollection(),
+	}
+}
+
+func (a *testApp) addLink(t *testing.T, ofType netlinkext.OFType, addrs ...string) *netlinkext.LinkExt {
+	link := netlinkext.NewLinkExtVeth("test", "test-p")
+	link.OfType = ofType
+	for _, addrStr := range addrs {
+		addr, err := netlink.ParseAddr(addrStr)
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		if addr.IP.To4() != nil {
+			link.Addr = addr
+		} else {
+			link.Addr6 = addr
+		}
+	}
+	a.links.Add(link)
+	return link
+}
+
+func (a *testApp) Start() error                                   { return nil }
+func (a *testApp) Stop() error                                    { return nil }
+func (a *testApp) ID() uuid.UUID                                  { return a.id }
+func (a *testApp) GetAppInfo() *app.AppInfo                       { return &app.AppInfo{Id: a.id} }
+func (a *testApp) IsRunning() bool                                { return true }
+func (a *testApp) GetExitCode() int                               { return -1 }
+func (a *testApp) SetDevice(*app.Device) error                    { return nil }
+func (a *testApp) GetDevice() *app.Device                         { return nil }
+func (a *testApp) Capabilities() *capability.CapabilityCollection { return a.capabilities }
+func (a *testApp) Links() *netlinkext.LinkCollection              { return a.links }
+
+func TestDNSClientIndex(t *testing.T) {
+	index := NewDNSClientIndex()
+
+	app1 := newTestApp()
+	aclLink := app1.addLink(t, netlinkext.ACLOFSwitch, "192.168.10.2/24", "fd00:10::2/64")
+	app1.addLink(t, netlinkext.ExternalOFSwitch, "192.168.20.1/24")
+	index.AddApp(app1)
+
+	client := index.Lookup(net.ParseIP("192.168.10.2"))
+	if client == nil {
+		t.Fatalf("Failed client is not found")
+	}
+	assert.Equal(t, app1, client.App)
+	assert.Equal(t, aclLink, client.Link)
+	assert.Nil(t, client.Device)
+
+	client = index.Lookup(net.ParseIP("fd00:10::2"))
+	if client == nil {
+		t.Fatalf("Failed IPv6 client is not found")
+	}
+	assert.Equal(t, app1, client.App)
+
+	// addresses on the external switch are shared by apps
+	assert.Nil(t, index.Lookup(net.ParseIP("192.168.20.1")))
+	assert.Nil(t, index.Lookup(net.ParseIP("192.168.10.3")))
+	assert.Nil(t, index.Lookup(nil))
+
+	// queries of device are decided by its app
+	app2 := newTestApp()
+	app2Link := app2.addLink(t, netlinkext.ACLOFSwitch, "192.168.10.3/24")
+	index.AddApp(app2)
+	deviceAddr, _ := netlink.ParseAddr("192.168.20.10/24")
+	device := &app.Device{
+		HWAddress: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		IPAddress: deviceAddr,
+		App:       app2,
+	}
+	index.AddDevice(device)
+	client = index.Lookup(net.ParseIP("192.168.20.10"))
+	if client == nil {
+		t.Fatalf("Failed device is not found")
+	}
+	assert.Equal(t, device, client.Device)
+	assert.Equal(t, app2, client.App)
+	assert.Equal(t, app2Link, client.Link)
+
+	// device without app has no link to allow answers
+	device.App = nil
+	client = index.Lookup(net.ParseIP("192.168.20.10"))
+	assert.Nil(t, client.App)
+	assert.Nil(t, client.Link)
+
+	// the old address is removed with the new one
+	newAddr, _ := netlink.ParseAddr("192.168.20.11/24")
+	device.IPAddress = newAddr
+	index.AddDevice(device)
+	assert.Nil(t, index.Lookup(net.ParseIP("192.168.20.10")))
+	assert.NotNil(t, index.Lookup(net.ParseIP("192.168.20.11")))
+
+	index.RemoveDevice(device)
+	assert.Nil(t, index.Lookup(net.ParseIP("192.168.20.11")))
+
+	index.RemoveApp(app1)
+	assert.Nil(t, index.Lookup(net.ParseIP("192.168.10.2")))
+	assert.Nil(t, index.Lookup(net.ParseIP("fd00:10::2")))
+	assert.NotNil(t, index.Lookup(net.ParseIP("192.168.10.3")))
+}
+
+func TestGetRemoteIP(t *testing.T) {
+	ip := getRemoteIP(&net.UDPAddr{IP: net.ParseIP("fd00:10::2"), Port: 5353})
+	assert.True(t, ip.Equal(net.ParseIP("fd00:10::2")))
+	ip = getRemoteIP(&net.TCPAddr{IP: net.ParseIP("192.168.10.2"), Port: 40000})
+	assert.True(t, ip.Equal(net.ParseIP("192.168.10.2")))
+	assert.Nil(t, getRemoteIP(&net.UnixAddr{Name: "/tmp/sock"}))
+}
+
+func TestDNSAnswerCache(t *testing.T) {
+	c := NewDNSAnswerCache()
+	app1 := newTestApp()
+	app2 := newTestApp()
+	now := time.Now()
+
+	answers := []dns.RR{}
+	for _, record := range []string{
+		"www.example.com. 300 IN CNAME cdn.example.net.",
+		"cdn.example.net. 60 IN A 192.0.2.1",
+		"cdn.example.net. 60 IN AAAA 2001:db8::1",
+	} {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		answers = append(answers, rr)
+	}
+	c.Add(app1.ID(), answers, now)
+
+	assert.True(t, c.IsAnswered(app1.ID(), "www.example.com", net.ParseIP("192.0.2.1"), now))
+	assert.True(t, c.IsAnswered(app1.ID(), "cdn.example.net", net.ParseIP("192.0.2.1"), now))
+	assert.True(t, c.IsAnswered(app1.ID(), "www.example.com", net.ParseIP("2001:db8::1"), now))
+	assert.False(t, c.IsAnswered(app1.ID(), "www.example.org", net.ParseIP("192.0.2.1"), now))
+	assert.False(t, c.IsAnswered(app1.ID(), "www.example.com", net.ParseIP("192.0.2.2"), now))
+	assert.False(t, c.IsAnswered(app2.ID(), "www.example.com", net.ParseIP("192.0.2.1"), now))
+
+	// the address expires with the A record
+	assert.False(t, c.IsAnswered(app1.ID(), "www.example.com", net.ParseIP("192.0.2.1"), now.Add(time.Minute)))
+
+	c.RemoveApp(app1.ID())
+	assert.False(t, c.IsAnswered(app1.ID(), "www.example.com", net.ParseIP("192.0.2.1"), now))
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+)
+
+// Decisions of the queries from apps
+const (
+	DNSQueryAllowed   = "allowed"
+	DNSQueryDenied    = "denied"
+	DNSQueryForwarded = "forwarded"
+	DNSQueryFailed    = "failed"
+)
+
+// DNSQuery is a query from app and the decision by policy
+type DNSQuery struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Name         string    `json:"name"`
+	Type         string    `json:"type"`
+	Decision     string    `json:"decision"`
+	CapabilityID uuid.UUID `json:"capabilityID,omitempty"`
+	Rcode        string    `json:"rcode"`
+	Answers      []string  `json:"answers"`
+}
+
+// DNSQueryLog holds the latest queries of each app
+type DNSQueryLog struct {
+	mu         sync.Mutex
+	queries    map[uuid.UUID][]*DNSQuery
+	maxHistory int
+}
+
+// NewDNSQueryLog creates log holding the latest maxHistory queries per app
+func NewDNSQueryLog(maxHistory int) *DNSQueryLog {
+	return &DNSQueryLog{
+		queries:    map[uuid.UUID][]*DNSQuery{},
+		maxHistory: maxHistory,
+	}
+}
+
+// Add records query of app
+func (l *DNSQueryLog) Add(appID uuid.UUID, query *DNSQuery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	queries := append(l.queries[appID], query)
+	if len(queries) > l.maxHistory {
+		queries = queries[len(queries)-l.maxHistory:]
+	}
+	l.queries[appID] = queries
+}
+
+// Get returns queries of app ordered from the oldest, or nil if app has not queried
+func (l *DNSQueryLog) Get(appID uuid.UUID) []*DNSQuery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	queries, ok := l.queries[appID]
+	if !ok {
+		return nil
+	}
+	copied := make([]*DNSQuery, len(queries))
+	copy(copied, queries)
+	return copied
+}
+
+// getQueryDecision returns how query r answered by response is decided.
+// Queries other than A and AAAA are forwarded without checking capabilities.
+func getQueryDecision(r *dns.Msg, response *dns.Msg, allowingCap *capability.Capability) string {
+	if allowingCap != nil {
+		return DNSQueryAllowed
+	}
+	if response.Rcode == dns.RcodeServerFailure {
+		return DNSQueryFailed
+	}
+	for _, question := range r.Question {
+		if question.Qtype == dns.TypeA || question.Qtype == dns.TypeAAAA {
+			return DNSQueryDenied
+		}
+	}
+	return DNSQueryForwarded
+}
+
+// newDNSQueries creates log entries of the questions in r
+func newDNSQueries(r *dns.Msg, response *dns.Msg, allowingCap *capability.Capability, now time.Time) []*DNSQuery {
+	answers := []string{}
+	for _, rr := range response.Answer {
+		answers = append(answers, rr.String())
+	}
+
+	queries := []*DNSQuery{}
+	for _, question := range r.Question {
+		query := &DNSQuery{
+			Timestamp: now,
+			Name:      trimDomain(question.Name),
+			Type:      dns.TypeToString[question.Qtype],
+			Decision:  getQueryDecision(r, response, allowingCap),
+			Rcode:     dns.RcodeToString[response.Rcode],
+			Answers:   answers,
+		}
+		if allowingCap != nil {
+			query.CapabilityID = allowingCap.CapabilityID
+		}
+		queries = append(queries, query)
+	}
+	return queries
+}
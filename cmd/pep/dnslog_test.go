@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDNSQueries(t *testing.T) {
+	cap := capability.NewCreateSkeltonCapability()
+	cap.CapabilityName = capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION
+	cap.CapabilityValue = "*.example.com"
+	now := time.Now()
+
+	query := &dns.Msg{}
+	query.SetQuestion("www.example.com.", dns.TypeA)
+	response := &dns.Msg{}
+	response.SetReply(query)
+	rr, err := dns.NewRR("www.example.com. 300 IN A 93.184.216.34")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	response.Answer = append(response.Answer, rr)
+
+	queries := newDNSQueries(query, response, cap, now)
+	assert.Equal(t, 1, len(queries))
+	assert.Equal(t, "www.example.com", queries[0].Name)
+	assert.Equal(t, "A", queries[0].Type)
+	assert.Equal(t, DNSQueryAllowed, queries[0].Decision)
+	assert.Equal(t, cap.CapabilityID, queries[0].CapabilityID)
+	assert.Equal(t, "NOERROR", queries[0].Rcode)
+	assert.Equal(t, []string{rr.String()}, queries[0].Answers)
+
+	denied := newDeniedResponse(query, DNSDenyNXDomain)
+	queries = newDNSQueries(query, denied, nil, now)
+	assert.Equal(t, DNSQueryDenied, queries[0].Decision)
+	assert.Equal(t, uuid.Nil, queries[0].CapabilityID)
+	assert.Equal(t, "NXDOMAIN", queries[0].Rcode)
+
+	failed := newErrorResponse(query, dns.RcodeServerFailure)
+	queries = newDNSQueries(query, failed, nil, now)
+	assert.Equal(t, DNSQueryFailed, queries[0].Decision)
+
+	query.SetQuestion("example.com.", dns.TypeMX)
+	queries = newDNSQueries(query, response, nil, now)
+	assert.Equal(t, DNSQueryForwarded, queries[0].Decision)
+	assert.Equal(t, "MX", queries[0].Type)
+}
+
+func TestDNSQueryLog(t *testing.T) {
+	queryLog := NewDNSQueryLog(2)
+	appID := uuid.New()
+	assert.Nil(t, queryLog.Get(appID))
+
+	for _, name := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		queryLog.Add(appID, &DNSQuery{Name: name})
+	}
+	queryLog.Add(uuid.New(), &DNSQuery{Name: "other.example.com"})
+
+	queries := queryLog.Get(appID)
+	assert.Equal(t, 2, len(queries))
+	assert.Equal(t, "b.example.com", queries[0].Name)
+	assert.Equal(t, "c.example.com", queries[1].Name)
+}
+
+func TestGetAppDNSQueries(t *testing.T) {
+	savedQueries := dnsQueries
+	dnsQueries = NewDNSQueryLog(10)
+	defer func() { dnsQueries = savedQueries }()
+
+	appID := uuid.New()
+	dnsQueries.Add(appID, &DNSQuery{Name: "www.example.com", Decision: DNSQueryAllowed})
+
+	req := httptest.NewRequest("GET", "/app/"+appID.String()+"/dns", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	queries := []*DNSQuery{}
+	err := json.Unmarshal(w.Body.Bytes(), &queries)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 1, len(queries))
+	assert.Equal(t, "www.example.com", queries[0].Name)
+
+	req = httptest.NewRequest("GET", "/app/"+uuid.New().String()+"/dns", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	req = httptest.NewRequest("GET", "/app/invalid/dns", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
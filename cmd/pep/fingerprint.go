@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/atomicfile"
+)
+
+// FingerprintRule maps devices whose fingerprints match to vendor and default package.
+// Empty fields match any value, VendorClass and Hostname accept glob patterns.
+type FingerprintRule struct {
+	ID            uuid.UUID `json:"id"`
+	Name          string    `json:"name"`
+	ParameterList string    `json:"parameterList,omitempty"`
+	VendorClass   string    `json:"vendorClass,omitempty"`
+	Hostname      string    `json:"hostname,omitempty"`
+	OUI           string    `json:"oui,omitempty"`
+	VendorID      uuid.UUID `json:"vendorID,omitempty"`
+	PkgID         uuid.UUID `json:"pkgID,omitempty"`
+}
+
+func matchPattern(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return err == nil && matched
+}
+
+// Match returns true if fingerprint satisfies every field of rule
+func (r *FingerprintRule) Match(fp *app.Fingerprint) bool {
+	if r.ParameterList != "" && r.ParameterList != fp.ParameterList {
+		return false
+	}
+	if r.OUI != "" && strings.ToLower(r.OUI) != fp.OUI {
+		return false
+	}
+	return matchPattern(r.VendorClass, fp.VendorClass) && matchPattern(r.Hostname, fp.Hostname)
+}
+
+// FingerprintRules holds rules evaluated in order and persists them to file
+type FingerprintRules struct {
+	mu    sync.Mutex
+	path  string
+	rules []*FingerprintRule
+}
+
+// NewFingerprintRules creates rules persisted at path
+func NewFingerprintRules(path string) *FingerprintRules {
+	return &FingerprintRules{
+		path:  path,
+		rules: []*FingerprintRule{},
+	}
+}
+
+// Load reads rules added before
+func (r *FingerprintRules) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bytes, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(bytes, &r.rules)
+}
+
+func (r *FingerprintRules) save() error {
+	bytes, err := json.MarshalIndent(r.rules, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(r.path, bytes, 0600)
+}
+
+// Add appends rule evaluated after the existing ones
+func (r *FingerprintRules) Add(rule *FingerprintRule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *rule
+	r.rules = append(r.rules, &copied)
+	err := r.save()
+	if err != nil {
+		r.rules = r.rules[:len(r.rules)-1]
+		return err
+	}
+
+	return nil
+}
+
+// Remove removes rule
+func (r *FingerprintRules) Remove(id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, rule := range r.rules {
+		if rule.ID != id {
+			continue
+		}
+		old := r.rules
+		r.rules = append(append([]*FingerprintRule{}, old[:i]...), old[i+1:]...)
+		err := r.save()
+		if err != nil {
+			r.rules = old
+			return err
+		}
+		return nil
+	}
+
+	return fmt.Errorf("rule %v not found", id)
+}
+
+// GetAll returns rules in evaluation order
+func (r *FingerprintRules) GetAll() []*FingerprintRule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rules := []*FingerprintRule{}
+	for _, rule := range r.rules {
+		copied := *rule
+		rules = append(rules, &copied)
+	}
+	return rules
+}
+
+// Match returns the first rule matching fingerprint
+func (r *FingerprintRules) Match(fp *app.Fingerprint) *FingerprintRule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rule := range r.rules {
+		if rule.Match(fp) {
+			copied := *rule
+			return &copied
+		}
+	}
+	return nil
+}
+
+// newFingerprint collects fingerprint of device from DHCP request
+func newFingerprint(req *dhcpv4.DHCPv4) *app.Fingerprint {
+	fp := &app.Fingerprint{
+		VendorClass: req.ClassIdentifier(),
+		Hostname:    req.HostName(),
+		OUI:         app.GetOUI(req.ClientHWAddr),
+	}
+
+	// the order of requested options differs between DHCP clients
+	params := []string{}
+	for _, code := range req.Options.Get(dhcpv4.OptionParameterRequestList) {
+		params = append(params, strconv.Itoa(int(code)))
+	}
+	fp.ParameterList = strings.Join(params, ",")
+
+	return fp
+}
+
+// applyFingerprint records fingerprint of device and applies the attributes and package of matched rule.
+// Devices registered through API keep their bindings.
+func applyFingerprint(device *app.Device, fp *app.Fingerprint) {
+	device.Fingerprint = fp
+
+	if registry.Get(device.HWAddress) != nil || device.App != nil {
+		return
+	}
+	rule := fingerprintRules.Match(fp)
+	if rule == nil {
+		return
+	}
+	log.Printf("info: device %v matches fingerprint rule %v(%v)", device.HWAddress, rule.Name, rule.ID)
+
+	if rule.VendorID != uuid.Nil {
+		device.VendorID = rule.VendorID
+	}
+	if rule.PkgID == uuid.Nil {
+		return
+	}
+	proc, err := newCompanionApp(rule.PkgID)
+	if err != nil {
+		log.Printf("error: Failed to prepare app of rule %v %v", rule.ID, err)
+		return
+	}
+	proc.SetDevice(device)
+	device.App = proc
+	if device.VendorID == uuid.Nil {
+		device.VendorID = proc.PkgInfo().MetaInfo.VendorID
+	}
+}
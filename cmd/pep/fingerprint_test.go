@@ -0,0 +1,123 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFingerprint(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("58:CB:52:56:73:21")
+	req, err := dhcpv4.NewDiscovery(hwAddr,
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier("udhcp 1.30.1")),
+		dhcpv4.WithOption(dhcpv4.OptHostName("bulb-1")),
+		dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.OptionParameterRequestList, []byte{1, 3, 6, 15})),
+	)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	fp := newFingerprint(req)
+	assert.Equal(t, "1,3,6,15", fp.ParameterList)
+	assert.Equal(t, "udhcp 1.30.1", fp.VendorClass)
+	assert.Equal(t, "bulb-1", fp.Hostname)
+	assert.Equal(t, "58:cb:52", fp.OUI)
+}
+
+func TestFingerprintRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pep-fingerprint")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fingerprint_rules.json")
+
+	fp := &app.Fingerprint{
+		ParameterList: "1,3,6,15",
+		VendorClass:   "udhcp 1.30.1",
+		Hostname:      "bulb-1",
+		OUI:           "58:cb:52",
+	}
+	bulb := &FingerprintRule{
+		ID:          uuid.New(),
+		Name:        "bulb",
+		VendorClass: "udhcp *",
+		Hostname:    "Bulb-*",
+		OUI:         "58:CB:52",
+		VendorID:    uuid.New(),
+	}
+	android := &FingerprintRule{
+		ID:          uuid.New(),
+		Name:        "android",
+		VendorClass: "android-dhcp-*",
+	}
+	assert.True(t, bulb.Match(fp))
+	assert.False(t, android.Match(fp))
+
+	rules := NewFingerprintRules(path)
+	err = rules.Add(android)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = rules.Add(bulb)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, bulb, rules.Match(fp))
+
+	loaded := NewFingerprintRules(path)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, []*FingerprintRule{android, bulb}, loaded.GetAll())
+
+	err = loaded.Remove(bulb.ID)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.NotNil(t, loaded.Remove(bulb.ID))
+	assert.Nil(t, loaded.Match(fp))
+}
+
+func TestApplyFingerprint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pep-fingerprint")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+	savedRules := fingerprintRules
+	fingerprintRules = NewFingerprintRules(filepath.Join(dir, "fingerprint_rules.json"))
+	defer func() { fingerprintRules = savedRules }()
+
+	vendorID := uuid.New()
+	rule := &FingerprintRule{
+		ID:       uuid.New(),
+		Name:     "bulb",
+		OUI:      "02:00:00",
+		VendorID: vendorID,
+	}
+	err = fingerprintRules.Add(rule)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:40")
+	device := &app.Device{
+		HWAddress: hwAddr,
+	}
+	fp := &app.Fingerprint{
+		OUI: app.GetOUI(hwAddr),
+	}
+	applyFingerprint(device, fp)
+	assert.Equal(t, fp, device.Fingerprint)
+	assert.Equal(t, vendorID, device.VendorID)
+	assert.Nil(t, device.App)
+}
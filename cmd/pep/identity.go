@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/atomicfile"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/eaptls"
+	"github.com/naoki9911/CREBAS/pkg/pkg"
+)
+
+// DeviceIdentity binds the identity in device certificate to its companion package and attributes
+type DeviceIdentity struct {
+	Identity string    `json:"identity" binding:"required"`
+	PkgID    uuid.UUID `json:"pkgID,omitempty"`
+	DeviceID uuid.UUID `json:"deviceID,omitempty"`
+	VendorID uuid.UUID `json:"vendorID,omitempty"`
+}
+
+// IdentityRegistry holds device identities persisted to file and stations authenticated with them
+type IdentityRegistry struct {
+	mu            sync.Mutex
+	path          string
+	identities    map[string]*DeviceIdentity
+	authenticated map[string]string
+}
+
+// NewIdentityRegistry creates registry persisted at path
+func NewIdentityRegistry(path string) *IdentityRegistry {
+	return &IdentityRegistry{
+		path:          path,
+		identities:    map[string]*DeviceIdentity{},
+		authenticated: map[string]string{},
+	}
+}
+
+// Load reads identities registered before
+func (r *IdentityRegistry) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bytes, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	identities := []*DeviceIdentity{}
+	err = json.Unmarshal(bytes, &identities)
+	if err != nil {
+		return err
+	}
+	for _, identity := range identities {
+		r.identities[identity.Identity] = identity
+	}
+
+	return nil
+}
+
+func (r *IdentityRegistry) save() error {
+	identities := []*DeviceIdentity{}
+	for _, identity := range r.identities {
+		identities = append(identities, identity)
+	}
+	bytes, err := json.MarshalIndent(identities, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(r.path, bytes, 0600)
+}
+
+// Add adds or replaces identity
+func (r *IdentityRegistry) Add(identity *DeviceIdentity) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *identity
+	old, ok := r.identities[identity.Identity]
+	r.identities[identity.Identity] = &copied
+	err := r.save()
+	if err != nil {
+		if ok {
+			r.identities[identity.Identity] = old
+		} else {
+			delete(r.identities, identity.Identity)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Remove removes identity
+func (r *IdentityRegistry) Remove(name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.identities[name]
+	if !ok {
+		return fmt.Errorf("identity %v not found", name)
+	}
+	delete(r.identities, name)
+	err := r.save()
+	if err != nil {
+		r.identities[name] = old
+		return err
+	}
+
+	return nil
+}
+
+// Get returns identity
+func (r *IdentityRegistry) Get(name string) *DeviceIdentity {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identity, ok := r.identities[name]
+	if !ok {
+		return nil
+	}
+	copied := *identity
+	return &copied
+}
+
+// GetAll returns all identities
+func (r *IdentityRegistry) GetAll() []*DeviceIdentity {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	identities := []*DeviceIdentity{}
+	for _, identity := range r.identities {
+		copied := *identity
+		identities = append(identities, &copied)
+	}
+	return identities
+}
+
+func (r *IdentityRegistry) setAuthenticated(hwAddr net.HardwareAddr, name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.authenticated[hwAddr.String()] = name
+}
+
+// GetAuthenticated returns the identity authenticated by the station, or empty if it is not authenticated
+func (r *IdentityRegistry) GetAuthenticated(hwAddr net.HardwareAddr) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.authenticated[hwAddr.String()]
+}
+
+// ClearAuthenticated forgets authentication of disconnected station
+func (r *IdentityRegistry) ClearAuthenticated(hwAddr net.HardwareAddr) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.authenticated, hwAddr.String())
+}
+
+// IsAuthenticated returns true if the station is authenticated by EAP-TLS
+func (r *IdentityRegistry) IsAuthenticated(hwAddr net.HardwareAddr) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.authenticated[hwAddr.String()]
+	return ok
+}
+
+func getDeviceByIdentity(name string) *app.Device {
+	selectedDevices := devices.Where(func(d *app.Device) bool {
+		return d.Identity == name
+	})
+	if len(selectedDevices) == 0 {
+		return nil
+	}
+	return selectedDevices[0]
+}
+
+// isPkgLoaded returns true if the package of pkgID is loaded
+func isPkgLoaded(pkgID uuid.UUID) bool {
+	return len(pkgs.Where(func(a *pkg.PackageInfo) bool {
+		return a.MetaInfo.PkgID == pkgID
+	})) != 0
+}
+
+func newCompanionApp(pkgID uuid.UUID) (*app.LinuxProcess, error) {
+	selectedPkgs := pkgs.Where(func(a *pkg.PackageInfo) bool {
+		return a.MetaInfo.PkgID == pkgID
+	})
+	if len(selectedPkgs) != 1 {
+		return nil, fmt.Errorf("unknown package ID %v", pkgID)
+	}
+
+	// packages prepared for test are not packed
+	pkgInfo := selectedPkgs[0]
+	if pkgInfo.PkgPath != "" {
+		unpacked, err := pkg.UnpackPkg(pkgInfo.PkgPath)
+		if err != nil {
+			return nil, err
+		}
+		pkgInfo = unpacked
+	}
+
+	return app.NewLinuxProcessFromPkgInfo(pkgInfo)
+}
+
+// bindDeviceIdentity selects the device of station by identity and applies its attributes
+func bindDeviceIdentity(hwAddr net.HardwareAddr, identity *DeviceIdentity) (*app.Device, error) {
+	device := getDeviceByIdentity(identity.Identity)
+	if device != nil && device.HWAddress.String() != hwAddr.String() {
+		// the device presents its certificate from another address
+		log.Printf("info: device %v moved from %v to %v", identity.Identity, device.HWAddress, hwAddr)
+		teardownDevice(device)
+		device.HWAddress = hwAddr
+	}
+	if device == nil {
+		device = getDeviceByHWAddr(hwAddr)
+	}
+	if device == nil {
+		device = &app.Device{
+			HWAddress: hwAddr,
+			ViaWlan:   true,
+		}
+		if pepConfig.wifiLink != nil {
+			device.OfPort = pepConfig.wifiLink.GetOfPort()
+		}
+		devices.Add(device)
+	}
+
+	device.Identity = identity.Identity
+	device.DeviceID = identity.DeviceID
+	device.VendorID = identity.VendorID
+
+	if device.App == nil && identity.PkgID != uuid.Nil {
+		proc, err := newCompanionApp(identity.PkgID)
+		if err != nil {
+			return nil, err
+		}
+		proc.SetDevice(device)
+		device.App = proc
+	}
+	if proc, ok := device.App.(*app.LinuxProcess); ok && proc.PkgInfo() != nil && device.VendorID == uuid.Nil {
+		device.VendorID = proc.PkgInfo().MetaInfo.VendorID
+	}
+
+	return device, nil
+}
+
+// authorizeStation accepts stations presenting certificate of registered identity.
+// The device is bound when the station is connected not to block RADIUS replies.
+func authorizeStation(result *eaptls.Result) error {
+	name := result.Certificate.Subject.CommonName
+	identity := identities.Get(name)
+	if identity == nil {
+		return fmt.Errorf("unknown identity %v", name)
+	}
+	if identity.PkgID != uuid.Nil && !isPkgLoaded(identity.PkgID) {
+		return fmt.Errorf("unknown package ID %v", identity.PkgID)
+	}
+
+	identities.setAuthenticated(result.HWAddress, name)
+	log.Printf("info: station %v is authenticated as %v", result.HWAddress, name)
+
+	return nil
+}
+
+// bindAuthenticatedStation binds the device of connected station to the identity it is authenticated with
+func bindAuthenticatedStation(hwAddr net.HardwareAddr) {
+	name := identities.GetAuthenticated(hwAddr)
+	if name == "" {
+		return
+	}
+	identity := identities.Get(name)
+	if identity == nil {
+		log.Printf("warning: identity %v of station %v is removed", name, hwAddr)
+		return
+	}
+
+	device, err := bindDeviceIdentity(hwAddr, identity)
+	if err != nil {
+		log.Printf("error: Failed to bind station %v to %v %v", hwAddr, name, err)
+		return
+	}
+	log.Printf("info: station %v is bound to %v(device %v vendor %v)", hwAddr, name, device.DeviceID, device.VendorID)
+}
+
+func startEAPTLSServer() {
+	certBytes, err := capability.ReadCertificateWithoutDecode(pepConfig.eapServerCertPath)
+	if err != nil {
+		log.Printf("error: Failed to read %v %v", pepConfig.eapServerCertPath, err)
+		return
+	}
+	cert, err := capability.DecodeCertificate(certBytes)
+	if err != nil {
+		log.Printf("error: Failed to decode %v %v", pepConfig.eapServerCertPath, err)
+		return
+	}
+	key, err := capability.ReadPrivateKey(pepConfig.eapServerKeyPath)
+	if err != nil {
+		log.Printf("error: Failed to read %v %v", pepConfig.eapServerKeyPath, err)
+		return
+	}
+
+	caBytes, err := capability.ReadCertificateWithoutDecode(pepConfig.eapCACertPath)
+	if err != nil {
+		log.Printf("error: Failed to read %v %v", pepConfig.eapCACertPath, err)
+		return
+	}
+	caCert, err := capability.DecodeCertificate(caBytes)
+	if err != nil {
+		log.Printf("error: Failed to decode %v %v", pepConfig.eapCACertPath, err)
+		return
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCert)
+
+	server := eaptls.NewServer([]byte(pepConfig.radiusSecret),
+		tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key},
+		caPool, authorizeStation)
+
+	log.Printf("info: Starting RADIUS server for EAP-TLS on %v", pepConfig.radiusAddr)
+	err = server.ListenAndServe(pepConfig.radiusAddr)
+	if err != nil {
+		log.Printf("error: RADIUS server stopped %v", err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/naoki9911/CREBAS/pkg/eaptls"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestIdentityRegistry(t *testing.T) (*IdentityRegistry, func()) {
+	dir, err := ioutil.TempDir("", "pep-identity")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	return NewIdentityRegistry(filepath.Join(dir, "crebas", "identities.json")), func() { os.RemoveAll(dir) }
+}
+
+func TestIdentityRegistry(t *testing.T) {
+	registry, cleanup := newTestIdentityRegistry(t)
+	defer cleanup()
+	err := registry.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	identity := &DeviceIdentity{
+		Identity: "test-device",
+		DeviceID: uuid.New(),
+	}
+	err = registry.Add(identity)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, identity, registry.Get("test-device"))
+	assert.Nil(t, registry.Get("unknown"))
+	assert.Equal(t, 1, len(registry.GetAll()))
+
+	// registered identities are kept across restarts
+	loaded := NewIdentityRegistry(registry.path)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, identity, loaded.Get("test-device"))
+
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:37")
+	assert.False(t, registry.IsAuthenticated(hwAddr))
+	registry.setAuthenticated(hwAddr, "test-device")
+	assert.True(t, registry.IsAuthenticated(hwAddr))
+	registry.ClearAuthenticated(hwAddr)
+	assert.False(t, registry.IsAuthenticated(hwAddr))
+
+	err = registry.Remove("test-device")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.NotNil(t, registry.Remove("test-device"))
+
+	loaded = NewIdentityRegistry(registry.path)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 0, len(loaded.GetAll()))
+}
+
+func TestBindDeviceIdentity(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:38")
+	identity := &DeviceIdentity{
+		Identity: "test-bind-device",
+		DeviceID: uuid.New(),
+		VendorID: uuid.New(),
+	}
+
+	device, err := bindDeviceIdentity(hwAddr, identity)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer devices.Remove(device)
+	assert.Equal(t, device, getDeviceByHWAddr(hwAddr))
+	assert.Equal(t, device, getDeviceByIdentity("test-bind-device"))
+	assert.Equal(t, identity.DeviceID, device.DeviceID)
+	assert.Equal(t, identity.VendorID, device.VendorID)
+	assert.True(t, device.ViaWlan)
+
+	// re-authentication selects the same device
+	rebound, err := bindDeviceIdentity(hwAddr, identity)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, device, rebound)
+
+	_, err = bindDeviceIdentity(hwAddr, &DeviceIdentity{
+		Identity: "test-bind-device",
+		PkgID:    uuid.New(),
+	})
+	assert.NotNil(t, err)
+}
+
+func TestAuthorizeStation(t *testing.T) {
+	origIdentities := identities
+	defer func() {
+		identities = origIdentities
+	}()
+	var cleanup func()
+	identities, cleanup = newTestIdentityRegistry(t)
+	defer cleanup()
+	for _, identity := range []*DeviceIdentity{
+		{Identity: "test-authorize-device", DeviceID: uuid.New()},
+		{Identity: "test-unknown-pkg", PkgID: uuid.New()},
+	} {
+		err := identities.Add(identity)
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+	}
+
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:39")
+	result := &eaptls.Result{
+		HWAddress:   hwAddr,
+		Certificate: &x509.Certificate{Subject: pkix.Name{CommonName: "test-authorize-device"}},
+	}
+	err := authorizeStation(result)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.True(t, identities.IsAuthenticated(hwAddr))
+	// the device is bound when the station is connected
+	assert.Nil(t, getDeviceByHWAddr(hwAddr))
+
+	bindAuthenticatedStation(hwAddr)
+	device := getDeviceByIdentity("test-authorize-device")
+	if device == nil {
+		t.Fatalf("Failed device is not bound")
+	}
+	defer devices.Remove(device)
+	assert.Equal(t, hwAddr.String(), device.HWAddress.String())
+
+	for _, name := range []string{"unknown", "test-unknown-pkg"} {
+		result.Certificate.Subject.CommonName = name
+		assert.NotNil(t, authorizeStation(result), name)
+	}
+}
+
+func TestPostIdentity(t *testing.T) {
+	origIdentities := identities
+	defer func() {
+		identities = origIdentities
+	}()
+	var cleanup func()
+	identities, cleanup = newTestIdentityRegistry(t)
+	defer cleanup()
+
+	body, _ := json.Marshal(&DeviceIdentity{Identity: "test-post-device"})
+	req := httptest.NewRequest("POST", "/identity", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotNil(t, identities.Get("test-post-device"))
+
+	// the package must be loaded as the station is rejected otherwise
+	body, _ = json.Marshal(&DeviceIdentity{Identity: "test-unknown-pkg", PkgID: uuid.New()})
+	req = httptest.NewRequest("POST", "/identity", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Nil(t, identities.Get("test-unknown-pkg"))
+}
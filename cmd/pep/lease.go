@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/atomicfile"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+	"github.com/vishvananda/netlink"
+)
+
+// Lease is an IPv4 address assigned to device by DHCP
+type Lease struct {
+	HWAddress   string    `json:"hwAddress"`
+	IPAddress   string    `json:"ipAddress"`
+	Expiry      time.Time `json:"expiry"`
+	LastRenewal time.Time `json:"lastRenewal"`
+	Renewals    uint64    `json:"renewals"`
+	Static      bool      `json:"static,omitempty"`
+}
+
+// Reservation is an IPv4 address always assigned to device
+type Reservation struct {
+	HWAddress string `json:"hwAddress" binding:"required"`
+	IPAddress string `json:"ipAddress" binding:"required"`
+}
+
+type leaseFile struct {
+	Leases       []*Lease       `json:"leases"`
+	Reservations []*Reservation `json:"reservations"`
+}
+
+// LeaseDB tracks leases and reservations of the addresses in pool and persists them to file
+type LeaseDB struct {
+	mu           sync.Mutex
+	path         string
+	pool         *ofswitch.IP4AddrPool
+	leaseTime    time.Duration
+	leases       map[string]*Lease
+	reservations map[string]*Reservation
+}
+
+// NewLeaseDB creates database assigning addresses of pool for leaseTime
+func NewLeaseDB(path string, pool *ofswitch.IP4AddrPool, leaseTime time.Duration) *LeaseDB {
+	return &LeaseDB{
+		path:         path,
+		pool:         pool,
+		leaseTime:    leaseTime,
+		leases:       map[string]*Lease{},
+		reservations: map[string]*Reservation{},
+	}
+}
+
+func (db *LeaseDB) toAddr(ip net.IP) (*netlink.Addr, error) {
+	return netlink.ParseAddr(fmt.Sprintf("%v/%v", ip, db.pool.SubnetLength()))
+}
+
+// Load reads leases and reservations and takes their addresses from pool.
+// Expired leases are dropped.
+func (db *LeaseDB) Load(now time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	bytes, err := ioutil.ReadFile(db.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	file := leaseFile{}
+	err = json.Unmarshal(bytes, &file)
+	if err != nil {
+		return err
+	}
+
+	for _, reservation := range file.Reservations {
+		addr, err := db.toAddr(net.ParseIP(reservation.IPAddress))
+		if err != nil {
+			return err
+		}
+		err = db.pool.LeaseWithAddr(addr)
+		if err != nil {
+			log.Printf("error: Failed to restore reservation %v for %v %v", reservation.IPAddress, reservation.HWAddress, err)
+			continue
+		}
+		db.reservations[reservation.HWAddress] = reservation
+	}
+
+	for _, lease := range file.Leases {
+		if !lease.Expiry.After(now) {
+			continue
+		}
+		reservation, ok := db.reservations[lease.HWAddress]
+		if ok && reservation.IPAddress == lease.IPAddress {
+			db.leases[lease.HWAddress] = lease
+			continue
+		}
+		addr, err := db.toAddr(net.ParseIP(lease.IPAddress))
+		if err != nil {
+			return err
+		}
+		err = db.pool.LeaseWithAddr(addr)
+		if err != nil {
+			log.Printf("error: Failed to restore lease %v for %v %v", lease.IPAddress, lease.HWAddress, err)
+			continue
+		}
+		lease.Static = false
+		db.leases[lease.HWAddress] = lease
+	}
+
+	return nil
+}
+
+func (db *LeaseDB) save() error {
+	file := leaseFile{
+		Leases:       []*Lease{},
+		Reservations: []*Reservation{},
+	}
+	for _, lease := range db.leases {
+		file.Leases = append(file.Leases, lease)
+	}
+	for _, reservation := range db.reservations {
+		file.Reservations = append(file.Reservations, reservation)
+	}
+	bytes, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(db.path, bytes, 0600)
+}
+
+// Acquire assigns address to device or renews its lease.
+// Reserved address is assigned to the device reserving it.
+func (db *LeaseDB) Acquire(hwAddr net.HardwareAddr, now time.Time) (*netlink.Addr, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	lease, ok := db.leases[hwAddr.String()]
+	reservation, reserved := db.reservations[hwAddr.String()]
+	if ok && reserved && lease.IPAddress != reservation.IPAddress {
+		// the address is reserved after the lease
+		err := db.pool.Release(net.ParseIP(lease.IPAddress))
+		if err != nil {
+			return nil, err
+		}
+		delete(db.leases, hwAddr.String())
+		ok = false
+	}
+
+	if ok {
+		lease.Expiry = now.Add(db.leaseTime)
+		lease.LastRenewal = now
+		lease.Renewals++
+	} else {
+		lease = &Lease{
+			HWAddress:   hwAddr.String(),
+			Expiry:      now.Add(db.leaseTime),
+			LastRenewal: now,
+			Static:      reserved,
+		}
+		if reserved {
+			lease.IPAddress = reservation.IPAddress
+		} else {
+			addr, err := db.pool.Lease()
+			if err != nil {
+				return nil, err
+			}
+			lease.IPAddress = addr.IP.String()
+		}
+		db.leases[lease.HWAddress] = lease
+	}
+
+	err := db.save()
+	if err != nil {
+		log.Printf("error: Failed to save leases %v", err)
+	}
+
+	return db.toAddr(net.ParseIP(lease.IPAddress))
+}
+
+// Release ends lease of device and returns its address to pool unless reserved
+func (db *LeaseDB) Release(hwAddr net.HardwareAddr) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	lease, ok := db.leases[hwAddr.String()]
+	if !ok {
+		return fmt.Errorf("lease for %v not found", hwAddr)
+	}
+	delete(db.leases, hwAddr.String())
+	if !lease.Static {
+		err := db.pool.Release(net.ParseIP(lease.IPAddress))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := db.save()
+	if err != nil {
+		log.Printf("error: Failed to save leases %v", err)
+	}
+
+	return nil
+}
+
+// Expired returns hwaddrs of devices whose leases are expired
+func (db *LeaseDB) Expired(now time.Time) []net.HardwareAddr {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	hwAddrs := []net.HardwareAddr{}
+	for _, lease := range db.leases {
+		if lease.Expiry.After(now) {
+			continue
+		}
+		hwAddr, err := net.ParseMAC(lease.HWAddress)
+		if err != nil {
+			continue
+		}
+		hwAddrs = append(hwAddrs, hwAddr)
+	}
+	return hwAddrs
+}
+
+// Reserve reserves address for device taking it from pool
+func (db *LeaseDB) Reserve(hwAddr net.HardwareAddr, ip net.IP) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.reservations[hwAddr.String()]; ok {
+		return fmt.Errorf("address for %v is already reserved", hwAddr)
+	}
+	addr, err := db.toAddr(ip)
+	if err != nil {
+		return err
+	}
+
+	lease, ok := db.leases[hwAddr.String()]
+	if ok && lease.IPAddress == ip.String() {
+		// the device keeps leased address
+		lease.Static = true
+	} else {
+		err = db.pool.LeaseWithAddr(addr)
+		if err != nil {
+			return err
+		}
+	}
+	db.reservations[hwAddr.String()] = &Reservation{
+		HWAddress: hwAddr.String(),
+		IPAddress: ip.String(),
+	}
+
+	err = db.save()
+	if err != nil {
+		log.Printf("error: Failed to save leases %v", err)
+	}
+
+	return nil
+}
+
+// Unreserve removes reservation and returns the address to pool unless leased
+func (db *LeaseDB) Unreserve(hwAddr net.HardwareAddr) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	reservation, ok := db.reservations[hwAddr.String()]
+	if !ok {
+		return fmt.Errorf("reservation for %v not found", hwAddr)
+	}
+	delete(db.reservations, hwAddr.String())
+
+	lease, ok := db.leases[hwAddr.String()]
+	if ok && lease.IPAddress == reservation.IPAddress {
+		// the address is released when the lease ends
+		lease.Static = false
+	} else {
+		err := db.pool.Release(net.ParseIP(reservation.IPAddress))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := db.save()
+	if err != nil {
+		log.Printf("error: Failed to save leases %v", err)
+	}
+
+	return nil
+}
+
+// GetAll returns all leases
+func (db *LeaseDB) GetAll() []*Lease {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	leases := []*Lease{}
+	for _, lease := range db.leases {
+		copied := *lease
+		leases = append(leases, &copied)
+	}
+	return leases
+}
+
+// GetReservations returns all reservations
+func (db *LeaseDB) GetReservations() []*Reservation {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	reservations := []*Reservation{}
+	for _, reservation := range db.reservations {
+		copied := *reservation
+		reservations = append(reservations, &copied)
+	}
+	return reservations
+}
+
+// expireLeases tears down devices whose leases are expired
+func expireLeases(now time.Time) {
+	for _, hwAddr := range leases.Expired(now) {
+		log.Printf("info: lease for %v is expired", hwAddr)
+		device := getDeviceByHWAddr(hwAddr)
+		if device != nil {
+			teardownDevice(device)
+		}
+		// the device may be registered without the leased address
+		err := leases.Release(hwAddr)
+		if err == nil {
+			log.Printf("info: released lease for %v", hwAddr)
+		}
+	}
+}
+
+func startLeaseExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		expireLeases(now)
+	}
+}
+
+func releaseDeviceLease(device *app.Device) {
+	err := leases.Release(device.HWAddress)
+	if err == nil {
+		return
+	}
+	// addresses leased before the lease database
+	err = extAddrPool.Release(device.IPAddress.IP)
+	if err != nil {
+		log.Printf("error: Failed to release %v %v", device.IPAddress.IP, err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func newTestLeaseDB(t *testing.T, path string) (*LeaseDB, *ofswitch.IP4AddrPool) {
+	subnet, err := netlink.ParseAddr("192.168.30.0/24")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	pool := ofswitch.NewIP4AddrPool(subnet)
+	return NewLeaseDB(path, pool, time.Minute), pool
+}
+
+func TestLeaseDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pep-lease")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "leases.json")
+
+	db, pool := newTestLeaseDB(t, path)
+	now := time.Now()
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:39")
+
+	addr, err := db.Acquire(hwAddr, now)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "192.168.30.1/24", addr.String())
+
+	// renewal keeps the address and extends expiry
+	renewed, err := db.Acquire(hwAddr, now.Add(30*time.Second))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, addr.String(), renewed.String())
+	assert.Equal(t, uint64(1), db.GetAll()[0].Renewals)
+	assert.Equal(t, 0, len(db.Expired(now.Add(time.Minute))))
+	assert.Equal(t, []net.HardwareAddr{hwAddr}, db.Expired(now.Add(2*time.Minute)))
+
+	// leases survive restarts
+	loaded, loadedPool := newTestLeaseDB(t, path)
+	err = loaded.Load(now)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 1, len(loaded.GetAll()))
+	assert.NotNil(t, loadedPool.LeaseWithAddr(addr))
+
+	// expired leases are dropped on load
+	expired, expiredPool := newTestLeaseDB(t, path)
+	err = expired.Load(now.Add(2 * time.Minute))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 0, len(expired.GetAll()))
+	assert.Nil(t, expiredPool.LeaseWithAddr(addr))
+
+	err = db.Release(hwAddr)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.NotNil(t, db.Release(hwAddr))
+	assert.Nil(t, pool.LeaseWithAddr(addr))
+}
+
+func TestLeaseDBReservation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pep-lease")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "leases.json")
+
+	db, pool := newTestLeaseDB(t, path)
+	now := time.Now()
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:40")
+	other, _ := net.ParseMAC("02:00:00:00:00:41")
+	reserved := net.ParseIP("192.168.30.100").To4()
+
+	err = db.Reserve(hwAddr, reserved)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.NotNil(t, db.Reserve(other, reserved))
+
+	addr, err := db.Acquire(hwAddr, now)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "192.168.30.100/24", addr.String())
+	assert.True(t, db.GetAll()[0].Static)
+
+	// reserved address is not returned to pool when the lease ends
+	err = db.Release(hwAddr)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	addr, err = db.Acquire(other, now)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.NotEqual(t, reserved.String(), addr.IP.String())
+
+	loaded, loadedPool := newTestLeaseDB(t, path)
+	err = loaded.Load(now)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 1, len(loaded.GetReservations()))
+	reservedAddr, _ := netlink.ParseAddr("192.168.30.100/24")
+	assert.NotNil(t, loadedPool.LeaseWithAddr(reservedAddr))
+
+	err = db.Unreserve(hwAddr)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Nil(t, pool.LeaseWithAddr(reservedAddr))
+}
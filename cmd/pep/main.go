@@ -15,9 +15,11 @@ import (
 	"github.com/google/uuid"
 	"github.com/naoki9911/CREBAS/pkg/app"
 	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/nat"
 	"github.com/naoki9911/CREBAS/pkg/netlinkext"
 	"github.com/naoki9911/CREBAS/pkg/ofswitch"
 	"github.com/naoki9911/CREBAS/pkg/pkg"
+	"github.com/naoki9911/CREBAS/pkg/resolver"
 	"github.com/naoki9911/gofc"
 	"github.com/vishvananda/netlink"
 )
@@ -29,9 +31,26 @@ var aclOfs = &ofswitch.OFSwitch{}
 var extOfs = &ofswitch.OFSwitch{}
 var appAddrPool = &ofswitch.IP4AddrPool{}
 var extAddrPool = &ofswitch.IP4AddrPool{}
+var extAddr6Pool = &ofswitch.IP6AddrPool{}
+var leases = &LeaseDB{}
 var controller = gofc.NewOFController()
-var dnsServer = "8.8.8.8:53"
+var dnsResolver = &resolver.Resolver{}
+var dnsQueries = NewDNSQueryLog(pepConfig.dnsQueryHistory)
+var dnsClients = NewDNSClientIndex()
+var dnsAnswers = NewDNSAnswerCache()
 var pepConfig = NewConfig()
+var traffic = NewTrafficAccounting(pepConfig.statsHistory)
+var spoofing = NewSpoofingMonitor(pepConfig.spoofingAlerts)
+var deniedAccesses = NewDeniedAccessLog(pepConfig.deniedHistory, time.Minute)
+var deniedPackets = make(chan *ofswitch.PacketInfo, pepConfig.deniedQueueSize)
+var stations = NewStationLifecycle(pepConfig.stationTeardownDelay, teardownDevice)
+var psks = NewPSKStore(pepConfig.wpaPSKFile)
+var identities = NewIdentityRegistry(pepConfig.identityRegistryPath)
+var registry = NewDeviceRegistry(pepConfig.deviceRegistryPath)
+var fingerprintRules = NewFingerprintRules(pepConfig.fingerprintRulesPath)
+var networkConfigs = NewNetworkConfigStore(pepConfig.networkConfigPath, pepConfig.networkConfig)
+var proxyConnections = NewProxyConnectionLog(pepConfig.egressProxyHistory)
+var egressNAT *nat.NAT
 var pepID uuid.UUID
 var certificate *x509.Certificate
 var privateKey *rsa.PrivateKey
@@ -53,12 +72,52 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	err = pkgs.LoadPkgs(pepConfig.pkgDir)
+	if err != nil {
+		log.Printf("error: Failed to load packages from %v %v", pepConfig.pkgDir, err)
+	}
+	err = identities.Load()
+	if err != nil {
+		panic(err)
+	}
 	err = prepareTestPkg()
 	if err != nil {
 		panic(err)
 	}
+	err = setupDeviceRegistry()
+	if err != nil {
+		panic(err)
+	}
+	err = fingerprintRules.Load()
+	if err != nil {
+		panic(err)
+	}
+	err = networkConfigs.Load()
+	if err != nil {
+		panic(err)
+	}
+	setupDevicePSKs()
+	dnsResolver, err = resolver.NewResolverFromURLs(pepConfig.dnsUpstreams, nil, pepConfig.dnsUpstreamTimeout)
+	if err != nil {
+		panic(err)
+	}
+	dnsResolver.Cache = resolver.NewCache(pepConfig.dnsCacheSize)
 	go startDNSServer(aclOfs)
+	if pepConfig.egressProxy {
+		err = setupEgressProxy(aclOfs)
+		if err != nil {
+			panic(err)
+		}
+		defer egressNAT.Close()
+		go startEgressProxy(aclOfs)
+	}
+	go startTrafficAccounting(extOfs, aclOfs, pepConfig.statsInterval)
+	go startDeniedPacketWorker()
 	go StartDHCPServer()
+	go startRouterAdvertisement(pepConfig.routerAdvInterval)
+	go startLeaseExpiry(pepConfig.leaseExpiryInterval)
+	go startHostapdMonitor(pepConfig.hostapdCtrlPath)
+	go startEAPTLSServer()
 	StartAPIServer()
 }
 
@@ -154,6 +213,8 @@ func waitOFSwitchConnectedToController(c *ofswitch.OFSwitch) {
 
 func prepareNetwork() error {
 	aclOfs = ofswitch.NewOFSwitch(pepConfig.aclOfsName)
+	aclOfs.SetServicePorts(pepConfig.servicePorts)
+	aclOfs.SetPortStatusHandler(handlePortStatus)
 	aclOfs.Delete()
 	err := aclOfs.Create()
 	if err != nil {
@@ -174,7 +235,19 @@ func prepareNetwork() error {
 		return err
 	}
 
+	// apps reach the host and the addresses resolved by DNS only
+	appendOFSwitchToController(aclOfs)
+	err = aclOfs.SetController("tcp:127.0.0.1:6653")
+	if err != nil {
+		return err
+	}
+	waitOFSwitchConnectedToController(aclOfs)
+
 	extOfs = ofswitch.NewOFSwitch(pepConfig.extOfsName)
+	extOfs.SetPuntRate(pepConfig.puntRate)
+	extOfs.SetDiscoveryRate(pepConfig.discoveryRate)
+	extOfs.SetPacketInHandler(handlePacketIn)
+	extOfs.SetPortStatusHandler(handlePortStatus)
 	extOfs.Delete()
 	err = extOfs.Create()
 	if err != nil {
@@ -205,6 +278,34 @@ func prepareNetwork() error {
 	if err != nil {
 		return err
 	}
+	leases = NewLeaseDB(pepConfig.leaseDBPath, extAddrPool, pepConfig.dhcpLeaseTime)
+	err = leases.Load(time.Now())
+	if err != nil {
+		return err
+	}
+
+	addr6, err := netlink.ParseAddr(pepConfig.extOfsAddr6)
+	if err != nil {
+		return err
+	}
+	err = extOfs.SetAddr(addr6)
+	if err != nil {
+		return err
+	}
+	extAddr6Pool = ofswitch.NewIP6AddrPool(addr6)
+	err = extAddr6Pool.LeaseWithAddr(addr6)
+	if err != nil {
+		return err
+	}
+
+	extAppAddr6, err := netlink.ParseAddr(pepConfig.extOfsAppAddr6)
+	if err != nil {
+		return err
+	}
+	err = extAddr6Pool.LeaseWithAddr(extAppAddr6)
+	if err != nil {
+		return err
+	}
 
 	err = extOfs.SetController("tcp:127.0.0.1:6653")
 	if err != nil {
@@ -254,12 +355,13 @@ func prepareTestPkg() error {
 	if err != nil {
 		return err
 	}
+	pkgs.Add(pkg1)
 
-	deviceIP, err := extAddrPool.Lease()
+	hwAddr, err := net.ParseMAC("58:cb:52:56:73:21")
 	if err != nil {
 		return err
 	}
-	hwAddr, err := net.ParseMAC("58:cb:52:56:73:21")
+	deviceIP, err := leases.Acquire(hwAddr, time.Now())
 	if err != nil {
 		return err
 	}
@@ -269,10 +371,21 @@ func prepareTestPkg() error {
 		App:       proc1,
 		OfPort:    pepConfig.wifiLink.GetOfPort(),
 		ViaWlan:   true,
+		Identity:  "test-virt-dev-1",
+		VendorID:  pkg1.MetaInfo.VendorID,
+	}
+	err = identities.Add(&DeviceIdentity{
+		Identity: device.Identity,
+		PkgID:    pkg1.MetaInfo.PkgID,
+		VendorID: pkg1.MetaInfo.VendorID,
+	})
+	if err != nil {
+		return err
 	}
 	proc1.SetDevice(device)
 
 	devices.Add(device)
+	dnsClients.AddDevice(device)
 
 	pkg2 := pkg.CreateSkeltonPackageInfo()
 	pkg2.MetaInfo.CMD = []string{"/bin/bash", "-c", "while true; do sleep 1; done"}
@@ -302,12 +415,13 @@ func prepareTestPkg() error {
 	if err != nil {
 		return err
 	}
+	pkgs.Add(pkg2)
 
-	device2IP, err := extAddrPool.Lease()
+	hwAddr2, err := net.ParseMAC("80:7d:3a:c8:2b:5c")
 	if err != nil {
 		return err
 	}
-	hwAddr2, err := net.ParseMAC("80:7d:3a:c8:2b:5c")
+	device2IP, err := leases.Acquire(hwAddr2, time.Now())
 	if err != nil {
 		return err
 	}
@@ -317,10 +431,21 @@ func prepareTestPkg() error {
 		App:       proc2,
 		OfPort:    pepConfig.wifiLink.GetOfPort(),
 		ViaWlan:   true,
+		Identity:  "test-virt-dev-2",
+		VendorID:  pkg2.MetaInfo.VendorID,
+	}
+	err = identities.Add(&DeviceIdentity{
+		Identity: device2.Identity,
+		PkgID:    pkg2.MetaInfo.PkgID,
+		VendorID: pkg2.MetaInfo.VendorID,
+	})
+	if err != nil {
+		return err
 	}
 	proc2.SetDevice(device2)
 
 	devices.Add(device2)
+	dnsClients.AddDevice(device2)
 
 	return nil
 }
@@ -353,7 +478,11 @@ func setupWiFi() error {
 
 	pepConfig.wifiLink = linkExt
 
-	err = extOfs.AddHostEAPoLFlow(linkExt)
+	return addWiFiHostFlows(linkExt)
+}
+
+func addWiFiHostFlows(linkExt *netlinkext.LinkExt) error {
+	err := extOfs.AddHostEAPoLFlow(linkExt)
 	if err != nil {
 		return err
 	}
@@ -365,5 +494,13 @@ func setupWiFi() error {
 	if err != nil {
 		return err
 	}
+	err = extOfs.AddHostAggregatedNDPFlow(linkExt)
+	if err != nil {
+		return err
+	}
+	err = extOfs.AddHostAggregatedDHCPv6Flow(linkExt)
+	if err != nil {
+		return err
+	}
 	return nil
 }
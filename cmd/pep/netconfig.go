@@ -0,0 +1,458 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/atomicfile"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+)
+
+// NetworkRoute is a classless static route.
+// Empty gateway means the router of the client.
+type NetworkRoute struct {
+	Dest    string `json:"dest" binding:"required"`
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// NetworkConfig is routes, domain search list and NTP servers
+// served to devices by DHCP and applied to app namespaces
+type NetworkConfig struct {
+	Routes       []*NetworkRoute `json:"routes,omitempty"`
+	DomainSearch []string        `json:"domainSearch,omitempty"`
+	NTPServers   []string        `json:"ntpServers,omitempty"`
+}
+
+// NetworkConfigs is the default config and the ones of each device and app
+type NetworkConfigs struct {
+	Defaults *NetworkConfig               `json:"defaults"`
+	Devices  map[string]*NetworkConfig    `json:"devices"`
+	Apps     map[uuid.UUID]*NetworkConfig `json:"apps"`
+}
+
+func parseIPv4(addr string) (net.IP, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("invalid IPv4 address %v", addr)
+	}
+	return ip.To4(), nil
+}
+
+// Validate checks routes and servers are IPv4 and normalizes domains
+func (c *NetworkConfig) Validate() error {
+	for _, route := range c.Routes {
+		_, dest, err := net.ParseCIDR(route.Dest)
+		if err != nil || dest.IP.To4() == nil {
+			return fmt.Errorf("invalid route destination %v", route.Dest)
+		}
+		route.Dest = dest.String()
+		if route.Gateway != "" {
+			gateway, err := parseIPv4(route.Gateway)
+			if err != nil {
+				return err
+			}
+			route.Gateway = gateway.String()
+		}
+	}
+	for i, domain := range c.DomainSearch {
+		normalized, err := capability.NormalizeDomain(domain)
+		if err != nil {
+			return err
+		}
+		c.DomainSearch[i] = normalized
+	}
+	for i, server := range c.NTPServers {
+		ip, err := parseIPv4(server)
+		if err != nil {
+			return err
+		}
+		c.NTPServers[i] = ip.String()
+	}
+	return nil
+}
+
+func (c *NetworkConfig) copy() *NetworkConfig {
+	copied := &NetworkConfig{
+		Routes:       []*NetworkRoute{},
+		DomainSearch: append([]string{}, c.DomainSearch...),
+		NTPServers:   append([]string{}, c.NTPServers...),
+	}
+	for _, route := range c.Routes {
+		r := *route
+		copied.Routes = append(copied.Routes, &r)
+	}
+	return copied
+}
+
+// mergeNetworkConfigs returns config of configs ordered from the least specific.
+// Routes to the same destination and NTP servers are overridden by the more specific one
+// and domain search lists are ordered from the more specific one.
+func mergeNetworkConfigs(configs ...*NetworkConfig) *NetworkConfig {
+	merged := &NetworkConfig{
+		Routes:       []*NetworkRoute{},
+		DomainSearch: []string{},
+		NTPServers:   []string{},
+	}
+	for _, config := range configs {
+		if config == nil {
+			continue
+		}
+		for _, route := range config.Routes {
+			copied := *route
+			overridden := false
+			for i, r := range merged.Routes {
+				if r.Dest == route.Dest {
+					merged.Routes[i] = &copied
+					overridden = true
+				}
+			}
+			if !overridden {
+				merged.Routes = append(merged.Routes, &copied)
+			}
+		}
+		if len(config.NTPServers) != 0 {
+			merged.NTPServers = append([]string{}, config.NTPServers...)
+		}
+	}
+
+	// the more specific list is searched first
+	for i := len(configs) - 1; i >= 0; i-- {
+		if configs[i] == nil {
+			continue
+		}
+		for _, domain := range configs[i].DomainSearch {
+			if !containsString(merged.DomainSearch, domain) {
+				merged.DomainSearch = append(merged.DomainSearch, domain)
+			}
+		}
+	}
+	return merged
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// NetworkConfigStore holds network configs of devices and apps on top of the defaults
+// and persists them to file
+type NetworkConfigStore struct {
+	mu       sync.Mutex
+	path     string
+	defaults *NetworkConfig
+	devices  map[string]*NetworkConfig
+	apps     map[uuid.UUID]*NetworkConfig
+}
+
+type networkConfigFile struct {
+	Devices map[string]*NetworkConfig    `json:"devices"`
+	Apps    map[uuid.UUID]*NetworkConfig `json:"apps"`
+}
+
+// NewNetworkConfigStore creates store persisted at path with the defaults served to every device and app
+func NewNetworkConfigStore(path string, defaults *NetworkConfig) *NetworkConfigStore {
+	if defaults == nil {
+		defaults = &NetworkConfig{}
+	}
+	return &NetworkConfigStore{
+		path:     path,
+		defaults: defaults.copy(),
+		devices:  map[string]*NetworkConfig{},
+		apps:     map[uuid.UUID]*NetworkConfig{},
+	}
+}
+
+// Load reads configs of devices and apps set before
+func (s *NetworkConfigStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bytes, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	file := &networkConfigFile{}
+	err = json.Unmarshal(bytes, file)
+	if err != nil {
+		return err
+	}
+	for device, config := range file.Devices {
+		hwAddr, err := net.ParseMAC(device)
+		if err != nil {
+			return err
+		}
+		err = config.Validate()
+		if err != nil {
+			return err
+		}
+		s.devices[hwAddr.String()] = config
+	}
+	for appID, config := range file.Apps {
+		err = config.Validate()
+		if err != nil {
+			return err
+		}
+		s.apps[appID] = config
+	}
+
+	return nil
+}
+
+func (s *NetworkConfigStore) save() error {
+	bytes, err := json.MarshalIndent(&networkConfigFile{
+		Devices: s.devices,
+		Apps:    s.apps,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(s.path, bytes, 0600)
+}
+
+// SetDevice validates and sets config of device
+func (s *NetworkConfigStore) SetDevice(hwAddr net.HardwareAddr, config *NetworkConfig) error {
+	config = config.copy()
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.devices[hwAddr.String()]
+	s.devices[hwAddr.String()] = config
+	err = s.save()
+	if err != nil {
+		if ok {
+			s.devices[hwAddr.String()] = old
+		} else {
+			delete(s.devices, hwAddr.String())
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RemoveDevice removes config of device
+func (s *NetworkConfigStore) RemoveDevice(hwAddr net.HardwareAddr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.devices[hwAddr.String()]
+	if !ok {
+		return fmt.Errorf("network config of device %v not found", hwAddr)
+	}
+	delete(s.devices, hwAddr.String())
+	err := s.save()
+	if err != nil {
+		s.devices[hwAddr.String()] = old
+		return err
+	}
+
+	return nil
+}
+
+// SetApp validates and sets config of app
+func (s *NetworkConfigStore) SetApp(appID uuid.UUID, config *NetworkConfig) error {
+	config = config.copy()
+	err := config.Validate()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.apps[appID]
+	s.apps[appID] = config
+	err = s.save()
+	if err != nil {
+		if ok {
+			s.apps[appID] = old
+		} else {
+			delete(s.apps, appID)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RemoveApp removes config of app
+func (s *NetworkConfigStore) RemoveApp(appID uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.apps[appID]
+	if !ok {
+		return fmt.Errorf("network config of app %v not found", appID)
+	}
+	delete(s.apps, appID)
+	err := s.save()
+	if err != nil {
+		s.apps[appID] = old
+		return err
+	}
+
+	return nil
+}
+
+// Get returns config of device and its app merged with the defaults.
+// hwAddr is nil for app namespaces and appID is uuid.Nil for devices without app.
+func (s *NetworkConfigStore) Get(hwAddr net.HardwareAddr, appID uuid.UUID) *NetworkConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deviceConfig *NetworkConfig
+	if hwAddr != nil {
+		deviceConfig = s.devices[hwAddr.String()]
+	}
+	return mergeNetworkConfigs(s.defaults, s.apps[appID], deviceConfig)
+}
+
+// GetAll returns the defaults and configs of devices and apps
+func (s *NetworkConfigStore) GetAll() *NetworkConfigs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	configs := &NetworkConfigs{
+		Defaults: s.defaults.copy(),
+		Devices:  map[string]*NetworkConfig{},
+		Apps:     map[uuid.UUID]*NetworkConfig{},
+	}
+	for hwAddr, config := range s.devices {
+		configs.Devices[hwAddr] = config.copy()
+	}
+	for appID, config := range s.apps {
+		configs.Apps[appID] = config.copy()
+	}
+	return configs
+}
+
+// getDHCPRoutes returns classless static routes of config.
+// The default route via router is included as clients ignore router option with them.
+func getDHCPRoutes(config *NetworkConfig, router net.IP) ([]*dhcpv4.Route, error) {
+	routes := []*dhcpv4.Route{
+		{
+			Dest:   &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			Router: router.To4(),
+		},
+	}
+	for _, route := range config.Routes {
+		_, dest, err := net.ParseCIDR(route.Dest)
+		if err != nil {
+			return nil, err
+		}
+		gateway := router.To4()
+		if route.Gateway != "" {
+			gateway, err = parseIPv4(route.Gateway)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if ones, _ := dest.Mask.Size(); ones == 0 {
+			routes[0].Router = gateway
+			continue
+		}
+		routes = append(routes, &dhcpv4.Route{
+			Dest:   dest,
+			Router: gateway,
+		})
+	}
+	return routes, nil
+}
+
+// updateDHCPNetworkOptions adds the options of config requested by client to response
+func updateDHCPNetworkOptions(req, resp *dhcpv4.DHCPv4, config *NetworkConfig, router net.IP) error {
+	if req.IsOptionRequested(dhcpv4.OptionClasslessStaticRoute) {
+		routes, err := getDHCPRoutes(config, router)
+		if err != nil {
+			return err
+		}
+		resp.Options.Update(dhcpv4.OptClasslessStaticRoute(routes...))
+	}
+
+	if req.IsOptionRequested(dhcpv4.OptionDNSDomainSearchList) && len(config.DomainSearch) != 0 {
+		resp.Options.Update(dhcpv4.OptDomainSearch(&rfc1035label.Labels{
+			Labels: config.DomainSearch,
+		}))
+	}
+
+	if req.IsOptionRequested(dhcpv4.OptionNTPServers) && len(config.NTPServers) != 0 {
+		servers := []net.IP{}
+		for _, server := range config.NTPServers {
+			ip, err := parseIPv4(server)
+			if err != nil {
+				return err
+			}
+			servers = append(servers, ip)
+		}
+		resp.Options.Update(dhcpv4.OptNTPServers(servers...))
+	}
+
+	return nil
+}
+
+// getDeviceNetworkConfig returns config served to device by DHCP
+func getDeviceNetworkConfig(hwAddr net.HardwareAddr) *NetworkConfig {
+	appID := uuid.Nil
+	if device := getDeviceByHWAddr(hwAddr); device != nil && device.App != nil {
+		appID = device.App.ID()
+	}
+	return networkConfigs.Get(hwAddr, appID)
+}
+
+// applyAppNetworkConfig sets routes and resolv.conf of app namespace.
+// The routes without gateway and the default route are via the ACL switch
+// and the routes removed from config are deleted.
+func applyAppNetworkConfig(proc *app.LinuxProcess) error {
+	router := aclOfs.Link.Addr.IP
+	config := networkConfigs.Get(nil, proc.ID())
+	routes, err := getDHCPRoutes(config, router)
+	if err != nil {
+		return err
+	}
+
+	configured := map[string]bool{}
+	for _, route := range routes {
+		if ones, _ := route.Dest.Mask.Size(); ones == 0 {
+			err = proc.SetDefaultRoute(route.Router)
+		} else {
+			err = proc.SetRoute(route.Dest, route.Router)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set route %v via %v %v", route.Dest, route.Router, err)
+		}
+		configured[route.Dest.String()] = true
+	}
+	for _, dest := range proc.GetRoutes() {
+		if configured[dest.String()] {
+			continue
+		}
+		err = proc.DeleteRoute(dest)
+		if err != nil {
+			return fmt.Errorf("failed to delete route %v %v", dest, err)
+		}
+	}
+
+	return proc.SetResolvConf(router, config.DomainSearch)
+}
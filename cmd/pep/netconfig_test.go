@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNetworkConfigValidate(t *testing.T) {
+	config := &NetworkConfig{
+		Routes: []*NetworkRoute{
+			{Dest: "10.1.2.3/16", Gateway: "192.168.20.253"},
+		},
+		DomainSearch: []string{"Home.Example."},
+		NTPServers:   []string{"192.168.20.254"},
+	}
+	err := config.Validate()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "10.1.0.0/16", config.Routes[0].Dest)
+	assert.Equal(t, "home.example", config.DomainSearch[0])
+
+	invalid := []*NetworkConfig{
+		{Routes: []*NetworkRoute{{Dest: "fd00::/64"}}},
+		{Routes: []*NetworkRoute{{Dest: "10.0.0.0/8", Gateway: "fd00::1"}}},
+		{Routes: []*NetworkRoute{{Dest: "10.0.0.1"}}},
+		{DomainSearch: []string{"a..example"}},
+		{NTPServers: []string{"ntp.example"}},
+	}
+	for _, config := range invalid {
+		assert.NotNil(t, config.Validate(), "%+v", config)
+	}
+}
+
+func TestNetworkConfigStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pep-netconfig")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "crebas", "network_configs.json")
+
+	store := NewNetworkConfigStore(path, &NetworkConfig{
+		Routes:       []*NetworkRoute{{Dest: "10.0.0.0/8"}},
+		DomainSearch: []string{"example"},
+		NTPServers:   []string{"192.168.20.254"},
+	})
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:01")
+	appID := uuid.New()
+
+	err = store.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = store.SetApp(appID, &NetworkConfig{
+		Routes:       []*NetworkRoute{{Dest: "10.0.0.0/8", Gateway: "192.168.10.253"}},
+		DomainSearch: []string{"app.example", "example"},
+	})
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = store.SetDevice(hwAddr, &NetworkConfig{
+		Routes:       []*NetworkRoute{{Dest: "172.16.0.0/12"}},
+		DomainSearch: []string{"device.example"},
+		NTPServers:   []string{"192.168.20.123"},
+	})
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.NotNil(t, store.SetDevice(hwAddr, &NetworkConfig{NTPServers: []string{"invalid"}}))
+
+	config := store.Get(hwAddr, appID)
+	assert.Equal(t, []*NetworkRoute{
+		{Dest: "10.0.0.0/8", Gateway: "192.168.10.253"},
+		{Dest: "172.16.0.0/12"},
+	}, config.Routes)
+	assert.Equal(t, []string{"device.example", "app.example", "example"}, config.DomainSearch)
+	assert.Equal(t, []string{"192.168.20.123"}, config.NTPServers)
+
+	config = store.Get(nil, uuid.Nil)
+	assert.Equal(t, []*NetworkRoute{{Dest: "10.0.0.0/8"}}, config.Routes)
+	assert.Equal(t, []string{"192.168.20.254"}, config.NTPServers)
+
+	all := store.GetAll()
+	assert.Equal(t, 1, len(all.Devices))
+	assert.Equal(t, 1, len(all.Apps))
+
+	// configs of devices and apps are kept across restarts
+	loaded := NewNetworkConfigStore(path, nil)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, all.Devices, loaded.GetAll().Devices)
+	assert.Equal(t, all.Apps, loaded.GetAll().Apps)
+
+	assert.Nil(t, store.RemoveDevice(hwAddr))
+	assert.NotNil(t, store.RemoveDevice(hwAddr))
+	assert.Nil(t, store.RemoveApp(appID))
+	assert.NotNil(t, store.RemoveApp(appID))
+
+	loaded = NewNetworkConfigStore(path, nil)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 0, len(loaded.GetAll().Devices))
+	assert.Equal(t, 0, len(loaded.GetAll().Apps))
+}
+
+func TestUpdateDHCPNetworkOptions(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:01")
+	req, err := dhcpv4.NewDiscovery(hwAddr, dhcpv4.WithRequestedOptions(
+		dhcpv4.OptionClasslessStaticRoute,
+		dhcpv4.OptionDNSDomainSearchList,
+		dhcpv4.OptionNTPServers,
+	))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	router := net.ParseIP("192.168.20.254")
+	config := &NetworkConfig{
+		Routes: []*NetworkRoute{
+			{Dest: "10.0.0.0/8", Gateway: "192.168.20.253"},
+			{Dest: "172.16.0.0/12"},
+		},
+		DomainSearch: []string{"home.example"},
+		NTPServers:   []string{"192.168.20.123"},
+	}
+	err = updateDHCPNetworkOptions(req, resp, config, router)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	// the default route is served with the classless routes
+	routes := dhcpv4.Routes{}
+	err = routes.FromBytes(resp.Options.Get(dhcpv4.OptionClasslessStaticRoute))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 3, len(routes))
+	assert.Equal(t, "0.0.0.0/0", routes[0].Dest.String())
+	assert.True(t, routes[0].Router.Equal(router))
+	assert.Equal(t, "10.0.0.0/8", routes[1].Dest.String())
+	assert.True(t, routes[1].Router.Equal(net.ParseIP("192.168.20.253")))
+	assert.True(t, routes[2].Router.Equal(router))
+
+	assert.Equal(t, []string{"home.example"}, resp.DomainSearch().Labels)
+	assert.Equal(t, 1, len(resp.NTPServers()))
+	assert.True(t, resp.NTPServers()[0].Equal(net.ParseIP("192.168.20.123")))
+
+	// options not requested are not served
+	req.Options.Update(dhcpv4.OptParameterRequestList(dhcpv4.OptionSubnetMask))
+	resp, _ = dhcpv4.NewReplyFromRequest(req)
+	err = updateDHCPNetworkOptions(req, resp, config, router)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionClasslessStaticRoute))
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionNTPServers))
+}
+
+func TestPostDeviceNetworkConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pep-netconfig")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	savedConfigs := networkConfigs
+	networkConfigs = NewNetworkConfigStore(filepath.Join(dir, "network_configs.json"), nil)
+	defer func() { networkConfigs = savedConfigs }()
+
+	body, _ := json.Marshal(&NetworkConfig{
+		Routes: []*NetworkRoute{{Dest: "10.0.0.0/8"}},
+	})
+	req := httptest.NewRequest("POST", "/network/device/02:00:00:00:00:01", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, len(networkConfigs.GetAll().Devices))
+
+	body, _ = json.Marshal(&NetworkConfig{
+		Routes: []*NetworkRoute{{Dest: "invalid"}},
+	})
+	req = httptest.NewRequest("POST", "/network/device/02:00:00:00:00:01", bytes.NewReader(body))
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	req = httptest.NewRequest("DELETE", "/network/device/02:00:00:00:00:01", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req = httptest.NewRequest("DELETE", "/network/device/02:00:00:00:00:01", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+)
+
+func getAppByLink(link *netlinkext.LinkExt) *app.LinuxProcess {
+	if link == nil {
+		return nil
+	}
+	for _, a := range apps.GetAll() {
+		proc, ok := a.(*app.LinuxProcess)
+		if !ok {
+			continue
+		}
+		links := proc.Links().Where(func(l *netlinkext.LinkExt) bool {
+			return l == link
+		})
+		if len(links) != 0 {
+			return proc
+		}
+	}
+	return nil
+}
+
+// deleteLinkAdmissionFlows deletes admission flows of the links of app on the external switch
+func deleteLinkAdmissionFlows(a app.AppInterface) {
+	for _, link := range a.Links().Where(func(l *netlinkext.LinkExt) bool { return l.OfType == netlinkext.ExternalOFSwitch }) {
+		if link.GetLink() == nil {
+			continue
+		}
+		err := extOfs.DeleteAdmissionFlow(link)
+		if err != nil {
+			log.Printf("error: Failed to delete admission flow of link %v %v", link.GetLink().Attrs().Name, err)
+		}
+	}
+}
+
+// failApp revokes flows of app losing its link and releases its addresses.
+// The app is started again by DHCP request from its device.
+func failApp(proc *app.LinuxProcess, reason string) {
+	if proc.IsFailed() {
+		return
+	}
+	log.Printf("warning: app %v failed: %v", proc.ID(), reason)
+
+	for _, cap := range proc.Capabilities().GetAll() {
+		err := revokeCapability(cap)
+		if err != nil {
+			log.Printf("error: Failed to revoke cap(%v) %v", cap.CapabilityID, err)
+		}
+	}
+
+	device := proc.GetDevice()
+	if device != nil {
+		err := extOfs.DeleteAdmissionFlow(device)
+		if err != nil {
+			log.Printf("error: Failed to delete admission flow of device %v %v", device.HWAddress, err)
+		}
+	}
+	deleteLinkAdmissionFlows(proc)
+
+	for _, link := range proc.Links().Where(func(l *netlinkext.LinkExt) bool { return l.Addr != nil }) {
+		if appAddrPool.Release(link.Addr.IP) == nil {
+			log.Printf("info: released %v of app %v", link.Addr.IP, proc.ID())
+		}
+	}
+
+	err := proc.MarkFailed(reason)
+	if err != nil {
+		log.Printf("error: Failed to mark app(%v) failed %v", proc.ID(), err)
+	}
+}
+
+func handleWiFiPortStatus(status *ofswitch.PortStatus) {
+	wifiLink := pepConfig.wifiLink
+
+	switch status.Reason {
+	case ofswitch.PortReasonDelete:
+		for _, device := range devices.Where(func(d *app.Device) bool { return d.ViaWlan }) {
+			spoofing.Unbind(device.HWAddress)
+			if proc, ok := device.App.(*app.LinuxProcess); ok && proc.IsRunning() {
+				failApp(proc, fmt.Sprintf("Wi-Fi port %v is deleted", status.Name))
+			}
+		}
+	case ofswitch.PortReasonAdd:
+		// the port may be re-numbered when the interface comes back
+		log.Printf("info: Wi-Fi port %v is added as %v", status.Name, status.PortNo)
+		wifiLink.Ofport = status.PortNo
+		for _, device := range devices.Where(func(d *app.Device) bool { return d.ViaWlan }) {
+			device.OfPort = status.PortNo
+		}
+		err := addWiFiHostFlows(wifiLink)
+		if err != nil {
+			log.Printf("error: Failed to add flows for Wi-Fi port %v", err)
+		}
+	}
+}
+
+// handlePortStatus cleans up apps and devices whose ports are deleted from switches
+func handlePortStatus(status *ofswitch.PortStatus) {
+	wifiLink := pepConfig.wifiLink
+	if status.Switch == extOfs.Name && wifiLink != nil && status.Name == wifiLink.GetLink().Attrs().Name {
+		handleWiFiPortStatus(status)
+		return
+	}
+
+	if status.Reason != ofswitch.PortReasonDelete {
+		return
+	}
+
+	proc := getAppByLink(status.Link)
+	if proc == nil {
+		return
+	}
+	failApp(proc, fmt.Sprintf("port %v(%v) of switch(%v) is deleted", status.Name, status.PortNo, status.Switch))
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/nat"
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+	"golang.org/x/sys/unix"
+)
+
+// Protocols of the connections steered to the egress proxy
+const (
+	ProxyProtocolHTTP = "http"
+	ProxyProtocolTLS  = "tls"
+)
+
+// Decisions of the connections steered to the egress proxy
+const (
+	ProxyAllowed = "allowed"
+	ProxyDenied  = "denied"
+	ProxyFailed  = "failed"
+)
+
+// tlsRecordTypeHandshake is the first byte of ClientHello
+const tlsRecordTypeHandshake = 0x16
+
+// ProxyConnection is a connection from app steered to the egress proxy and the decision by policy
+type ProxyConnection struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Client       string    `json:"client"`
+	Destination  string    `json:"destination"`
+	Protocol     string    `json:"protocol,omitempty"`
+	ServerName   string    `json:"serverName,omitempty"`
+	Decision     string    `json:"decision"`
+	CapabilityID uuid.UUID `json:"capabilityID,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// ProxyConnectionLog holds the latest connections of each app
+type ProxyConnectionLog struct {
+	mu          sync.Mutex
+	connections map[uuid.UUID][]*ProxyConnection
+	maxHistory  int
+}
+
+// NewProxyConnectionLog creates log holding the latest maxHistory connections per app
+func NewProxyConnectionLog(maxHistory int) *ProxyConnectionLog {
+	return &ProxyConnectionLog{
+		connections: map[uuid.UUID][]*ProxyConnection{},
+		maxHistory:  maxHistory,
+	}
+}
+
+// Add records connection of app
+func (l *ProxyConnectionLog) Add(appID uuid.UUID, connection *ProxyConnection) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	connections := append(l.connections[appID], connection)
+	if len(connections) > l.maxHistory {
+		connections = connections[len(connections)-l.maxHistory:]
+	}
+	l.connections[appID] = connections
+}
+
+// Get returns connections of app ordered from the oldest, or nil if app has not connected
+func (l *ProxyConnectionLog) Get(appID uuid.UUID) []*ProxyConnection {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	connections, ok := l.connections[appID]
+	if !ok {
+		return nil
+	}
+	copied := make([]*ProxyConnection, len(connections))
+	copy(copied, connections)
+	return copied
+}
+
+// readOnlyConn feeds ClientHello to TLS server and fails its replies
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+func (c *readOnlyConn) Read(b []byte) (int, error)         { return c.reader.Read(b) }
+func (c *readOnlyConn) Write(b []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c *readOnlyConn) Close() error                       { return nil }
+func (c *readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c *readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c *readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c *readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// readClientHelloServerName returns SNI of ClientHello read from reader.
+// The handshake is aborted once ClientHello is parsed.
+func readClientHelloServerName(reader io.Reader) (string, error) {
+	serverName := ""
+	parsed := false
+	err := tls.Server(&readOnlyConn{reader: reader}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			parsed = true
+			return nil, fmt.Errorf("ClientHello is read")
+		},
+	}).Handshake()
+	if !parsed {
+		return "", fmt.Errorf("invalid ClientHello %v", err)
+	}
+	if serverName == "" {
+		return "", fmt.Errorf("ClientHello without SNI")
+	}
+	return serverName, nil
+}
+
+// readHTTPHost returns host of Host header without port
+func readHTTPHost(reader io.Reader) (string, error) {
+	req, err := http.ReadRequest(bufio.NewReader(reader))
+	if err != nil {
+		return "", err
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		return "", fmt.Errorf("HTTP request without Host")
+	}
+	return host, nil
+}
+
+// peekServerName reads SNI of TLS or Host of HTTP sent by client.
+// It returns the bytes read from conn to be replayed to the destination.
+func peekServerName(conn net.Conn) (string, string, []byte, error) {
+	buffered := &bytes.Buffer{}
+	reader := io.TeeReader(conn, buffered)
+
+	first := make([]byte, 1)
+	_, err := io.ReadFull(reader, first)
+	if err != nil {
+		return "", "", buffered.Bytes(), err
+	}
+	reader = io.MultiReader(bytes.NewReader(first), reader)
+
+	if first[0] == tlsRecordTypeHandshake {
+		serverName, err := readClientHelloServerName(reader)
+		return ProxyProtocolTLS, trimDomain(strings.ToLower(serverName)), buffered.Bytes(), err
+	}
+	host, err := readHTTPHost(reader)
+	return ProxyProtocolHTTP, trimDomain(strings.ToLower(host)), buffered.Bytes(), err
+}
+
+// getOriginalDst returns the destination of connection before redirected to the proxy
+func getOriginalDst(conn *net.TCPConn) (*net.TCPAddr, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	// SO_ORIGINAL_DST returns sockaddr_in fitting in ipv6_mreq
+	var addr *unix.IPv6Mreq
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		addr, sockErr = unix.GetsockoptIPv6Mreq(int(fd), unix.IPPROTO_IP, unix.SO_ORIGINAL_DST)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+
+	return &net.TCPAddr{
+		IP:   net.IPv4(addr.Multiaddr[4], addr.Multiaddr[5], addr.Multiaddr[6], addr.Multiaddr[7]),
+		Port: int(addr.Multiaddr[2])<<8 | int(addr.Multiaddr[3]),
+	}, nil
+}
+
+// spliceConnections copies both directions until they are closed
+func spliceConnections(a net.Conn, b net.Conn) {
+	done := make(chan struct{}, 2)
+	pipe := func(dst net.Conn, src net.Conn) {
+		io.Copy(dst, src)
+		if tcpConn, ok := dst.(*net.TCPConn); ok {
+			tcpConn.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+	go pipe(a, b)
+	go pipe(b, a)
+	<-done
+	<-done
+}
+
+func writeProxyDeniedResponse(conn net.Conn, protocol string, status int) {
+	// TLS clients are rejected by closing the connection
+	if protocol != ProxyProtocolHTTP {
+		return
+	}
+	fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nConnection: close\r\nContent-Length: 0\r\n\r\n", status, http.StatusText(status))
+}
+
+// writeProxyRequest sends the first HTTP request read from reader to upstream with Connection: close.
+// Following requests on the connection would carry Host not checked by policy.
+func writeProxyRequest(upstream net.Conn, reader io.Reader) error {
+	req, err := http.ReadRequest(bufio.NewReader(reader))
+	if err != nil {
+		return err
+	}
+	req.Header.Del("Connection")
+	req.Header.Del("Keep-Alive")
+	req.Close = true
+	return req.Write(upstream)
+}
+
+// isProxyAddr returns true if addr is the listener of the proxy connected without redirection
+func isProxyAddr(addr *net.TCPAddr, local net.Addr) bool {
+	localAddr, ok := local.(*net.TCPAddr)
+	return ok && addr.Port == localAddr.Port && addr.IP.Equal(localAddr.IP)
+}
+
+// connectProxyUpstream connects to dst if the app of client holds ExternalCommunication
+// allowing SNI or Host sent by client and dst was answered for it by the DNS proxy. It returns the decision and the connection to dst,
+// which is nil unless the decision is allowed.
+func connectProxyUpstream(conn net.Conn, dst *net.TCPAddr, client *DNSClient) (*ProxyConnection, net.Conn) {
+	clientIP := getRemoteIP(conn.RemoteAddr())
+	connection := &ProxyConnection{
+		Timestamp:   time.Now(),
+		Client:      clientIP.String(),
+		Destination: dst.String(),
+		Decision:    ProxyDenied,
+	}
+
+	if isProxyAddr(dst, conn.LocalAddr()) {
+		connection.Error = "not redirected to the proxy"
+		log.Printf("warning: connection from %v is not redirected", clientIP)
+		return connection, nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(pepConfig.egressProxyTimeout))
+	protocol, serverName, buffered, err := peekServerName(conn)
+	conn.SetReadDeadline(time.Time{})
+	connection.Protocol = protocol
+	connection.ServerName = serverName
+	if err != nil {
+		connection.Error = err.Error()
+		log.Printf("info: connection from %v to %v is denied %v", clientIP, dst, err)
+		writeProxyDeniedResponse(conn, protocol, http.StatusBadRequest)
+		return connection, nil
+	}
+
+	caps := client.App.Capabilities().Where(func(c *capability.Capability) bool {
+		return c.CapabilityName == capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION
+	})
+	cap := getDomainCapability(caps, serverName)
+	if cap == nil {
+		log.Printf("info: connection from %v to %v(%v) is denied", clientIP, dst, serverName)
+		writeProxyDeniedResponse(conn, protocol, http.StatusForbidden)
+		return connection, nil
+	}
+
+	// SNI or Host is trusted only for the addresses the DNS proxy answered for it
+	if !dnsAnswers.IsAnswered(client.App.ID(), serverName, dst.IP, time.Now()) {
+		connection.Error = "destination is not answered for server name"
+		log.Printf("info: connection from %v to %v(%v) is denied as not answered", clientIP, dst, serverName)
+		writeProxyDeniedResponse(conn, protocol, http.StatusForbidden)
+		return connection, nil
+	}
+	connection.CapabilityID = cap.CapabilityID
+
+	upstream, err := net.DialTimeout("tcp", dst.String(), pepConfig.egressProxyTimeout)
+	if err != nil {
+		connection.Decision = ProxyFailed
+		connection.Error = err.Error()
+		log.Printf("error: Failed to connect to %v(%v) for %v %v", dst, serverName, clientIP, err)
+		writeProxyDeniedResponse(conn, protocol, http.StatusBadGateway)
+		return connection, nil
+	}
+
+	// the bytes read for the decision are replayed to dst
+	if protocol == ProxyProtocolHTTP {
+		err = writeProxyRequest(upstream, io.MultiReader(bytes.NewReader(buffered), conn))
+	} else {
+		_, err = upstream.Write(buffered)
+	}
+	if err != nil {
+		upstream.Close()
+		connection.Decision = ProxyFailed
+		connection.Error = err.Error()
+		log.Printf("error: Failed to send to %v(%v) for %v %v", dst, serverName, clientIP, err)
+		return connection, nil
+	}
+
+	connection.Decision = ProxyAllowed
+	log.Printf("info: connection from %v to %v(%v) is allowed by %v", clientIP, dst, serverName, cap.CapabilityID)
+	return connection, upstream
+}
+
+// handleProxyConnection splices connection redirected from dst if it is allowed
+func handleProxyConnection(conn net.Conn, dst *net.TCPAddr) {
+	defer conn.Close()
+
+	client := dnsClients.Lookup(getRemoteIP(conn.RemoteAddr()))
+	if client == nil || client.App == nil {
+		log.Printf("warning: connection from unknown client %v to %v is refused", conn.RemoteAddr(), dst)
+		return
+	}
+
+	connection, upstream := connectProxyUpstream(conn, dst, client)
+	proxyConnections.Add(client.App.ID(), connection)
+	if upstream == nil {
+		return
+	}
+	defer upstream.Close()
+
+	// HTTP client has sent the only request allowed on the connection
+	if connection.Protocol == ProxyProtocolHTTP {
+		io.Copy(conn, upstream)
+		return
+	}
+	spliceConnections(conn, upstream)
+}
+
+// addEgressProxyFlow steers HTTP and TLS of app link to the proxy if enabled
+func addEgressProxyFlow(link *netlinkext.LinkExt) error {
+	if !pepConfig.egressProxy {
+		return nil
+	}
+	return aclOfs.AddEgressProxyFlow(link, pepConfig.egressProxyPorts)
+}
+
+// setupEgressProxy redirects HTTP and TLS from app links arriving at the host to the proxy
+func setupEgressProxy(s *ofswitch.OFSwitch) error {
+	egressNAT = nat.NewNAT(nil)
+	err := egressNAT.Setup()
+	if err != nil {
+		return err
+	}
+
+	return egressNAT.AddRedirect(&nat.Redirect{
+		InboundLink: s.Name,
+		Ports:       pepConfig.egressProxyPorts,
+		ToPort:      pepConfig.egressProxyPort,
+	})
+}
+
+func startEgressProxy(s *ofswitch.OFSwitch) {
+	host := net.JoinHostPort(s.Link.Addr.IP.String(), fmt.Sprint(pepConfig.egressProxyPort))
+	listener, err := net.Listen("tcp", host)
+	if err != nil {
+		log.Printf("error: Failed to start egress proxy %v", err)
+		return
+	}
+	log.Printf("info: Starting egress proxy at %v", host)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Printf("error: Failed to accept %v", err)
+			continue
+		}
+		go func() {
+			dst, err := getOriginalDst(conn.(*net.TCPConn))
+			if err != nil {
+				log.Printf("error: Failed to get original destination of %v %v", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			handleProxyConnection(conn, dst)
+		}()
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeekServerNameTLS(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: "WWW.Example.com"}).Handshake()
+		client.Close()
+	}()
+
+	protocol, serverName, buffered, err := peekServerName(server)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, ProxyProtocolTLS, protocol)
+	assert.Equal(t, "www.example.com", serverName)
+	assert.Equal(t, byte(tlsRecordTypeHandshake), buffered[0])
+}
+
+func TestPeekServerNameHTTP(t *testing.T) {
+	request := "GET / HTTP/1.1\r\nHost: Example.com:8080\r\n\r\n"
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		io.WriteString(client, request)
+		client.Close()
+	}()
+
+	protocol, serverName, buffered, err := peekServerName(server)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, ProxyProtocolHTTP, protocol)
+	assert.Equal(t, "example.com", serverName)
+	assert.Equal(t, request, string(buffered))
+
+	client, server = net.Pipe()
+	defer server.Close()
+	go func() {
+		io.WriteString(client, "GET / HTTP/1.0\r\n\r\n")
+		client.Close()
+	}()
+	_, _, _, err = peekServerName(server)
+	assert.NotNil(t, err)
+}
+
+func TestProxyConnectionLog(t *testing.T) {
+	l := NewProxyConnectionLog(2)
+	app1 := newTestApp()
+
+	assert.Nil(t, l.Get(app1.ID()))
+	for _, name := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+		l.Add(app1.ID(), &ProxyConnection{ServerName: name})
+	}
+	connections := l.Get(app1.ID())
+	assert.Equal(t, 2, len(connections))
+	assert.Equal(t, "b.example.com", connections[0].ServerName)
+	assert.Equal(t, "c.example.com", connections[1].ServerName)
+}
+
+// startTestUpstream accepts a connection and replies the first line received
+func startTestUpstream(t *testing.T) *net.TCPAddr {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		io.WriteString(conn, line)
+	}()
+	return listener.Addr().(*net.TCPAddr)
+}
+
+// proxyTestRequest sends request to dst through handleProxyConnection and returns the reply
+func proxyTestRequest(t *testing.T, dst *net.TCPAddr, request string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		handleProxyConnection(conn, dst)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(client, request)
+	reply, _ := bufio.NewReader(client).ReadString('\n')
+	return reply
+}
+
+func TestHandleProxyConnection(t *testing.T) {
+	origClients := dnsClients
+	origAnswers := dnsAnswers
+	origConnections := proxyConnections
+	defer func() {
+		dnsClients = origClients
+		dnsAnswers = origAnswers
+		proxyConnections = origConnections
+	}()
+	dnsClients = NewDNSClientIndex()
+	dnsAnswers = NewDNSAnswerCache()
+	proxyConnections = NewProxyConnectionLog(10)
+
+	app1 := newTestApp()
+	app1.addLink(t, netlinkext.ACLOFSwitch, "127.0.0.1/8")
+	cap := capability.NewCreateSkeltonCapability()
+	cap.CapabilityName = capability.CAPABILITY_NAME_EXTERNAL_COMMUNICATION
+	cap.CapabilityValue = "*.example.com"
+	app1.capabilities.Add(cap)
+	dnsClients.AddApp(app1)
+	rr, err := dns.NewRR("www.example.com. 300 IN A 127.0.0.1")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	dnsAnswers.Add(app1.ID(), []dns.RR{rr}, time.Now())
+
+	request := "GET / HTTP/1.1\r\nHost: www.example.com\r\n\r\n"
+	reply := proxyTestRequest(t, startTestUpstream(t), request)
+	assert.Equal(t, "GET / HTTP/1.1\r\n", reply)
+
+	reply = proxyTestRequest(t, startTestUpstream(t), "GET / HTTP/1.1\r\nHost: www.example.org\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 403 "+http.StatusText(http.StatusForbidden)+"\r\n", reply)
+
+	// allowed name not answered with the destination
+	reply = proxyTestRequest(t, startTestUpstream(t), "GET / HTTP/1.1\r\nHost: api.example.com\r\n\r\n")
+	assert.Equal(t, "HTTP/1.1 403 "+http.StatusText(http.StatusForbidden)+"\r\n", reply)
+
+	// the decision is recorded before the connection is closed
+	time.Sleep(100 * time.Millisecond)
+	connections := proxyConnections.Get(app1.ID())
+	if len(connections) != 3 {
+		t.Fatalf("Failed unexpected connections %v", connections)
+	}
+	assert.Equal(t, ProxyAllowed, connections[0].Decision)
+	assert.Equal(t, "www.example.com", connections[0].ServerName)
+	assert.Equal(t, cap.CapabilityID, connections[0].CapabilityID)
+	assert.Equal(t, ProxyDenied, connections[1].Decision)
+	assert.Equal(t, "www.example.org", connections[1].ServerName)
+	assert.Equal(t, ProxyDenied, connections[2].Decision)
+	assert.Equal(t, "api.example.com", connections[2].ServerName)
+}
+
+func TestWriteProxyRequest(t *testing.T) {
+	request := "POST / HTTP/1.1\r\nHost: www.example.com\r\nConnection: keep-alive\r\nContent-Length: 4\r\n\r\nbodyGET /next HTTP/1.1\r\n"
+	client, server := net.Pipe()
+	defer server.Close()
+	go func() {
+		writeProxyRequest(client, strings.NewReader(request))
+		client.Close()
+	}()
+
+	req, err := http.ReadRequest(bufio.NewReader(server))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, "www.example.com", req.Host)
+	assert.Equal(t, "body", string(body))
+	assert.Equal(t, []string{"close"}, req.Header.Values("Connection"))
+	assert.True(t, req.Close)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/hostapd"
+)
+
+const pskLength = 24
+
+// DevicePSK is a Wi-Fi passphrase issued to device
+type DevicePSK struct {
+	KeyID      string    `json:"keyID"`
+	HWAddress  string    `json:"hwAddress"`
+	PkgID      uuid.UUID `json:"pkgID,omitempty"`
+	Passphrase string    `json:"passphrase,omitempty"`
+	IssuedAt   time.Time `json:"issuedAt"`
+}
+
+// PSKStore manages wpa_psk_file of hostapd holding a passphrase per device
+type PSKStore struct {
+	mu   sync.Mutex
+	path string
+	psks map[string]*DevicePSK
+}
+
+// NewPSKStore creates store writing wpa_psk_file at path
+func NewPSKStore(path string) *PSKStore {
+	return &PSKStore{
+		path: path,
+		psks: map[string]*DevicePSK{},
+	}
+}
+
+func getDevicePkgID(device *app.Device) uuid.UUID {
+	proc, ok := device.App.(*app.LinuxProcess)
+	if !ok || proc.PkgInfo() == nil {
+		return uuid.Nil
+	}
+	return proc.PkgInfo().MetaInfo.PkgID
+}
+
+// Load reads passphrases issued before from wpa_psk_file
+func (s *PSKStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	entries, err := hostapd.ParsePSKFile(file)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		s.psks[entry.HWAddress.String()] = &DevicePSK{
+			KeyID:      entry.KeyID,
+			HWAddress:  entry.HWAddress.String(),
+			Passphrase: entry.Passphrase,
+			IssuedAt:   info.ModTime(),
+		}
+	}
+
+	return nil
+}
+
+func (s *PSKStore) save() error {
+	entries := []*hostapd.PSKEntry{}
+	for _, psk := range s.psks {
+		hwAddr, err := net.ParseMAC(psk.HWAddress)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, &hostapd.PSKEntry{
+			KeyID:      psk.KeyID,
+			HWAddress:  hwAddr,
+			Passphrase: psk.Passphrase,
+		})
+	}
+
+	return hostapd.WritePSKFile(s.path, entries)
+}
+
+// Issue issues new passphrase to device replacing the old one
+func (s *PSKStore) Issue(device *app.Device) (*DevicePSK, error) {
+	passphrase, err := hostapd.GeneratePassphrase(pskLength)
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+
+	psk := &DevicePSK{
+		KeyID:      keyID.String()[0:8],
+		HWAddress:  device.HWAddress.String(),
+		PkgID:      getDevicePkgID(device),
+		Passphrase: passphrase,
+		IssuedAt:   time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.psks[psk.HWAddress]
+	s.psks[psk.HWAddress] = psk
+	err = s.save()
+	if err != nil {
+		if old != nil {
+			s.psks[psk.HWAddress] = old
+		} else {
+			delete(s.psks, psk.HWAddress)
+		}
+		return nil, err
+	}
+
+	copied := *psk
+	return &copied, nil
+}
+
+// IssueMissing issues passphrase to Wi-Fi device without one.
+// It returns nil if the device is not Wi-Fi station or already has passphrase.
+func (s *PSKStore) IssueMissing(device *app.Device) (*DevicePSK, error) {
+	if !device.ViaWlan || s.Get(device.HWAddress) != nil {
+		return nil, nil
+	}
+
+	return s.Issue(device)
+}
+
+// Revoke removes passphrase of device
+func (s *PSKStore) Revoke(hwAddr net.HardwareAddr) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old, ok := s.psks[hwAddr.String()]
+	if !ok {
+		return fmt.Errorf("psk for %v not found", hwAddr)
+	}
+	delete(s.psks, hwAddr.String())
+	err := s.save()
+	if err != nil {
+		s.psks[hwAddr.String()] = old
+		return err
+	}
+
+	return nil
+}
+
+// Get returns passphrase of device
+func (s *PSKStore) Get(hwAddr net.HardwareAddr) *DevicePSK {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	psk, ok := s.psks[hwAddr.String()]
+	if !ok {
+		return nil
+	}
+	copied := *psk
+	return &copied
+}
+
+// GetAll returns passphrases of all devices without secrets
+func (s *PSKStore) GetAll() []*DevicePSK {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	psks := []*DevicePSK{}
+	for _, psk := range s.psks {
+		copied := *psk
+		copied.Passphrase = ""
+		psks = append(psks, &copied)
+	}
+	return psks
+}
+
+// Bind binds passphrases loaded from file to the packages of registered devices
+func (s *PSKStore) Bind(targetDevices app.DeviceSlice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, device := range targetDevices {
+		psk, ok := s.psks[device.HWAddress.String()]
+		if ok {
+			psk.PkgID = getDevicePkgID(device)
+		}
+	}
+}
+
+// Verify returns true if the station authenticated with the passphrase issued to it
+func (s *PSKStore) Verify(hwAddr net.HardwareAddr, keyID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	psk, ok := s.psks[hwAddr.String()]
+	return ok && psk.KeyID == keyID
+}
+
+func requestHostapd(fn func(*hostapd.Client) error) error {
+	client, err := hostapd.Dial(pepConfig.hostapdCtrlPath)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return fn(client)
+}
+
+// reloadDevicePSK makes hostapd use the new passphrase and disconnects the station using the old one
+func reloadDevicePSK(hwAddr net.HardwareAddr) error {
+	return requestHostapd(func(client *hostapd.Client) error {
+		err := client.ReloadPSK()
+		if err != nil {
+			return err
+		}
+		if hwAddr == nil {
+			return nil
+		}
+		// the station may not be connected
+		err = client.Deauthenticate(hwAddr)
+		if err != nil {
+			log.Printf("info: station %v is not deauthenticated %v", hwAddr, err)
+		}
+		return nil
+	})
+}
+
+// setupDevicePSKs loads passphrases and issues them to registered devices without one.
+// Failures are logged as the PEP serves devices without Wi-Fi as well.
+func setupDevicePSKs() {
+	err := psks.Load()
+	if err != nil {
+		log.Printf("error: Failed to load psk %v", err)
+		return
+	}
+	psks.Bind(devices.GetAll())
+
+	for _, device := range devices.Where(func(d *app.Device) bool { return d.ViaWlan }) {
+		psk, err := psks.IssueMissing(device)
+		if err != nil {
+			log.Printf("error: Failed to issue psk to device %v %v", device.HWAddress, err)
+			continue
+		}
+		if psk != nil {
+			log.Printf("info: issued psk %v to device %v", psk.KeyID, device.HWAddress)
+		}
+	}
+
+	err = reloadDevicePSK(nil)
+	if err != nil {
+		log.Printf("error: Failed to reload psk of hostapd %v", err)
+	}
+}
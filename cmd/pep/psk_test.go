@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPSKStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pep-psk")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hostapd.wpa_psk")
+
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:01")
+	device := &app.Device{
+		HWAddress: hwAddr,
+		ViaWlan:   true,
+	}
+
+	store := NewPSKStore(path)
+	err = store.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	psk, err := store.Issue(device)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, pskLength, len(psk.Passphrase))
+	assert.True(t, store.Verify(hwAddr, psk.KeyID))
+	assert.False(t, store.Verify(hwAddr, ""))
+	assert.Equal(t, "", store.GetAll()[0].Passphrase)
+
+	rotated, err := store.Issue(device)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.NotEqual(t, psk.KeyID, rotated.KeyID)
+	assert.False(t, store.Verify(hwAddr, psk.KeyID))
+	assert.Equal(t, 1, len(store.GetAll()))
+
+	loaded := NewPSKStore(path)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, rotated.Passphrase, loaded.Get(hwAddr).Passphrase)
+	assert.True(t, loaded.Verify(hwAddr, rotated.KeyID))
+
+	err = loaded.Revoke(hwAddr)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Nil(t, loaded.Get(hwAddr))
+	assert.NotNil(t, loaded.Revoke(hwAddr))
+
+	issued, err := loaded.IssueMissing(device)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.True(t, loaded.Verify(hwAddr, issued.KeyID))
+	// the passphrase already issued is kept
+	issued, err = loaded.IssueMissing(device)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Nil(t, issued)
+
+	wiredAddr, _ := net.ParseMAC("02:00:00:00:00:02")
+	issued, err = loaded.IssueMissing(&app.Device{HWAddress: wiredAddr})
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Nil(t, issued)
+	assert.Nil(t, loaded.Get(wiredAddr))
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/atomicfile"
+)
+
+// DeviceRecord binds hwaddr of device to its name and companion package
+type DeviceRecord struct {
+	HWAddress string    `json:"hwAddress" binding:"required"`
+	Name      string    `json:"name,omitempty"`
+	PkgID     uuid.UUID `json:"pkgID,omitempty"`
+}
+
+// DeviceUpdate is the fields of DeviceRecord changed by PATCH
+type DeviceUpdate struct {
+	Name  *string    `json:"name"`
+	PkgID *uuid.UUID `json:"pkgID"`
+}
+
+// DeviceInfo is a device with its binding
+type DeviceInfo struct {
+	*app.Device
+	AppID      uuid.UUID `json:"appID,omitempty"`
+	PkgID      uuid.UUID `json:"pkgID,omitempty"`
+	Registered bool      `json:"registered"`
+}
+
+// DeviceRegistry holds devices registered through API and persists them to file
+type DeviceRegistry struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]*DeviceRecord
+}
+
+// NewDeviceRegistry creates registry persisted at path
+func NewDeviceRegistry(path string) *DeviceRegistry {
+	return &DeviceRegistry{
+		path:    path,
+		records: map[string]*DeviceRecord{},
+	}
+}
+
+// Load reads devices registered before
+func (r *DeviceRegistry) Load() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bytes, err := ioutil.ReadFile(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	records := []*DeviceRecord{}
+	err = json.Unmarshal(bytes, &records)
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		hwAddr, err := net.ParseMAC(record.HWAddress)
+		if err != nil {
+			return err
+		}
+		record.HWAddress = hwAddr.String()
+		r.records[record.HWAddress] = record
+	}
+
+	return nil
+}
+
+func (r *DeviceRegistry) save() error {
+	records := []*DeviceRecord{}
+	for _, record := range r.records {
+		records = append(records, record)
+	}
+	bytes, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return atomicfile.WriteFile(r.path, bytes, 0600)
+}
+
+// Put adds or replaces record
+func (r *DeviceRegistry) Put(record *DeviceRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	copied := *record
+	old, ok := r.records[record.HWAddress]
+	r.records[record.HWAddress] = &copied
+	err := r.save()
+	if err != nil {
+		if ok {
+			r.records[record.HWAddress] = old
+		} else {
+			delete(r.records, record.HWAddress)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Remove removes record of device
+func (r *DeviceRegistry) Remove(hwAddr net.HardwareAddr) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.records[hwAddr.String()]
+	if !ok {
+		return fmt.Errorf("device %v not found", hwAddr)
+	}
+	delete(r.records, hwAddr.String())
+	err := r.save()
+	if err != nil {
+		r.records[hwAddr.String()] = old
+		return err
+	}
+
+	return nil
+}
+
+// Get returns record of device
+func (r *DeviceRegistry) Get(hwAddr net.HardwareAddr) *DeviceRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	record, ok := r.records[hwAddr.String()]
+	if !ok {
+		return nil
+	}
+	copied := *record
+	return &copied
+}
+
+// GetAll returns all records
+func (r *DeviceRegistry) GetAll() []*DeviceRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	records := []*DeviceRecord{}
+	for _, record := range r.records {
+		copied := *record
+		records = append(records, &copied)
+	}
+	return records
+}
+
+func getDeviceInfo(device *app.Device) *DeviceInfo {
+	info := &DeviceInfo{
+		Device:     device,
+		PkgID:      getDevicePkgID(device),
+		Registered: registry.Get(device.HWAddress) != nil,
+	}
+	if device.App != nil {
+		info.AppID = device.App.ID()
+	}
+	return info
+}
+
+// releaseDeviceApp stops companion app of device and deletes its namespace
+func releaseDeviceApp(device *app.Device, reason string) {
+	proc, ok := device.App.(*app.LinuxProcess)
+	if !ok {
+		device.App = nil
+		return
+	}
+
+	if proc.IsRunning() {
+		failApp(proc, reason)
+	}
+	err := proc.Stop()
+	if err != nil {
+		log.Printf("error: Failed to stop app(%v) %v", proc.ID(), err)
+	}
+	dnsClients.RemoveApp(proc)
+	dnsAnswers.RemoveApp(proc.ID())
+	if len(apps.Where(func(a app.AppInterface) bool { return a == proc })) != 0 {
+		err = apps.Remove(proc)
+		if err != nil {
+			log.Printf("error: Failed to remove app(%v) %v", proc.ID(), err)
+		}
+	}
+	device.App = nil
+}
+
+// applyDeviceRecord creates device of record and prepares app of its package
+func applyDeviceRecord(record *DeviceRecord) (*app.Device, error) {
+	hwAddr, err := net.ParseMAC(record.HWAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	device := getDeviceByHWAddr(hwAddr)
+	if device == nil {
+		device = &app.Device{
+			HWAddress: hwAddr,
+		}
+		// devices are Wi-Fi stations as ones found by DHCP
+		if pepConfig.wifiLink != nil {
+			device.OfPort = pepConfig.wifiLink.GetOfPort()
+			device.ViaWlan = true
+		}
+		devices.Add(device)
+	}
+	device.Name = record.Name
+
+	if device.App != nil && getDevicePkgID(device) == record.PkgID {
+		return device, nil
+	}
+
+	var proc *app.LinuxProcess
+	if record.PkgID != uuid.Nil {
+		proc, err = newCompanionApp(record.PkgID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if device.App != nil {
+		releaseDeviceApp(device, "device is bound to another package")
+	}
+	if proc != nil {
+		proc.SetDevice(device)
+		device.App = proc
+		device.VendorID = proc.PkgInfo().MetaInfo.VendorID
+	}
+
+	return device, nil
+}
+
+// removeDevice releases app, addresses and flows of device
+func removeDevice(device *app.Device) {
+	stations.cancel(device.HWAddress)
+	if device.App != nil {
+		releaseDeviceApp(device, "device is removed")
+	}
+	teardownDevice(device)
+
+	if psks.Get(device.HWAddress) != nil {
+		err := psks.Revoke(device.HWAddress)
+		if err != nil {
+			log.Printf("error: Failed to revoke psk of %v %v", device.HWAddress, err)
+			return
+		}
+		err = reloadDevicePSK(device.HWAddress)
+		if err != nil {
+			log.Printf("error: Failed to reload psk of hostapd %v", err)
+		}
+	}
+}
+
+// setupDeviceRegistry restores devices registered before
+func setupDeviceRegistry() error {
+	err := registry.Load()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range registry.GetAll() {
+		_, err = applyDeviceRecord(record)
+		if err != nil {
+			log.Printf("error: Failed to restore device %v %v", record.HWAddress, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeviceRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pep-registry")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "crebas", "devices.json")
+
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:38")
+	record := &DeviceRecord{
+		HWAddress: hwAddr.String(),
+		Name:      "sensor",
+		PkgID:     uuid.New(),
+	}
+
+	r := NewDeviceRegistry(path)
+	err = r.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = r.Put(record)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, record, r.Get(hwAddr))
+
+	// registered devices are kept across restarts
+	loaded := NewDeviceRegistry(path)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, []*DeviceRecord{record}, loaded.GetAll())
+
+	err = loaded.Remove(hwAddr)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.NotNil(t, loaded.Remove(hwAddr))
+
+	loaded = NewDeviceRegistry(path)
+	err = loaded.Load()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 0, len(loaded.GetAll()))
+}
+
+func TestApplyDeviceRecord(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:39")
+	record := &DeviceRecord{
+		HWAddress: hwAddr.String(),
+		Name:      "sensor",
+	}
+
+	device, err := applyDeviceRecord(record)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer devices.Remove(device)
+	assert.Equal(t, device, getDeviceByHWAddr(hwAddr))
+	assert.Equal(t, "sensor", device.Name)
+	assert.Nil(t, device.App)
+
+	record.Name = "renamed"
+	renamed, err := applyDeviceRecord(record)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, device, renamed)
+	assert.Equal(t, "renamed", device.Name)
+
+	record.PkgID = uuid.New()
+	_, err = applyDeviceRecord(record)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// routerAdvFlags sets Managed and Other configuration as addresses and options are served by DHCPv6
+const routerAdvFlags uint8 = 0xc0
+
+// routerAdvMaxLifetime is the maximum router lifetime of RFC 4861
+const routerAdvMaxLifetime = 9000 * time.Second
+
+// prefixOnLinkFlag marks the prefix on-link. Autonomous flag is cleared not to bypass DHCPv6.
+const prefixOnLinkFlag uint8 = 0x80
+
+// newRouterAdvertisement returns ICMPv6 Router Advertisement announcing the router as default route
+// for lifetime and prefix as on-link
+func newRouterAdvertisement(prefix *net.IPNet, hwAddr net.HardwareAddr, lifetime time.Duration) ([]byte, error) {
+	if prefix.IP.To4() != nil || prefix.IP.To16() == nil {
+		return nil, fmt.Errorf("invalid IPv6 prefix %v", prefix)
+	}
+	if lifetime > routerAdvMaxLifetime {
+		lifetime = routerAdvMaxLifetime
+	}
+	ones, _ := prefix.Mask.Size()
+
+	prefixInfo := make([]byte, 30)
+	prefixInfo[0] = uint8(ones)
+	prefixInfo[1] = prefixOnLinkFlag
+	binary.BigEndian.PutUint32(prefixInfo[2:], uint32(lifetime.Seconds()))
+	binary.BigEndian.PutUint32(prefixInfo[6:], uint32(lifetime.Seconds()))
+	copy(prefixInfo[14:], prefix.IP.Mask(prefix.Mask).To16())
+
+	ra := &layers.ICMPv6RouterAdvertisement{
+		HopLimit:       64,
+		Flags:          routerAdvFlags,
+		RouterLifetime: uint16(lifetime.Seconds()),
+		Options: layers.ICMPv6Options{
+			{Type: layers.ICMPv6OptPrefixInfo, Data: prefixInfo},
+			{Type: layers.ICMPv6OptSourceAddress, Data: hwAddr},
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	err := ra.SerializeTo(buf, gopacket.SerializeOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	// checksum is filled by kernel
+	msg := &icmp.Message{
+		Type: ipv6.ICMPTypeRouterAdvertisement,
+		Body: &icmp.RawBody{Data: buf.Bytes()},
+	}
+	return msg.Marshal(nil)
+}
+
+func listenRouterSolicitation(ifi *net.Interface) (*icmp.PacketConn, error) {
+	conn, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, err
+	}
+
+	// Neighbor Discovery is accepted with hop limit 255 only
+	p := conn.IPv6PacketConn()
+	var filter ipv6.ICMPFilter
+	filter.SetAll(true)
+	filter.Accept(ipv6.ICMPTypeRouterSolicitation)
+	for _, fn := range []func() error{
+		func() error { return p.SetHopLimit(255) },
+		func() error { return p.SetMulticastHopLimit(255) },
+		func() error { return p.SetMulticastInterface(ifi) },
+		func() error { return p.SetICMPFilter(&filter) },
+		func() error { return p.SetControlMessage(ipv6.FlagInterface, true) },
+		func() error { return p.JoinGroup(ifi, &net.IPAddr{IP: net.IPv6linklocalallrouters}) },
+	} {
+		err = fn()
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// startRouterAdvertisement advertises the external switch as default router every interval
+// and answers Router Solicitations of devices
+func startRouterAdvertisement(interval time.Duration) {
+	ifi, err := net.InterfaceByName(pepConfig.extOfsName)
+	if err != nil {
+		log.Printf("error: Failed to find %v %v", pepConfig.extOfsName, err)
+		return
+	}
+	addr6, err := netlink.ParseAddr(pepConfig.extOfsAddr6)
+	if err != nil {
+		log.Printf("error: Failed to parse %v %v", pepConfig.extOfsAddr6, err)
+		return
+	}
+	prefix := &net.IPNet{IP: addr6.IP.Mask(addr6.Mask), Mask: addr6.Mask}
+	msg, err := newRouterAdvertisement(prefix, ifi.HardwareAddr, 3*interval)
+	if err != nil {
+		log.Printf("error: Failed to build router advertisement %v", err)
+		return
+	}
+
+	conn, err := listenRouterSolicitation(ifi)
+	if err != nil {
+		log.Printf("error: Failed to listen router solicitation %v", err)
+		return
+	}
+	defer conn.Close()
+
+	allNodes := &net.IPAddr{IP: net.IPv6linklocalallnodes, Zone: ifi.Name}
+	advertise := func() {
+		_, err := conn.WriteTo(msg, allNodes)
+		if err != nil {
+			log.Printf("error: Failed to send router advertisement %v", err)
+		}
+	}
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			_, cm, _, err := conn.IPv6PacketConn().ReadFrom(buf)
+			if err != nil {
+				log.Printf("error: Failed to read router solicitation %v", err)
+				return
+			}
+			if cm != nil && cm.IfIndex != ifi.Index {
+				continue
+			}
+			advertise()
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	advertise()
+	for range ticker.C {
+		advertise()
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRouterAdvertisement(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("fd00:20::fe/64")
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:01")
+
+	msg, err := newRouterAdvertisement(prefix, hwAddr, 90*time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, uint8(layers.ICMPv6TypeRouterAdvertisement), msg[0])
+
+	ra := &layers.ICMPv6RouterAdvertisement{}
+	err = ra.DecodeFromBytes(msg[4:], gopacket.NilDecodeFeedback)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.True(t, ra.ManagedAddressConfig())
+	assert.True(t, ra.OtherConfig())
+	assert.Equal(t, uint16(90), ra.RouterLifetime)
+	if len(ra.Options) != 2 {
+		t.Fatalf("Failed unexpected options %v", ra.Options)
+	}
+	for _, opt := range ra.Options {
+		switch opt.Type {
+		case layers.ICMPv6OptPrefixInfo:
+			assert.Equal(t, uint8(64), opt.Data[0])
+			assert.Equal(t, prefixOnLinkFlag, opt.Data[1])
+			assert.Equal(t, net.ParseIP("fd00:20::"), net.IP(opt.Data[14:30]))
+		case layers.ICMPv6OptSourceAddress:
+			assert.Equal(t, hwAddr, net.HardwareAddr(opt.Data))
+		default:
+			t.Fatalf("Failed unexpected option %v", opt)
+		}
+	}
+
+	msg, err = newRouterAdvertisement(prefix, hwAddr, time.Hour*24)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = ra.DecodeFromBytes(msg[4:], gopacket.NilDecodeFeedback)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, uint16(9000), ra.RouterLifetime)
+
+	_, prefix4, _ := net.ParseCIDR("192.168.20.0/24")
+	_, err = newRouterAdvertisement(prefix4, hwAddr, time.Minute)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+)
+
+// SpoofingAlert is raised when frames violating the lease binding are dropped
+type SpoofingAlert struct {
+	HWAddress      string    `json:"hwAddress"`
+	IPAddress      string    `json:"ipAddress"`
+	OfPort         uint32    `json:"ofPort"`
+	DroppedPackets uint64    `json:"droppedPackets"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// SpoofingCounter is the number of frames dropped by anti-spoofing flows of device
+type SpoofingCounter struct {
+	HWAddress      string `json:"hwAddress"`
+	IPAddress      string `json:"ipAddress"`
+	DroppedPackets uint64 `json:"droppedPackets"`
+	DroppedBytes   uint64 `json:"droppedBytes"`
+}
+
+// SpoofingMonitor tracks lease bindings and spoofing attempts
+type SpoofingMonitor struct {
+	mu        sync.Mutex
+	bindings  map[string]string
+	counters  map[string]*SpoofingCounter
+	alerts    []*SpoofingAlert
+	maxAlerts int
+}
+
+// NewSpoofingMonitor creates monitor holding the latest maxAlerts alerts
+func NewSpoofingMonitor(maxAlerts int) *SpoofingMonitor {
+	return &SpoofingMonitor{
+		bindings:  map[string]string{},
+		counters:  map[string]*SpoofingCounter{},
+		alerts:    []*SpoofingAlert{},
+		maxAlerts: maxAlerts,
+	}
+}
+
+// bindDeviceLease installs anti-spoofing flows binding device's port, hwaddr and leased addresses.
+// It is called again when the device gets IPv6 address in addition.
+func bindDeviceLease(ofs *ofswitch.OFSwitch, monitor *SpoofingMonitor, device *app.Device) error {
+	if device.OfPort == 0 || device.IPAddress == nil {
+		return nil
+	}
+
+	hwAddr := device.HWAddress.String()
+	ipAddr := device.IPAddress.IP.String()
+	binding := ipAddr
+	if device.IP6Address != nil {
+		binding += "," + device.IP6Address.IP.String()
+	}
+
+	monitor.mu.Lock()
+	defer monitor.mu.Unlock()
+
+	if monitor.bindings[hwAddr] == binding {
+		return nil
+	}
+
+	err := ofs.DeleteAntiSpoofingFlow(device)
+	if err != nil {
+		return err
+	}
+	err = ofs.AddAntiSpoofingFlow(device)
+	if err != nil {
+		return err
+	}
+
+	monitor.bindings[hwAddr] = binding
+	monitor.counters[hwAddr] = &SpoofingCounter{
+		HWAddress: hwAddr,
+		IPAddress: ipAddr,
+	}
+	log.Printf("info: device %v is bound to %v on port %v", hwAddr, binding, device.OfPort)
+
+	return nil
+}
+
+// Unbind forgets the binding of device whose anti-spoofing flows are removed with its port
+func (m *SpoofingMonitor) Unbind(hwAddr net.HardwareAddr) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.bindings, hwAddr.String())
+	delete(m.counters, hwAddr.String())
+}
+
+// Update counts frames dropped by anti-spoofing flows and raises alerts for increased ones
+func (m *SpoofingMonitor) Update(flowStats []*ofswitch.FlowStat, targetDevices app.DeviceSlice) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, device := range targetDevices {
+		hwAddr := device.HWAddress.String()
+		counter, ok := m.counters[hwAddr]
+		if !ok {
+			continue
+		}
+
+		cookie := ofswitch.SpoofingCookie(device.HWAddress)
+		var packets, bytes uint64
+		for _, flowStat := range flowStats {
+			if flowStat.Cookie != cookie {
+				continue
+			}
+			packets += flowStat.PacketCount
+			bytes += flowStat.ByteCount
+		}
+
+		if packets > counter.DroppedPackets {
+			alert := &SpoofingAlert{
+				HWAddress:      hwAddr,
+				IPAddress:      counter.IPAddress,
+				OfPort:         device.OfPort,
+				DroppedPackets: packets - counter.DroppedPackets,
+				Timestamp:      now,
+			}
+			log.Printf("warning: spoofing attempt from %v on port %v (%v packets dropped)", hwAddr, device.OfPort, alert.DroppedPackets)
+			m.alerts = append(m.alerts, alert)
+			if len(m.alerts) > m.maxAlerts {
+				m.alerts = m.alerts[len(m.alerts)-m.maxAlerts:]
+			}
+		}
+
+		// counters go backwards when flows are re-installed
+		counter.DroppedPackets = packets
+		counter.DroppedBytes = bytes
+	}
+}
+
+// GetCounters returns the counters of all bound devices
+func (m *SpoofingMonitor) GetCounters() []*SpoofingCounter {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counters := []*SpoofingCounter{}
+	for _, counter := range m.counters {
+		copied := *counter
+		counters = append(counters, &copied)
+	}
+	return counters
+}
+
+// GetAlerts returns alerts ordered from the oldest
+func (m *SpoofingMonitor) GetAlerts() []*SpoofingAlert {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	alerts := make([]*SpoofingAlert, len(m.alerts))
+	copy(alerts, m.alerts)
+	return alerts
+}
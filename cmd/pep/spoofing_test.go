@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpoofingMonitorUpdate(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:01")
+	device := &app.Device{
+		HWAddress: hwAddr,
+		OfPort:    3,
+	}
+	monitor := NewSpoofingMonitor(2)
+	monitor.counters[hwAddr.String()] = &SpoofingCounter{
+		HWAddress: hwAddr.String(),
+	}
+	cookie := ofswitch.SpoofingCookie(hwAddr)
+
+	monitor.Update([]*ofswitch.FlowStat{}, app.DeviceSlice{device})
+	assert.Equal(t, 0, len(monitor.GetAlerts()))
+
+	flowStats := []*ofswitch.FlowStat{
+		{Cookie: cookie, PacketCount: 2, ByteCount: 200},
+		{Cookie: cookie, PacketCount: 1, ByteCount: 100},
+		{Cookie: 1, PacketCount: 10, ByteCount: 1000},
+	}
+	monitor.Update(flowStats, app.DeviceSlice{device})
+	alerts := monitor.GetAlerts()
+	assert.Equal(t, 1, len(alerts))
+	assert.Equal(t, uint64(3), alerts[0].DroppedPackets)
+	assert.Equal(t, uint32(3), alerts[0].OfPort)
+	assert.Equal(t, uint64(300), monitor.GetCounters()[0].DroppedBytes)
+
+	// no new drops
+	monitor.Update(flowStats, app.DeviceSlice{device})
+	assert.Equal(t, 1, len(monitor.GetAlerts()))
+
+	flowStats[0].PacketCount = 5
+	monitor.Update(flowStats, app.DeviceSlice{device})
+	flowStats[0].PacketCount = 7
+	monitor.Update(flowStats, app.DeviceSlice{device})
+	alerts = monitor.GetAlerts()
+	assert.Equal(t, 2, len(alerts))
+	assert.Equal(t, uint64(2), alerts[1].DroppedPackets)
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/hostapd"
+)
+
+// hostapdEventTimeout is the interval checking hostapd is alive while no event arrives
+const hostapdEventTimeout = 30 * time.Second
+
+// StationLifecycle suspends devices of disconnected stations and tears them down after delay
+type StationLifecycle struct {
+	mu       sync.Mutex
+	timers   map[string]*time.Timer
+	delay    time.Duration
+	teardown func(*app.Device)
+}
+
+// NewStationLifecycle creates lifecycle tearing down devices by teardown after delay
+func NewStationLifecycle(delay time.Duration, teardown func(*app.Device)) *StationLifecycle {
+	return &StationLifecycle{
+		timers:   map[string]*time.Timer{},
+		delay:    delay,
+		teardown: teardown,
+	}
+}
+
+func (l *StationLifecycle) schedule(device *app.Device) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	hwAddr := device.HWAddress.String()
+	if timer, ok := l.timers[hwAddr]; ok {
+		timer.Stop()
+	}
+	l.timers[hwAddr] = time.AfterFunc(l.delay, func() {
+		l.mu.Lock()
+		delete(l.timers, hwAddr)
+		l.mu.Unlock()
+
+		if device.Suspended {
+			l.teardown(device)
+		}
+	})
+}
+
+func (l *StationLifecycle) cancel(hwAddr net.HardwareAddr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if timer, ok := l.timers[hwAddr.String()]; ok {
+		timer.Stop()
+		delete(l.timers, hwAddr.String())
+	}
+}
+
+// IsPending returns true if the device waits for teardown
+func (l *StationLifecycle) IsPending(hwAddr net.HardwareAddr) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.timers[hwAddr.String()]
+	return ok
+}
+
+func getDeviceByHWAddr(hwAddr net.HardwareAddr) *app.Device {
+	selectedDevices := devices.Where(func(d *app.Device) bool {
+		return d.HWAddress.String() == hwAddr.String()
+	})
+	if len(selectedDevices) == 0 {
+		return nil
+	}
+	return selectedDevices[0]
+}
+
+// HandleConnected creates device for new station or resumes suspended one
+func (l *StationLifecycle) HandleConnected(hwAddr net.HardwareAddr) *app.Device {
+	l.cancel(hwAddr)
+
+	device := getDeviceByHWAddr(hwAddr)
+	if device == nil {
+		// address is leased by DHCP
+		device = &app.Device{
+			HWAddress: hwAddr,
+			ViaWlan:   true,
+		}
+		if pepConfig.wifiLink != nil {
+			device.OfPort = pepConfig.wifiLink.GetOfPort()
+		}
+		devices.Add(device)
+		log.Printf("info: station %v is connected", hwAddr)
+		return device
+	}
+
+	device.Suspended = false
+	log.Printf("info: station %v is connected again", hwAddr)
+	return device
+}
+
+// HandleDisconnected suspends device of the station and schedules teardown
+func (l *StationLifecycle) HandleDisconnected(hwAddr net.HardwareAddr) *app.Device {
+	device := getDeviceByHWAddr(hwAddr)
+	if device == nil {
+		return nil
+	}
+
+	device.Suspended = true
+	l.schedule(device)
+	log.Printf("info: station %v is disconnected, device is suspended for %v", hwAddr, l.delay)
+	return device
+}
+
+// resumeDevice admits packets from the app of reconnected station again
+func resumeDevice(device *app.Device) {
+	proc, ok := device.App.(*app.LinuxProcess)
+	if !ok || !proc.IsRunning() || proc.ACLLink == nil || device.IPAddress == nil {
+		return
+	}
+	err := extOfs.AddAdmissionFlow(proc.ACLLink, device)
+	if err != nil {
+		log.Printf("error: Failed to add admission flow of device %v %v", device.HWAddress, err)
+	}
+}
+
+// suspendDevice stops admitting packets from the app of disconnected station
+func suspendDevice(device *app.Device) {
+	err := extOfs.DeleteAdmissionFlow(device)
+	if err != nil {
+		log.Printf("error: Failed to delete admission flow of device %v %v", device.HWAddress, err)
+	}
+}
+
+// teardownDevice releases the lease of the station and stops its app.
+// Devices provisioned with app are kept so that the app is started again by DHCP.
+func teardownDevice(device *app.Device) {
+	log.Printf("info: tearing down device %v", device.HWAddress)
+
+	if proc, ok := device.App.(*app.LinuxProcess); ok && proc.IsRunning() {
+		failApp(proc, "station is disconnected")
+	}
+
+	err := extOfs.DeleteAntiSpoofingFlow(device)
+	if err != nil {
+		log.Printf("error: Failed to delete anti-spoofing flow of device %v %v", device.HWAddress, err)
+	}
+	spoofing.Unbind(device.HWAddress)
+	dnsClients.RemoveDevice(device)
+
+	if device.IPAddress != nil {
+		releaseDeviceLease(device)
+		device.IPAddress = nil
+	}
+	if device.IP6Address != nil {
+		err = extAddr6Pool.Release(device.IP6Address.IP)
+		if err != nil {
+			log.Printf("error: Failed to release %v %v", device.IP6Address.IP, err)
+		}
+		device.IP6Address = nil
+	}
+
+	if device.App == nil {
+		err = devices.Remove(device)
+		if err != nil {
+			log.Printf("error: Failed to remove device %v %v", device.HWAddress, err)
+		}
+	}
+}
+
+func handleStationEvent(event *hostapd.Event) {
+	if event.HWAddress == nil {
+		return
+	}
+
+	switch event.Name {
+	case hostapd.EventStationConnected:
+		if !psks.Verify(event.HWAddress, event.GetArg("keyid")) && !identities.IsAuthenticated(event.HWAddress) {
+			log.Printf("warning: station %v is connected without its psk or certificate", event.HWAddress)
+			err := requestHostapd(func(client *hostapd.Client) error {
+				return client.Deauthenticate(event.HWAddress)
+			})
+			if err != nil {
+				log.Printf("error: Failed to deauthenticate %v %v", event.HWAddress, err)
+			}
+			return
+		}
+		bindAuthenticatedStation(event.HWAddress)
+		device := stations.HandleConnected(event.HWAddress)
+		resumeDevice(device)
+	case hostapd.EventStationDisconnected:
+		identities.ClearAuthenticated(event.HWAddress)
+		device := stations.HandleDisconnected(event.HWAddress)
+		if device != nil {
+			suspendDevice(device)
+		}
+	}
+}
+
+func monitorHostapd(ctrlPath string) error {
+	client, err := hostapd.Dial(ctrlPath)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	err = client.Attach()
+	if err != nil {
+		return err
+	}
+	log.Printf("info: attached to hostapd %v", ctrlPath)
+
+	for {
+		event, err := client.ReceiveEvent(hostapdEventTimeout)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				// hostapd may be restarted without notifying
+				err = client.Ping()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		handleStationEvent(event)
+	}
+}
+
+// startHostapdMonitor subscribes station events of hostapd and reconnects when it is restarted
+func startHostapdMonitor(ctrlPath string) {
+	for {
+		err := monitorHostapd(ctrlPath)
+		log.Printf("error: hostapd monitor stopped %v", err)
+		time.Sleep(5 * time.Second)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStationLifecycle(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:35")
+	tornDown := make(chan *app.Device, 1)
+	lifecycle := NewStationLifecycle(50*time.Millisecond, func(d *app.Device) {
+		tornDown <- d
+	})
+
+	device := lifecycle.HandleConnected(hwAddr)
+	defer devices.Remove(device)
+	assert.True(t, device.ViaWlan)
+	assert.False(t, device.Suspended)
+	assert.Equal(t, device, getDeviceByHWAddr(hwAddr))
+
+	// reconnected before teardown
+	lifecycle.HandleDisconnected(hwAddr)
+	assert.True(t, device.Suspended)
+	assert.True(t, lifecycle.IsPending(hwAddr))
+	assert.Equal(t, device, lifecycle.HandleConnected(hwAddr))
+	assert.False(t, device.Suspended)
+	assert.False(t, lifecycle.IsPending(hwAddr))
+
+	select {
+	case <-tornDown:
+		t.Fatalf("Failed reconnected device is torn down")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	lifecycle.HandleDisconnected(hwAddr)
+	select {
+	case d := <-tornDown:
+		assert.Equal(t, device, d)
+	case <-time.After(time.Second):
+		t.Fatalf("Failed device is not torn down")
+	}
+	assert.False(t, lifecycle.IsPending(hwAddr))
+
+	unknown, _ := net.ParseMAC("02:00:00:00:00:36")
+	assert.Nil(t, lifecycle.HandleDisconnected(unknown))
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/naoki9911/CREBAS/pkg/app"
+	"github.com/naoki9911/CREBAS/pkg/capability"
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+)
+
+// TrafficReport is traffic counters and rates of app, device or capability
+type TrafficReport struct {
+	ID          string                   `json:"id"`
+	PacketCount uint64                   `json:"packetCount"`
+	ByteCount   uint64                   `json:"byteCount"`
+	PacketRate  float64                  `json:"packetRate"`
+	ByteRate    float64                  `json:"byteRate"`
+	Samples     []ofswitch.TrafficSample `json:"samples,omitempty"`
+}
+
+// TrafficAccounting aggregates switch counters per app, device and capability
+type TrafficAccounting struct {
+	mu          sync.Mutex
+	historySize int
+	apps        map[uuid.UUID]*ofswitch.TrafficRing
+	devices     map[string]*ofswitch.TrafficRing
+	caps        map[uuid.UUID]*ofswitch.TrafficRing
+}
+
+// NewTrafficAccounting creates TrafficAccounting which holds historySize samples per entry
+func NewTrafficAccounting(historySize int) *TrafficAccounting {
+	return &TrafficAccounting{
+		historySize: historySize,
+		apps:        map[uuid.UUID]*ofswitch.TrafficRing{},
+		devices:     map[string]*ofswitch.TrafficRing{},
+		caps:        map[uuid.UUID]*ofswitch.TrafficRing{},
+	}
+}
+
+func capabilityCookie(cap *capability.Capability) uint64 {
+	return ofswitch.NewCookie(ofswitch.CookieTypeCapability, cap.CapabilityID[:])
+}
+
+func aggregatePortTraffic(now time.Time, portStats map[uint32]*ofswitch.PortStat, ofPorts []uint32) ofswitch.TrafficSample {
+	sample := ofswitch.TrafficSample{
+		Timestamp: now,
+	}
+	for _, ofPort := range ofPorts {
+		portStat, ok := portStats[ofPort]
+		if !ok {
+			continue
+		}
+		sample.PacketCount += portStat.RxPackets + portStat.TxPackets
+		sample.ByteCount += portStat.RxBytes + portStat.TxBytes
+	}
+
+	return sample
+}
+
+func aggregateDeviceTraffic(now time.Time, flowStats []*ofswitch.FlowStat, hwAddr net.HardwareAddr) ofswitch.TrafficSample {
+	sample := ofswitch.TrafficSample{
+		Timestamp: now,
+	}
+	for _, flowStat := range flowStats {
+		// packets reaching the following tables are already counted in admission table
+		if flowStat.TableID != ofswitch.TableAdmission {
+			continue
+		}
+		if flowStat.EthSrc.String() != hwAddr.String() && flowStat.EthDst.String() != hwAddr.String() {
+			continue
+		}
+		sample.PacketCount += flowStat.PacketCount
+		sample.ByteCount += flowStat.ByteCount
+	}
+
+	return sample
+}
+
+func aggregateCookieTraffic(now time.Time, flowStats []*ofswitch.FlowStat, cookie uint64) ofswitch.TrafficSample {
+	sample := ofswitch.TrafficSample{
+		Timestamp: now,
+	}
+	for _, flowStat := range flowStats {
+		if flowStat.Cookie != cookie {
+			continue
+		}
+		sample.PacketCount += flowStat.PacketCount
+		sample.ByteCount += flowStat.ByteCount
+	}
+
+	return sample
+}
+
+func getAppOfPorts(a app.AppInterface) []uint32 {
+	links := a.Links().Where(func(l *netlinkext.LinkExt) bool {
+		return l.OfType == netlinkext.ExternalOFSwitch
+	})
+
+	ofPorts := []uint32{}
+	for _, link := range links {
+		ofPorts = append(ofPorts, link.Ofport)
+	}
+
+	return ofPorts
+}
+
+// Update aggregates the counters collected from ofs.
+// Capabilities are counted on egressOfs too as the flows of external communication are installed there.
+func (t *TrafficAccounting) Update(ofs *ofswitch.OFSwitch, egressOfs *ofswitch.OFSwitch, targetApps []app.AppInterface, targetDevices app.DeviceSlice) {
+	now := time.Now()
+	flowStats := ofs.GetFlowStats()
+	portStats := ofs.GetPortStats()
+	capFlowStats := append(append([]*ofswitch.FlowStat{}, flowStats...), egressOfs.GetFlowStats()...)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	appRings := map[uuid.UUID]*ofswitch.TrafficRing{}
+	capRings := map[uuid.UUID]*ofswitch.TrafficRing{}
+	for _, a := range targetApps {
+		appRing, ok := t.apps[a.ID()]
+		if !ok {
+			appRing = ofswitch.NewTrafficRing(t.historySize)
+		}
+		appRing.Push(aggregatePortTraffic(now, portStats, getAppOfPorts(a)))
+		appRings[a.ID()] = appRing
+
+		for _, cap := range a.Capabilities().GetAll() {
+			capRing, ok := t.caps[cap.CapabilityID]
+			if !ok {
+				capRing = ofswitch.NewTrafficRing(t.historySize)
+			}
+			capRing.Push(aggregateCookieTraffic(now, capFlowStats, capabilityCookie(cap)))
+			capRings[cap.CapabilityID] = capRing
+		}
+	}
+
+	deviceRings := map[string]*ofswitch.TrafficRing{}
+	for _, device := range targetDevices {
+		hwAddr := device.HWAddress.String()
+		deviceRing, ok := t.devices[hwAddr]
+		if !ok {
+			deviceRing = ofswitch.NewTrafficRing(t.historySize)
+		}
+		deviceRing.Push(aggregateDeviceTraffic(now, flowStats, device.HWAddress))
+		deviceRings[hwAddr] = deviceRing
+	}
+
+	// entries of removed apps, caps and devices are dropped here
+	t.apps = appRings
+	t.caps = capRings
+	t.devices = deviceRings
+}
+
+func newTrafficReport(id string, ring *ofswitch.TrafficRing, withSamples bool) *TrafficReport {
+	report := &TrafficReport{
+		ID: id,
+	}
+	latest, ok := ring.Latest()
+	if ok {
+		report.PacketCount = latest.PacketCount
+		report.ByteCount = latest.ByteCount
+	}
+	report.PacketRate, report.ByteRate = ring.Rate()
+	if withSamples {
+		report.Samples = ring.GetAll()
+	}
+
+	return report
+}
+
+// GetAppReports returns traffic of all apps
+func (t *TrafficAccounting) GetAppReports() []*TrafficReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reports := []*TrafficReport{}
+	for id, ring := range t.apps {
+		reports = append(reports, newTrafficReport(id.String(), ring, false))
+	}
+	return reports
+}
+
+// GetAppReport returns traffic of the app with time-series
+func (t *TrafficAccounting) GetAppReport(appID uuid.UUID) *TrafficReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ring, ok := t.apps[appID]
+	if !ok {
+		return nil
+	}
+	return newTrafficReport(appID.String(), ring, true)
+}
+
+// GetDeviceReports returns traffic of all devices
+func (t *TrafficAccounting) GetDeviceReports() []*TrafficReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reports := []*TrafficReport{}
+	for hwAddr, ring := range t.devices {
+		reports = append(reports, newTrafficReport(hwAddr, ring, false))
+	}
+	return reports
+}
+
+// GetDeviceReport returns traffic of the device with time-series
+func (t *TrafficAccounting) GetDeviceReport(hwAddr net.HardwareAddr) *TrafficReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ring, ok := t.devices[hwAddr.String()]
+	if !ok {
+		return nil
+	}
+	return newTrafficReport(hwAddr.String(), ring, true)
+}
+
+// GetCapabilityReports returns traffic of all capabilities
+func (t *TrafficAccounting) GetCapabilityReports() []*TrafficReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	reports := []*TrafficReport{}
+	for id, ring := range t.caps {
+		reports = append(reports, newTrafficReport(id.String(), ring, false))
+	}
+	return reports
+}
+
+// GetCapabilityReport returns traffic of the capability with time-series
+func (t *TrafficAccounting) GetCapabilityReport(capID uuid.UUID) *TrafficReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ring, ok := t.caps[capID]
+	if !ok {
+		return nil
+	}
+	return newTrafficReport(capID.String(), ring, true)
+}
+
+func startTrafficAccounting(ofs *ofswitch.OFSwitch, egressOfs *ofswitch.OFSwitch, interval time.Duration) {
+	ofs.StartStatsCollection(interval)
+	egressOfs.StartStatsCollection(interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		traffic.Update(ofs, egressOfs, apps.GetAll(), devices.GetAll())
+		spoofing.Update(ofs.GetFlowStats(), devices.GetAll())
+	}
+}
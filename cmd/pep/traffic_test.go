@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/naoki9911/CREBAS/pkg/ofswitch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateTraffic(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:01")
+	otherAddr, _ := net.ParseMAC("02:00:00:00:00:02")
+	flowStats := []*ofswitch.FlowStat{
+		{TableID: ofswitch.TableAdmission, Cookie: 1, EthSrc: hwAddr, EthDst: otherAddr, PacketCount: 10, ByteCount: 1000},
+		{TableID: ofswitch.TableAdmission, Cookie: 2, EthSrc: otherAddr, EthDst: hwAddr, PacketCount: 5, ByteCount: 500},
+		{TableID: ofswitch.TableAdmission, Cookie: 1, EthSrc: otherAddr, PacketCount: 1, ByteCount: 100},
+		{TableID: ofswitch.TablePolicy, Cookie: 3, EthSrc: hwAddr, EthDst: otherAddr, PacketCount: 10, ByteCount: 1000},
+	}
+
+	now := time.Now()
+	sample := aggregateDeviceTraffic(now, flowStats, hwAddr)
+	assert.Equal(t, uint64(15), sample.PacketCount)
+	assert.Equal(t, uint64(1500), sample.ByteCount)
+
+	sample = aggregateCookieTraffic(now, flowStats, 1)
+	assert.Equal(t, uint64(11), sample.PacketCount)
+	assert.Equal(t, uint64(1100), sample.ByteCount)
+
+	portStats := map[uint32]*ofswitch.PortStat{
+		1: {PortNo: 1, RxPackets: 1, TxPackets: 2, RxBytes: 100, TxBytes: 200},
+		2: {PortNo: 2, RxPackets: 3, TxPackets: 4, RxBytes: 300, TxBytes: 400},
+	}
+	sample = aggregatePortTraffic(now, portStats, []uint32{2, 3})
+	assert.Equal(t, uint64(7), sample.PacketCount)
+	assert.Equal(t, uint64(700), sample.ByteCount)
+}
@@ -13,12 +13,14 @@ require (
 	github.com/golang-collections/go-datastructures v0.0.0-20150211160725-59788d5eb259
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/google/gopacket v1.1.19
+	github.com/google/nftables v0.0.0-20210514154851-a285acebcad3
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/mux v1.8.0 // indirect
 	github.com/insomniacslk/dhcp v0.0.0-20210315110227-c51060810aaa
 	github.com/json-iterator/go v1.1.10 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/leodido/go-urn v1.2.1 // indirect
+	github.com/mdlayher/netlink v1.1.1
 	github.com/miekg/dns v1.1.41
 	github.com/naoki9911/gofc v0.0.0-20210408150517-64811794fd5d
 	github.com/sirupsen/logrus v1.8.1
@@ -26,8 +28,9 @@ require (
 	github.com/ugorji/go v1.2.5 // indirect
 	github.com/vishvananda/netlink v1.1.0
 	github.com/vishvananda/netns v0.0.0-20210104183010-2eb08e3e575f
-	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2 // indirect
-	golang.org/x/sys v0.0.0-20210403161142-5e06dd20ab57 // indirect
+	golang.org/x/net v0.10.0
+	golang.org/x/sys v0.12.0
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+	layeh.com/radius v0.0.0-20231213012653-1006025d24f8
 )
@@ -28,4 +28,6 @@ type AppInfo struct {
 	DeviceLinkName          string    `json:"deviceLinkName"`
 	DeviceLinkPeerHWAddress string    `json:"deviceLinkPeerHWAddress`
 	Server                  bool      `json:"server"`
+	Failed                  bool      `json:"failed,omitempty"`
+	FailReason              string    `json:"failReason,omitempty"`
 }
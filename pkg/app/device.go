@@ -3,15 +3,27 @@ package app
 import (
 	"net"
 
+	"github.com/google/uuid"
 	"github.com/vishvananda/netlink"
 )
 
 type Device struct {
-	HWAddress net.HardwareAddr `json:"hwAddress"`
-	IPAddress *netlink.Addr    `json:"ipAddress"`
-	App       AppInterface `json:"-"`
-	OfPort    uint32
-	ViaWlan   bool
+	HWAddress  net.HardwareAddr `json:"hwAddress"`
+	Name       string           `json:"name,omitempty"`
+	IPAddress  *netlink.Addr    `json:"ipAddress"`
+	IP6Address *netlink.Addr    `json:"ip6Address,omitempty"`
+	MaxRate    uint32           `json:"maxRate,omitempty"`
+	App        AppInterface     `json:"-"`
+	OfPort     uint32
+	ViaWlan    bool
+	// the station is disconnected from the AP and its lease is kept for a while
+	Suspended bool `json:"suspended,omitempty"`
+	// identity in the certificate verified by EAP-TLS and its attributes
+	Identity string    `json:"identity,omitempty"`
+	DeviceID uuid.UUID `json:"deviceID,omitempty"`
+	VendorID uuid.UUID `json:"vendorID,omitempty"`
+	// attributes collected from DHCP requests
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
 }
 
 func (d *Device) GetHWAddress() net.HardwareAddr {
@@ -22,6 +34,10 @@ func (d *Device) GetIPAddress() *netlink.Addr {
 	return d.IPAddress
 }
 
+func (d *Device) GetIP6Address() *netlink.Addr {
+	return d.IP6Address
+}
+
 func (d *Device) GetOfPort() uint32 {
 	return d.OfPort
 }
@@ -0,0 +1,26 @@
+package app
+
+import (
+	"net"
+	"strings"
+)
+
+// Fingerprint is DHCP attributes identifying the kind of device
+type Fingerprint struct {
+	// option 55 parameter request list in requested order, e.g. "1,3,6,15"
+	ParameterList string `json:"parameterList,omitempty"`
+	// option 60 vendor class identifier
+	VendorClass string `json:"vendorClass,omitempty"`
+	// option 12 host name
+	Hostname string `json:"hostname,omitempty"`
+	// the first 3 bytes of hwaddr
+	OUI string `json:"oui"`
+}
+
+// GetOUI returns OUI of hwaddr formatted as "xx:xx:xx"
+func GetOUI(hwAddr net.HardwareAddr) string {
+	if len(hwAddr) < 3 {
+		return ""
+	}
+	return strings.ToLower(hwAddr[0:3].String())
+}
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/google/uuid"
@@ -26,6 +28,7 @@ type LinuxProcess struct {
 	id           uuid.UUID
 	pid          int
 	defaultRoute net.IP
+	routes       map[string]*net.IPNet
 	links        *netlinkext.LinkCollection
 	namespace    string
 	cmd          []string
@@ -33,6 +36,8 @@ type LinuxProcess struct {
 	exitChan     chan bool
 	device       *Device
 	capabilities *capability.CapabilityCollection
+	failed       bool
+	failReason   string
 
 	DhcpConfigPath string
 	DeviceLink     *netlinkext.LinkExt
@@ -46,6 +51,7 @@ func NewLinuxProcess() (*LinuxProcess, error) {
 	proc.pid = -1
 	proc.exitCode = -1
 	proc.exitChan = make(chan bool, 1)
+	proc.routes = map[string]*net.IPNet{}
 	proc.links = netlinkext.NewLinkCollection()
 	proc.capabilities = capability.NewCapabilityCollection()
 
@@ -70,6 +76,8 @@ func NewLinuxProcessFromPkgInfo(pkgInfo *pkg.PackageInfo) (*LinuxProcess, error)
 
 // Start process
 func (p *LinuxProcess) Start() error {
+	p.failed = false
+	p.failReason = ""
 	return p.execCmdWithNetns()
 }
 
@@ -117,6 +125,49 @@ func (p *LinuxProcess) killProc() error {
 	return nil
 }
 
+// MarkFailed kills process and deletes its links because one of them is lost.
+// The namespace is kept so that the process can be started again with new links.
+// Links are deleted even if the process fails to be killed and all the failures are returned.
+func (p *LinuxProcess) MarkFailed(reason string) error {
+	p.failed = true
+	p.failReason = reason
+
+	failures := []string{}
+	err := p.killProc()
+	if err != nil {
+		failures = append(failures, fmt.Sprintf("failed to kill process %v", err))
+	}
+
+	links := p.links.Where(func(link *netlinkext.LinkExt) bool { return true })
+	for _, link := range links {
+		err = link.Delete()
+		if errors.Is(err, syscall.ENODEV) {
+			// the lost link is already deleted
+			log.Printf("info: link %v of app %v is already deleted", link.GetLink().Attrs().Name, p.id)
+		} else if err != nil {
+			failures = append(failures, fmt.Sprintf("failed to delete link %v %v", link.GetLink().Attrs().Name, err))
+		}
+		p.links.Remove(link)
+	}
+	p.DeviceLink = nil
+	p.ACLLink = nil
+
+	if len(failures) != 0 {
+		return fmt.Errorf("%v", strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// IsFailed returns true if the process is marked failed and not started again
+func (p *LinuxProcess) IsFailed() bool {
+	return p.failed
+}
+
+// FailReason returns the reason why the process is marked failed
+func (p *LinuxProcess) FailReason() string {
+	return p.failReason
+}
+
 // Delete deletes process
 func (p *LinuxProcess) delete() error {
 	links := p.links.Where(func(link *netlinkext.LinkExt) bool { return true })
@@ -143,7 +194,9 @@ func (p *LinuxProcess) ID() uuid.UUID {
 func (p *LinuxProcess) GetAppInfo() *AppInfo {
 	if p.ACLLink == nil || p.DeviceLink == nil {
 		appInfo := AppInfo{
-			Id: p.id,
+			Id:         p.id,
+			Failed:     p.failed,
+			FailReason: p.failReason,
 		}
 		return &appInfo
 	} else {
@@ -225,8 +278,9 @@ func (p *LinuxProcess) AddLinkWithReservedAddr(ofs *ofswitch.OFSwitch, ofType ne
 	return link, nil
 }
 
+// SetDefaultRoute adds or replaces the default route via addr in the namespace
 func (p *LinuxProcess) SetDefaultRoute(addr net.IP) error {
-	err := exec.Command("ip", "netns", "exec", p.namespace, "ip", "route", "add", "default", "via", addr.String()).Run()
+	err := p.SetRoute(&net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}, addr)
 	if err != nil {
 		return err
 	}
@@ -235,6 +289,71 @@ func (p *LinuxProcess) SetDefaultRoute(addr net.IP) error {
 	return nil
 }
 
+func (p *LinuxProcess) routeHandle() (*netlink.Handle, error) {
+	ns, err := netns.GetFromName(p.namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer ns.Close()
+
+	return netlink.NewHandleAt(ns)
+}
+
+// SetRoute adds or replaces the route to dest via gateway in the namespace
+func (p *LinuxProcess) SetRoute(dest *net.IPNet, gateway net.IP) error {
+	handle, err := p.routeHandle()
+	if err != nil {
+		return err
+	}
+	defer handle.Delete()
+
+	err = handle.RouteReplace(&netlink.Route{
+		Dst: dest,
+		Gw:  gateway,
+	})
+	if err != nil {
+		return err
+	}
+
+	p.routes[dest.String()] = dest
+	return nil
+}
+
+// DeleteRoute deletes the route to dest set by SetRoute from the namespace
+func (p *LinuxProcess) DeleteRoute(dest *net.IPNet) error {
+	if _, ok := p.routes[dest.String()]; !ok {
+		return fmt.Errorf("route to %v not found", dest)
+	}
+
+	handle, err := p.routeHandle()
+	if err != nil {
+		return err
+	}
+	defer handle.Delete()
+
+	err = handle.RouteDel(&netlink.Route{
+		Dst: dest,
+	})
+	if err != nil {
+		return err
+	}
+
+	delete(p.routes, dest.String())
+	if ones, _ := dest.Mask.Size(); ones == 0 {
+		p.defaultRoute = nil
+	}
+	return nil
+}
+
+// GetRoutes returns destinations of the routes set by SetRoute
+func (p *LinuxProcess) GetRoutes() []*net.IPNet {
+	routes := []*net.IPNet{}
+	for _, dest := range p.routes {
+		routes = append(routes, dest)
+	}
+	return routes
+}
+
 func (p *LinuxProcess) GetDefaultRoute() net.IP {
 	return p.defaultRoute
 }
@@ -260,11 +379,20 @@ func (p *LinuxProcess) Links() *netlinkext.LinkCollection {
 	return p.links
 }
 
+func (p *LinuxProcess) PkgInfo() *pkg.PackageInfo {
+	return p.pkgInfo
+}
+
 func (p *LinuxProcess) NameSpace() string {
 	return p.namespace
 }
 
 func (p *LinuxProcess) SetDNSServer(addr net.IP) error {
+	return p.SetResolvConf(addr, nil)
+}
+
+// SetResolvConf writes resolv.conf of the namespace with the server and the domain search list
+func (p *LinuxProcess) SetResolvConf(addr net.IP, search []string) error {
 	netnsPath := "/etc/netns/" + p.namespace
 	cmd := exec.Command("mkdir", "-p", netnsPath)
 	if err := cmd.Run(); err != nil {
@@ -283,6 +411,13 @@ func (p *LinuxProcess) SetDNSServer(addr net.IP) error {
 		log.Printf("error: Failed to write to %v", netnsPath+"/resolv.conf")
 		return err
 	}
+	if len(search) != 0 {
+		_, err = file.WriteString("search " + strings.Join(search, " ") + "\n")
+		if err != nil {
+			log.Printf("error: Failed to write to %v", netnsPath+"/resolv.conf")
+			return err
+		}
+	}
 
 	return nil
 }
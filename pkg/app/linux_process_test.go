@@ -2,6 +2,7 @@ package app
 
 import (
 	"fmt"
+	"net"
 	"os/exec"
 	"testing"
 	"time"
@@ -108,3 +109,125 @@ func TestLinkAttach(t *testing.T) {
 		t.Fatalf("Failed %v", err)
 	}
 }
+
+func TestMarkFailedAndRestartProcess(t *testing.T) {
+	p, err := NewLinuxProcess()
+	if err != nil {
+		t.Fatalf("Failed %#v", err)
+	}
+	defer p.Stop()
+	p.cmd = []string{"/usr/bin/sleep", "10"}
+	err = p.Start()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	err = p.MarkFailed("link lost")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	if p.IsRunning() {
+		t.Fatalf("pid %v exists", p.pid)
+	}
+	if !p.IsFailed() || p.FailReason() != "link lost" {
+		t.Fatalf("Failed proc is not marked failed")
+	}
+	if p.links.Count() != 0 {
+		t.Fatalf("Failed links remain %v", p.links.Count())
+	}
+
+	_, err = netns.GetFromName(p.namespace)
+	if err != nil {
+		t.Fatalf("netns %v does not exist", p.namespace)
+	}
+
+	err = p.Start()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	if !p.IsRunning() || p.IsFailed() {
+		t.Fatalf("Failed proc %v is not restarted", p.pid)
+	}
+}
+
+func TestSetAndDeleteRoute(t *testing.T) {
+	p, err := NewLinuxProcess()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer p.delete()
+
+	ns, err := netns.GetFromName(p.namespace)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer ns.Close()
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer handle.Delete()
+	lo, err := handle.LinkByName("lo")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	addr, _ := netlink.ParseAddr("10.0.0.2/24")
+	err = handle.AddrAdd(lo, addr)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = handle.LinkSetUp(lo)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	err = p.SetDefaultRoute(net.ParseIP("10.0.0.1"))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	_, dest, _ := net.ParseCIDR("192.168.5.0/24")
+	for _, gateway := range []string{"10.0.0.1", "10.0.0.3"} {
+		err = p.SetRoute(dest, net.ParseIP(gateway))
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+	}
+	routes, err := handle.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	gateways := map[string]string{}
+	for _, route := range routes {
+		if route.Dst == nil {
+			gateways["0.0.0.0/0"] = route.Gw.String()
+		} else if route.Gw != nil {
+			gateways[route.Dst.String()] = route.Gw.String()
+		}
+	}
+	if gateways["0.0.0.0/0"] != "10.0.0.1" || gateways[dest.String()] != "10.0.0.3" || len(p.GetRoutes()) != 2 {
+		t.Fatalf("Failed routes %v", gateways)
+	}
+
+	err = p.DeleteRoute(dest)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	if p.DeleteRoute(dest) == nil {
+		t.Fatalf("Failed deleted route %v is deleted again", dest)
+	}
+	routes, err = handle.RouteList(nil, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	for _, route := range routes {
+		if route.Dst != nil && route.Dst.String() == dest.String() {
+			t.Fatalf("route %v remains", dest)
+		}
+	}
+	if len(p.GetRoutes()) != 1 || p.GetDefaultRoute() == nil {
+		t.Fatalf("Failed routes %v", p.GetRoutes())
+	}
+}
@@ -0,0 +1,40 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile replaces the file at path with data and perm.
+// Data is written to a temporary file in the same directory and renamed over path,
+// so readers see either the old or the new content even if writing fails midway.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Chmod(perm)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), path)
+}
@@ -0,0 +1,46 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "atomicfile")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sub", "data.json")
+	err = WriteFile(path, []byte("old"), 0644)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = WriteFile(path, []byte("new"), 0600)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "new", string(data))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// no temporary file is left
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 1, len(files))
+}
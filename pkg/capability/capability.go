@@ -6,7 +6,6 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
-	"strings"
 
 	"github.com/google/uuid"
 )
@@ -19,6 +18,7 @@ type Capability struct {
 	AppID                 uuid.UUID                      `json:"appID"`
 	CapabilityName        string                         `json:"capabilityName"`
 	CapabilityValue       string                         `json:"capabilityValue"`
+	MaxRate               uint32                         `json:"maxRate,omitempty"`
 	GrantCondition        string                         `json:"grantCondition,omitempty"`
 	GrantPolicy           CapabilityAttributeBasedPolicy `json:"grantPolicy,omitempty"`
 	AuthorizeCapabilityID uuid.UUID                      `json:"authorizeCapabilityID"`
@@ -107,27 +107,15 @@ func NewCreateSkeltonCapabilityRequest() *CapabilityRequest {
 	return cap
 }
 
+// IsDomainAllowed returns true if domain is permitted by CapabilityValue parsed as DomainPattern.
+// Domain may be a pattern requested to be granted.
 func (cap *Capability) IsDomainAllowed(domain string) bool {
-	allowedDomain := cap.CapabilityValue
-
-	if allowedDomain != "*" {
-		// Matching For "*.example.com" or "*example.com"
-		if strings.HasPrefix(allowedDomain, "*") {
-			var matchDomain string
-			if allowedDomain[1] == '.' {
-				matchDomain = allowedDomain[2:]
-			} else {
-				matchDomain = allowedDomain[1:]
-			}
-
-			if !strings.HasSuffix(domain, matchDomain) {
-				return false
-			}
-		}
+	pattern, err := ParseDomainPattern(cap.CapabilityValue)
+	if err != nil {
+		return false
 	}
 
-	return true
-
+	return pattern.Match(domain)
 }
 
 func (cap *Capability) GetGrantedCap(cpID uuid.UUID, capReq *CapabilityRequest) *Capability {
@@ -143,6 +131,7 @@ func (cap *Capability) GetGrantedCap(cpID uuid.UUID, capReq *CapabilityRequest)
 		AppID:                 cap.AppID,
 		CapabilityName:        cap.CapabilityName,
 		CapabilityValue:       cap.CapabilityValue,
+		MaxRate:               cap.MaxRate,
 		AuthorizeCapabilityID: cap.CapabilityID,
 		CapabilitySignature: CapabilitySignature{
 			SignerID:  cpID,
@@ -169,6 +158,7 @@ func (cap *Capability) getExternalCommunicationGrantedCap(cpID uuid.UUID, capReq
 		AppID:                 cap.AppID,
 		CapabilityName:        capReq.RequestCapabilityName,
 		CapabilityValue:       capReq.RequestCapabilityValue,
+		MaxRate:               cap.MaxRate,
 		AuthorizeCapabilityID: cap.CapabilityID,
 		CapabilitySignature: CapabilitySignature{
 			SignerID:  cpID,
@@ -190,6 +180,7 @@ func (cap *Capability) GetDelegatedCapability(assignerID uuid.UUID, assigneeID u
 		AppID:                 cap.AppID,
 		CapabilityName:        cap.CapabilityName,
 		CapabilityValue:       cap.CapabilityValue,
+		MaxRate:               cap.MaxRate,
 		AuthorizeCapabilityID: cap.CapabilityID,
 		GrantPolicy: CapabilityAttributeBasedPolicy{
 			RequesterAttribute: cap.GrantPolicy.RequesterAttribute,
@@ -0,0 +1,108 @@
+package capability
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Service discovery protocols in NeighborDiscovery capability value
+const (
+	DiscoveryProtocolMDNS = "mdns"
+	DiscoveryProtocolSSDP = "ssdp"
+)
+
+// DiscoveryAnyService matches every service type of the protocol
+const DiscoveryAnyService = "*"
+
+// DiscoveryValue is the broadcast transports and the discovered service types permitted by NeighborDiscovery capability
+type DiscoveryValue struct {
+	Transports []*TransportValue
+	// service types of each protocol
+	Services map[string][]string
+}
+
+// NormalizeServiceType converts mDNS service type to lowercase without the trailing dot.
+// SSDP search targets are compared as they are.
+func NormalizeServiceType(protocol string, serviceType string) string {
+	serviceType = strings.TrimSpace(serviceType)
+	if protocol == DiscoveryProtocolMDNS {
+		serviceType = strings.ToLower(strings.TrimSuffix(serviceType, "."))
+	}
+	return serviceType
+}
+
+// ParseDiscoveryValue parses capability value like "8000/udp", "mdns:_googlecast._tcp"
+// or "8000/udp, mdns:*, ssdp:urn:schemas-upnp-org:device:MediaRenderer:1".
+// Empty value means "8000/udp" and value without transports permits no broadcast.
+func ParseDiscoveryValue(value string) (*DiscoveryValue, error) {
+	discovery := &DiscoveryValue{
+		Transports: []*TransportValue{},
+		Services:   map[string][]string{},
+	}
+
+	if strings.TrimSpace(value) == "" {
+		transport, err := ParseTransportValue(value)
+		if err != nil {
+			return nil, err
+		}
+		discovery.Transports = append(discovery.Transports, transport)
+		return discovery, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("empty item in %v", value)
+		}
+		protocol := ""
+		if idx := strings.Index(item, ":"); idx >= 0 {
+			protocol = strings.ToLower(item[:idx])
+		}
+
+		switch protocol {
+		case DiscoveryProtocolMDNS, DiscoveryProtocolSSDP:
+			serviceType := NormalizeServiceType(protocol, item[len(protocol)+1:])
+			if serviceType == "" {
+				return nil, fmt.Errorf("empty service type in %v", value)
+			}
+			discovery.Services[protocol] = append(discovery.Services[protocol], serviceType)
+		default:
+			transport, err := ParseTransportValue(item)
+			if err != nil {
+				return nil, err
+			}
+			discovery.Transports = append(discovery.Transports, transport)
+		}
+	}
+
+	return discovery, nil
+}
+
+// Allows returns true if the service type of protocol is permitted.
+// DiscoveryAnyService requested like "ssdp:all" is permitted by any service type of the protocol.
+func (d *DiscoveryValue) Allows(protocol string, serviceType string) bool {
+	serviceType = NormalizeServiceType(protocol, serviceType)
+	for _, permitted := range d.Services[protocol] {
+		if permitted == DiscoveryAnyService || serviceType == DiscoveryAnyService || permitted == serviceType {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsAnnouncement returns true if the service type of protocol announced by peer is permitted.
+// Announcement of DiscoveryAnyService carries unknown service types and is permitted only by DiscoveryAnyService.
+func (d *DiscoveryValue) AllowsAnnouncement(protocol string, serviceType string) bool {
+	serviceType = NormalizeServiceType(protocol, serviceType)
+	for _, permitted := range d.Services[protocol] {
+		if permitted == DiscoveryAnyService || permitted == serviceType {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDiscoveryValue parses CapabilityValue as DiscoveryValue
+func (cap *Capability) GetDiscoveryValue() (*DiscoveryValue, error) {
+	return ParseDiscoveryValue(cap.CapabilityValue)
+}
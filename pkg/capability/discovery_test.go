@@ -0,0 +1,53 @@
+package capability
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDiscoveryValue(t *testing.T) {
+	discovery, err := ParseDiscoveryValue("")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 1, len(discovery.Transports))
+	assert.Equal(t, uint16(DefaultTransportPort), discovery.Transports[0].Port)
+	assert.False(t, discovery.Allows(DiscoveryProtocolMDNS, "_googlecast._tcp"))
+
+	discovery, err = ParseDiscoveryValue("8000/udp, mdns:_googlecast._tcp, MDNS:_hap._tcp., ssdp:urn:schemas-upnp-org:device:MediaRenderer:1")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 1, len(discovery.Transports))
+	assert.Equal(t, TransportProtocolUDP, discovery.Transports[0].Protocol)
+	assert.True(t, discovery.Allows(DiscoveryProtocolMDNS, "_googlecast._tcp"))
+	assert.True(t, discovery.Allows(DiscoveryProtocolMDNS, "_GoogleCast._tcp."))
+	assert.True(t, discovery.Allows(DiscoveryProtocolMDNS, "_hap._tcp"))
+	assert.False(t, discovery.Allows(DiscoveryProtocolMDNS, "_airplay._tcp"))
+	assert.True(t, discovery.Allows(DiscoveryProtocolMDNS, DiscoveryAnyService))
+	assert.True(t, discovery.Allows(DiscoveryProtocolSSDP, "urn:schemas-upnp-org:device:MediaRenderer:1"))
+	assert.False(t, discovery.Allows(DiscoveryProtocolSSDP, "upnp:rootdevice"))
+	assert.True(t, discovery.AllowsAnnouncement(DiscoveryProtocolMDNS, "_GoogleCast._tcp."))
+	assert.False(t, discovery.AllowsAnnouncement(DiscoveryProtocolMDNS, "_airplay._tcp"))
+	assert.False(t, discovery.AllowsAnnouncement(DiscoveryProtocolMDNS, DiscoveryAnyService))
+
+	// service types without transports permit no broadcast
+	discovery, err = ParseDiscoveryValue("mdns:*")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 0, len(discovery.Transports))
+	assert.True(t, discovery.Allows(DiscoveryProtocolMDNS, "_airplay._tcp"))
+	assert.False(t, discovery.Allows(DiscoveryProtocolSSDP, DiscoveryAnyService))
+	assert.True(t, discovery.AllowsAnnouncement(DiscoveryProtocolMDNS, DiscoveryAnyService))
+}
+
+func TestParseDiscoveryValueInvalid(t *testing.T) {
+	values := []string{"mdns:", "ssdp: ", "80/icmp", "8000/udp,", "dns:_hap._tcp"}
+
+	for _, value := range values {
+		_, err := ParseDiscoveryValue(value)
+		assert.NotNil(t, err, value)
+	}
+}
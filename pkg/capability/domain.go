@@ -0,0 +1,263 @@
+package capability
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// domainExceptSeparator separates excluded patterns in capability value
+// like "*.example.com except ads.example.com, *.tracker.example.com"
+const domainExceptSeparator = " except "
+
+const wildcardLabel = "*"
+
+// anyLabels stands for one or more labels at the left of the name
+const anyLabels = "**"
+
+// domainProfile converts names to lowercase punycode.
+// Underscores are allowed as in service names like "_dmarc.example.com".
+var domainProfile = idna.New(
+	idna.MapForLookup(),
+	idna.StrictDomainName(false),
+	idna.Transitional(false),
+	idna.VerifyDNSLength(true),
+)
+
+// DomainPattern is a set of domains permitted by ExternalCommunication capability.
+// A leading "*" label matches one or more labels and other "*" labels match exactly one label.
+type DomainPattern struct {
+	// labels ordered from the top level domain
+	labels []string
+	// the leading label is a wildcard matching one or more labels
+	subdomains bool
+	// the name without leading wildcard also matches as legacy "*example.com"
+	apex bool
+	// "*" matches any domain
+	any        bool
+	exclusions []*DomainPattern
+}
+
+func isInvalidLabelRune(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_')
+}
+
+// normalizeLabels converts domain to lowercase punycode labels ordered from the top level domain.
+// Wildcard labels are kept when allowWildcard is true.
+func normalizeLabels(domain string, allowWildcard bool) ([]string, error) {
+	domain = strings.TrimSuffix(strings.TrimSpace(domain), ".")
+	if domain == "" {
+		return nil, fmt.Errorf("empty domain")
+	}
+
+	labels := strings.Split(domain, ".")
+	normalized := make([]string, len(labels))
+	for i, label := range labels {
+		if label == wildcardLabel {
+			if !allowWildcard {
+				return nil, fmt.Errorf("wildcard is not allowed in %v", domain)
+			}
+			normalized[len(labels)-1-i] = label
+			continue
+		}
+		if strings.Contains(label, wildcardLabel) {
+			return nil, fmt.Errorf("wildcard must be a whole label in %v", domain)
+		}
+		ascii, err := domainProfile.ToASCII(label)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label %q in %v: %v", label, domain, err)
+		}
+		if ascii == "" || strings.IndexFunc(ascii, isInvalidLabelRune) >= 0 {
+			return nil, fmt.Errorf("invalid label %q in %v", label, domain)
+		}
+		// the converted label must be converted to itself
+		if again, err := domainProfile.ToASCII(ascii); err != nil || again != ascii {
+			return nil, fmt.Errorf("invalid label %q in %v", label, domain)
+		}
+		normalized[len(labels)-1-i] = ascii
+	}
+
+	if len(strings.Join(normalized, ".")) > 253 {
+		return nil, fmt.Errorf("too long domain %v", domain)
+	}
+
+	return normalized, nil
+}
+
+// NormalizeDomain converts domain to lowercase punycode without the trailing dot
+func NormalizeDomain(domain string) (string, error) {
+	labels, err := normalizeLabels(domain, false)
+	if err != nil {
+		return "", err
+	}
+	return joinLabels(labels), nil
+}
+
+func joinLabels(labels []string) string {
+	reversed := make([]string, len(labels))
+	for i, label := range labels {
+		reversed[len(labels)-1-i] = label
+	}
+	return strings.Join(reversed, ".")
+}
+
+func parseSinglePattern(value string) (*DomainPattern, error) {
+	value = strings.TrimSpace(value)
+	if value == wildcardLabel {
+		return &DomainPattern{any: true}, nil
+	}
+
+	pattern := &DomainPattern{}
+	if strings.HasPrefix(value, wildcardLabel) && !strings.HasPrefix(value, wildcardLabel+".") {
+		// legacy "*example.com" permits example.com and its subdomains
+		value = value[len(wildcardLabel):]
+		pattern.apex = true
+		pattern.subdomains = true
+	} else if strings.HasPrefix(value, wildcardLabel+".") {
+		value = value[len(wildcardLabel)+1:]
+		pattern.subdomains = true
+	}
+
+	labels, err := normalizeLabels(value, true)
+	if err != nil {
+		return nil, err
+	}
+	pattern.labels = labels
+
+	return pattern, nil
+}
+
+// ParseDomainPattern parses capability value like "example.com", "*.example.com",
+// "api.*.example.com" or "*.example.com except ads.example.com, *.tracker.example.com".
+// Names are compared in lowercase punycode ignoring the trailing dot.
+func ParseDomainPattern(value string) (*DomainPattern, error) {
+	// lowercase ASCII only to keep the offsets in value
+	lower := []byte(value)
+	for i, b := range lower {
+		if b >= 'A' && b <= 'Z' {
+			lower[i] = b + 'a' - 'A'
+		}
+	}
+	idx := strings.Index(string(lower), domainExceptSeparator)
+	if idx < 0 {
+		return parseSinglePattern(value)
+	}
+
+	pattern, err := parseSinglePattern(value[:idx])
+	if err != nil {
+		return nil, err
+	}
+	for _, exclusion := range strings.Split(value[idx+len(domainExceptSeparator):], ",") {
+		excluded, err := parseSinglePattern(exclusion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exclusion %v: %v", exclusion, err)
+		}
+		pattern.exclusions = append(pattern.exclusions, excluded)
+	}
+
+	return pattern, nil
+}
+
+// variants returns label sequences of the names in pattern ordered from the top level domain.
+// The sequence ending with anyLabels covers the names with one or more labels at the left.
+func (p *DomainPattern) variants() [][]string {
+	if p.any {
+		return [][]string{{anyLabels}}
+	}
+	variants := [][]string{}
+	if !p.subdomains || p.apex {
+		variants = append(variants, p.labels)
+	}
+	if p.subdomains {
+		variants = append(variants, append(append([]string{}, p.labels...), anyLabels))
+	}
+	return variants
+}
+
+// coverLabels returns true if every name of name sequence is a name of pattern sequence
+func coverLabels(pattern []string, name []string) bool {
+	for i := 0; ; i++ {
+		if i >= len(pattern) || i >= len(name) {
+			return i >= len(pattern) && i >= len(name)
+		}
+		if pattern[i] == anyLabels {
+			return true
+		}
+		if name[i] == anyLabels {
+			return false
+		}
+		if pattern[i] != wildcardLabel && pattern[i] != name[i] {
+			return false
+		}
+	}
+}
+
+// overlapLabels returns true if some name is in both sequences
+func overlapLabels(a []string, b []string) bool {
+	for i := 0; ; i++ {
+		if i >= len(a) || i >= len(b) {
+			return i >= len(a) && i >= len(b)
+		}
+		if a[i] == anyLabels || b[i] == anyLabels {
+			return true
+		}
+		if a[i] != wildcardLabel && b[i] != wildcardLabel && a[i] != b[i] {
+			return false
+		}
+	}
+}
+
+// Match returns true if domain is permitted by pattern.
+// Domain may be a pattern itself, then every name of it must be permitted.
+func (p *DomainPattern) Match(domain string) bool {
+	name, err := ParseDomainPattern(domain)
+	if err != nil || len(name.exclusions) != 0 {
+		return false
+	}
+
+	for _, nameVariant := range name.variants() {
+		covered := false
+		for _, variant := range p.variants() {
+			if coverLabels(variant, nameVariant) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+
+		for _, exclusion := range p.exclusions {
+			for _, excluded := range exclusion.variants() {
+				if overlapLabels(excluded, nameVariant) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
+
+// String returns the normalized value of pattern
+func (p *DomainPattern) String() string {
+	value := wildcardLabel
+	if !p.any {
+		value = joinLabels(p.labels)
+		if p.apex {
+			value = wildcardLabel + value
+		} else if p.subdomains {
+			value = wildcardLabel + "." + value
+		}
+	}
+
+	excluded := []string{}
+	for _, exclusion := range p.exclusions {
+		excluded = append(excluded, exclusion.String())
+	}
+	if len(excluded) != 0 {
+		value += domainExceptSeparator + strings.Join(excluded, ", ")
+	}
+	return value
+}
@@ -0,0 +1,187 @@
+package capability
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain     string
+		normalized string
+	}{
+		{"example.com", "example.com"},
+		{"Example.COM.", "example.com"},
+		{" www.example.com ", "www.example.com"},
+		{"_dmarc.example.com", "_dmarc.example.com"},
+		{"bücher.example", "xn--bcher-kva.example"},
+		{"BÜCHER.example", "xn--bcher-kva.example"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+	}
+
+	for _, test := range tests {
+		normalized, err := NormalizeDomain(test.domain)
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		assert.Equal(t, test.normalized, normalized, test.domain)
+	}
+
+	invalid := []string{"", ".", "a..example.com", "*.example.com", "ex*ample.com", strings.Repeat("a", 64) + ".com"}
+	for _, domain := range invalid {
+		_, err := NormalizeDomain(domain)
+		assert.NotNil(t, err, domain)
+	}
+}
+
+func TestDomainPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		domain  string
+		allowed bool
+	}{
+		{"*", "example.com", true},
+		{"*", "*.example.com", true},
+		{"example.com", "example.com", true},
+		{"example.com", "EXAMPLE.com.", true},
+		{"example.com", "www.example.com", false},
+		{"example.com", "evil.com", false},
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "evilexample.com", false},
+		{"*.example.com", "example.com.evil.com", false},
+		// legacy value permits the domain and its subdomains
+		{"*example.com", "example.com", true},
+		{"*example.com", "www.example.com", true},
+		{"*example.com", "evilexample.com", false},
+		// wildcard label other than leading one matches exactly one label
+		{"api.*.example.com", "api.eu.example.com", true},
+		{"api.*.example.com", "api.eu.west.example.com", false},
+		{"api.*.example.com", "api.example.com", false},
+		{"*.bücher.example", "www.xn--bcher-kva.example", true},
+		{"*.xn--bcher-kva.example", "www.Bücher.example", true},
+		// patterns requested to be granted
+		{"*.example.com", "*.hoge.example.com", true},
+		{"*.hoge.example.com", "*.test.hoge.example.com", true},
+		{"*.hoge.example.com", "*.example.com", false},
+		{"example.com", "*.example.com", false},
+		{"*.example.com", "*", false},
+		{"*.example.com", "*example.com", false},
+		{"*example.com", "*.example.com", true},
+		{"api.*.example.com", "*.example.com", false},
+		{"*.example.com", "api.*.example.com", true},
+		// invalid names are never allowed
+		{"*", "a..example.com", false},
+		{"*", "", false},
+		{"ex*ample.com", "example.com", false},
+	}
+
+	for _, test := range tests {
+		cap := NewCreateSkeltonCapability()
+		cap.CapabilityName = CAPABILITY_NAME_EXTERNAL_COMMUNICATION
+		cap.CapabilityValue = test.pattern
+		assert.Equal(t, test.allowed, cap.IsDomainAllowed(test.domain), "%v %v", test.pattern, test.domain)
+	}
+}
+
+func TestDomainPatternExclusion(t *testing.T) {
+	pattern, err := ParseDomainPattern("*.example.com except ads.example.com, *.tracker.example.com")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "*.example.com except ads.example.com, *.tracker.example.com", pattern.String())
+
+	assert.True(t, pattern.Match("www.example.com"))
+	assert.True(t, pattern.Match("tracker.example.com"))
+	assert.False(t, pattern.Match("ads.example.com"))
+	assert.False(t, pattern.Match("ADS.example.com."))
+	assert.False(t, pattern.Match("x.tracker.example.com"))
+	assert.True(t, pattern.Match("x.ads.example.com"))
+
+	// requested patterns must not include excluded names
+	assert.True(t, pattern.Match("*.www.example.com"))
+	assert.False(t, pattern.Match("*.example.com"))
+	assert.False(t, pattern.Match("*.tracker.example.com"))
+	assert.False(t, pattern.Match("*.a.tracker.example.com"))
+	assert.False(t, pattern.Match("*.example.com except ads.example.com"))
+
+	_, err = ParseDomainPattern("*.example.com except ")
+	assert.NotNil(t, err)
+}
+
+func FuzzDomainPatternMatch(f *testing.F) {
+	seeds := []struct {
+		pattern string
+		domain  string
+	}{
+		{"*", "example.com"},
+		{"example.com", "example.com"},
+		{"*.example.com", "www.example.com"},
+		{"*example.com", "evilexample.com"},
+		{"api.*.example.com", "api.eu.example.com"},
+		{"*.example.com except ads.example.com", "ads.example.com"},
+		{"*.bücher.example", "www.xn--bcher-kva.example"},
+	}
+	for _, seed := range seeds {
+		f.Add(seed.pattern, seed.domain)
+	}
+
+	f.Fuzz(func(t *testing.T, value string, domain string) {
+		pattern, err := ParseDomainPattern(value)
+		if err != nil {
+			return
+		}
+		normalized, err := NormalizeDomain(domain)
+		if err != nil {
+			// invalid names are never allowed
+			if !strings.Contains(domain, wildcardLabel) && pattern.Match(domain) {
+				t.Fatalf("invalid domain %q is allowed by %q", domain, value)
+			}
+			return
+		}
+
+		allowed := pattern.Match(domain)
+		// trailing dot and case do not change the result
+		if allowed != pattern.Match(normalized) || allowed != pattern.Match(strings.ToUpper(normalized)+".") {
+			t.Fatalf("%q and %q differ for %q", domain, normalized, value)
+		}
+
+		// the normalized value is the same pattern
+		reparsed, err := ParseDomainPattern(pattern.String())
+		if err != nil {
+			t.Fatalf("Failed to parse %q of %q %v", pattern.String(), value, err)
+		}
+		if allowed != reparsed.Match(normalized) {
+			t.Fatalf("%q and %q differ for %q", value, pattern.String(), normalized)
+		}
+
+		// exact pattern allows itself only
+		exact, err := ParseDomainPattern(normalized)
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		if !exact.Match(domain) || exact.Match("x."+normalized) {
+			t.Fatalf("exact pattern %q mismatches", normalized)
+		}
+
+		// leading wildcard allows the subdomains only at label boundaries
+		subdomains, err := ParseDomainPattern("*." + normalized)
+		if err != nil {
+			return
+		}
+		if subdomains.Match(normalized) || subdomains.Match("x"+normalized) {
+			t.Fatalf("*.%q allows the name out of its subdomains", normalized)
+		}
+		if len(normalized) < 250 && !subdomains.Match("x."+normalized) {
+			t.Fatalf("*.%q does not allow its subdomain", normalized)
+		}
+
+		// the name requested as a pattern is allowed if it is allowed as a name
+		if allowed != pattern.Match(normalized) {
+			t.Fatalf("%q mismatches as pattern", normalized)
+		}
+	})
+}
@@ -46,6 +46,7 @@ func (cap *Capability) Sign(privateKey *rsa.PrivateKey) error {
 		AppID:           cap.AppID,
 		CapabilityName:  cap.CapabilityName,
 		CapabilityValue: cap.CapabilityValue,
+		MaxRate:         cap.MaxRate,
 		GrantCondition:  cap.GrantCondition,
 		GrantPolicy:     cap.GrantPolicy,
 		CapabilitySignature: CapabilitySignature{
@@ -83,6 +84,7 @@ func (cap *Capability) Verify(publicKey *rsa.PublicKey) error {
 		AppID:           cap.AppID,
 		CapabilityName:  cap.CapabilityName,
 		CapabilityValue: cap.CapabilityValue,
+		MaxRate:         cap.MaxRate,
 		GrantCondition:  cap.GrantCondition,
 		GrantPolicy:     cap.GrantPolicy,
 		CapabilitySignature: CapabilitySignature{
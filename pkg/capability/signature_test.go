@@ -1,6 +1,7 @@
 package capability
 
 import (
+	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
 	"testing"
@@ -169,3 +170,27 @@ func TestBase64Certificate(t *testing.T) {
 		t.Fatalf("Failed %v", err)
 	}
 }
+
+func TestCapabilityMaxRateSigned(t *testing.T) {
+	privKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	cap := NewCreateSkeltonCapability()
+	cap.MaxRate = 1000
+	err = cap.Sign(privKey)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	err = cap.Verify(&privKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	cap.MaxRate = 0
+	err = cap.Verify(&privKey.PublicKey)
+	if err == nil {
+		t.Fatalf("Verification must fail for tampered rate")
+	}
+}
@@ -0,0 +1,71 @@
+package capability
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Transport protocols in capability value
+const (
+	TransportProtocolTCP  = "tcp"
+	TransportProtocolUDP  = "udp"
+	TransportProtocolSCTP = "sctp"
+)
+
+// DefaultTransportPort is used when capability value has no port
+const DefaultTransportPort = 8000
+
+// TransportValue is a port and protocol permitted by capability
+type TransportValue struct {
+	Protocol string
+	// Port is 0 when any port is permitted
+	Port uint16
+}
+
+// ParseTransportValue parses capability value like "8000/udp", "1883/tcp" or "*/sctp".
+// Protocol defaults to udp and empty value means "8000/udp".
+func ParseTransportValue(value string) (*TransportValue, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return &TransportValue{
+			Protocol: TransportProtocolUDP,
+			Port:     DefaultTransportPort,
+		}, nil
+	}
+
+	portStr := value
+	protocol := TransportProtocolUDP
+	if idx := strings.Index(value, "/"); idx >= 0 {
+		portStr = value[:idx]
+		protocol = strings.ToLower(value[idx+1:])
+	}
+
+	switch protocol {
+	case TransportProtocolTCP, TransportProtocolUDP, TransportProtocolSCTP:
+	default:
+		return nil, fmt.Errorf("invalid protocol %v in %v", protocol, value)
+	}
+
+	if portStr == "*" {
+		return &TransportValue{
+			Protocol: protocol,
+			Port:     0,
+		}, nil
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, fmt.Errorf("invalid port %v in %v", portStr, value)
+	}
+
+	return &TransportValue{
+		Protocol: protocol,
+		Port:     uint16(port),
+	}, nil
+}
+
+// GetTransportValue parses CapabilityValue as TransportValue
+func (cap *Capability) GetTransportValue() (*TransportValue, error) {
+	return ParseTransportValue(cap.CapabilityValue)
+}
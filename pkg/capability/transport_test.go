@@ -0,0 +1,41 @@
+package capability
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTransportValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		protocol string
+		port     uint16
+	}{
+		{"", TransportProtocolUDP, 8000},
+		{"8000", TransportProtocolUDP, 8000},
+		{"8000/udp", TransportProtocolUDP, 8000},
+		{"1883/tcp", TransportProtocolTCP, 1883},
+		{"80/TCP", TransportProtocolTCP, 80},
+		{"*/tcp", TransportProtocolTCP, 0},
+		{"3868/sctp", TransportProtocolSCTP, 3868},
+	}
+
+	for _, test := range tests {
+		transport, err := ParseTransportValue(test.value)
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		assert.Equal(t, test.protocol, transport.Protocol, test.value)
+		assert.Equal(t, test.port, transport.Port, test.value)
+	}
+}
+
+func TestParseTransportValueInvalid(t *testing.T) {
+	values := []string{"0/tcp", "65536/udp", "80/icmp", "http/tcp", "/tcp"}
+
+	for _, value := range values {
+		_, err := ParseTransportValue(value)
+		assert.NotNil(t, err, value)
+	}
+}
@@ -0,0 +1,112 @@
+package eaptls
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// EAP codes
+const (
+	CodeRequest  uint8 = 1
+	CodeResponse uint8 = 2
+	CodeSuccess  uint8 = 3
+	CodeFailure  uint8 = 4
+)
+
+// EAP types
+const (
+	TypeIdentity uint8 = 1
+	TypeNak      uint8 = 3
+	TypeTLS      uint8 = 13
+)
+
+// EAP-TLS flags
+const (
+	flagLength uint8 = 0x80
+	flagMore   uint8 = 0x40
+	flagStart  uint8 = 0x20
+)
+
+// Packet is an EAP packet
+type Packet struct {
+	Code       uint8
+	Identifier uint8
+	Type       uint8
+	Data       []byte
+}
+
+// ParsePacket parses EAP packet
+func ParsePacket(b []byte) (*Packet, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("eap packet too short")
+	}
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if length < 4 || length > len(b) {
+		return nil, fmt.Errorf("invalid eap packet length %v", length)
+	}
+
+	p := &Packet{
+		Code:       b[0],
+		Identifier: b[1],
+	}
+	if p.Code == CodeRequest || p.Code == CodeResponse {
+		if length < 5 {
+			return nil, fmt.Errorf("eap packet without type")
+		}
+		p.Type = b[4]
+		p.Data = b[5:length]
+	}
+
+	return p, nil
+}
+
+// Encode encodes EAP packet
+func (p *Packet) Encode() []byte {
+	if p.Code == CodeSuccess || p.Code == CodeFailure {
+		return []byte{p.Code, p.Identifier, 0, 4}
+	}
+
+	b := make([]byte, 5+len(p.Data))
+	b[0] = p.Code
+	b[1] = p.Identifier
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+	b[4] = p.Type
+	copy(b[5:], p.Data)
+	return b
+}
+
+// tlsMessage is the payload of EAP-TLS packet
+type tlsMessage struct {
+	Flags       uint8
+	TotalLength uint32
+	Data        []byte
+}
+
+func parseTLSMessage(b []byte) (*tlsMessage, error) {
+	if len(b) < 1 {
+		return nil, fmt.Errorf("eap-tls message without flags")
+	}
+	m := &tlsMessage{
+		Flags: b[0],
+		Data:  b[1:],
+	}
+	if m.Flags&flagLength != 0 {
+		if len(b) < 5 {
+			return nil, fmt.Errorf("eap-tls message without length")
+		}
+		m.TotalLength = binary.BigEndian.Uint32(b[1:5])
+		m.Data = b[5:]
+	}
+	return m, nil
+}
+
+func (m *tlsMessage) encode() []byte {
+	if m.Flags&flagLength == 0 {
+		return append([]byte{m.Flags}, m.Data...)
+	}
+	b := make([]byte, 5+len(m.Data))
+	b[0] = m.Flags
+	binary.BigEndian.PutUint32(b[1:5], m.TotalLength)
+	copy(b[5:], m.Data)
+	return b
+}
@@ -0,0 +1,309 @@
+package eaptls
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+	"layeh.com/radius/vendors/microsoft"
+)
+
+// DefaultSessionTimeout is the duration keeping idle authentication
+const DefaultSessionTimeout = 30 * time.Second
+
+// Result is the station authenticated by EAP-TLS
+type Result struct {
+	HWAddress net.HardwareAddr
+	// identity sent in EAP-Response/Identity, which is not verified
+	Identity    string
+	Certificate *x509.Certificate
+}
+
+// Server is a RADIUS server authenticating stations by EAP-TLS
+type Server struct {
+	mu        sync.Mutex
+	secret    []byte
+	tlsConfig *tls.Config
+	sessions  map[string]*session
+	// decides whether the authenticated station is accepted
+	authorize      func(*Result) error
+	SessionTimeout time.Duration
+}
+
+// NewServer creates server presenting certificate and verifying stations with clientCAs
+func NewServer(secret []byte, certificate tls.Certificate, clientCAs *x509.CertPool, authorize func(*Result) error) *Server {
+	return &Server{
+		secret: secret,
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			ClientCAs:    clientCAs,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+			// EAP-TLS with TLS 1.3 (RFC 9190) is not supported
+			MaxVersion: tls.VersionTLS12,
+		},
+		sessions:       map[string]*session{},
+		authorize:      authorize,
+		SessionTimeout: DefaultSessionTimeout,
+	}
+}
+
+// ListenAndServe serves RADIUS on addr like ":1812"
+func (s *Server) ListenAndServe(addr string) error {
+	server := radius.PacketServer{
+		Addr:         addr,
+		Handler:      s,
+		SecretSource: radius.StaticSecretSource(s.secret),
+	}
+	return server.ListenAndServe()
+}
+
+// ServeRADIUS implements radius.Handler
+func (s *Server) ServeRADIUS(w radius.ResponseWriter, r *radius.Request) {
+	if r.Code != radius.CodeAccessRequest {
+		return
+	}
+
+	resp, err := s.HandleAccessRequest(r.Packet)
+	if err != nil {
+		log.Printf("error: Failed to handle access request from %v %v", r.RemoteAddr, err)
+		return
+	}
+	err = w.Write(resp)
+	if err != nil {
+		log.Printf("error: Failed to reply to %v %v", r.RemoteAddr, err)
+	}
+}
+
+func verifyMessageAuthenticator(p *radius.Packet) error {
+	received := rfc2869.MessageAuthenticator_Get(p)
+	if len(received) != md5.Size {
+		return fmt.Errorf("message authenticator is missing")
+	}
+
+	q := *p
+	q.Attributes = make(radius.Attributes, len(p.Attributes))
+	copy(q.Attributes, p.Attributes)
+	rfc2869.MessageAuthenticator_Set(&q, make([]byte, md5.Size))
+	b, err := q.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(md5.New, p.Secret)
+	mac.Write(b)
+	if !hmac.Equal(mac.Sum(nil), received) {
+		return fmt.Errorf("invalid message authenticator")
+	}
+	return nil
+}
+
+// signResponse sets Message-Authenticator computed with the request authenticator
+func signResponse(p *radius.Packet) error {
+	err := rfc2869.MessageAuthenticator_Set(p, make([]byte, md5.Size))
+	if err != nil {
+		return err
+	}
+	b, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(md5.New, p.Secret)
+	mac.Write(b)
+	return rfc2869.MessageAuthenticator_Set(p, mac.Sum(nil))
+}
+
+func (s *Server) reply(req *radius.Packet, code radius.Code, eap *Packet, state string) (*radius.Packet, error) {
+	resp := req.Response(code)
+	err := rfc2869.EAPMessage_Set(resp, eap.Encode())
+	if err != nil {
+		return nil, err
+	}
+	if state != "" {
+		err = rfc2865.State_SetString(resp, state)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+func (s *Server) reject(req *radius.Packet, identifier uint8) (*radius.Packet, error) {
+	resp, err := s.reply(req, radius.CodeAccessReject, &Packet{Code: CodeFailure, Identifier: identifier}, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp, signResponse(resp)
+}
+
+func (s *Server) accept(req *radius.Packet, sess *session, identifier uint8) (*radius.Packet, error) {
+	msk, err := sess.msk()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.reply(req, radius.CodeAccessAccept, &Packet{Code: CodeSuccess, Identifier: identifier}, "")
+	if err != nil {
+		return nil, err
+	}
+	err = microsoft.MSMPPERecvKey_Add(resp, msk[0:32])
+	if err != nil {
+		return nil, err
+	}
+	err = microsoft.MSMPPESendKey_Add(resp, msk[32:64])
+	if err != nil {
+		return nil, err
+	}
+	err = rfc2865.UserName_SetString(resp, sess.identity)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, signResponse(resp)
+}
+
+func (s *Server) getSession(state string) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, sess := range s.sessions {
+		if now.Sub(sess.lastSeen) > s.SessionTimeout {
+			sess.close()
+			delete(s.sessions, key)
+		}
+	}
+
+	sess, ok := s.sessions[state]
+	if !ok {
+		return nil
+	}
+	sess.lastSeen = now
+	return sess
+}
+
+func (s *Server) addSession(sess *session) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	state := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[state] = sess
+
+	return state, nil
+}
+
+func (s *Server) removeSession(state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[state]; ok {
+		sess.close()
+		delete(s.sessions, state)
+	}
+}
+
+// HandleAccessRequest processes Access-Request carrying EAP-Message and returns the response
+func (s *Server) HandleAccessRequest(req *radius.Packet) (*radius.Packet, error) {
+	err := verifyMessageAuthenticator(req)
+	if err != nil {
+		return nil, err
+	}
+
+	eapBytes, err := rfc2869.EAPMessage_Lookup(req)
+	if err != nil {
+		return nil, fmt.Errorf("access request without eap message")
+	}
+	eap, err := ParsePacket(eapBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	state := rfc2865.State_GetString(req)
+	if state == "" {
+		if eap.Code != CodeResponse || eap.Type != TypeIdentity {
+			return s.reject(req, eap.Identifier)
+		}
+		sess := newSession(string(eap.Data), eap.Identifier, s.tlsConfig)
+		state, err = s.addSession(sess)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.reply(req, radius.CodeAccessChallenge, sess.start(), state)
+		if err != nil {
+			return nil, err
+		}
+		return resp, signResponse(resp)
+	}
+
+	sess := s.getSession(state)
+	if sess == nil {
+		return s.reject(req, eap.Identifier)
+	}
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.accepted {
+		return s.accept(req, sess, eap.Identifier+1)
+	}
+	// the session may be failed while waiting for the retransmitted request
+	if s.getSession(state) != sess {
+		return s.reject(req, eap.Identifier)
+	}
+
+	next, err := sess.handle(eap)
+	if err != nil {
+		log.Printf("info: eap-tls authentication of %v failed %v", sess.identity, err)
+		s.removeSession(state)
+		return s.reject(req, eap.Identifier+1)
+	}
+	if next != nil {
+		resp, err := s.reply(req, radius.CodeAccessChallenge, next, state)
+		if err != nil {
+			return nil, err
+		}
+		return resp, signResponse(resp)
+	}
+
+	// handshake is acknowledged by peer.
+	// The session is kept until it expires to accept the retransmitted acknowledgement.
+	sess.close()
+	result := &Result{
+		Identity:    sess.identity,
+		Certificate: sess.peerCertificate(),
+	}
+	hwAddr, err := net.ParseMAC(rfc2865.CallingStationID_GetString(req))
+	if err == nil {
+		result.HWAddress = hwAddr
+	}
+	if result.Certificate == nil || result.HWAddress == nil {
+		s.removeSession(state)
+		return s.reject(req, eap.Identifier+1)
+	}
+	if s.authorize != nil {
+		err = s.authorize(result)
+		if err != nil {
+			log.Printf("info: station %v(%v) is not authorized %v", result.HWAddress, result.Certificate.Subject.CommonName, err)
+			s.removeSession(state)
+			return s.reject(req, eap.Identifier+1)
+		}
+	}
+
+	sess.accepted = true
+	return s.accept(req, sess, eap.Identifier+1)
+}
@@ -0,0 +1,241 @@
+package eaptls
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"layeh.com/radius"
+	"layeh.com/radius/rfc2865"
+	"layeh.com/radius/rfc2869"
+)
+
+var testSecret = []byte("testing123")
+
+func createTestCertificate(t *testing.T, cn string, isCA bool, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		DNSNames:              []string{cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	return cert, key
+}
+
+type testPeer struct {
+	conn       *eapConn
+	tlsConn    *tls.Conn
+	done       chan error
+	identifier uint8
+	state      []byte
+	inBuf      []byte
+}
+
+func newTestAccessRequest(eap *Packet, state []byte) *radius.Packet {
+	req := radius.New(radius.CodeAccessRequest, testSecret)
+	rfc2865.CallingStationID_SetString(req, "02-00-00-00-00-01")
+	rfc2869.EAPMessage_Set(req, eap.Encode())
+	if state != nil {
+		rfc2865.State_Set(req, state)
+	}
+	signResponse(req)
+	return req
+}
+
+func (p *testPeer) respond(data []byte) *radius.Packet {
+	eap := &Packet{
+		Code:       CodeResponse,
+		Identifier: p.identifier,
+		Type:       TypeTLS,
+		Data:       (&tlsMessage{Data: data}).encode(),
+	}
+	return newTestAccessRequest(eap, p.state)
+}
+
+// next returns the next Access-Request for the challenge
+func (p *testPeer) next(t *testing.T, challenge *radius.Packet) *radius.Packet {
+	eap, err := ParsePacket(rfc2869.EAPMessage_Get(challenge))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	p.identifier = eap.Identifier
+	p.state = rfc2865.State_Get(challenge)
+	m, err := parseTLSMessage(eap.Data)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	if m.Flags&flagStart != 0 {
+		go func() {
+			p.done <- p.tlsConn.Handshake()
+		}()
+		<-p.conn.waiting
+		return p.respond(p.conn.takeOutput())
+	}
+
+	p.inBuf = append(p.inBuf, m.Data...)
+	if m.Flags&flagMore != 0 {
+		return p.respond(nil)
+	}
+	p.conn.in <- p.inBuf
+	p.inBuf = nil
+	select {
+	case <-p.conn.waiting:
+	case err := <-p.done:
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+	}
+	return p.respond(p.conn.takeOutput())
+}
+
+// handleRetransmitted sends req twice concurrently as retransmitted by authenticator
+// and returns the response after checking both carry the same EAP message
+func handleRetransmitted(t *testing.T, server *Server, req *radius.Packet) *radius.Packet {
+	responses := make(chan *radius.Packet, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := server.HandleAccessRequest(req)
+			if err != nil {
+				t.Errorf("Failed %v", err)
+			}
+			responses <- resp
+		}()
+	}
+	resp1, resp2 := <-responses, <-responses
+	if resp1 == nil || resp2 == nil {
+		t.Fatalf("Failed no response")
+	}
+	assert.Equal(t, resp1.Code, resp2.Code)
+	if resp1.Code == radius.CodeAccessChallenge {
+		assert.Equal(t, rfc2869.EAPMessage_Get(resp1), rfc2869.EAPMessage_Get(resp2))
+	}
+	return resp1
+}
+
+func authenticate(t *testing.T, server *Server, clientCert tls.Certificate, caPool *x509.CertPool, retransmit bool) *radius.Packet {
+	peer := &testPeer{
+		conn: newEAPConn(),
+		done: make(chan error, 1),
+	}
+	peer.tlsConn = tls.Client(peer.conn, &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      caPool,
+		ServerName:   "pep",
+	})
+	defer peer.conn.Close()
+
+	req := newTestAccessRequest(&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("dev1")}, nil)
+	for i := 0; i < 20; i++ {
+		var resp *radius.Packet
+		if retransmit && peer.state != nil {
+			resp = handleRetransmitted(t, server, req)
+		} else {
+			var err error
+			resp, err = server.HandleAccessRequest(req)
+			if err != nil {
+				t.Fatalf("Failed %v", err)
+			}
+		}
+		assert.Nil(t, verifyMessageAuthenticator(resp))
+		if resp.Code != radius.CodeAccessChallenge {
+			return resp
+		}
+		req = peer.next(t, resp)
+	}
+	t.Fatalf("Failed authentication does not finish")
+	return nil
+}
+
+func TestEAPTLS(t *testing.T) {
+	caCert, caKey := createTestCertificate(t, "ca", true, nil, nil)
+	serverCert, serverKey := createTestCertificate(t, "pep", false, caCert, caKey)
+	deviceCert, deviceKey := createTestCertificate(t, "virt-dev-1", false, caCert, caKey)
+	otherCA, otherKey := createTestCertificate(t, "other-ca", true, nil, nil)
+	otherCert, otherDeviceKey := createTestCertificate(t, "virt-dev-1", false, otherCA, otherKey)
+
+	caPool := x509.NewCertPool()
+	caPool.AddCert(caCert)
+
+	var authorized *Result
+	server := NewServer(testSecret,
+		tls.Certificate{Certificate: [][]byte{serverCert.Raw}, PrivateKey: serverKey},
+		caPool,
+		func(result *Result) error {
+			if result.Certificate.Subject.CommonName != "virt-dev-1" {
+				return fmt.Errorf("unknown device")
+			}
+			authorized = result
+			return nil
+		})
+
+	resp := authenticate(t, server, tls.Certificate{Certificate: [][]byte{deviceCert.Raw}, PrivateKey: deviceKey}, caPool, false)
+	assert.Equal(t, radius.CodeAccessAccept, resp.Code)
+	eap, err := ParsePacket(rfc2869.EAPMessage_Get(resp))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, CodeSuccess, eap.Code)
+	assert.Equal(t, "02:00:00:00:00:01", authorized.HWAddress.String())
+	assert.Equal(t, "dev1", authorized.Identity)
+	assert.Equal(t, "virt-dev-1", authorized.Certificate.Subject.CommonName)
+	// accepted session is kept for the retransmitted acknowledgement
+	assert.Equal(t, 1, len(server.sessions))
+
+	// certificate signed by unknown CA
+	resp = authenticate(t, server, tls.Certificate{Certificate: [][]byte{otherCert.Raw}, PrivateKey: otherDeviceKey}, caPool, false)
+	assert.Equal(t, radius.CodeAccessReject, resp.Code)
+	assert.Equal(t, 1, len(server.sessions))
+
+	// retransmitted responses are answered with the same request until the handshake is acknowledged
+	authorized = nil
+	resp = authenticate(t, server, tls.Certificate{Certificate: [][]byte{deviceCert.Raw}, PrivateKey: deviceKey}, caPool, true)
+	assert.Equal(t, radius.CodeAccessAccept, resp.Code)
+	assert.Equal(t, "virt-dev-1", authorized.Certificate.Subject.CommonName)
+	assert.Equal(t, 2, len(server.sessions))
+
+	// accepted sessions expire
+	server.SessionTimeout = 0
+	assert.Nil(t, server.getSession(""))
+	assert.Equal(t, 0, len(server.sessions))
+}
+
+func TestMessageAuthenticator(t *testing.T) {
+	req := newTestAccessRequest(&Packet{Code: CodeResponse, Identifier: 1, Type: TypeIdentity, Data: []byte("dev1")}, nil)
+	assert.Nil(t, verifyMessageAuthenticator(req))
+
+	req.Secret = []byte("invalid")
+	assert.NotNil(t, verifyMessageAuthenticator(req))
+
+	server := NewServer(testSecret, tls.Certificate{}, x509.NewCertPool(), nil)
+	_, err := server.HandleAccessRequest(req)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,256 @@
+package eaptls
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxFragmentSize is the size of TLS data in an EAP-TLS request fitting in EAPOL frames
+const maxFragmentSize = 1000
+
+// mskLabel is the label to export keying material of EAP-TLS (RFC 5216)
+const mskLabel = "client EAP encryption"
+
+// eapConn is net.Conn carrying TLS records in EAP-TLS messages.
+// TLS server reads records received from peer and the written ones are sent in the next request.
+type eapConn struct {
+	mu      sync.Mutex
+	in      chan []byte
+	readBuf []byte
+	out     bytes.Buffer
+	// notified when TLS server waits for the next flight of peer
+	waiting chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func newEAPConn() *eapConn {
+	return &eapConn{
+		in:      make(chan []byte, 1),
+		waiting: make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (c *eapConn) Read(b []byte) (int, error) {
+	if len(c.readBuf) == 0 {
+		c.waiting <- struct{}{}
+		select {
+		case data := <-c.in:
+			c.readBuf = data
+		case <-c.closed:
+			return 0, io.EOF
+		}
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *eapConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.out.Write(b)
+}
+
+func (c *eapConn) takeOutput() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]byte, c.out.Len())
+	copy(out, c.out.Bytes())
+	c.out.Reset()
+	return out
+}
+
+func (c *eapConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *eapConn) LocalAddr() net.Addr                { return eapAddr{} }
+func (c *eapConn) RemoteAddr() net.Addr               { return eapAddr{} }
+func (c *eapConn) SetDeadline(t time.Time) error      { return nil }
+func (c *eapConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *eapConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type eapAddr struct{}
+
+func (eapAddr) Network() string { return "eap" }
+func (eapAddr) String() string  { return "eap" }
+
+// session is an EAP-TLS authentication of a station
+type session struct {
+	// serializes retransmitted and concurrent Access-Requests of the session
+	mu         sync.Mutex
+	identifier uint8
+	identity   string
+	conn       *eapConn
+	tlsConn    *tls.Conn
+	done       chan error
+	finished   bool
+	handshake  error
+	// fragments received from peer
+	inBuf []byte
+	// fragments to be sent to peer
+	outBuf    []byte
+	outLength int
+	// request replayed when peer retransmits its response
+	last *Packet
+	// Access-Accept is sent again for the acknowledgement retransmitted by authenticator
+	accepted bool
+	// updated by Server under its lock
+	lastSeen time.Time
+}
+
+func newSession(identity string, identifier uint8, config *tls.Config) *session {
+	s := &session{
+		identifier: identifier,
+		identity:   identity,
+		conn:       newEAPConn(),
+		done:       make(chan error, 1),
+		lastSeen:   time.Now(),
+	}
+	s.tlsConn = tls.Server(s.conn, config)
+	go func() {
+		s.done <- s.tlsConn.Handshake()
+	}()
+
+	// wait until TLS server reads ClientHello
+	select {
+	case <-s.conn.waiting:
+	case err := <-s.done:
+		s.finished = true
+		s.handshake = err
+	}
+
+	return s
+}
+
+func (s *session) close() {
+	s.conn.Close()
+}
+
+func (s *session) nextRequest(data []byte) *Packet {
+	s.identifier++
+	s.last = &Packet{
+		Code:       CodeRequest,
+		Identifier: s.identifier,
+		Type:       TypeTLS,
+		Data:       data,
+	}
+	return s.last
+}
+
+func (s *session) start() *Packet {
+	return s.nextRequest((&tlsMessage{Flags: flagStart}).encode())
+}
+
+// nextFragment returns request carrying the next fragment of TLS data to peer
+func (s *session) nextFragment() *Packet {
+	m := &tlsMessage{}
+	if len(s.outBuf) == s.outLength && len(s.outBuf) > maxFragmentSize {
+		m.Flags |= flagLength
+		m.TotalLength = uint32(s.outLength)
+	}
+	size := len(s.outBuf)
+	if size > maxFragmentSize {
+		size = maxFragmentSize
+		m.Flags |= flagMore
+	}
+	m.Data = s.outBuf[:size]
+	s.outBuf = s.outBuf[size:]
+
+	return s.nextRequest(m.encode())
+}
+
+// feed passes TLS records of peer to TLS server and waits until it replies or finishes handshake
+func (s *session) feed(data []byte) error {
+	s.conn.in <- data
+	select {
+	case <-s.conn.waiting:
+	case err := <-s.done:
+		s.finished = true
+		s.handshake = err
+	}
+
+	s.outBuf = s.conn.takeOutput()
+	s.outLength = len(s.outBuf)
+	return s.handshake
+}
+
+// handle processes EAP-TLS response of peer.
+// It returns the next request, or nil when the handshake is finished and acknowledged by peer.
+func (s *session) handle(p *Packet) (*Packet, error) {
+	// response to the previous request is retransmitted as our request was lost
+	if p.Code == CodeResponse && p.Identifier+1 == s.identifier && s.last != nil {
+		return s.last, nil
+	}
+	if p.Code != CodeResponse || p.Identifier != s.identifier {
+		return nil, fmt.Errorf("unexpected eap packet code %v id %v", p.Code, p.Identifier)
+	}
+	if p.Type != TypeTLS {
+		return nil, fmt.Errorf("peer does not accept eap-tls (type %v)", p.Type)
+	}
+
+	m, err := parseTLSMessage(p.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	// peer acknowledges our fragment
+	if len(s.outBuf) != 0 {
+		if len(m.Data) != 0 {
+			return nil, fmt.Errorf("peer sent data before receiving all fragments")
+		}
+		return s.nextFragment(), nil
+	}
+
+	s.inBuf = append(s.inBuf, m.Data...)
+	if m.Flags&flagMore != 0 {
+		// acknowledge fragment
+		return s.nextRequest((&tlsMessage{}).encode()), nil
+	}
+
+	if len(s.inBuf) == 0 {
+		if s.finished {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("peer sent empty message during handshake")
+	}
+
+	data := s.inBuf
+	s.inBuf = nil
+	if s.finished {
+		return nil, fmt.Errorf("peer sent data after handshake")
+	}
+	err = s.feed(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.outBuf) == 0 {
+		return nil, fmt.Errorf("tls server sent no data")
+	}
+
+	return s.nextFragment(), nil
+}
+
+// peerCertificate returns the verified certificate of peer
+func (s *session) peerCertificate() *x509.Certificate {
+	state := s.tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// msk returns Master Session Key derived from TLS session
+func (s *session) msk() ([]byte, error) {
+	state := s.tlsConn.ConnectionState()
+	return state.ExportKeyingMaterial(mskLabel, nil, 128)
+}
@@ -0,0 +1,165 @@
+package hostapd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const ctrlBufferSize = 4096
+
+// DefaultRequestTimeout is the timeout waiting for the reply of hostapd
+const DefaultRequestTimeout = 3 * time.Second
+
+// Client is a client of hostapd control interface
+type Client struct {
+	mu        sync.Mutex
+	conn      *net.UnixConn
+	localPath string
+	attached  bool
+	// events received while waiting for replies
+	pending []*Event
+	Timeout time.Duration
+}
+
+// Dial connects to hostapd control socket at ctrlPath like /var/run/hostapd/wlan0
+func Dial(ctrlPath string) (*Client, error) {
+	localDir, err := ioutil.TempDir("", "hostapd-ctrl")
+	if err != nil {
+		return nil, err
+	}
+	localPath := filepath.Join(localDir, "sock")
+
+	conn, err := net.DialUnix("unixgram",
+		&net.UnixAddr{Name: localPath, Net: "unixgram"},
+		&net.UnixAddr{Name: ctrlPath, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(localDir)
+		return nil, err
+	}
+
+	client := &Client{
+		conn:      conn,
+		localPath: localPath,
+		Timeout:   DefaultRequestTimeout,
+	}
+
+	return client, nil
+}
+
+// Close closes the connection. It may be called while waiting for events.
+func (c *Client) Close() error {
+	if c.attached {
+		// the reply is not waited because the receiver may be blocked
+		c.conn.Write([]byte("DETACH"))
+	}
+	err := c.conn.Close()
+	os.RemoveAll(filepath.Dir(c.localPath))
+	return err
+}
+
+func (c *Client) receive(deadline time.Time) (string, error) {
+	buf := make([]byte, ctrlBufferSize)
+	err := c.conn.SetReadDeadline(deadline)
+	if err != nil {
+		return "", err
+	}
+	n, err := c.conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// Request sends command and returns the reply.
+// Events received while waiting for the reply are kept for ReceiveEvent.
+func (c *Client) Request(cmd string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.conn.Write([]byte(cmd))
+	if err != nil {
+		return "", err
+	}
+
+	deadline := time.Now().Add(c.Timeout)
+	for {
+		reply, err := c.receive(deadline)
+		if err != nil {
+			return "", err
+		}
+		if isEventMessage(reply) {
+			event, err := ParseEvent(reply)
+			if err == nil {
+				c.pending = append(c.pending, event)
+			}
+			continue
+		}
+		return reply, nil
+	}
+}
+
+func (c *Client) requestOK(cmd string) error {
+	reply, err := c.Request(cmd)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(reply) != "OK" {
+		return fmt.Errorf("hostapd replied %q to %v", strings.TrimSpace(reply), cmd)
+	}
+	return nil
+}
+
+// Ping checks hostapd is alive
+func (c *Client) Ping() error {
+	reply, err := c.Request("PING")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(reply) != "PONG" {
+		return fmt.Errorf("hostapd replied %q to PING", strings.TrimSpace(reply))
+	}
+	return nil
+}
+
+// Attach subscribes events
+func (c *Client) Attach() error {
+	err := c.requestOK("ATTACH")
+	if err != nil {
+		return err
+	}
+	c.attached = true
+	return nil
+}
+
+// ReceiveEvent waits for an event until timeout. Zero timeout waits forever.
+func (c *Client) ReceiveEvent(timeout time.Duration) (*Event, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.pending) != 0 {
+		event := c.pending[0]
+		c.pending = c.pending[1:]
+		return event, nil
+	}
+
+	deadline := time.Time{}
+	if timeout != 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	for {
+		msg, err := c.receive(deadline)
+		if err != nil {
+			return nil, err
+		}
+		if !isEventMessage(msg) {
+			continue
+		}
+		return ParseEvent(msg)
+	}
+}
@@ -0,0 +1,104 @@
+package hostapd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEvent(t *testing.T) {
+	event, err := ParseEvent("<3>AP-STA-CONNECTED 02:00:00:00:00:01 keyid=dev1\n")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 3, event.Level)
+	assert.Equal(t, EventStationConnected, event.Name)
+	assert.Equal(t, "02:00:00:00:00:01", event.HWAddress.String())
+	assert.Equal(t, "dev1", event.GetArg("keyid"))
+	assert.Equal(t, "", event.GetArg("hoge"))
+
+	event, err = ParseEvent("<2>CTRL-EVENT-TERMINATING")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "CTRL-EVENT-TERMINATING", event.Name)
+	assert.Nil(t, event.HWAddress)
+
+	_, err = ParseEvent("OK\n")
+	assert.NotNil(t, err)
+	_, err = ParseEvent("<x>AP-STA-CONNECTED")
+	assert.NotNil(t, err)
+}
+
+func TestClientWithFakeServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostapd-test")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := NewFakeServer(filepath.Join(dir, "wlan0"))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer server.Close()
+	server.SetReply("STATUS", "state=ENABLED\n")
+
+	client, err := Dial(filepath.Join(dir, "wlan0"))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	err = client.Ping()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	reply, err := client.Request("STATUS")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "state=ENABLED\n", reply)
+	assert.Equal(t, []string{"PING", "STATUS"}, server.Requests())
+
+	err = client.Attach()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 1, server.AttachedCount())
+
+	server.Emit("AP-STA-DISCONNECTED 02:00:00:00:00:01")
+	event, err := client.ReceiveEvent(time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, EventStationDisconnected, event.Name)
+	assert.Equal(t, "02:00:00:00:00:01", event.HWAddress.String())
+
+	_, err = client.ReceiveEvent(10 * time.Millisecond)
+	assert.NotNil(t, err)
+
+	// events received while waiting for the reply are not lost
+	server.Emit("AP-STA-CONNECTED 02:00:00:00:00:02")
+	time.Sleep(10 * time.Millisecond)
+	err = client.Ping()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	event, err = client.ReceiveEvent(time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, EventStationConnected, event.Name)
+	assert.Equal(t, "02:00:00:00:00:02", event.HWAddress.String())
+
+	err = client.Close()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, server.AttachedCount())
+}
@@ -0,0 +1,72 @@
+package hostapd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Events of station association
+const (
+	EventStationConnected    = "AP-STA-CONNECTED"
+	EventStationDisconnected = "AP-STA-DISCONNECTED"
+)
+
+// Event is an unsolicited message from hostapd like "<3>AP-STA-CONNECTED 02:00:00:00:00:01"
+type Event struct {
+	Level     int
+	Name      string
+	HWAddress net.HardwareAddr
+	Args      []string
+	Raw       string
+}
+
+func isEventMessage(msg string) bool {
+	return strings.HasPrefix(msg, "<")
+}
+
+// ParseEvent parses event message
+func ParseEvent(msg string) (*Event, error) {
+	msg = strings.TrimRight(msg, "\n")
+	end := strings.Index(msg, ">")
+	if !isEventMessage(msg) || end < 0 {
+		return nil, fmt.Errorf("invalid event %q", msg)
+	}
+	level, err := strconv.Atoi(msg[1:end])
+	if err != nil {
+		return nil, fmt.Errorf("invalid event level %q", msg)
+	}
+
+	fields := strings.Fields(msg[end+1:])
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty event %q", msg)
+	}
+
+	event := &Event{
+		Level: level,
+		Name:  fields[0],
+		Args:  fields[1:],
+		Raw:   msg,
+	}
+
+	// station events have its address as the first argument
+	if len(event.Args) > 0 {
+		hwAddr, err := net.ParseMAC(event.Args[0])
+		if err == nil {
+			event.HWAddress = hwAddr
+		}
+	}
+
+	return event, nil
+}
+
+// GetArg returns the value of argument like "keyid=xxx"
+func (e *Event) GetArg(key string) string {
+	for _, arg := range e.Args {
+		if strings.HasPrefix(arg, key+"=") {
+			return arg[len(key)+1:]
+		}
+	}
+	return ""
+}
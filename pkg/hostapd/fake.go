@@ -0,0 +1,118 @@
+package hostapd
+
+import (
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FakeServer is a fake hostapd control socket for tests
+type FakeServer struct {
+	mu       sync.Mutex
+	conn     *net.UnixConn
+	path     string
+	attached map[string]*net.UnixAddr
+	replies  map[string]string
+	requests []string
+	done     chan struct{}
+}
+
+// NewFakeServer listens on path as hostapd control socket
+func NewFakeServer(path string) (*FakeServer, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+
+	server := &FakeServer{
+		conn:     conn,
+		path:     path,
+		attached: map[string]*net.UnixAddr{},
+		replies: map[string]string{
+			"PING": "PONG\n",
+		},
+		requests: []string{},
+		done:     make(chan struct{}),
+	}
+	go server.serve()
+
+	return server, nil
+}
+
+// SetReply sets the reply to command. Unknown commands are replied "UNKNOWN COMMAND".
+func (s *FakeServer) SetReply(cmd string, reply string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replies[cmd] = reply
+}
+
+// Requests returns received commands except ATTACH and DETACH
+func (s *FakeServer) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := make([]string, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// AttachedCount returns the number of attached clients
+func (s *FakeServer) AttachedCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.attached)
+}
+
+// Emit sends event like "AP-STA-CONNECTED 02:00:00:00:00:01" to attached clients
+func (s *FakeServer) Emit(event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, addr := range s.attached {
+		s.conn.WriteToUnix([]byte("<3>"+event), addr)
+	}
+}
+
+// Close stops the server
+func (s *FakeServer) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	os.Remove(s.path)
+	return err
+}
+
+func (s *FakeServer) serve() {
+	defer close(s.done)
+
+	buf := make([]byte, ctrlBufferSize)
+	for {
+		n, addr, err := s.conn.ReadFromUnix(buf)
+		if err != nil {
+			return
+		}
+		if addr == nil {
+			continue
+		}
+		cmd := strings.TrimSpace(string(buf[:n]))
+
+		s.mu.Lock()
+		reply := "OK\n"
+		switch cmd {
+		case "ATTACH":
+			s.attached[addr.Name] = addr
+		case "DETACH":
+			delete(s.attached, addr.Name)
+		default:
+			s.requests = append(s.requests, cmd)
+			r, ok := s.replies[cmd]
+			if !ok {
+				r = "UNKNOWN COMMAND\n"
+			}
+			reply = r
+		}
+		s.mu.Unlock()
+
+		s.conn.WriteToUnix([]byte(reply), addr)
+	}
+}
@@ -0,0 +1,114 @@
+package hostapd
+
+import (
+	"bufio"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/naoki9911/CREBAS/pkg/atomicfile"
+)
+
+const passphraseChars = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// PSKEntry is a line of wpa_psk_file binding passphrase to station address
+type PSKEntry struct {
+	KeyID      string
+	HWAddress  net.HardwareAddr
+	Passphrase string
+}
+
+// GeneratePassphrase generates random passphrase. WPA passphrase is 8 to 63 characters.
+func GeneratePassphrase(length int) (string, error) {
+	if length < 8 || length > 63 {
+		return "", fmt.Errorf("invalid passphrase length %v", length)
+	}
+
+	passphrase := make([]byte, length)
+	max := big.NewInt(int64(len(passphraseChars)))
+	for i := range passphrase {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		passphrase[i] = passphraseChars[n.Int64()]
+	}
+
+	return string(passphrase), nil
+}
+
+// ParsePSKFile parses wpa_psk_file like "keyid=dev1 02:00:00:00:00:01 passphrase"
+func ParsePSKFile(r io.Reader) ([]*PSKEntry, error) {
+	entries := []*PSKEntry{}
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entry := &PSKEntry{}
+		fields := strings.Fields(line)
+		for len(fields) > 0 && strings.Contains(fields[0], "=") {
+			if strings.HasPrefix(fields[0], "keyid=") {
+				entry.KeyID = fields[0][len("keyid="):]
+			}
+			fields = fields[1:]
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid line %v of psk file", lineNo)
+		}
+		hwAddr, err := net.ParseMAC(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid address at line %v of psk file: %v", lineNo, err)
+		}
+		entry.HWAddress = hwAddr
+		entry.Passphrase = fields[1]
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// FormatPSKFile formats entries as wpa_psk_file
+func FormatPSKFile(entries []*PSKEntry) string {
+	var b strings.Builder
+	b.WriteString("# generated by CREBAS PEP\n")
+	for _, entry := range entries {
+		if entry.KeyID != "" {
+			b.WriteString("keyid=" + entry.KeyID + " ")
+		}
+		b.WriteString(entry.HWAddress.String() + " " + entry.Passphrase + "\n")
+	}
+	return b.String()
+}
+
+// WritePSKFile replaces wpa_psk_file at path with entries
+func WritePSKFile(path string, entries []*PSKEntry) error {
+	return atomicfile.WriteFile(path, []byte(FormatPSKFile(entries)), 0600)
+}
+
+// ReloadPSK makes hostapd read wpa_psk_file again
+func (c *Client) ReloadPSK() error {
+	err := c.requestOK("RELOAD_WPA_PSK")
+	if err == nil {
+		return nil
+	}
+
+	// hostapd before 2.10 does not support RELOAD_WPA_PSK
+	return c.requestOK("RELOAD")
+}
+
+// Deauthenticate disconnects station to make it authenticate again
+func (c *Client) Deauthenticate(hwAddr net.HardwareAddr) error {
+	return c.requestOK("DEAUTHENTICATE " + hwAddr.String())
+}
@@ -0,0 +1,102 @@
+package hostapd
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPSKFile(t *testing.T) {
+	hwAddr1, _ := net.ParseMAC("02:00:00:00:00:01")
+	hwAddr2, _ := net.ParseMAC("02:00:00:00:00:02")
+	entries := []*PSKEntry{
+		{KeyID: "dev1", HWAddress: hwAddr1, Passphrase: "passphrase1"},
+		{HWAddress: hwAddr2, Passphrase: "passphrase2"},
+	}
+
+	dir, err := ioutil.TempDir("", "hostapd-test")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "hostapd.wpa_psk")
+
+	err = WritePSKFile(path, entries)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer file.Close()
+	parsed, err := ParsePSKFile(file)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, entries, parsed)
+
+	parsed, err = ParsePSKFile(strings.NewReader("keyid=dev3 vlanid=2 02:00:00:00:00:03 passphrase3\n"))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "dev3", parsed[0].KeyID)
+	assert.Equal(t, "passphrase3", parsed[0].Passphrase)
+
+	_, err = ParsePSKFile(strings.NewReader("02:00:00:00:00:03\n"))
+	assert.NotNil(t, err)
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	passphrase1, err := GeneratePassphrase(20)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	passphrase2, err := GeneratePassphrase(20)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 20, len(passphrase1))
+	assert.NotEqual(t, passphrase1, passphrase2)
+
+	_, err = GeneratePassphrase(7)
+	assert.NotNil(t, err)
+}
+
+func TestReloadPSK(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hostapd-test")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := NewFakeServer(filepath.Join(dir, "wlan0"))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer server.Close()
+	server.SetReply("RELOAD", "OK\n")
+
+	client, err := Dial(filepath.Join(dir, "wlan0"))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	defer client.Close()
+
+	err = client.ReloadPSK()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, []string{"RELOAD_WPA_PSK", "RELOAD"}, server.Requests())
+}
@@ -0,0 +1,340 @@
+package nat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// TableName is the nftables table holding all rules of CREBAS
+const TableName = "crebas"
+
+// Names of the sets referred by the rules
+const (
+	SetSources  = "nat_sources"
+	SetInbound  = "fwd_inbound"
+	SetOutbound = "nat_outbound"
+)
+
+// ifnameLen is the length of interface names loaded by meta iifname and oifname
+const ifnameLen = unix.IFNAMSIZ
+
+// typeIfname is the ifname datatype of nft
+var typeIfname = nftables.SetDatatype{Name: "ifname", Bytes: ifnameLen}
+
+func init() {
+	typeIfname.SetNFTMagic(41)
+}
+
+// Forwarding is a network behind inbound link masqueraded to outbound link
+type Forwarding struct {
+	InboundLink  string
+	Source       *net.IPNet
+	OutboundLink string
+}
+
+// NAT keeps masquerade and forward rules in the dedicated table.
+// The rules are fixed and refer to named sets, so forwardings are added and removed
+// by the elements of the sets atomically. Sources, inbound links and outbound links
+// are not paired with each other as a namespace has a single outbound link.
+type NAT struct {
+	mu          sync.Mutex
+	conn        *nftables.Conn
+	table       *nftables.Table
+	sources     *nftables.Set
+	inbound     *nftables.Set
+	outbound    *nftables.Set
+	forwardings map[string]*Forwarding
+	prerouting  *nftables.Chain
+	redirects   map[string]*Redirect
+}
+
+// NewNAT creates NAT on conn. nil conn talks to nftables of the current namespace.
+func NewNAT(conn *nftables.Conn) *NAT {
+	if conn == nil {
+		conn = &nftables.Conn{}
+	}
+	table := &nftables.Table{
+		Name:   TableName,
+		Family: nftables.TableFamilyIPv4,
+	}
+
+	return &NAT{
+		conn:  conn,
+		table: table,
+		sources: &nftables.Set{
+			Table:    table,
+			Name:     SetSources,
+			Interval: true,
+			KeyType:  nftables.TypeIPAddr,
+		},
+		inbound: &nftables.Set{
+			Table:   table,
+			Name:    SetInbound,
+			KeyType: typeIfname,
+		},
+		outbound: &nftables.Set{
+			Table:   table,
+			Name:    SetOutbound,
+			KeyType: typeIfname,
+		},
+		forwardings: map[string]*Forwarding{},
+		redirects:   map[string]*Redirect{},
+	}
+}
+
+func ifname(name string) []byte {
+	b := make([]byte, ifnameLen)
+	copy(b, name+"\x00")
+	return b
+}
+
+// sourceElements returns the interval of network
+func sourceElements(network *net.IPNet) []nftables.SetElement {
+	start := network.IP.Mask(network.Mask).To4()
+	ones, _ := network.Mask.Size()
+	elements := []nftables.SetElement{{Key: []byte(start)}}
+
+	// the interval ends at the next address of broadcast unless it is the last one
+	if ones != 0 {
+		end := make([]byte, net.IPv4len)
+		binary.BigEndian.PutUint32(end, binary.BigEndian.Uint32(start)+(uint32(1)<<uint(32-ones)))
+		elements = append(elements, nftables.SetElement{Key: end, IntervalEnd: true})
+	}
+	return elements
+}
+
+func lookupExprs(meta expr.Any, set *nftables.Set) []expr.Any {
+	return []expr.Any{
+		meta,
+		&expr.Lookup{SourceRegister: 1, SetName: set.Name, SetID: set.ID},
+	}
+}
+
+func (n *NAT) getMatchExprs(inbound bool) []expr.Any {
+	exprs := []expr.Any{}
+	if inbound {
+		exprs = append(exprs, lookupExprs(&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1}, n.inbound)...)
+	}
+	// ip saddr
+	exprs = append(exprs, lookupExprs(&expr.Payload{
+		DestRegister: 1,
+		Base:         expr.PayloadBaseNetworkHeader,
+		Offset:       12,
+		Len:          4,
+	}, n.sources)...)
+	exprs = append(exprs, lookupExprs(&expr.Meta{Key: expr.MetaKeyOIFNAME, Register: 1}, n.outbound)...)
+	return exprs
+}
+
+// Setup replaces the table left by previous run with the empty sets and the rules
+func (n *NAT) Setup() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// adding the table before deleting it does not fail when it does not exist
+	n.conn.AddTable(n.table)
+	n.conn.DelTable(n.table)
+	n.conn.AddTable(n.table)
+
+	for _, set := range []*nftables.Set{n.sources, n.inbound, n.outbound} {
+		set.ID = 0
+		err := n.conn.AddSet(set, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	accept := ofPolicy(nftables.ChainPolicyAccept)
+	forward := n.conn.AddChain(&nftables.Chain{
+		Name:     "forward",
+		Table:    n.table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookForward,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   accept,
+	})
+	postrouting := n.conn.AddChain(&nftables.Chain{
+		Name:     "postrouting",
+		Table:    n.table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	})
+	n.prerouting = n.conn.AddChain(&nftables.Chain{
+		Name:     "prerouting",
+		Table:    n.table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPrerouting,
+		Priority: nftables.ChainPriorityNATDest,
+	})
+
+	// ct state established,related accept
+	n.conn.AddRule(&nftables.Rule{
+		Table: n.table,
+		Chain: forward,
+		Exprs: []expr.Any{
+			&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+			&expr.Bitwise{
+				SourceRegister: 1,
+				DestRegister:   1,
+				Len:            4,
+				Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitESTABLISHED | expr.CtStateBitRELATED),
+				Xor:            binaryutil.NativeEndian.PutUint32(0),
+			},
+			&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0)},
+			&expr.Verdict{Kind: expr.VerdictAccept},
+		},
+	})
+
+	// iifname @fwd_inbound ip saddr @nat_sources oifname @nat_outbound accept
+	n.conn.AddRule(&nftables.Rule{
+		Table: n.table,
+		Chain: forward,
+		Exprs: append(n.getMatchExprs(true), &expr.Verdict{Kind: expr.VerdictAccept}),
+	})
+
+	// ip saddr @nat_sources oifname @nat_outbound masquerade
+	n.conn.AddRule(&nftables.Rule{
+		Table: n.table,
+		Chain: postrouting,
+		Exprs: append(n.getMatchExprs(false), &expr.Masq{}),
+	})
+
+	err := n.conn.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to set up table %v: %v", TableName, err)
+	}
+	n.forwardings = map[string]*Forwarding{}
+	n.redirects = map[string]*Redirect{}
+
+	return nil
+}
+
+func ofPolicy(policy nftables.ChainPolicy) *nftables.ChainPolicy {
+	return &policy
+}
+
+// isOutboundUsed returns true if outbound link is used by forwarding other than the one of inbound link
+func (n *NAT) isOutboundUsed(outboundLink string, inboundLink string) bool {
+	for _, f := range n.forwardings {
+		if f.InboundLink != inboundLink && f.OutboundLink == outboundLink {
+			return true
+		}
+	}
+	return false
+}
+
+// Add masquerades and forwards the network of inbound link.
+// Adding the same forwarding again does nothing.
+func (n *NAT) Add(forwarding *Forwarding) error {
+	if forwarding.Source == nil || forwarding.Source.IP.To4() == nil {
+		return fmt.Errorf("invalid source network %v", forwarding.Source)
+	}
+	if forwarding.InboundLink == "" || forwarding.OutboundLink == "" {
+		return fmt.Errorf("links of forwarding are required")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if old, ok := n.forwardings[forwarding.InboundLink]; ok {
+		if old.OutboundLink == forwarding.OutboundLink && old.Source.String() == forwarding.Source.String() {
+			return nil
+		}
+		return fmt.Errorf("link %v is already forwarded", forwarding.InboundLink)
+	}
+	for _, f := range n.forwardings {
+		if f.Source.Contains(forwarding.Source.IP) || forwarding.Source.Contains(f.Source.IP) {
+			return fmt.Errorf("network %v overlaps %v of link %v", forwarding.Source, f.Source, f.InboundLink)
+		}
+	}
+
+	err := n.conn.SetAddElements(n.sources, sourceElements(forwarding.Source))
+	if err != nil {
+		return err
+	}
+	err = n.conn.SetAddElements(n.inbound, []nftables.SetElement{{Key: ifname(forwarding.InboundLink)}})
+	if err != nil {
+		return err
+	}
+	err = n.conn.SetAddElements(n.outbound, []nftables.SetElement{{Key: ifname(forwarding.OutboundLink)}})
+	if err != nil {
+		return err
+	}
+	err = n.conn.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to add forwarding of %v: %v", forwarding.InboundLink, err)
+	}
+
+	copied := *forwarding
+	n.forwardings[forwarding.InboundLink] = &copied
+	return nil
+}
+
+// Remove deletes forwarding of inbound link
+func (n *NAT) Remove(inboundLink string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	forwarding, ok := n.forwardings[inboundLink]
+	if !ok {
+		return fmt.Errorf("forwarding of link %v not found", inboundLink)
+	}
+
+	err := n.conn.SetDeleteElements(n.sources, sourceElements(forwarding.Source))
+	if err != nil {
+		return err
+	}
+	err = n.conn.SetDeleteElements(n.inbound, []nftables.SetElement{{Key: ifname(forwarding.InboundLink)}})
+	if err != nil {
+		return err
+	}
+	if !n.isOutboundUsed(forwarding.OutboundLink, inboundLink) {
+		err = n.conn.SetDeleteElements(n.outbound, []nftables.SetElement{{Key: ifname(forwarding.OutboundLink)}})
+		if err != nil {
+			return err
+		}
+	}
+	err = n.conn.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to remove forwarding of %v: %v", inboundLink, err)
+	}
+
+	delete(n.forwardings, inboundLink)
+	return nil
+}
+
+// GetAll returns forwardings
+func (n *NAT) GetAll() []*Forwarding {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	forwardings := []*Forwarding{}
+	for _, f := range n.forwardings {
+		copied := *f
+		forwardings = append(forwardings, &copied)
+	}
+	return forwardings
+}
+
+// Close deletes the table with all rules and sets
+func (n *NAT) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.conn.DelTable(n.table)
+	err := n.conn.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to delete table %v: %v", TableName, err)
+	}
+	n.forwardings = map[string]*Forwarding{}
+	n.redirects = map[string]*Redirect{}
+	return nil
+}
@@ -0,0 +1,156 @@
+package nat
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+type testConn struct {
+	msgTypes []int
+}
+
+func (c *testConn) dial(req []netlink.Message) ([]netlink.Message, error) {
+	for _, msg := range req {
+		// batch begin and end are not of nftables subsystem
+		if int(msg.Header.Type)>>8 != unix.NFNL_SUBSYS_NFTABLES {
+			continue
+		}
+		c.msgTypes = append(c.msgTypes, int(msg.Header.Type)&0xff)
+	}
+	return req, nil
+}
+
+func (c *testConn) count(msgType int) int {
+	count := 0
+	for _, t := range c.msgTypes {
+		if t == msgType {
+			count++
+		}
+	}
+	return count
+}
+
+func newTestNAT(t *testing.T) (*NAT, *testConn) {
+	tc := &testConn{}
+	n := NewNAT(&nftables.Conn{TestDial: tc.dial})
+	err := n.Setup()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	return n, tc
+}
+
+func mustParseCIDR(t *testing.T, s string) *net.IPNet {
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	return network
+}
+
+func TestSetup(t *testing.T) {
+	_, tc := newTestNAT(t)
+
+	assert.Equal(t, []int{unix.NFT_MSG_NEWTABLE, unix.NFT_MSG_DELTABLE, unix.NFT_MSG_NEWTABLE}, tc.msgTypes[:3])
+	assert.Equal(t, 3, tc.count(unix.NFT_MSG_NEWSET))
+	assert.Equal(t, 3, tc.count(unix.NFT_MSG_NEWCHAIN))
+	assert.Equal(t, 3, tc.count(unix.NFT_MSG_NEWRULE))
+}
+
+func TestSourceElements(t *testing.T) {
+	elements := sourceElements(mustParseCIDR(t, "192.168.10.1/24"))
+	assert.Equal(t, 2, len(elements))
+	assert.Equal(t, []byte{192, 168, 10, 0}, elements[0].Key)
+	assert.Equal(t, []byte{192, 168, 11, 0}, elements[1].Key)
+	assert.True(t, elements[1].IntervalEnd)
+
+	elements = sourceElements(mustParseCIDR(t, "0.0.0.0/0"))
+	assert.Equal(t, 1, len(elements))
+	assert.Equal(t, []byte{0, 0, 0, 0}, elements[0].Key)
+}
+
+func TestAddRemove(t *testing.T) {
+	n, tc := newTestNAT(t)
+
+	forwarding := &Forwarding{
+		InboundLink:  "veth0",
+		Source:       mustParseCIDR(t, "192.168.10.0/24"),
+		OutboundLink: "eth0",
+	}
+	tc.msgTypes = nil
+	err := n.Add(forwarding)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 3, tc.count(unix.NFT_MSG_NEWSETELEM))
+
+	// adding the same forwarding again does nothing
+	tc.msgTypes = nil
+	err = n.Add(forwarding)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 0, len(tc.msgTypes))
+
+	err = n.Add(&Forwarding{
+		InboundLink:  "veth1",
+		Source:       mustParseCIDR(t, "192.168.10.128/25"),
+		OutboundLink: "eth0",
+	})
+	assert.NotNil(t, err)
+
+	err = n.Add(&Forwarding{
+		InboundLink:  "veth1",
+		Source:       mustParseCIDR(t, "192.168.20.0/24"),
+		OutboundLink: "eth0",
+	})
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 2, len(n.GetAll()))
+
+	// eth0 is kept for veth1
+	tc.msgTypes = nil
+	err = n.Remove("veth0")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 2, tc.count(unix.NFT_MSG_DELSETELEM))
+
+	tc.msgTypes = nil
+	err = n.Remove("veth1")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 3, tc.count(unix.NFT_MSG_DELSETELEM))
+	assert.Equal(t, 0, len(n.GetAll()))
+
+	err = n.Remove("veth1")
+	assert.NotNil(t, err)
+}
+
+func TestClose(t *testing.T) {
+	n, tc := newTestNAT(t)
+
+	err := n.Add(&Forwarding{
+		InboundLink:  "veth0",
+		Source:       mustParseCIDR(t, "192.168.10.0/24"),
+		OutboundLink: "eth0",
+	})
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	tc.msgTypes = nil
+	err = n.Close()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, []int{unix.NFT_MSG_DELTABLE}, tc.msgTypes)
+	assert.Equal(t, 0, len(n.GetAll()))
+}
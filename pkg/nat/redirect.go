@@ -0,0 +1,87 @@
+package nat
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+// Redirect is TCP ports arriving at inbound link redirected to the local port.
+// The original destination is returned by SO_ORIGINAL_DST of the accepted socket.
+type Redirect struct {
+	InboundLink string
+	Ports       []uint16
+	ToPort      uint16
+}
+
+func portBytes(port uint16) []byte {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, port)
+	return b
+}
+
+// AddRedirect redirects the ports of inbound link to the local port.
+// Redirects are kept until Setup or Close.
+func (n *NAT) AddRedirect(redirect *Redirect) error {
+	if redirect.InboundLink == "" || len(redirect.Ports) == 0 || redirect.ToPort == 0 {
+		return fmt.Errorf("invalid redirect %v", redirect)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.prerouting == nil {
+		return fmt.Errorf("table %v is not set up", TableName)
+	}
+	if _, ok := n.redirects[redirect.InboundLink]; ok {
+		return fmt.Errorf("link %v is already redirected", redirect.InboundLink)
+	}
+
+	ports := &nftables.Set{
+		Table:     n.table,
+		Anonymous: true,
+		Constant:  true,
+		KeyType:   nftables.TypeInetService,
+	}
+	elements := []nftables.SetElement{}
+	for _, port := range redirect.Ports {
+		elements = append(elements, nftables.SetElement{Key: portBytes(port)})
+	}
+	err := n.conn.AddSet(ports, elements)
+	if err != nil {
+		return err
+	}
+
+	// iifname $link tcp dport { $ports } redirect to :$port
+	n.conn.AddRule(&nftables.Rule{
+		Table: n.table,
+		Chain: n.prerouting,
+		Exprs: []expr.Any{
+			&expr.Meta{Key: expr.MetaKeyIIFNAME, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ifname(redirect.InboundLink)},
+			&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+			&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+			&expr.Payload{
+				DestRegister: 1,
+				Base:         expr.PayloadBaseTransportHeader,
+				Offset:       2,
+				Len:          2,
+			},
+			&expr.Lookup{SourceRegister: 1, SetName: ports.Name, SetID: ports.ID},
+			&expr.Immediate{Register: 1, Data: portBytes(redirect.ToPort)},
+			&expr.Redir{RegisterProtoMin: 1},
+		},
+	})
+	err = n.conn.Flush()
+	if err != nil {
+		return fmt.Errorf("failed to redirect link %v: %v", redirect.InboundLink, err)
+	}
+
+	copied := *redirect
+	copied.Ports = append([]uint16{}, redirect.Ports...)
+	n.redirects[redirect.InboundLink] = &copied
+	return nil
+}
@@ -0,0 +1,38 @@
+package nat
+
+import (
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestAddRedirect(t *testing.T) {
+	redirect := &Redirect{
+		InboundLink: "crebas-acl-ofs",
+		Ports:       []uint16{80, 443},
+		ToPort:      3129,
+	}
+
+	// the chain is created by Setup
+	n := NewNAT(&nftables.Conn{TestDial: (&testConn{}).dial})
+	err := n.AddRedirect(redirect)
+	assert.NotNil(t, err)
+
+	n, tc := newTestNAT(t)
+	tc.msgTypes = nil
+	err = n.AddRedirect(redirect)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 1, tc.count(unix.NFT_MSG_NEWSET))
+	assert.Equal(t, 1, tc.count(unix.NFT_MSG_NEWSETELEM))
+	assert.Equal(t, 1, tc.count(unix.NFT_MSG_NEWRULE))
+
+	err = n.AddRedirect(redirect)
+	assert.NotNil(t, err)
+
+	err = n.AddRedirect(&Redirect{InboundLink: "veth0", ToPort: 3129})
+	assert.NotNil(t, err)
+}
@@ -13,6 +13,7 @@ import (
 type LinkExt struct {
 	link         netlink.Link
 	Addr         *netlink.Addr
+	Addr6        *netlink.Addr
 	namespace    string
 	OfType       OFType
 	Ofport       uint32
@@ -109,7 +110,11 @@ func (l *LinkExt) SetAddr(addr *netlink.Addr) error {
 		return err
 	}
 
-	l.Addr = addr
+	if addr.IP.To4() == nil {
+		l.Addr6 = addr
+	} else {
+		l.Addr = addr
+	}
 
 	return nil
 }
@@ -212,6 +217,10 @@ func (l *LinkExt) GetIPAddress() *netlink.Addr {
 	return l.Addr
 }
 
+func (l *LinkExt) GetIP6Address() *netlink.Addr {
+	return l.Addr6
+}
+
 func (l *LinkExt) GetOfPort() uint32 {
 	return l.Ofport
 }
@@ -0,0 +1,81 @@
+package ofswitch
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// Cookie types are stored in the upper 8 bits of flow cookie
+const (
+	CookieTypeNone uint8 = iota
+	CookieTypeCapability
+	CookieTypeDevice
+	CookieTypeGroup
+	CookieTypeAdmission
+	CookieTypeSpoofing
+	CookieTypePunt
+	CookieTypeEgress
+	CookieTypeDiscovery
+	CookieTypeSpoofingAllow
+)
+
+const cookieTypeShift = 56
+const cookieIDMask = (uint64(1) << cookieTypeShift) - 1
+
+// NewCookie creates flow cookie from type and the first 7 bytes of id
+func NewCookie(cookieType uint8, id []byte) uint64 {
+	idBytes := make([]byte, 8)
+	if len(id) > 7 {
+		id = id[:7]
+	}
+	copy(idBytes[8-len(id):], id)
+
+	return (uint64(cookieType) << cookieTypeShift) | (binary.BigEndian.Uint64(idBytes) & cookieIDMask)
+}
+
+// GetCookieType returns type of cookie
+func GetCookieType(cookie uint64) uint8 {
+	return uint8(cookie >> cookieTypeShift)
+}
+
+// DeviceCookie returns cookie for the flows of device
+func DeviceCookie(hwAddr net.HardwareAddr) uint64 {
+	return NewCookie(CookieTypeDevice, hwAddr)
+}
+
+// AdmissionCookie returns cookie for the admission flows of device
+func AdmissionCookie(hwAddr net.HardwareAddr) uint64 {
+	return NewCookie(CookieTypeAdmission, hwAddr)
+}
+
+// SpoofingCookie returns cookie for the flows dropping spoofed frames of device
+func SpoofingCookie(hwAddr net.HardwareAddr) uint64 {
+	return NewCookie(CookieTypeSpoofing, hwAddr)
+}
+
+// SpoofingAllowCookie returns cookie for the flows passing frames of device from its bound addresses
+func SpoofingAllowCookie(hwAddr net.HardwareAddr) uint64 {
+	return NewCookie(CookieTypeSpoofingAllow, hwAddr)
+}
+
+// PuntCookie returns cookie for the flow sending packets to controller
+func PuntCookie() uint64 {
+	return NewCookie(CookieTypePunt, nil)
+}
+
+// GroupCookie returns cookie for the flows forwarding to group
+func GroupCookie(groupID uint32) uint64 {
+	id := make([]byte, 4)
+	binary.BigEndian.PutUint32(id, groupID)
+	return NewCookie(CookieTypeGroup, id)
+}
+
+// EgressCookie returns cookie for the flows of app link reaching the host
+func EgressCookie(hwAddr net.HardwareAddr) uint64 {
+	return NewCookie(CookieTypeEgress, hwAddr)
+}
+
+// DiscoveryCookie returns cookie for the flows sending mDNS and SSDP to controller
+func DiscoveryCookie() uint64 {
+	return NewCookie(CookieTypeDiscovery, nil)
+}
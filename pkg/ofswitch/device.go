@@ -9,6 +9,7 @@ import (
 type DeviceLink interface {
 	GetHWAddress() net.HardwareAddr
 	GetIPAddress() *netlink.Addr
+	GetIP6Address() *netlink.Addr
 	GetOfPort() uint32
 	GetViaWlan() bool
 }
@@ -0,0 +1,111 @@
+package ofswitch
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// discoveryPriority is higher than the admission flows to relay the multicast of apps and devices
+const discoveryPriority = 200
+
+// DiscoveryGroup is a multicast group of local service discovery
+type DiscoveryGroup struct {
+	Addr net.IP
+	Port uint16
+}
+
+// DiscoveryGroups are the groups of mDNS and SSDP sent to controller
+var DiscoveryGroups = []DiscoveryGroup{
+	{Addr: net.ParseIP("224.0.0.251"), Port: 5353},
+	{Addr: net.ParseIP("ff02::fb"), Port: 5353},
+	{Addr: net.ParseIP("239.255.255.250"), Port: 1900},
+	{Addr: net.ParseIP("ff02::c"), Port: 1900},
+}
+
+// SetDiscoveryRate sets rate limit(kbps) of mDNS and SSDP packets sent to controller to be relayed.
+// 0 leaves them to the other flows. It takes effect when the pipeline is set up.
+func (c *OFSwitch) SetDiscoveryRate(rate uint32) {
+	c.discoveryRate = rate
+}
+
+func getDiscoveryMatch(group DiscoveryGroup) (*ofp13.OfpMatch, error) {
+	match := ofp13.NewOfpMatch()
+	if group.Addr.To4() != nil {
+		match.Append(ofp13.NewOxmEthType(0x0800))
+		match.Append(ofp13.NewOxmIpProto(IPProtoUDP))
+		ipDst, err := ofp13.NewOxmIpv4Dst(group.Addr.String())
+		if err != nil {
+			return nil, err
+		}
+		match.Append(ipDst)
+	} else if group.Addr.To16() != nil {
+		match.Append(ofp13.NewOxmEthType(0x86dd))
+		match.Append(ofp13.NewOxmIpProto(IPProtoUDP))
+		ipDst, err := ofp13.NewOxmIpv6Dst(group.Addr.String())
+		if err != nil {
+			return nil, err
+		}
+		match.Append(ipDst)
+	} else {
+		return nil, fmt.Errorf("invalid discovery group %v", group.Addr)
+	}
+	match.Append(ofp13.NewOxmUdpDst(group.Port))
+
+	return match, nil
+}
+
+func (c *OFSwitch) addDiscoveryFlows() error {
+	if c.discoveryRate == 0 {
+		return nil
+	}
+
+	cookie := DiscoveryCookie()
+	_, err := c.SetMeter(cookie, c.discoveryRate, 0)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range DiscoveryGroups {
+		match, err := getDiscoveryMatch(group)
+		if err != nil {
+			return err
+		}
+		instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
+		instruction.Append(ofp13.NewOfpActionOutput(ofp13.OFPP_CONTROLLER, OFPCML_NO_BUFFER))
+		instructions := c.appendMeterInstruction([]ofp13.OfpInstruction{instruction}, cookie)
+
+		err = c.sendFlowModAdd(TableAdmission, discoveryPriority, cookie, match, instructions)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SendPacket sends ethernet frame from controller to outport.
+// The destination address of frame is rewritten to ethDst if it is not nil.
+func (c *OFSwitch) SendPacket(data []byte, outport uint32, ethDst net.HardwareAddr) error {
+	if c.dp == nil {
+		return fmt.Errorf("switch(%v) is not connected", c.Name)
+	}
+
+	actions := []ofp13.OfpAction{}
+	if ethDst != nil {
+		field, err := ofp13.NewOxmEthDst(ethDst.String())
+		if err != nil {
+			return err
+		}
+		actions = append(actions, ofp13.NewOfpActionSetField(field))
+	}
+	actions = append(actions, ofp13.NewOfpActionOutput(outport, OFPCML_NO_BUFFER))
+
+	msg := ofp13.NewOfpPacketOut(ofp13.OFP_NO_BUFFER, ofp13.OFPP_CONTROLLER, actions, data)
+	if !c.dp.Send(msg) {
+		return fmt.Errorf("failed to send packet to switch(%v)", c.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,22 @@
+package ofswitch
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDiscoveryMatch(t *testing.T) {
+	for _, group := range DiscoveryGroups {
+		match, err := getDiscoveryMatch(group)
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		// eth_type, ip_proto, ip_dst and udp_dst
+		assert.Equal(t, 4, len(match.OxmFields))
+	}
+
+	_, err := getDiscoveryMatch(DiscoveryGroup{Addr: net.IP{}, Port: 5353})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,250 @@
+package ofswitch
+
+import (
+	"fmt"
+	"math"
+	"net"
+
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// Priorities of the flows from app links to the host.
+// The proxy flows take precedence over the flows of DNS answers
+// so that the steered ports always reach the proxy.
+// Packets matching none of them are dropped by the table-miss.
+const (
+	egressBasePriority  = 100
+	egressProxyPriority = 75
+	egressPriority      = 50
+)
+
+const dnsPort uint16 = 53
+
+// egressHardTimeout converts record TTL to flow timeout.
+// TTL 0 is raised to 1 second because timeout 0 means permanent.
+func egressHardTimeout(ttl uint32) uint16 {
+	if ttl == 0 {
+		return 1
+	}
+	if ttl > math.MaxUint16 {
+		return math.MaxUint16
+	}
+	return uint16(ttl)
+}
+
+func (c *OFSwitch) getEgressMatch(link *netlinkext.LinkExt, ip net.IP) (*ofp13.OfpMatch, error) {
+	match := ofp13.NewOfpMatch()
+	match.Append(ofp13.NewOxmInPort(link.Ofport))
+
+	ethsrc, err := ofp13.NewOxmEthSrc(link.GetHWAddress().String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ethsrc)
+
+	if ip.To4() != nil {
+		match.Append(ofp13.NewOxmEthType(0x0800))
+		ipSrc, err := ofp13.NewOxmIpv4Src(link.Addr.IP.String())
+		if err != nil {
+			return nil, err
+		}
+		match.Append(ipSrc)
+		ipDst, err := ofp13.NewOxmIpv4Dst(ip.String())
+		if err != nil {
+			return nil, err
+		}
+		match.Append(ipDst)
+	} else {
+		if link.Addr6 == nil {
+			return nil, fmt.Errorf("link of port %v has no IPv6 address", link.Ofport)
+		}
+		match.Append(ofp13.NewOxmEthType(0x86dd))
+		ipSrc, err := ofp13.NewOxmIpv6Src(link.Addr6.IP.String())
+		if err != nil {
+			return nil, err
+		}
+		match.Append(ipSrc)
+		ipDst, err := ofp13.NewOxmIpv6Dst(ip.String())
+		if err != nil {
+			return nil, err
+		}
+		match.Append(ipDst)
+	}
+
+	return match, nil
+}
+
+// SetServicePorts sets TCP ports of the host, like PEP and CP APIs, reachable from app links.
+// It takes effect for the links added by AddEgressBaseFlow afterwards.
+func (c *OFSwitch) SetServicePorts(ports []uint16) {
+	c.servicePorts = append([]uint16{}, ports...)
+}
+
+// getEgressBaseMatches returns matches of DNS over UDP and TCP and the service ports of the host.
+// DNS is also allowed over IPv6 when both the host and the link have IPv6 addresses.
+func (c *OFSwitch) getEgressBaseMatches(link *netlinkext.LinkExt) ([]*ofp13.OfpMatch, error) {
+	dnsAddrs := []net.IP{c.Link.Addr.IP}
+	if c.Link.Addr6 != nil && link.Addr6 != nil {
+		dnsAddrs = append(dnsAddrs, c.Link.Addr6.IP)
+	}
+
+	matches := []*ofp13.OfpMatch{}
+	for _, dnsAddr := range dnsAddrs {
+		for _, protoType := range []uint8{IPProtoUDP, IPProtoTCP} {
+			match, err := c.getEgressMatch(link, dnsAddr)
+			if err != nil {
+				return nil, err
+			}
+			match.Append(ofp13.NewOxmIpProto(protoType))
+			if protoType == IPProtoUDP {
+				match.Append(ofp13.NewOxmUdpDst(dnsPort))
+			} else {
+				match.Append(ofp13.NewOxmTcpDst(dnsPort))
+			}
+			matches = append(matches, match)
+		}
+	}
+
+	for _, port := range c.servicePorts {
+		match, err := c.getEgressMatch(link, c.Link.Addr.IP)
+		if err != nil {
+			return nil, err
+		}
+		match.Append(ofp13.NewOxmIpProto(IPProtoTCP))
+		match.Append(ofp13.NewOxmTcpDst(port))
+		matches = append(matches, match)
+	}
+
+	return matches, nil
+}
+
+func (c *OFSwitch) getOutputInstructions(outport uint32) []ofp13.OfpInstruction {
+	instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
+	instruction.Append(ofp13.NewOfpActionOutput(outport, OFPCML_NO_BUFFER))
+	return []ofp13.OfpInstruction{instruction}
+}
+
+// AddEgressBaseFlow allows app link to reach only ARP, ICMP, DNS and the service ports of the host
+// and the host to reach the link. Other destinations are allowed by AddEgressFlow.
+func (c *OFSwitch) AddEgressBaseFlow(link *netlinkext.LinkExt) error {
+	err := c.AddHostRestrictedFlow(link)
+	if err != nil {
+		return err
+	}
+
+	cookie := EgressCookie(link.GetHWAddress())
+	matches, err := c.getEgressBaseMatches(link)
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		err = c.sendFlowModAdd(TableAdmission, egressBasePriority, cookie, match, c.getOutputInstructions(c.Link.Ofport))
+		if err != nil {
+			return err
+		}
+	}
+
+	// replies of DNS and the egress traffic routed by the host
+	match := ofp13.NewOfpMatch()
+	match.Append(ofp13.NewOxmInPort(c.Link.Ofport))
+	ethdst, err := ofp13.NewOxmEthDst(link.GetHWAddress().String())
+	if err != nil {
+		return err
+	}
+	match.Append(ethdst)
+
+	return c.sendFlowModAdd(TableAdmission, egressBasePriority, cookie, match, c.getOutputInstructions(link.Ofport))
+}
+
+// AddEgressFlow allows app link to reach ip through the host for ttl seconds even while the flow is in use,
+// as the address is not allowed once the record expires. Adding the flow again resets its timeout. The flow is limited by the meter bound to cookie.
+func (c *OFSwitch) AddEgressFlow(link *netlinkext.LinkExt, ip net.IP, ttl uint32, cookie uint64) error {
+	match, err := c.getEgressMatch(link, ip)
+	if err != nil {
+		return err
+	}
+
+	fm := ofp13.NewOfpFlowModAdd(
+		cookie,
+		0,
+		TableAdmission,
+		egressPriority,
+		0,
+		match,
+		c.appendMeterInstruction(c.getOutputInstructions(c.Link.Ofport), cookie),
+	)
+	fm.HardTimeout = egressHardTimeout(ttl)
+
+	return c.sendFlowMod(fm)
+}
+
+func (c *OFSwitch) getEgressProxyMatch(link *netlinkext.LinkExt, port uint16) (*ofp13.OfpMatch, error) {
+	match := ofp13.NewOfpMatch()
+	match.Append(ofp13.NewOxmInPort(link.Ofport))
+
+	ethsrc, err := ofp13.NewOxmEthSrc(link.GetHWAddress().String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ethsrc)
+	match.Append(ofp13.NewOxmEthType(0x0800))
+	ipSrc, err := ofp13.NewOxmIpv4Src(link.Addr.IP.String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ipSrc)
+	match.Append(ofp13.NewOxmIpProto(IPProtoTCP))
+	match.Append(ofp13.NewOxmTcpDst(port))
+
+	return match, nil
+}
+
+// getEgressProxyDropMatch returns match of IPv6 TCP port the proxy can't steer
+func (c *OFSwitch) getEgressProxyDropMatch(link *netlinkext.LinkExt, port uint16) (*ofp13.OfpMatch, error) {
+	match := ofp13.NewOfpMatch()
+	match.Append(ofp13.NewOxmInPort(link.Ofport))
+
+	ethsrc, err := ofp13.NewOxmEthSrc(link.GetHWAddress().String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ethsrc)
+	match.Append(ofp13.NewOxmEthType(0x86dd))
+	match.Append(ofp13.NewOxmIpProto(IPProtoTCP))
+	match.Append(ofp13.NewOxmTcpDst(port))
+
+	return match, nil
+}
+
+// AddEgressProxyFlow allows app link to reach TCP ports of any IPv4 destination through the host
+// redirecting them to the transparent proxy. The ports over IPv6 are dropped as the proxy handles only IPv4.
+// The flows are deleted by DeleteEgressBaseFlow.
+func (c *OFSwitch) AddEgressProxyFlow(link *netlinkext.LinkExt, ports []uint16) error {
+	cookie := EgressCookie(link.GetHWAddress())
+	for _, port := range ports {
+		match, err := c.getEgressProxyMatch(link, port)
+		if err != nil {
+			return err
+		}
+		err = c.sendFlowModAdd(TableAdmission, egressProxyPriority, cookie, match, c.getOutputInstructions(c.Link.Ofport))
+		if err != nil {
+			return err
+		}
+
+		match, err = c.getEgressProxyDropMatch(link, port)
+		if err != nil {
+			return err
+		}
+		err = c.sendFlowModAdd(TableAdmission, egressProxyPriority, cookie, match, []ofp13.OfpInstruction{})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteEgressBaseFlow deletes flows added by AddEgressBaseFlow and AddEgressProxyFlow except ARP and ICMP
+func (c *OFSwitch) DeleteEgressBaseFlow(link DeviceLink) error {
+	return c.DeleteFlowsByCookie(EgressCookie(link.GetHWAddress()))
+}
@@ -0,0 +1,154 @@
+package ofswitch
+
+import (
+	"net"
+	"testing"
+
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestEgressHardTimeout(t *testing.T) {
+	assert.Equal(t, uint16(1), egressHardTimeout(0))
+	assert.Equal(t, uint16(300), egressHardTimeout(300))
+	assert.Equal(t, uint16(65535), egressHardTimeout(86400*7))
+}
+
+func TestGetEgressMatch(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:41")
+	addr, err := netlink.ParseAddr("192.168.10.2/24")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	link := &netlinkext.LinkExt{
+		Addr:   addr,
+		Ofport: 3,
+	}
+	link.SetLink(&netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{HardwareAddr: hwAddr},
+	})
+
+	ofs := NewOFSwitch("test-egress")
+	match, err := ofs.getEgressMatch(link, net.ParseIP("93.184.216.34"))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	// in_port, eth_src, eth_type, ipv4_src and ipv4_dst
+	assert.Equal(t, 5, len(match.OxmFields))
+
+	// the link without IPv6 address can't reach IPv6 destinations
+	_, err = ofs.getEgressMatch(link, net.ParseIP("2606:2800:220:1::1"))
+	assert.NotNil(t, err)
+
+	link.Addr6, err = netlink.ParseAddr("fd00:10::2/64")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	match, err = ofs.getEgressMatch(link, net.ParseIP("2606:2800:220:1::1"))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	// in_port, eth_src, eth_type, ipv6_src and ipv6_dst
+	assert.Equal(t, 5, len(match.OxmFields))
+	ipSrc := match.OxmFields[3].(*ofp13.OxmIpv6)
+	assert.True(t, ipSrc.Value.Equal(net.ParseIP("fd00:10::2")))
+}
+
+func TestGetEgressProxyMatch(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:41")
+	addr, err := netlink.ParseAddr("192.168.10.2/24")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	link := &netlinkext.LinkExt{
+		Addr:   addr,
+		Ofport: 3,
+	}
+	link.SetLink(&netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{HardwareAddr: hwAddr},
+	})
+
+	ofs := NewOFSwitch("test-egress")
+	match, err := ofs.getEgressProxyMatch(link, 443)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	// in_port, eth_src, eth_type, ipv4_src, ip_proto and tcp_dst
+	assert.Equal(t, 6, len(match.OxmFields))
+
+	match, err = ofs.getEgressProxyDropMatch(link, 443)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	// in_port, eth_src, eth_type, ip_proto and tcp_dst
+	assert.Equal(t, 5, len(match.OxmFields))
+}
+
+func TestGetEgressBaseMatches(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:41")
+	addr, err := netlink.ParseAddr("192.168.10.2/24")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	link := &netlinkext.LinkExt{
+		Addr:   addr,
+		Ofport: 3,
+	}
+	link.SetLink(&netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{HardwareAddr: hwAddr},
+	})
+
+	ofs := NewOFSwitch("test-egress")
+	ofs.Link.Addr, err = netlink.ParseAddr("192.168.10.1/24")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	matches, err := ofs.getEgressBaseMatches(link)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	// DNS over UDP and TCP
+	assert.Equal(t, 2, len(matches))
+
+	ofs.SetServicePorts([]uint16{8080, 8081})
+	matches, err = ofs.getEgressBaseMatches(link)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 4, len(matches))
+	for i, port := range []uint16{8080, 8081} {
+		match := matches[2+i]
+		// in_port, eth_src, eth_type, ipv4_src, ipv4_dst, ip_proto and tcp_dst
+		assert.Equal(t, 7, len(match.OxmFields))
+		ipDst := match.OxmFields[4].(*ofp13.OxmIpv4)
+		assert.True(t, ipDst.Value.Equal(net.ParseIP("192.168.10.1")))
+		tcpDst := match.OxmFields[6].(*ofp13.OxmTcp)
+		assert.Equal(t, uint32(ofp13.OXM_OF_TCP_DST), tcpDst.TlvHeader)
+		assert.Equal(t, port, tcpDst.Value)
+	}
+
+	// DNS over IPv6 is allowed only when both have IPv6 addresses
+	ofs.Link.Addr6, err = netlink.ParseAddr("fd00:10::1/64")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	matches, err = ofs.getEgressBaseMatches(link)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 4, len(matches))
+
+	link.Addr6, err = netlink.ParseAddr("fd00:10::2/64")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	matches, err = ofs.getEgressBaseMatches(link)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 6, len(matches))
+	ipDst := matches[2].OxmFields[4].(*ofp13.OxmIpv6)
+	assert.True(t, ipDst.Value.Equal(net.ParseIP("fd00:10::1")))
+}
@@ -0,0 +1,297 @@
+package ofswitch
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// Group is OpenFlow group of type ALL which copies packets to every port
+type Group struct {
+	ID    uint32   `json:"id"`
+	Key   string   `json:"key"`
+	Ports []uint32 `json:"ports"`
+	// Cookie is the capability cookie of the policy flows forwarding to the group
+	Cookie uint64 `json:"cookie"`
+	// refs has cookies of the capabilities authorizing each port
+	refs map[uint32]map[uint64]bool
+	// purged has cookies of the capabilities authorizing ports deleted from switch until they are revoked
+	purged map[uint32]map[uint64]bool
+}
+
+func (g *Group) copy() *Group {
+	copied := &Group{
+		ID:     g.ID,
+		Key:    g.Key,
+		Ports:  make([]uint32, len(g.Ports)),
+		Cookie: g.Cookie,
+	}
+	copy(copied.Ports, g.Ports)
+	return copied
+}
+
+type groupTable struct {
+	mu     sync.Mutex
+	groups map[uint32]*Group
+	nextID uint32
+}
+
+func newGroupTable() *groupTable {
+	return &groupTable{
+		groups: map[uint32]*Group{},
+		nextID: 1,
+	}
+}
+
+func (t *groupTable) getByKey(key string) *Group {
+	for _, group := range t.groups {
+		if group.Key == key {
+			return group
+		}
+	}
+	return nil
+}
+
+func (t *groupTable) allocateID() (uint32, error) {
+	for i := uint32(0); i < ofp13.OFPG_MAX; i++ {
+		id := t.nextID
+		t.nextID++
+		if t.nextID >= ofp13.OFPG_MAX {
+			t.nextID = 1
+		}
+		if _, ok := t.groups[id]; !ok {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no group ID available")
+}
+
+// addPort adds port authorized by the capability of cookie to the group of key
+// and returns true if the group is created. Adding the same pair again does nothing.
+func (t *groupTable) addPort(key string, port uint32, cookie uint64) (*Group, bool, error) {
+	created := false
+	group := t.getByKey(key)
+	if group == nil {
+		id, err := t.allocateID()
+		if err != nil {
+			return nil, false, err
+		}
+		group = &Group{
+			ID:     id,
+			Key:    key,
+			Ports:  []uint32{},
+			Cookie: cookie,
+			refs:   map[uint32]map[uint64]bool{},
+			purged: map[uint32]map[uint64]bool{},
+		}
+		t.groups[id] = group
+		created = true
+	}
+
+	if _, ok := group.refs[port]; !ok {
+		group.Ports = append(group.Ports, port)
+		group.refs[port] = map[uint64]bool{}
+	}
+	group.refs[port][cookie] = true
+
+	return group, created, nil
+}
+
+// removePort revokes port authorized by the capability of cookie from the group of key.
+// The port is removed when no capability authorizes it
+// and the group is deleted when no capability refers to it.
+// It returns true if Cookie of the group is handed over to another capability.
+func (t *groupTable) removePort(key string, port uint32, cookie uint64) (*Group, bool, error) {
+	group := t.getByKey(key)
+	if group == nil {
+		return nil, false, fmt.Errorf("group %v not found", key)
+	}
+
+	switch {
+	case group.refs[port][cookie]:
+		delete(group.refs[port], cookie)
+		if len(group.refs[port]) == 0 {
+			delete(group.refs, port)
+			group.removeBucket(port)
+		}
+	case group.purged[port][cookie]:
+		delete(group.purged[port], cookie)
+		if len(group.purged[port]) == 0 {
+			delete(group.purged, port)
+		}
+	default:
+		return nil, false, fmt.Errorf("port %v is not in group %v", port, key)
+	}
+
+	if len(group.refs) == 0 && len(group.purged) == 0 {
+		delete(t.groups, group.ID)
+		return group, false, nil
+	}
+
+	if group.Cookie != cookie || group.hasCookie(cookie) {
+		return group, false, nil
+	}
+	group.Cookie = group.anyCookie()
+
+	return group, true, nil
+}
+
+// purgePort removes the buckets of port deleted from switch and returns the groups modified.
+// The capabilities authorizing the port still refer to the groups until they are revoked.
+func (t *groupTable) purgePort(port uint32) []*Group {
+	groups := []*Group{}
+	for _, group := range t.groups {
+		cookies, ok := group.refs[port]
+		if !ok {
+			continue
+		}
+		delete(group.refs, port)
+		group.removeBucket(port)
+		if _, ok := group.purged[port]; !ok {
+			group.purged[port] = map[uint64]bool{}
+		}
+		for cookie := range cookies {
+			group.purged[port][cookie] = true
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func (g *Group) removeBucket(port uint32) {
+	for i, p := range g.Ports {
+		if p == port {
+			g.Ports = append(g.Ports[:i], g.Ports[i+1:]...)
+			return
+		}
+	}
+}
+
+func (g *Group) hasCookie(cookie uint64) bool {
+	for _, refs := range []map[uint32]map[uint64]bool{g.refs, g.purged} {
+		for _, cookies := range refs {
+			if cookies[cookie] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// anyCookie returns the smallest cookie of the capabilities referring to the group
+func (g *Group) anyCookie() uint64 {
+	found := false
+	min := uint64(0)
+	for _, refs := range []map[uint32]map[uint64]bool{g.refs, g.purged} {
+		for _, cookies := range refs {
+			for cookie := range cookies {
+				if !found || cookie < min {
+					min = cookie
+					found = true
+				}
+			}
+		}
+	}
+	return min
+}
+
+func (c *OFSwitch) sendGroupMod(command uint16, group *Group) error {
+	gm := ofp13.NewOfpGroupMod(command, ofp13.OFPGT_ALL, group.ID)
+	if command != ofp13.OFPGC_DELETE {
+		for _, port := range group.Ports {
+			bucket := ofp13.NewOfpBucket(0, ofp13.OFPP_ANY, ofp13.OFPG_ANY)
+			bucket.Append(ofp13.NewOfpActionOutput(port, OFPCML_NO_BUFFER))
+			gm.Append(bucket)
+		}
+	}
+
+	if !c.dp.Send(gm) {
+		return fmt.Errorf("failed to send group to switch(%v)", c.Name)
+	}
+
+	return nil
+}
+
+// AddGroupPort adds port authorized by the capability of cookie to the buckets of group
+// identified by key and returns true if the group is newly created
+func (c *OFSwitch) AddGroupPort(key string, port uint32, cookie uint64) (*Group, bool, error) {
+	c.groups.mu.Lock()
+	defer c.groups.mu.Unlock()
+
+	group, created, err := c.groups.addPort(key, port, cookie)
+	if err != nil {
+		return nil, false, err
+	}
+
+	command := uint16(ofp13.OFPGC_MODIFY)
+	if created {
+		command = ofp13.OFPGC_ADD
+	}
+	err = c.sendGroupMod(command, group)
+	if err != nil {
+		return nil, false, err
+	}
+
+	return group.copy(), created, nil
+}
+
+// RemoveGroupPort revokes port authorized by the capability of cookie from the buckets
+// of group identified by key. The group and the flows forwarding to it are deleted
+// when no capability refers to it. It returns true if the policy flows tagged with cookie
+// have to be sent again with the new Cookie of the group.
+func (c *OFSwitch) RemoveGroupPort(key string, port uint32, cookie uint64) (*Group, bool, error) {
+	c.groups.mu.Lock()
+	defer c.groups.mu.Unlock()
+
+	group, handedOver, err := c.groups.removePort(key, port, cookie)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if _, ok := c.groups.groups[group.ID]; ok {
+		err = c.sendGroupMod(ofp13.OFPGC_MODIFY, group)
+		if err != nil {
+			return nil, false, err
+		}
+		return group.copy(), handedOver, nil
+	}
+
+	err = c.sendGroupMod(ofp13.OFPGC_DELETE, group)
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = c.DeleteFlowsByCookie(GroupCookie(group.ID))
+	if err != nil {
+		return nil, false, err
+	}
+
+	return group.copy(), false, nil
+}
+
+// PurgeGroupPort removes the buckets of port deleted from switch from all groups
+func (c *OFSwitch) PurgeGroupPort(port uint32) error {
+	c.groups.mu.Lock()
+	defer c.groups.mu.Unlock()
+
+	for _, group := range c.groups.purgePort(port) {
+		err := c.sendGroupMod(ofp13.OFPGC_MODIFY, group)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetGroups returns all groups
+func (c *OFSwitch) GetGroups() []*Group {
+	c.groups.mu.Lock()
+	defer c.groups.mu.Unlock()
+
+	groups := []*Group{}
+	for _, group := range c.groups.groups {
+		groups = append(groups, group.copy())
+	}
+	return groups
+}
@@ -0,0 +1,157 @@
+package ofswitch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupTableAddRemovePort(t *testing.T) {
+	table := newGroupTable()
+
+	group, created, err := table.addPort("key", 10, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.True(t, created)
+	assert.Equal(t, uint32(1), group.ID)
+	assert.Equal(t, uint64(1), group.Cookie)
+
+	group, created, err = table.addPort("key", 11, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.False(t, created)
+	assert.Equal(t, []uint32{10, 11}, group.Ports)
+
+	// enforcing the same capability again does not add a reference
+	group, _, err = table.addPort("key", 11, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, []uint32{10, 11}, group.Ports)
+
+	group, _, err = table.removePort("key", 11, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, []uint32{10}, group.Ports)
+
+	_, _, err = table.removePort("key", 11, 1)
+	assert.NotNil(t, err)
+
+	// the port authorized by two capabilities remains until both are revoked
+	_, _, err = table.addPort("key", 11, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	_, _, err = table.addPort("key", 11, 2)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	group, _, err = table.removePort("key", 11, 2)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, []uint32{10, 11}, group.Ports)
+	group, _, err = table.removePort("key", 11, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, []uint32{10}, group.Ports)
+
+	group, _, err = table.removePort("key", 10, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 0, len(group.Ports))
+	assert.Nil(t, table.getByKey("key"))
+
+	group, created, err = table.addPort("other", 10, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.True(t, created)
+	assert.Equal(t, uint32(2), group.ID)
+}
+
+func TestGroupTableHandOverCookie(t *testing.T) {
+	table := newGroupTable()
+
+	for _, ref := range [][2]uint64{{10, 1}, {11, 2}, {12, 3}} {
+		_, _, err := table.addPort("key", uint32(ref[0]), ref[1])
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+	}
+
+	// revoking the capability not owning the policy flows keeps them
+	group, handedOver, err := table.removePort("key", 12, 3)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.False(t, handedOver)
+	assert.Equal(t, uint64(1), group.Cookie)
+
+	group, handedOver, err = table.removePort("key", 10, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.True(t, handedOver)
+	assert.Equal(t, uint64(2), group.Cookie)
+
+	group, handedOver, err = table.removePort("key", 11, 2)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.False(t, handedOver)
+	assert.Nil(t, table.getByKey("key"))
+}
+
+func TestGroupTablePurgePort(t *testing.T) {
+	table := newGroupTable()
+
+	for _, ref := range [][2]uint64{{10, 1}, {11, 2}, {11, 3}} {
+		_, _, err := table.addPort("key", uint32(ref[0]), ref[1])
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+	}
+
+	groups := table.purgePort(10)
+	assert.Equal(t, 1, len(groups))
+	assert.Equal(t, []uint32{11}, groups[0].Ports)
+	assert.Equal(t, 0, len(table.purgePort(12)))
+
+	// the capability of the purged port still owns the policy flows until revoked
+	group, handedOver, err := table.removePort("key", 11, 2)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.False(t, handedOver)
+	assert.Equal(t, uint64(1), group.Cookie)
+
+	table.purgePort(11)
+	group, handedOver, err = table.removePort("key", 10, 1)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.True(t, handedOver)
+	assert.Equal(t, uint64(3), group.Cookie)
+	assert.Equal(t, 0, len(group.Ports))
+	assert.NotNil(t, table.getByKey("key"))
+
+	_, _, err = table.removePort("key", 10, 1)
+	assert.NotNil(t, err)
+	_, _, err = table.removePort("key", 11, 3)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Nil(t, table.getByKey("key"))
+}
+
+func TestGroupCookie(t *testing.T) {
+	cookie := GroupCookie(3)
+	assert.Equal(t, CookieTypeGroup, GetCookieType(cookie))
+	assert.Equal(t, uint64(3), cookie&cookieIDMask)
+}
@@ -108,6 +108,11 @@ func (p *IP4AddrPool) Release(addr net.IP) error {
 	return p.allocationPool.ClearBit(hostAddr)
 }
 
+// SubnetLength returns prefix length of the addresses in pool
+func (p *IP4AddrPool) SubnetLength() int {
+	return p.subnetLength
+}
+
 func getHostAddr(addr net.IP, subnetLength int) uint32 {
 	ipUint32 := binary.BigEndian.Uint32(addr.To4())
 	hostMask := uint32(math.Pow(2, float64(32-subnetLength)) - 1)
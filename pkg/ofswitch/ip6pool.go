@@ -0,0 +1,124 @@
+package ofswitch
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/golang-collections/go-datastructures/bitarray"
+	"github.com/vishvananda/netlink"
+)
+
+// ip6PoolMaxHostLength limits the pool to the lower 16 bits of the subnet
+const ip6PoolMaxHostLength = 16
+
+type IP6AddrPool struct {
+	subnet         *net.IPNet
+	subnetLength   int
+	hostCount      uint64
+	allocationPool bitarray.BitArray
+	mu             sync.Mutex
+}
+
+func NewIP6AddrPool(subnet *netlink.Addr) *IP6AddrPool {
+	subnetLength, _ := subnet.IPNet.Mask.Size()
+	hostLength := 128 - subnetLength
+	if hostLength > ip6PoolMaxHostLength {
+		hostLength = ip6PoolMaxHostLength
+	}
+	hostCount := uint64(1) << uint(hostLength)
+	pool := &IP6AddrPool{
+		subnet:         subnet.IPNet,
+		subnetLength:   subnetLength,
+		hostCount:      hostCount,
+		allocationPool: bitarray.NewBitArray(hostCount),
+		mu:             sync.Mutex{},
+	}
+	// Subnet-Router anycast address
+	pool.allocationPool.SetBit(0)
+
+	return pool
+}
+
+func (p *IP6AddrPool) getHostIndex(addr net.IP) (uint64, error) {
+	if addr.To4() != nil || !p.subnet.Contains(addr) {
+		return 0, fmt.Errorf("IP %v is out of range %v", addr.String(), p.subnet.String())
+	}
+
+	hostIndex := binary.BigEndian.Uint64(addr.To16()[8:])
+	if p.subnetLength < 64 && binary.BigEndian.Uint64(addr.To16()[:8]) != binary.BigEndian.Uint64(p.subnet.IP.To16()[:8]) {
+		return 0, fmt.Errorf("IP %v is out of pool range", addr.String())
+	}
+	hostIndex -= binary.BigEndian.Uint64(p.subnet.IP.To16()[8:])
+	if hostIndex >= p.hostCount {
+		return 0, fmt.Errorf("IP %v is out of pool range", addr.String())
+	}
+
+	return hostIndex, nil
+}
+
+func (p *IP6AddrPool) LeaseWithAddr(addr *netlink.Addr) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hostIndex, err := p.getHostIndex(addr.IP)
+	if err != nil {
+		return err
+	}
+
+	isSet, err := p.allocationPool.GetBit(hostIndex)
+	if err != nil {
+		return err
+	}
+	if isSet {
+		return fmt.Errorf("IP %v is already assigned", addr.IP.String())
+	}
+
+	return p.allocationPool.SetBit(hostIndex)
+}
+
+func (p *IP6AddrPool) Lease() (*netlink.Addr, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := uint64(0); i < p.hostCount; i++ {
+		isSet, err := p.allocationPool.GetBit(i)
+		if err != nil {
+			return nil, err
+		}
+		if isSet {
+			continue
+		}
+		p.allocationPool.SetBit(i)
+
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, p.subnet.IP.To16())
+		binary.BigEndian.PutUint64(ip[8:], binary.BigEndian.Uint64(ip[8:])+i)
+
+		return netlink.ParseAddr(ip.String() + "/" + strconv.Itoa(p.subnetLength))
+	}
+
+	return nil, fmt.Errorf("no available address")
+}
+
+func (p *IP6AddrPool) Release(addr net.IP) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	hostIndex, err := p.getHostIndex(addr)
+	if err != nil {
+		return err
+	}
+
+	isSet, err := p.allocationPool.GetBit(hostIndex)
+	if err != nil {
+		return err
+	}
+	if !isSet {
+		return fmt.Errorf("IP %v is not assigned", addr.String())
+	}
+
+	return p.allocationPool.ClearBit(hostIndex)
+}
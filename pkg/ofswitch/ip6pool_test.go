@@ -0,0 +1,89 @@
+package ofswitch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestIP6LeaseWithAddr(t *testing.T) {
+	subnet, err := netlink.ParseAddr("fd00:20::/64")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	ipPool := NewIP6AddrPool(subnet)
+
+	ip, err := netlink.ParseAddr("fd00:20::1/64")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	err = ipPool.LeaseWithAddr(ip)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	err = ipPool.LeaseWithAddr(ip)
+	if err == nil {
+		t.Fatalf("Reallocation occured")
+	}
+
+	err = ipPool.Release(ip.IP)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	err = ipPool.LeaseWithAddr(ip)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	ip, err = netlink.ParseAddr("fd00:21::1/64")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = ipPool.LeaseWithAddr(ip)
+	if err == nil {
+		t.Fatalf("Out of range address is leased")
+	}
+
+	ip, err = netlink.ParseAddr("fd00:20::1:0/64")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = ipPool.LeaseWithAddr(ip)
+	if err == nil {
+		t.Fatalf("Out of pool address is leased")
+	}
+}
+
+func TestIP6Lease(t *testing.T) {
+	subnet, err := netlink.ParseAddr("fd00:20::/120")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	ipPool := NewIP6AddrPool(subnet)
+
+	ip, err := netlink.ParseAddr("fd00:20::1/120")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	err = ipPool.LeaseWithAddr(ip)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	for i := 2; i < 256; i++ {
+		addr, err := ipPool.Lease()
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		assert.Equal(t, byte(i), addr.IP[15])
+	}
+
+	_, err = ipPool.Lease()
+	if err == nil {
+		t.Fatalf("Lease must fail when pool is exhausted")
+	}
+}
@@ -0,0 +1,242 @@
+package ofswitch
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/naoki9911/gofc"
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// Meter is OpenFlow meter bound to the flows with the same cookie
+type Meter struct {
+	ID                 uint32 `json:"id"`
+	Switch             string `json:"switch"`
+	Cookie             uint64 `json:"cookie"`
+	Rate               uint32 `json:"rate"`
+	Burst              uint32 `json:"burst"`
+	FlowCount          uint32 `json:"flowCount"`
+	PacketInCount      uint64 `json:"packetInCount"`
+	ByteInCount        uint64 `json:"byteInCount"`
+	DroppedPacketCount uint64 `json:"droppedPacketCount"`
+	DroppedByteCount   uint64 `json:"droppedByteCount"`
+}
+
+type meterTable struct {
+	mu     sync.Mutex
+	meters map[uint32]*Meter
+	nextID uint32
+}
+
+func newMeterTable() *meterTable {
+	return &meterTable{
+		meters: map[uint32]*Meter{},
+		nextID: 1,
+	}
+}
+
+func (t *meterTable) getByCookie(cookie uint64) *Meter {
+	for _, meter := range t.meters {
+		if meter.Cookie == cookie {
+			return meter
+		}
+	}
+	return nil
+}
+
+func (t *meterTable) allocateID() (uint32, error) {
+	for i := uint32(0); i < ofp13.OFPM_MAX; i++ {
+		id := t.nextID
+		t.nextID++
+		if t.nextID >= ofp13.OFPM_MAX {
+			t.nextID = 1
+		}
+		if _, ok := t.meters[id]; !ok {
+			return id, nil
+		}
+	}
+	return 0, fmt.Errorf("no meter ID available")
+}
+
+// SetMeter creates or modifies meter limiting flows with cookie to rate kbps
+func (c *OFSwitch) SetMeter(cookie uint64, rate uint32, burst uint32) (*Meter, error) {
+	if c.dp == nil {
+		return nil, fmt.Errorf("switch(%v) is not connected", c.Name)
+	}
+
+	c.meters.mu.Lock()
+	defer c.meters.mu.Unlock()
+
+	command := uint16(ofp13.OFPMC_MODIFY)
+	meter := c.meters.getByCookie(cookie)
+	if meter == nil {
+		id, err := c.meters.allocateID()
+		if err != nil {
+			return nil, err
+		}
+		meter = &Meter{
+			ID:     id,
+			Cookie: cookie,
+		}
+		command = ofp13.OFPMC_ADD
+	}
+
+	flags := uint16(ofp13.OFPMF_KBPS | ofp13.OFPMF_STATS)
+	if burst != 0 {
+		flags |= ofp13.OFPMF_BURST
+	}
+	mm := ofp13.NewOfpMeterMod(command, flags, meter.ID)
+	mm.AppendMeterBand(ofp13.NewOfpMeterBandDrop(rate, burst))
+	if !c.dp.Send(mm) {
+		return nil, fmt.Errorf("failed to send meter to switch(%v)", c.Name)
+	}
+
+	meter.Rate = rate
+	meter.Burst = burst
+	c.meters.meters[meter.ID] = meter
+
+	return meter, nil
+}
+
+// DeleteMeter deletes meter and the flows bound to it
+func (c *OFSwitch) DeleteMeter(id uint32) error {
+	c.meters.mu.Lock()
+	defer c.meters.mu.Unlock()
+
+	meter, ok := c.meters.meters[id]
+	if !ok {
+		return fmt.Errorf("meter %v not found", id)
+	}
+	if c.dp == nil {
+		return fmt.Errorf("switch(%v) is not connected", c.Name)
+	}
+
+	mm := ofp13.NewOfpMeterMod(ofp13.OFPMC_DELETE, 0, id)
+	if !c.dp.Send(mm) {
+		return fmt.Errorf("failed to send meter to switch(%v)", c.Name)
+	}
+	delete(c.meters.meters, id)
+
+	return c.DeleteFlowsByCookie(meter.Cookie)
+}
+
+// DeleteMeterByCookie deletes meter bound to cookie if exists
+func (c *OFSwitch) DeleteMeterByCookie(cookie uint64) error {
+	c.meters.mu.Lock()
+	meter := c.meters.getByCookie(cookie)
+	c.meters.mu.Unlock()
+
+	if meter == nil {
+		return nil
+	}
+
+	return c.DeleteMeter(meter.ID)
+}
+
+// GetMeterByCookie returns meter bound to cookie
+func (c *OFSwitch) GetMeterByCookie(cookie uint64) *Meter {
+	c.meters.mu.Lock()
+	defer c.meters.mu.Unlock()
+
+	meter := c.meters.getByCookie(cookie)
+	if meter == nil {
+		return nil
+	}
+	copied := *meter
+	copied.Switch = c.Name
+	return &copied
+}
+
+// GetMeter returns meter of id
+func (c *OFSwitch) GetMeter(id uint32) *Meter {
+	c.meters.mu.Lock()
+	defer c.meters.mu.Unlock()
+
+	meter, ok := c.meters.meters[id]
+	if !ok {
+		return nil
+	}
+	copied := *meter
+	copied.Switch = c.Name
+	return &copied
+}
+
+// GetMeters returns all meters
+func (c *OFSwitch) GetMeters() []*Meter {
+	c.meters.mu.Lock()
+	defer c.meters.mu.Unlock()
+
+	meters := []*Meter{}
+	for _, meter := range c.meters.meters {
+		copied := *meter
+		copied.Switch = c.Name
+		meters = append(meters, &copied)
+	}
+	return meters
+}
+
+// DeleteFlowsByCookie deletes all flows with cookie
+func (c *OFSwitch) DeleteFlowsByCookie(cookie uint64) error {
+	fm := ofp13.NewOfpFlowModDelete(
+		cookie,
+		0xffffffffffffffff,
+		ofp13.OFPTT_ALL,
+		0,
+		ofp13.OFPP_ANY,
+		ofp13.OFPG_ANY,
+		0,
+		ofp13.NewOfpMatch(),
+	)
+
+	if c.dp == nil {
+		return fmt.Errorf("switch(%v) is not connected", c.Name)
+	}
+	if !c.dp.Send(fm) {
+		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
+	}
+
+	return nil
+}
+
+func (c *OFSwitch) appendMeterInstruction(instructions []ofp13.OfpInstruction, cookie uint64) []ofp13.OfpInstruction {
+	if cookie == 0 {
+		return instructions
+	}
+
+	meter := c.GetMeterByCookie(cookie)
+	if meter == nil {
+		return instructions
+	}
+
+	return append([]ofp13.OfpInstruction{ofp13.NewOfpInstructionMeter(meter.ID)}, instructions...)
+}
+
+// HandleMeterStatsReply stores meter counters
+func (c *OFSwitch) HandleMeterStatsReply(msg *ofp13.OfpMultipartReply, dp *gofc.Datapath) {
+	if dp != c.dp {
+		return
+	}
+
+	c.meters.mu.Lock()
+	defer c.meters.mu.Unlock()
+
+	for _, mp := range msg.Body {
+		obj, ok := mp.(*ofp13.OfpMeterStats)
+		if !ok {
+			continue
+		}
+		meter, ok := c.meters.meters[obj.MeterId]
+		if !ok {
+			continue
+		}
+		meter.FlowCount = obj.FlowCount
+		meter.PacketInCount = obj.PacketInCount
+		meter.ByteInCount = obj.ByteInCount
+		meter.DroppedPacketCount = 0
+		meter.DroppedByteCount = 0
+		for _, band := range obj.BandStats {
+			meter.DroppedPacketCount += band.PacketBandCount
+			meter.DroppedByteCount += band.ByteBandCount
+		}
+	}
+}
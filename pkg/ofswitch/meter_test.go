@@ -0,0 +1,44 @@
+package ofswitch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMeterTableAllocateID(t *testing.T) {
+	table := newMeterTable()
+
+	id, err := table.allocateID()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, uint32(1), id)
+	table.meters[id] = &Meter{ID: id, Cookie: 100}
+
+	id, err = table.allocateID()
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, uint32(2), id)
+
+	assert.Equal(t, uint32(1), table.getByCookie(100).ID)
+	assert.Nil(t, table.getByCookie(200))
+}
+
+func TestMeterNotConnected(t *testing.T) {
+	ofs := NewOFSwitch("test")
+
+	_, err := ofs.SetMeter(100, 1000, 0)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(ofs.GetMeters()))
+
+	ofs.meters.meters[1] = &Meter{ID: 1, Cookie: 100}
+	err = ofs.DeleteMeter(1)
+	assert.NotNil(t, err)
+	assert.NotNil(t, ofs.GetMeterByCookie(100))
+	assert.Equal(t, "test", ofs.GetMeter(1).Switch)
+	assert.Nil(t, ofs.GetMeter(2))
+
+	assert.NotNil(t, ofs.DeleteFlowsByCookie(100))
+}
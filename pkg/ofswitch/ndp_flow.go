@@ -0,0 +1,244 @@
+package ofswitch
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// ICMPv6 types of Neighbor Discovery Protocol
+const (
+	icmpv6TypeRouterSolicitation    uint8 = 133
+	icmpv6TypeRouterAdvertisement   uint8 = 134
+	icmpv6TypeNeighborSolicitation  uint8 = 135
+	icmpv6TypeNeighborAdvertisement uint8 = 136
+)
+
+const ipProtoICMPv6 uint8 = 58
+
+const ipv6MulticastHWAddr = "33:33:00:00:00:00"
+const ipv6MulticastHWAddrMask = "ff:ff:00:00:00:00"
+
+// IPv6AllNodesHWAddr is multicast hwaddr of ff02::1
+const IPv6AllNodesHWAddr = "33:33:00:00:00:01"
+
+func (c *OFSwitch) sendFlowModDelete(match *ofp13.OfpMatch) error {
+	fm := ofp13.NewOfpFlowModDelete(
+		0,
+		0,
+		TableAdmission,
+		0,
+		0,
+		0,
+		0,
+		match,
+	)
+
+	if !c.dp.Send(fm) {
+		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
+	}
+
+	return nil
+}
+
+func getICMPv6Match(inPort uint32, icmpv6Type uint8) *ofp13.OfpMatch {
+	match := ofp13.NewOfpMatch()
+
+	inport := ofp13.NewOxmInPort(inPort)
+	match.Append(inport)
+
+	ethType := ofp13.NewOxmEthType(0x86dd)
+	match.Append(ethType)
+
+	ipProto := ofp13.NewOxmIpProto(ipProtoICMPv6)
+	match.Append(ipProto)
+
+	icmpType := ofp13.NewOxmIcmpv6Type(icmpv6Type)
+	match.Append(icmpType)
+
+	return match
+}
+
+// AddHostAggregatedNDPFlow passes NDP between the host and clients behind link
+func (c *OFSwitch) AddHostAggregatedNDPFlow(link DeviceLink) error {
+	for _, icmpv6Type := range []uint8{icmpv6TypeRouterAdvertisement, icmpv6TypeNeighborSolicitation, icmpv6TypeNeighborAdvertisement} {
+		match := getICMPv6Match(c.Link.GetOfPort(), icmpv6Type)
+		err := c.SendFlowModAddOutput(match, link.GetOfPort(), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, icmpv6Type := range []uint8{icmpv6TypeRouterSolicitation, icmpv6TypeNeighborSolicitation, icmpv6TypeNeighborAdvertisement} {
+		match := getICMPv6Match(link.GetOfPort(), icmpv6Type)
+		err := c.SendFlowModAddOutput(match, c.Link.GetOfPort(), 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DeleteHostAggregatedNDPFlow deletes flows added by AddHostAggregatedNDPFlow
+func (c *OFSwitch) DeleteHostAggregatedNDPFlow(link DeviceLink) error {
+	for _, icmpv6Type := range []uint8{icmpv6TypeRouterAdvertisement, icmpv6TypeNeighborSolicitation, icmpv6TypeNeighborAdvertisement} {
+		err := c.sendFlowModDelete(getICMPv6Match(c.Link.GetOfPort(), icmpv6Type))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, icmpv6Type := range []uint8{icmpv6TypeRouterSolicitation, icmpv6TypeNeighborSolicitation, icmpv6TypeNeighborAdvertisement} {
+		err := c.sendFlowModDelete(getICMPv6Match(link.GetOfPort(), icmpv6Type))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getDHCPv6Match(inPort uint32, srcPort uint16, dstPort uint16) *ofp13.OfpMatch {
+	match := ofp13.NewOfpMatch()
+
+	inport := ofp13.NewOxmInPort(inPort)
+	match.Append(inport)
+
+	ethType := ofp13.NewOxmEthType(0x86dd)
+	match.Append(ethType)
+
+	ipProto := ofp13.NewOxmIpProto(17)
+	match.Append(ipProto)
+
+	udpSrc := ofp13.NewOxmUdpSrc(srcPort)
+	match.Append(udpSrc)
+
+	udpDst := ofp13.NewOxmUdpDst(dstPort)
+	match.Append(udpDst)
+
+	return match
+}
+
+// AddHostAggregatedDHCPv6Flow passes DHCPv6 between the host and clients behind link
+func (c *OFSwitch) AddHostAggregatedDHCPv6Flow(link DeviceLink) error {
+	err := c.SendFlowModAddOutput(getDHCPv6Match(link.GetOfPort(), 546, 547), c.Link.GetOfPort(), 200)
+	if err != nil {
+		return err
+	}
+
+	return c.SendFlowModAddOutput(getDHCPv6Match(c.Link.GetOfPort(), 547, 546), link.GetOfPort(), 200)
+}
+
+// DeleteHostAggregatedDHCPv6Flow deletes flows added by AddHostAggregatedDHCPv6Flow
+func (c *OFSwitch) DeleteHostAggregatedDHCPv6Flow(link DeviceLink) error {
+	err := c.sendFlowModDelete(getDHCPv6Match(link.GetOfPort(), 546, 547))
+	if err != nil {
+		return err
+	}
+
+	return c.sendFlowModDelete(getDHCPv6Match(c.Link.GetOfPort(), 547, 546))
+}
+
+func getNDPTargetMatch(inPort uint32, icmpv6Type uint8, target net.IP) (*ofp13.OfpMatch, error) {
+	match := getICMPv6Match(inPort, icmpv6Type)
+
+	ndTarget, err := ofp13.NewOxmIpv6NdTarget(target.String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ndTarget)
+
+	return match, nil
+}
+
+func (c *OFSwitch) addNDPTargetFlow(linkA DeviceLink, linkB DeviceLink, priority uint16) error {
+	// NS from A resolving B
+	match, err := getNDPTargetMatch(linkA.GetOfPort(), icmpv6TypeNeighborSolicitation, linkB.GetIP6Address().IP)
+	if err != nil {
+		return err
+	}
+	err = c.SendFlowModAddOutput(match, linkB.GetOfPort(), priority)
+	if err != nil {
+		return err
+	}
+
+	// NA from A answering B
+	match, err = getNDPTargetMatch(linkA.GetOfPort(), icmpv6TypeNeighborAdvertisement, linkA.GetIP6Address().IP)
+	if err != nil {
+		return err
+	}
+	return c.SendFlowModAddOutput(match, linkB.GetOfPort(), priority)
+}
+
+// AddDeviceAppNDPFlow is IPv6 counterpart of AddDeviceAppARPFlow
+func (c *OFSwitch) AddDeviceAppNDPFlow(deviceLink DeviceLink, appLink DeviceLink) error {
+	if deviceLink.GetIP6Address() == nil || appLink.GetIP6Address() == nil {
+		return nil
+	}
+
+	err := c.addNDPTargetFlow(deviceLink, appLink, 200)
+	if err != nil {
+		return err
+	}
+
+	return c.addNDPTargetFlow(appLink, deviceLink, 200)
+}
+
+// AddAppsNDPFlow is IPv6 counterpart of AddAppsARPFlow
+func (c *OFSwitch) AddAppsNDPFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink) error {
+	if deviceLinkA.GetIP6Address() == nil || deviceLinkB.GetIP6Address() == nil {
+		return nil
+	}
+
+	// NS from A resolving B
+	match, err := getNDPTargetMatch(appLinkA.GetOfPort(), icmpv6TypeNeighborSolicitation, deviceLinkB.GetIP6Address().IP)
+	if err != nil {
+		return err
+	}
+	err = c.SendPolicyFlowOutput(match, appLinkB.GetOfPort(), 90, 0)
+	if err != nil {
+		return err
+	}
+
+	match, err = getNDPTargetMatch(appLinkB.GetOfPort(), icmpv6TypeNeighborSolicitation, deviceLinkA.GetIP6Address().IP)
+	if err != nil {
+		return err
+	}
+	err = c.SendPolicyFlowOutput(match, appLinkA.GetOfPort(), 90, 0)
+	if err != nil {
+		return err
+	}
+
+	// NA answering the solicitations
+	match, err = getNDPTargetMatch(appLinkA.GetOfPort(), icmpv6TypeNeighborAdvertisement, deviceLinkA.GetIP6Address().IP)
+	if err != nil {
+		return err
+	}
+	err = c.SendPolicyFlowOutput(match, appLinkB.GetOfPort(), 90, 0)
+	if err != nil {
+		return err
+	}
+
+	match, err = getNDPTargetMatch(appLinkB.GetOfPort(), icmpv6TypeNeighborAdvertisement, deviceLinkB.GetIP6Address().IP)
+	if err != nil {
+		return err
+	}
+	return c.SendPolicyFlowOutput(match, appLinkA.GetOfPort(), 90, 0)
+}
+
+func (c *OFSwitch) getMulticastTunnelMatch(inPort uint32) (*ofp13.OfpMatch, error) {
+	match := ofp13.NewOfpMatch()
+
+	inport := ofp13.NewOxmInPort(inPort)
+	match.Append(inport)
+
+	ethdst, err := ofp13.NewOxmEthDstW(ipv6MulticastHWAddr, ipv6MulticastHWAddrMask)
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ethdst)
+
+	return match, nil
+}
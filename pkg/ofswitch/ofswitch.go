@@ -29,6 +29,19 @@ type OFSwitch struct {
 	ports         *netlinkext.LinkCollection
 	DatapathID    uint64
 	dp            *gofc.Datapath
+	stats         *switchStats
+	meters        *meterTable
+	groups        *groupTable
+	forwarding    *forwardingTable
+	// called for each packet sent to controller
+	packetInHandler func(*PacketInfo)
+	puntRate        uint32
+	discoveryRate   uint32
+	// TCP ports of the host reachable from app links
+	servicePorts []uint16
+	portStates   *portTable
+	// called for each port-status message
+	portStatusHandler func(*PortStatus)
 }
 
 // NewOFSwitch creates openflow switch
@@ -40,6 +53,11 @@ func NewOFSwitch(switchName string) *OFSwitch {
 	ofs.ports = netlinkext.NewLinkCollection()
 	ofs.DatapathID = 0
 	ofs.dp = nil
+	ofs.stats = newSwitchStats()
+	ofs.meters = newMeterTable()
+	ofs.groups = newGroupTable()
+	ofs.forwarding = newForwardingTable()
+	ofs.portStates = newPortTable()
 	ofs.Link = &netlinkext.LinkExt{
 		Ofport: ofPortLocal,
 	}
@@ -105,7 +123,11 @@ func (s *OFSwitch) SetAddr(addr *netlink.Addr) error {
 	if err != nil {
 		return err
 	}
-	s.Link.Addr = addr
+	if addr.IP.To4() == nil {
+		s.Link.Addr6 = addr
+	} else {
+		s.Link.Addr = addr
+	}
 	return nil
 }
 
@@ -117,56 +139,10 @@ func (c *OFSwitch) HandleSwitchFeatures(msg *ofp13.OfpSwitchFeatures, dp *gofc.D
 	}
 	c.dp = dp
 	fmt.Println("Handle SwitchFeatures")
-	// create match
-	ethdst, _ := ofp13.NewOxmEthDst("00:00:00:00:00:00")
-	if ethdst == nil {
-		fmt.Println(ethdst)
-		return
-	}
-	match := ofp13.NewOfpMatch()
-	match.Append(ethdst)
-
-	// create Instruction
-	instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
-
-	// create actions
-	seteth, _ := ofp13.NewOxmEthDst("11:22:33:44:55:66")
-	instruction.Append(ofp13.NewOfpActionSetField(seteth))
-
-	// append Instruction
-	instructions := make([]ofp13.OfpInstruction, 0)
-	instructions = append(instructions, instruction)
-
-	// create flow mod
-	fm := ofp13.NewOfpFlowModModify(
-		0, // cookie
-		0, // cookie mask
-		0, // tableid
-		0, // priority
-		ofp13.OFPFF_SEND_FLOW_REM,
-		match,
-		instructions,
-	)
-
-	// send FlowMod
-	dp.Send(fm)
 
-	// Create and send AggregateStatsRequest
-	mf := ofp13.NewOfpMatch()
-	mf.Append(ethdst)
-	mp := ofp13.NewOfpAggregateStatsRequest(0, 0, ofp13.OFPP_ANY, ofp13.OFPG_ANY, 0, 0, mf)
-	dp.Send(mp)
-}
-
-// HandleAggregateStatsReply reply some
-func (c *OFSwitch) HandleAggregateStatsReply(msg *ofp13.OfpMultipartReply, dp *gofc.Datapath) {
-	fmt.Println("Handle AggregateStats")
-	for _, mp := range msg.Body {
-		if obj, ok := mp.(*ofp13.OfpAggregateStats); ok {
-			fmt.Println(obj.PacketCount)
-			fmt.Println(obj.ByteCount)
-			fmt.Println(obj.FlowCount)
-		}
+	err := c.SetupPipeline()
+	if err != nil {
+		log.Printf("error: Failed to setup pipeline of switch(%v) %v", c.Name, err)
 	}
 }
 
@@ -174,9 +150,6 @@ func (c *OFSwitch) HandleErrorMsg(msg *ofp13.OfpErrorMsg, dp *gofc.Datapath) {
 	log.Printf("error: HandleErrorMsg Type:%d Code:%d", msg.Type, msg.Code)
 }
 
-func (c *OFSwitch) HandlePortStatus(msg *ofp13.OfpPortStatus, dp *gofc.Datapath) {
-}
-
 // AttackLink attaches link to ovs
 func (c *OFSwitch) AttachLink(linkExt *netlinkext.LinkExt, ofType netlinkext.OFType) error {
 	switch link := linkExt.GetLink().(type) {
@@ -304,7 +277,7 @@ func (c *OFSwitch) addUnicastARPFlow(linkA *netlinkext.LinkExt, linkB *netlinkex
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -350,7 +323,7 @@ func (c *OFSwitch) addBroadcastARPFlow(linkA *netlinkext.LinkExt, linkB *netlink
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -439,7 +412,7 @@ func (c *OFSwitch) deleteUnicastARPFlow(linkA DeviceLink, linkB DeviceLink) erro
 	fm := ofp13.NewOfpFlowModDelete(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		0,
@@ -481,7 +454,7 @@ func (c *OFSwitch) deleteBroadcastARPFlow(linkA DeviceLink, linkB DeviceLink) er
 	fm := ofp13.NewOfpFlowModDelete(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		0,
@@ -569,7 +542,7 @@ func (c *OFSwitch) addUnicastICMPFlow(linkA *netlinkext.LinkExt, linkB *netlinke
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -633,7 +606,7 @@ func (c *OFSwitch) addUnicastTunnelFlow(linkA *netlinkext.LinkExt, linkB *netlin
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -673,7 +646,7 @@ func (c *OFSwitch) addBroadcastTunnelFlow(linkA *netlinkext.LinkExt, linkB *netl
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -784,7 +757,7 @@ func (c *OFSwitch) addUnicastTransportDstFlow(linkA *netlinkext.LinkExt, linkB *
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -859,7 +832,7 @@ func (c *OFSwitch) addUnicastTransportSrcFlow(linkA *netlinkext.LinkExt, linkB *
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -943,7 +916,7 @@ func (c *OFSwitch) addDHCPBroadcastFlow(client *netlinkext.LinkExt, server *netl
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		20,
 		0,
 		match,
@@ -1006,7 +979,7 @@ func (c *OFSwitch) addDHCPBroadcastFlow(client *netlinkext.LinkExt, server *netl
 	fm = ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		20,
 		0,
 		match,
@@ -1066,7 +1039,7 @@ func (c *OFSwitch) addDHCPUnicastFlow(client *netlinkext.LinkExt, server *netlin
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		20,
 		0,
 		match,
@@ -1123,7 +1096,7 @@ func (c *OFSwitch) addDHCPUnicastFlow(client *netlinkext.LinkExt, server *netlin
 	fm = ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		20,
 		0,
 		match,
@@ -1142,22 +1115,33 @@ func (c *OFSwitch) AddDeviceTunnelFlow(linkA DeviceLink, linkB DeviceLink) error
 		return err
 	}
 
-	err = c.addUnicastDeviceTunnelFlow(linkA, linkB)
+	cookie := DeviceCookie(linkA.GetHWAddress())
+	err = c.addUnicastDeviceTunnelFlow(linkA, linkB, cookie)
+	if err != nil {
+		return err
+	}
+
+	err = c.addUnicastDeviceTunnelFlow(linkB, linkA, cookie)
 	if err != nil {
 		return err
 	}
 
-	err = c.addUnicastDeviceTunnelFlow(linkB, linkA)
+	err = c.addBroadcastDeviceTunnelFlow(linkA, linkB, cookie)
 	if err != nil {
 		return err
 	}
 
-	err = c.addBroadcastDeviceTunnelFlow(linkA, linkB)
+	err = c.addBroadcastDeviceTunnelFlow(linkB, linkA, cookie)
 	if err != nil {
 		return err
 	}
 
-	err = c.addBroadcastDeviceTunnelFlow(linkB, linkA)
+	err = c.addMulticastDeviceTunnelFlow(linkA, linkB, cookie)
+	if err != nil {
+		return err
+	}
+
+	err = c.addMulticastDeviceTunnelFlow(linkB, linkA, cookie)
 	if err != nil {
 		return err
 	}
@@ -1165,7 +1149,7 @@ func (c *OFSwitch) AddDeviceTunnelFlow(linkA DeviceLink, linkB DeviceLink) error
 	return nil
 }
 
-func (c *OFSwitch) addUnicastDeviceTunnelFlow(linkA DeviceLink, linkB DeviceLink) error {
+func (c *OFSwitch) addUnicastDeviceTunnelFlow(linkA DeviceLink, linkB DeviceLink, cookie uint64) error {
 	match := ofp13.NewOfpMatch()
 
 	inport := ofp13.NewOxmInPort(linkA.GetOfPort())
@@ -1183,29 +1167,10 @@ func (c *OFSwitch) addUnicastDeviceTunnelFlow(linkA DeviceLink, linkB DeviceLink
 	}
 	match.Append(ethdst)
 
-	instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
-	instruction.Append(ofp13.NewOfpActionOutput(linkB.GetOfPort(), OFPCML_NO_BUFFER))
-	instructions := make([]ofp13.OfpInstruction, 0)
-	instructions = append(instructions, instruction)
-
-	fm := ofp13.NewOfpFlowModAdd(
-		0,
-		0,
-		0,
-		10,
-		0,
-		match,
-		instructions,
-	)
-
-	if !c.dp.Send(fm) {
-		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
-	}
-
-	return nil
+	return c.SendFlowModAddOutputWithCookie(match, linkB.GetOfPort(), 10, cookie)
 }
 
-func (c *OFSwitch) addBroadcastDeviceTunnelFlow(linkA DeviceLink, linkB DeviceLink) error {
+func (c *OFSwitch) addBroadcastDeviceTunnelFlow(linkA DeviceLink, linkB DeviceLink, cookie uint64) error {
 	match := ofp13.NewOfpMatch()
 
 	inport := ofp13.NewOxmInPort(linkA.GetOfPort())
@@ -1223,26 +1188,16 @@ func (c *OFSwitch) addBroadcastDeviceTunnelFlow(linkA DeviceLink, linkB DeviceLi
 	}
 	match.Append(ethdst)
 
-	instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
-	instruction.Append(ofp13.NewOfpActionOutput(linkB.GetOfPort(), OFPCML_NO_BUFFER))
-	instructions := make([]ofp13.OfpInstruction, 0)
-	instructions = append(instructions, instruction)
-
-	fm := ofp13.NewOfpFlowModAdd(
-		0,
-		0,
-		0,
-		10,
-		0,
-		match,
-		instructions,
-	)
+	return c.SendFlowModAddOutputWithCookie(match, linkB.GetOfPort(), 10, cookie)
+}
 
-	if !c.dp.Send(fm) {
-		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
+func (c *OFSwitch) addMulticastDeviceTunnelFlow(linkA DeviceLink, linkB DeviceLink, cookie uint64) error {
+	match, err := c.getMulticastTunnelMatch(linkA.GetOfPort())
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return c.SendFlowModAddOutputWithCookie(match, linkB.GetOfPort(), 10, cookie)
 }
 
 func (c *OFSwitch) AddDeviceARPFlow(linkA DeviceLink, linkB DeviceLink) error {
@@ -1326,7 +1281,7 @@ func (c *OFSwitch) addUnicastDeviceARPFlow(linkA DeviceLink, linkB DeviceLink) e
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		20,
 		0,
 		match,
@@ -1373,7 +1328,7 @@ func (c *OFSwitch) addBroadcastDeviceARPFlow(linkA DeviceLink, linkB DeviceLink,
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		20,
 		0,
 		match,
@@ -1422,7 +1377,7 @@ func (c *OFSwitch) addEAPoLFlow(linkA DeviceLink, linkB DeviceLink) error {
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		200,
 		0,
 		match,
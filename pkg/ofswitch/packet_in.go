@@ -0,0 +1,141 @@
+package ofswitch
+
+import (
+	"log"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/naoki9911/gofc"
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// puntMaxLen is the bytes of the packet sent to controller, enough for the headers
+const puntMaxLen = 128
+
+// PacketInfo is a summary of the packet sent to controller
+type PacketInfo struct {
+	TableID uint8            `json:"tableID"`
+	Cookie  uint64           `json:"cookie"`
+	InPort  uint32           `json:"inPort"`
+	EthSrc  net.HardwareAddr `json:"ethSrc"`
+	EthDst  net.HardwareAddr `json:"ethDst"`
+	EthType uint16           `json:"ethType"`
+	IPSrc   net.IP           `json:"ipSrc,omitempty"`
+	IPDst   net.IP           `json:"ipDst,omitempty"`
+	IPProto uint8            `json:"ipProto,omitempty"`
+	SrcPort uint16           `json:"srcPort,omitempty"`
+	DstPort uint16           `json:"dstPort,omitempty"`
+	Length  int              `json:"length"`
+	// the frame sent to controller, truncated by the flow
+	Data []byte `json:"-"`
+}
+
+// ParsePacket parses the headers of ethernet frame
+func ParsePacket(data []byte) *PacketInfo {
+	info := &PacketInfo{
+		Length: len(data),
+	}
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Lazy)
+
+	if ethLayer := packet.Layer(layers.LayerTypeEthernet); ethLayer != nil {
+		eth := ethLayer.(*layers.Ethernet)
+		info.EthSrc = eth.SrcMAC
+		info.EthDst = eth.DstMAC
+		info.EthType = uint16(eth.EthernetType)
+	}
+
+	if arpLayer := packet.Layer(layers.LayerTypeARP); arpLayer != nil {
+		arp := arpLayer.(*layers.ARP)
+		info.IPSrc = net.IP(arp.SourceProtAddress)
+		info.IPDst = net.IP(arp.DstProtAddress)
+	}
+
+	if ipLayer := packet.Layer(layers.LayerTypeIPv4); ipLayer != nil {
+		ip := ipLayer.(*layers.IPv4)
+		info.IPSrc = ip.SrcIP
+		info.IPDst = ip.DstIP
+		info.IPProto = uint8(ip.Protocol)
+	}
+
+	if ipLayer := packet.Layer(layers.LayerTypeIPv6); ipLayer != nil {
+		ip := ipLayer.(*layers.IPv6)
+		info.IPSrc = ip.SrcIP
+		info.IPDst = ip.DstIP
+		info.IPProto = uint8(ip.NextHeader)
+	}
+
+	if tcpLayer := packet.Layer(layers.LayerTypeTCP); tcpLayer != nil {
+		tcp := tcpLayer.(*layers.TCP)
+		info.SrcPort = uint16(tcp.SrcPort)
+		info.DstPort = uint16(tcp.DstPort)
+	}
+
+	if udpLayer := packet.Layer(layers.LayerTypeUDP); udpLayer != nil {
+		udp := udpLayer.(*layers.UDP)
+		info.SrcPort = uint16(udp.SrcPort)
+		info.DstPort = uint16(udp.DstPort)
+	}
+
+	if sctpLayer := packet.Layer(layers.LayerTypeSCTP); sctpLayer != nil {
+		sctp := sctpLayer.(*layers.SCTP)
+		info.SrcPort = uint16(sctp.SrcPort)
+		info.DstPort = uint16(sctp.DstPort)
+	}
+
+	return info
+}
+
+// SetPacketInHandler sets handler called for each packet sent to controller
+func (c *OFSwitch) SetPacketInHandler(handler func(*PacketInfo)) {
+	c.packetInHandler = handler
+}
+
+// SetPuntRate sets rate limit(kbps) of the packets sent to controller by policy table miss.
+// 0 drops them without sending to controller. It takes effect when the pipeline is set up.
+func (c *OFSwitch) SetPuntRate(rate uint32) {
+	c.puntRate = rate
+}
+
+func (c *OFSwitch) addPolicyMissFlow() error {
+	if c.puntRate == 0 {
+		return c.sendFlowModAdd(TablePolicy, 0, 0, ofp13.NewOfpMatch(), []ofp13.OfpInstruction{})
+	}
+
+	cookie := PuntCookie()
+	_, err := c.SetMeter(cookie, c.puntRate, 0)
+	if err != nil {
+		return err
+	}
+
+	instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
+	instruction.Append(ofp13.NewOfpActionOutput(ofp13.OFPP_CONTROLLER, puntMaxLen))
+	instructions := c.appendMeterInstruction([]ofp13.OfpInstruction{instruction}, cookie)
+
+	return c.sendFlowModAdd(TablePolicy, 0, cookie, ofp13.NewOfpMatch(), instructions)
+}
+
+// HandlePacketIn passes the packets sent to controller to the handler
+func (c *OFSwitch) HandlePacketIn(msg *ofp13.OfpPacketIn, dp *gofc.Datapath) {
+	if dp != c.dp {
+		return
+	}
+
+	info := ParsePacket(msg.Data)
+	info.TableID = msg.TableId
+	info.Cookie = msg.Cookie
+	info.Data = msg.Data
+	if msg.Match != nil {
+		for _, field := range msg.Match.OxmFields {
+			if inport, ok := field.(*ofp13.OxmInPort); ok {
+				info.InPort = inport.Value
+			}
+		}
+	}
+
+	if c.packetInHandler == nil {
+		log.Printf("info: packet-in from switch(%v) port %v %v -> %v", c.Name, info.InPort, info.EthSrc, info.EthDst)
+		return
+	}
+	c.packetInHandler(info)
+}
@@ -0,0 +1,49 @@
+package ofswitch
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePacket(t *testing.T) {
+	srcMAC, _ := net.ParseMAC("02:00:00:00:00:01")
+	dstMAC, _ := net.ParseMAC("02:00:00:00:00:02")
+	eth := &layers.Ethernet{
+		SrcMAC:       srcMAC,
+		DstMAC:       dstMAC,
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP("192.168.20.2"),
+		DstIP:    net.ParseIP("192.168.20.3"),
+	}
+	udp := &layers.UDP{
+		SrcPort: 40000,
+		DstPort: 8000,
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, gopacket.Payload([]byte("hello")))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+
+	info := ParsePacket(buf.Bytes())
+	assert.Equal(t, srcMAC, info.EthSrc)
+	assert.Equal(t, dstMAC, info.EthDst)
+	assert.Equal(t, uint16(0x0800), info.EthType)
+	assert.True(t, info.IPSrc.Equal(net.ParseIP("192.168.20.2")))
+	assert.True(t, info.IPDst.Equal(net.ParseIP("192.168.20.3")))
+	assert.Equal(t, IPProtoUDP, info.IPProto)
+	assert.Equal(t, uint16(40000), info.SrcPort)
+	assert.Equal(t, uint16(8000), info.DstPort)
+}
@@ -0,0 +1,260 @@
+package ofswitch
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// Tables of the pipeline.
+// Frames of devices from addresses not bound to them are dropped by TableAntiSpoofing.
+// Packets admitted by TableAdmission are evaluated by capability flows in TablePolicy,
+// which writes the destination into metadata and TableForwarding outputs them.
+const (
+	TableAntiSpoofing uint8 = 0
+	TableAdmission    uint8 = 1
+	TablePolicy       uint8 = 2
+	TableForwarding   uint8 = 3
+)
+
+// metadataGroupFlag marks metadata holding group ID instead of port number
+const metadataGroupFlag uint64 = 1 << 32
+
+const metadataMask uint64 = 0xffffffffffffffff
+
+const admissionPriority = 50
+
+// linkAdmissionPriority is lower than the tunnel flows between devices and their apps
+// so that only the packets not tunneled are evaluated by policy table
+const linkAdmissionPriority = 5
+
+type forwardingTable struct {
+	mu    sync.Mutex
+	ports map[uint32]bool
+}
+
+func newForwardingTable() *forwardingTable {
+	return &forwardingTable{
+		ports: map[uint32]bool{},
+	}
+}
+
+func (c *OFSwitch) sendFlowMod(fm *ofp13.OfpFlowMod) error {
+	if !c.dp.Send(fm) {
+		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
+	}
+
+	return nil
+}
+
+func (c *OFSwitch) sendFlowModAdd(tableID uint8, priority uint16, cookie uint64, match *ofp13.OfpMatch, instructions []ofp13.OfpInstruction) error {
+	fm := ofp13.NewOfpFlowModAdd(
+		cookie,
+		0,
+		tableID,
+		priority,
+		0,
+		match,
+		instructions,
+	)
+
+	return c.sendFlowMod(fm)
+}
+
+// SetupPipeline installs table-miss flows of anti-spoofing, policy and forwarding tables.
+// Packets of unbound devices pass anti-spoofing table.
+// Packets missing policy table are denied and sampled to controller if punt rate is set.
+// mDNS and SSDP are sent to controller to be relayed if discovery rate is set.
+func (c *OFSwitch) SetupPipeline() error {
+	c.forwarding.mu.Lock()
+	c.forwarding.ports = map[uint32]bool{}
+	c.forwarding.mu.Unlock()
+
+	instructions := []ofp13.OfpInstruction{ofp13.NewOfpInstructionGotoTable(TableAdmission)}
+	err := c.sendFlowModAdd(TableAntiSpoofing, 0, 0, ofp13.NewOfpMatch(), instructions)
+	if err != nil {
+		return err
+	}
+
+	err = c.addPolicyMissFlow()
+	if err != nil {
+		return err
+	}
+
+	err = c.addDiscoveryFlows()
+	if err != nil {
+		return err
+	}
+
+	return c.sendFlowModAdd(TableForwarding, 0, 0, ofp13.NewOfpMatch(), []ofp13.OfpInstruction{})
+}
+
+func (c *OFSwitch) addForwardingFlow(outport uint32) error {
+	c.forwarding.mu.Lock()
+	defer c.forwarding.mu.Unlock()
+
+	if c.forwarding.ports[outport] {
+		return nil
+	}
+
+	match := ofp13.NewOfpMatch()
+	match.Append(ofp13.NewOxmMetadataW(uint64(outport), metadataMask))
+
+	instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
+	instruction.Append(ofp13.NewOfpActionOutput(outport, OFPCML_NO_BUFFER))
+
+	err := c.sendFlowModAdd(TableForwarding, 10, 0, match, []ofp13.OfpInstruction{instruction})
+	if err != nil {
+		return err
+	}
+	c.forwarding.ports[outport] = true
+
+	return nil
+}
+
+func (c *OFSwitch) addForwardingGroupFlow(groupID uint32) error {
+	match := ofp13.NewOfpMatch()
+	match.Append(ofp13.NewOxmMetadataW(metadataGroupFlag|uint64(groupID), metadataMask))
+
+	instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
+	instruction.Append(ofp13.NewOfpActionGroup(groupID))
+
+	return c.sendFlowModAdd(TableForwarding, 10, GroupCookie(groupID), match, []ofp13.OfpInstruction{instruction})
+}
+
+func (c *OFSwitch) getPolicyInstructions(metadata uint64, cookie uint64) []ofp13.OfpInstruction {
+	instructions := []ofp13.OfpInstruction{
+		ofp13.NewOfpInstructionWriteMetadata(metadata, metadataMask),
+		ofp13.NewOfpInstructionGotoTable(TableForwarding),
+	}
+
+	return c.appendMeterInstruction(instructions, cookie)
+}
+
+// SendPolicyFlowOutput sends flow to policy table forwarding matched packets to outport
+func (c *OFSwitch) SendPolicyFlowOutput(match *ofp13.OfpMatch, outport uint32, priority uint16, cookie uint64) error {
+	err := c.addForwardingFlow(outport)
+	if err != nil {
+		return err
+	}
+
+	return c.sendFlowModAdd(TablePolicy, priority, cookie, match, c.getPolicyInstructions(uint64(outport), cookie))
+}
+
+// SendPolicyFlowGroup sends flow to policy table forwarding matched packets to group.
+// The flow is tagged with cookie and the forwarding flow of the group with its GroupCookie.
+func (c *OFSwitch) SendPolicyFlowGroup(match *ofp13.OfpMatch, groupID uint32, priority uint16, cookie uint64) error {
+	err := c.addForwardingGroupFlow(groupID)
+	if err != nil {
+		return err
+	}
+
+	return c.sendFlowModAdd(TablePolicy, priority, cookie, match, c.getPolicyInstructions(metadataGroupFlag|uint64(groupID), cookie))
+}
+
+func (c *OFSwitch) getAdmissionMatch(inPortLink DeviceLink, deviceLink DeviceLink, ethType uint16) (*ofp13.OfpMatch, error) {
+	match := ofp13.NewOfpMatch()
+
+	inport := ofp13.NewOxmInPort(inPortLink.GetOfPort())
+	match.Append(inport)
+
+	ethsrc, err := ofp13.NewOxmEthSrc(deviceLink.GetHWAddress().String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ethsrc)
+
+	match.Append(ofp13.NewOxmEthType(ethType))
+
+	return match, nil
+}
+
+func (c *OFSwitch) getAdmissionMatches(inPortLink DeviceLink, deviceLink DeviceLink) ([]*ofp13.OfpMatch, error) {
+	matches := []*ofp13.OfpMatch{}
+
+	match, err := c.getAdmissionMatch(inPortLink, deviceLink, 0x0806)
+	if err != nil {
+		return nil, err
+	}
+	arpSpa, err := ofp13.NewOxmArpSpa(deviceLink.GetIPAddress().IP.String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(arpSpa)
+	matches = append(matches, match)
+
+	match, err = c.getAdmissionMatch(inPortLink, deviceLink, 0x0800)
+	if err != nil {
+		return nil, err
+	}
+	ipSrc, err := ofp13.NewOxmIpv4Src(deviceLink.GetIPAddress().IP.String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ipSrc)
+	matches = append(matches, match)
+
+	if deviceLink.GetIP6Address() == nil {
+		return matches, nil
+	}
+
+	match, err = c.getAdmissionMatch(inPortLink, deviceLink, 0x86dd)
+	if err != nil {
+		return nil, err
+	}
+	ip6Src, err := ofp13.NewOxmIpv6Src(deviceLink.GetIP6Address().IP.String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ip6Src)
+	matches = append(matches, match)
+
+	// NDP may be sent from link-local address
+	match, err = c.getAdmissionMatch(inPortLink, deviceLink, 0x86dd)
+	if err != nil {
+		return nil, err
+	}
+	ip6Src, err = ofp13.NewOxmIpv6SrcW("fe80::", 10)
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ip6Src)
+	matches = append(matches, match)
+
+	return matches, nil
+}
+
+func (c *OFSwitch) addAdmissionFlow(inPortLink DeviceLink, deviceLink DeviceLink, priority uint16) error {
+	matches, err := c.getAdmissionMatches(inPortLink, deviceLink)
+	if err != nil {
+		return err
+	}
+
+	cookie := AdmissionCookie(deviceLink.GetHWAddress())
+	for _, match := range matches {
+		instructions := []ofp13.OfpInstruction{ofp13.NewOfpInstructionGotoTable(TablePolicy)}
+		err = c.sendFlowModAdd(TableAdmission, priority, cookie, match, instructions)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// AddAdmissionFlow admits packets from inPortLink bound to deviceLink's hwaddr and addresses to policy table
+func (c *OFSwitch) AddAdmissionFlow(inPortLink DeviceLink, deviceLink DeviceLink) error {
+	return c.addAdmissionFlow(inPortLink, deviceLink, admissionPriority)
+}
+
+// AddLinkAdmissionFlow admits packets from link bound to its own hwaddr and addresses to policy table
+// unless the tunnel flows of link forward them
+func (c *OFSwitch) AddLinkAdmissionFlow(link DeviceLink) error {
+	return c.addAdmissionFlow(link, link, linkAdmissionPriority)
+}
+
+// DeleteAdmissionFlow deletes flows added by AddAdmissionFlow and AddLinkAdmissionFlow
+func (c *OFSwitch) DeleteAdmissionFlow(deviceLink DeviceLink) error {
+	return c.DeleteFlowsByCookie(AdmissionCookie(deviceLink.GetHWAddress()))
+}
@@ -0,0 +1,49 @@
+package ofswitch
+
+import (
+	"net"
+	"testing"
+
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestGetAdmissionMatches(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:51")
+	addr, err := netlink.ParseAddr("192.168.20.1/24")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	link := &netlinkext.LinkExt{
+		Addr:   addr,
+		Ofport: 4,
+	}
+	link.SetLink(&netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{HardwareAddr: hwAddr},
+	})
+
+	ofs := NewOFSwitch("test-admission")
+	// ARP and IPv4 of the link itself
+	matches, err := ofs.getAdmissionMatches(link, link)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 2, len(matches))
+	for _, match := range matches {
+		// in_port, eth_src, eth_type and the source address
+		assert.Equal(t, 4, len(match.OxmFields))
+	}
+
+	addr6, err := netlink.ParseAddr("fd00:20::1/64")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	link.Addr6 = addr6
+	// global and link-local IPv6 in addition
+	matches, err = ofs.getAdmissionMatches(link, link)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 4, len(matches))
+}
@@ -0,0 +1,185 @@
+package ofswitch
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/naoki9911/gofc"
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// Reasons of port status
+const (
+	PortReasonAdd    = "add"
+	PortReasonDelete = "delete"
+	PortReasonModify = "modify"
+)
+
+// PortStatus is a state of switch port reported by port-status message
+type PortStatus struct {
+	Switch    string              `json:"switch"`
+	PortNo    uint32              `json:"portNo"`
+	Name      string              `json:"name"`
+	HWAddress string              `json:"hwAddress"`
+	LinkDown  bool                `json:"linkDown"`
+	Reason    string              `json:"reason"`
+	Timestamp time.Time           `json:"timestamp"`
+	Link      *netlinkext.LinkExt `json:"-"`
+}
+
+type portTable struct {
+	mu     sync.Mutex
+	states map[uint32]*PortStatus
+}
+
+func newPortTable() *portTable {
+	return &portTable{
+		states: map[uint32]*PortStatus{},
+	}
+}
+
+func (t *portTable) update(status *PortStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if status.Reason == PortReasonDelete {
+		delete(t.states, status.PortNo)
+		return
+	}
+	t.states[status.PortNo] = status
+}
+
+func (t *portTable) getAll() []*PortStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	states := []*PortStatus{}
+	for _, status := range t.states {
+		copied := *status
+		states = append(states, &copied)
+	}
+	return states
+}
+
+func newPortStatus(switchName string, msg *ofp13.OfpPortStatus) *PortStatus {
+	status := &PortStatus{
+		Switch:    switchName,
+		PortNo:    msg.Desc.PortNo,
+		Name:      strings.TrimRight(string(msg.Desc.Name), "\x00"),
+		HWAddress: msg.Desc.HwAddr.String(),
+		LinkDown:  msg.Desc.State&ofp13.OFPPS_LINK_DOWN != 0 || msg.Desc.Config&ofp13.OFPPC_PORT_DOWN != 0,
+		Timestamp: time.Now(),
+	}
+
+	switch msg.Reason {
+	case ofp13.OFPPR_ADD:
+		status.Reason = PortReasonAdd
+	case ofp13.OFPPR_DELETE:
+		status.Reason = PortReasonDelete
+	default:
+		status.Reason = PortReasonModify
+	}
+
+	return status
+}
+
+// SetPortStatusHandler sets handler called for each port-status message after the switch is updated
+func (c *OFSwitch) SetPortStatusHandler(handler func(*PortStatus)) {
+	c.portStatusHandler = handler
+}
+
+// GetPortStatuses returns the latest states of the ports reported by the switch
+func (c *OFSwitch) GetPortStatuses() []*PortStatus {
+	return c.portStates.getAll()
+}
+
+func (c *OFSwitch) getLinkByOfPort(ofport uint32) *netlinkext.LinkExt {
+	links := c.ports.Where(func(link *netlinkext.LinkExt) bool {
+		return link.Ofport == ofport
+	})
+	if len(links) == 0 {
+		return nil
+	}
+	return links[0]
+}
+
+// DeleteFlowsByPort deletes flows matching packets from the port or outputting to the port
+func (c *OFSwitch) DeleteFlowsByPort(ofport uint32) error {
+	match := ofp13.NewOfpMatch()
+	match.Append(ofp13.NewOxmInPort(ofport))
+	fm := ofp13.NewOfpFlowModDelete(
+		0,
+		0,
+		ofp13.OFPTT_ALL,
+		0,
+		ofp13.OFPP_ANY,
+		ofp13.OFPG_ANY,
+		0,
+		match,
+	)
+	if !c.dp.Send(fm) {
+		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
+	}
+
+	fm = ofp13.NewOfpFlowModDelete(
+		0,
+		0,
+		ofp13.OFPTT_ALL,
+		0,
+		ofport,
+		ofp13.OFPG_ANY,
+		0,
+		ofp13.NewOfpMatch(),
+	)
+	if !c.dp.Send(fm) {
+		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
+	}
+
+	c.forwarding.mu.Lock()
+	delete(c.forwarding.ports, ofport)
+	c.forwarding.mu.Unlock()
+
+	return nil
+}
+
+// HandlePortStatus updates ports and removes flows referencing deleted port
+func (c *OFSwitch) HandlePortStatus(msg *ofp13.OfpPortStatus, dp *gofc.Datapath) {
+	if dp != c.dp || msg.Desc == nil {
+		return
+	}
+
+	status := newPortStatus(c.Name, msg)
+	status.Link = c.getLinkByOfPort(status.PortNo)
+	c.portStates.update(status)
+
+	switch status.Reason {
+	case PortReasonDelete:
+		log.Printf("info: port %v(%v) is deleted from switch(%v)", status.Name, status.PortNo, c.Name)
+		if status.Link != nil {
+			err := c.ports.Remove(status.Link)
+			if err != nil {
+				log.Printf("error: Failed to remove port %v %v", status.PortNo, err)
+			}
+		}
+		err := c.DeleteFlowsByPort(status.PortNo)
+		if err != nil {
+			log.Printf("error: Failed to delete flows of port %v %v", status.PortNo, err)
+		}
+		err = c.PurgeGroupPort(status.PortNo)
+		if err != nil {
+			log.Printf("error: Failed to delete buckets of port %v %v", status.PortNo, err)
+		}
+	case PortReasonModify:
+		if status.LinkDown {
+			log.Printf("warning: link of port %v(%v) on switch(%v) is down", status.Name, status.PortNo, c.Name)
+		}
+	}
+
+	if c.portStatusHandler != nil {
+		c.portStatusHandler(status)
+	}
+}
@@ -0,0 +1,47 @@
+package ofswitch
+
+import (
+	"net"
+	"testing"
+
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPortStatus(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:01")
+	name := make([]byte, 16)
+	copy(name, "veth-p")
+	msg := &ofp13.OfpPortStatus{
+		Reason: ofp13.OFPPR_ADD,
+		Desc: &ofp13.OfpPort{
+			PortNo: 3,
+			HwAddr: hwAddr,
+			Name:   name,
+		},
+	}
+
+	table := newPortTable()
+	status := newPortStatus("ovs-test", msg)
+	assert.Equal(t, "veth-p", status.Name)
+	assert.Equal(t, PortReasonAdd, status.Reason)
+	assert.False(t, status.LinkDown)
+	table.update(status)
+
+	msg.Reason = ofp13.OFPPR_MODIFY
+	msg.Desc.State = ofp13.OFPPS_LINK_DOWN
+	status = newPortStatus("ovs-test", msg)
+	assert.Equal(t, PortReasonModify, status.Reason)
+	assert.True(t, status.LinkDown)
+	table.update(status)
+
+	states := table.getAll()
+	assert.Equal(t, 1, len(states))
+	assert.True(t, states[0].LinkDown)
+
+	msg.Reason = ofp13.OFPPR_DELETE
+	status = newPortStatus("ovs-test", msg)
+	assert.Equal(t, PortReasonDelete, status.Reason)
+	table.update(status)
+	assert.Equal(t, 0, len(table.getAll()))
+}
@@ -0,0 +1,138 @@
+package ofswitch
+
+import (
+	"net"
+
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// Priorities in TableAntiSpoofing.
+// Frames from device's port and hwaddr are dropped unless their source address is bound to the device.
+const (
+	antiSpoofingAllowPriority = 20
+	antiSpoofingDropPriority  = 10
+)
+
+// ipv6LinkLocalPrefix is the source of NDP and DHCPv6 before device gets its address
+var ipv6LinkLocalPrefix = &net.IPNet{IP: net.ParseIP("fe80::"), Mask: net.CIDRMask(64, 128)}
+
+func (c *OFSwitch) getAntiSpoofingDropMatch(link DeviceLink) (*ofp13.OfpMatch, error) {
+	match := ofp13.NewOfpMatch()
+	match.Append(ofp13.NewOxmInPort(link.GetOfPort()))
+
+	ethsrc, err := ofp13.NewOxmEthSrc(link.GetHWAddress().String())
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ethsrc)
+
+	return match, nil
+}
+
+func (c *OFSwitch) getAntiSpoofingMatch(link DeviceLink, ethType uint16) (*ofp13.OfpMatch, error) {
+	match, err := c.getAntiSpoofingDropMatch(link)
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ofp13.NewOxmEthType(ethType))
+
+	return match, nil
+}
+
+// getAntiSpoofingAllowMatches returns matches of IPv4 and ARP from link's leased address or 0.0.0.0
+// used by DHCP and ARP probe, and IPv6 from link's address, :: used by DAD or link-local addresses
+func (c *OFSwitch) getAntiSpoofingAllowMatches(link DeviceLink) ([]*ofp13.OfpMatch, error) {
+	matches := []*ofp13.OfpMatch{}
+
+	allowed := []net.IP{net.IPv4zero}
+	if addr := link.GetIPAddress(); addr != nil {
+		allowed = append(allowed, addr.IP)
+	}
+	for _, ip := range allowed {
+		match, err := c.getAntiSpoofingMatch(link, 0x0800)
+		if err != nil {
+			return nil, err
+		}
+		ipSrc, err := ofp13.NewOxmIpv4Src(ip.String())
+		if err != nil {
+			return nil, err
+		}
+		match.Append(ipSrc)
+		matches = append(matches, match)
+
+		match, err = c.getAntiSpoofingMatch(link, 0x0806)
+		if err != nil {
+			return nil, err
+		}
+		arpSpa, err := ofp13.NewOxmArpSpa(ip.String())
+		if err != nil {
+			return nil, err
+		}
+		match.Append(arpSpa)
+		matches = append(matches, match)
+	}
+
+	allowed = []net.IP{net.IPv6unspecified}
+	if addr := link.GetIP6Address(); addr != nil {
+		allowed = append(allowed, addr.IP)
+	}
+	for _, ip := range allowed {
+		match, err := c.getAntiSpoofingMatch(link, 0x86dd)
+		if err != nil {
+			return nil, err
+		}
+		ipSrc, err := ofp13.NewOxmIpv6Src(ip.String())
+		if err != nil {
+			return nil, err
+		}
+		match.Append(ipSrc)
+		matches = append(matches, match)
+	}
+
+	match, err := c.getAntiSpoofingMatch(link, 0x86dd)
+	if err != nil {
+		return nil, err
+	}
+	ones, _ := ipv6LinkLocalPrefix.Mask.Size()
+	ipSrc, err := ofp13.NewOxmIpv6SrcW(ipv6LinkLocalPrefix.IP.String(), ones)
+	if err != nil {
+		return nil, err
+	}
+	match.Append(ipSrc)
+	matches = append(matches, match)
+
+	return matches, nil
+}
+
+// AddAntiSpoofingFlow passes frames from link's port and hwaddr to TableAdmission
+// only if their source address is bound to link and drops the others.
+// Dropped frames are counted by the flow with SpoofingCookie.
+func (c *OFSwitch) AddAntiSpoofingFlow(link DeviceLink) error {
+	matches, err := c.getAntiSpoofingAllowMatches(link)
+	if err != nil {
+		return err
+	}
+	allowCookie := SpoofingAllowCookie(link.GetHWAddress())
+	for _, match := range matches {
+		instructions := []ofp13.OfpInstruction{ofp13.NewOfpInstructionGotoTable(TableAdmission)}
+		err = c.sendFlowModAdd(TableAntiSpoofing, antiSpoofingAllowPriority, allowCookie, match, instructions)
+		if err != nil {
+			return err
+		}
+	}
+
+	match, err := c.getAntiSpoofingDropMatch(link)
+	if err != nil {
+		return err
+	}
+	return c.sendFlowModAdd(TableAntiSpoofing, antiSpoofingDropPriority, SpoofingCookie(link.GetHWAddress()), match, []ofp13.OfpInstruction{})
+}
+
+// DeleteAntiSpoofingFlow deletes flows added by AddAntiSpoofingFlow
+func (c *OFSwitch) DeleteAntiSpoofingFlow(link DeviceLink) error {
+	err := c.DeleteFlowsByCookie(SpoofingAllowCookie(link.GetHWAddress()))
+	if err != nil {
+		return err
+	}
+	return c.DeleteFlowsByCookie(SpoofingCookie(link.GetHWAddress()))
+}
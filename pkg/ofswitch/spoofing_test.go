@@ -0,0 +1,55 @@
+package ofswitch
+
+import (
+	"net"
+	"testing"
+
+	"github.com/naoki9911/CREBAS/pkg/netlinkext"
+	"github.com/stretchr/testify/assert"
+	"github.com/vishvananda/netlink"
+)
+
+func TestGetAntiSpoofingMatches(t *testing.T) {
+	hwAddr, _ := net.ParseMAC("02:00:00:00:00:32")
+	addr, err := netlink.ParseAddr("192.168.20.5/24")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	link := &netlinkext.LinkExt{
+		Addr:   addr,
+		Ofport: 3,
+	}
+	link.SetLink(&netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{HardwareAddr: hwAddr},
+	})
+
+	ofs := NewOFSwitch("test-spoofing")
+	// IPv4 and ARP from the leased address and 0.0.0.0, IPv6 from :: and link-local
+	matches, err := ofs.getAntiSpoofingAllowMatches(link)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 6, len(matches))
+	for _, match := range matches {
+		// in_port, eth_src, eth_type and the source address
+		assert.Equal(t, 4, len(match.OxmFields))
+	}
+
+	addr6, err := netlink.ParseAddr("fd00:20::5/64")
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	link.Addr6 = addr6
+	matches, err = ofs.getAntiSpoofingAllowMatches(link)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 7, len(matches))
+
+	// the other frames of the port and hwaddr are dropped by a flow
+	match, err := ofs.getAntiSpoofingDropMatch(link)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, 2, len(match.OxmFields))
+}
@@ -0,0 +1,354 @@
+package ofswitch
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/naoki9911/gofc"
+	"github.com/naoki9911/gofc/ofprotocol/ofp13"
+)
+
+// TrafficSample is a snapshot of counters
+type TrafficSample struct {
+	Timestamp   time.Time `json:"timestamp"`
+	PacketCount uint64    `json:"packetCount"`
+	ByteCount   uint64    `json:"byteCount"`
+}
+
+// TrafficRing is a time-series ring buffer for TrafficSample
+type TrafficRing struct {
+	mu      sync.Mutex
+	samples []TrafficSample
+	head    int
+	count   int
+}
+
+// NewTrafficRing creates ring buffer which holds size samples
+func NewTrafficRing(size int) *TrafficRing {
+	if size < 2 {
+		size = 2
+	}
+	return &TrafficRing{
+		samples: make([]TrafficSample, size),
+	}
+}
+
+// Push appends sample and overwrites the oldest one when full
+func (r *TrafficRing) Push(sample TrafficSample) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.samples[r.head] = sample
+	r.head = (r.head + 1) % len(r.samples)
+	if r.count < len(r.samples) {
+		r.count++
+	}
+}
+
+// GetAll returns samples ordered from the oldest
+func (r *TrafficRing) GetAll() []TrafficSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	samples := make([]TrafficSample, 0, r.count)
+	start := (r.head - r.count + len(r.samples)) % len(r.samples)
+	for i := 0; i < r.count; i++ {
+		samples = append(samples, r.samples[(start+i)%len(r.samples)])
+	}
+
+	return samples
+}
+
+// Latest returns the newest sample
+func (r *TrafficRing) Latest() (TrafficSample, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.count == 0 {
+		return TrafficSample{}, false
+	}
+	return r.samples[(r.head-1+len(r.samples))%len(r.samples)], true
+}
+
+// Rate returns packets/sec and bytes/sec between the two newest samples
+func (r *TrafficRing) Rate() (float64, float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.count < 2 {
+		return 0, 0
+	}
+	latest := r.samples[(r.head-1+len(r.samples))%len(r.samples)]
+	prev := r.samples[(r.head-2+len(r.samples))%len(r.samples)]
+
+	duration := latest.Timestamp.Sub(prev.Timestamp).Seconds()
+	if duration <= 0 {
+		return 0, 0
+	}
+
+	// counters go backwards when flows are re-installed
+	if latest.PacketCount < prev.PacketCount || latest.ByteCount < prev.ByteCount {
+		return 0, 0
+	}
+
+	packetRate := float64(latest.PacketCount-prev.PacketCount) / duration
+	byteRate := float64(latest.ByteCount-prev.ByteCount) / duration
+	return packetRate, byteRate
+}
+
+// FlowStat is a counter of the flow installed in the switch
+type FlowStat struct {
+	TableID     uint8            `json:"tableID"`
+	Priority    uint16           `json:"priority"`
+	Cookie      uint64           `json:"cookie"`
+	InPort      uint32           `json:"inPort"`
+	EthSrc      net.HardwareAddr `json:"ethSrc"`
+	EthDst      net.HardwareAddr `json:"ethDst"`
+	PacketCount uint64           `json:"packetCount"`
+	ByteCount   uint64           `json:"byteCount"`
+}
+
+// PortStat is a counter of the port of the switch
+type PortStat struct {
+	PortNo    uint32 `json:"portNo"`
+	RxPackets uint64 `json:"rxPackets"`
+	TxPackets uint64 `json:"txPackets"`
+	RxBytes   uint64 `json:"rxBytes"`
+	TxBytes   uint64 `json:"txBytes"`
+	RxDropped uint64 `json:"rxDropped"`
+	TxDropped uint64 `json:"txDropped"`
+}
+
+// NewFlowStat converts OfpFlowStats into FlowStat
+func NewFlowStat(stats *ofp13.OfpFlowStats) *FlowStat {
+	flowStat := &FlowStat{
+		TableID:     stats.TableId,
+		Priority:    stats.Priority,
+		Cookie:      stats.Cookie,
+		PacketCount: stats.PacketCount,
+		ByteCount:   stats.ByteCount,
+	}
+
+	if stats.Match == nil {
+		return flowStat
+	}
+
+	for _, field := range stats.Match.OxmFields {
+		switch oxm := field.(type) {
+		case *ofp13.OxmInPort:
+			flowStat.InPort = oxm.Value
+		case *ofp13.OxmEth:
+			if oxm.TlvHeader == ofp13.OXM_OF_ETH_SRC {
+				flowStat.EthSrc = oxm.Value
+			} else if oxm.TlvHeader == ofp13.OXM_OF_ETH_DST {
+				flowStat.EthDst = oxm.Value
+			}
+		}
+	}
+
+	return flowStat
+}
+
+// NewPortStat converts OfpPortStats into PortStat
+func NewPortStat(stats *ofp13.OfpPortStats) *PortStat {
+	return &PortStat{
+		PortNo:    stats.PortNo,
+		RxPackets: stats.RxPackets,
+		TxPackets: stats.TxPackets,
+		RxBytes:   stats.RxBytes,
+		TxBytes:   stats.TxBytes,
+		RxDropped: stats.RxDropped,
+		TxDropped: stats.TxDropped,
+	}
+}
+
+type switchStats struct {
+	mu               sync.Mutex
+	flowStats        []*FlowStat
+	pendingFlowStats []*FlowStat
+	portStats        map[uint32]*PortStat
+	pendingPortStats map[uint32]*PortStat
+	aggregate        *TrafficRing
+	updatedAt        time.Time
+	stopChan         chan bool
+}
+
+func newSwitchStats() *switchStats {
+	return &switchStats{
+		flowStats:        []*FlowStat{},
+		pendingFlowStats: []*FlowStat{},
+		portStats:        map[uint32]*PortStat{},
+		pendingPortStats: map[uint32]*PortStat{},
+		aggregate:        NewTrafficRing(60),
+	}
+}
+
+// RequestStats sends flow, port, meter and aggregate stats requests to the switch
+func (c *OFSwitch) RequestStats() error {
+	if !c.IsConnectedToController() {
+		return fmt.Errorf("switch(%v) is not connected", c.Name)
+	}
+
+	fm := ofp13.NewOfpFlowStatsRequest(0, ofp13.OFPTT_ALL, ofp13.OFPP_ANY, ofp13.OFPG_ANY, 0, 0, ofp13.NewOfpMatch())
+	if !c.dp.Send(fm) {
+		return fmt.Errorf("failed to send flow stats request to switch(%v)", c.Name)
+	}
+
+	pm := ofp13.NewOfpPortStatsRequest(ofp13.OFPP_ANY, 0)
+	if !c.dp.Send(pm) {
+		return fmt.Errorf("failed to send port stats request to switch(%v)", c.Name)
+	}
+
+	mm := ofp13.NewOfpMeterStatsRequest(ofp13.OFPM_ALL, 0)
+	if !c.dp.Send(mm) {
+		return fmt.Errorf("failed to send meter stats request to switch(%v)", c.Name)
+	}
+
+	am := ofp13.NewOfpAggregateStatsRequest(0, ofp13.OFPTT_ALL, ofp13.OFPP_ANY, ofp13.OFPG_ANY, 0, 0, ofp13.NewOfpMatch())
+	if !c.dp.Send(am) {
+		return fmt.Errorf("failed to send aggregate stats request to switch(%v)", c.Name)
+	}
+
+	return nil
+}
+
+// StartStatsCollection requests stats to the switch periodically
+func (c *OFSwitch) StartStatsCollection(interval time.Duration) {
+	c.StopStatsCollection()
+
+	stopChan := make(chan bool, 1)
+	c.stats.mu.Lock()
+	c.stats.stopChan = stopChan
+	c.stats.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+				if !c.IsConnectedToController() {
+					continue
+				}
+				err := c.RequestStats()
+				if err != nil {
+					log.Printf("error: Failed to request stats %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// StopStatsCollection stops periodic stats collection
+func (c *OFSwitch) StopStatsCollection() {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	if c.stats.stopChan != nil {
+		c.stats.stopChan <- true
+		c.stats.stopChan = nil
+	}
+}
+
+// GetFlowStats returns flow counters collected last time
+func (c *OFSwitch) GetFlowStats() []*FlowStat {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	flowStats := make([]*FlowStat, len(c.stats.flowStats))
+	copy(flowStats, c.stats.flowStats)
+	return flowStats
+}
+
+// GetPortStats returns port counters collected last time
+func (c *OFSwitch) GetPortStats() map[uint32]*PortStat {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	portStats := map[uint32]*PortStat{}
+	for portNo, portStat := range c.stats.portStats {
+		portStats[portNo] = portStat
+	}
+	return portStats
+}
+
+// GetAggregateStats returns time-series of the switch-wide counters
+func (c *OFSwitch) GetAggregateStats() *TrafficRing {
+	return c.stats.aggregate
+}
+
+// GetStatsUpdatedAt returns the time when flow stats were updated
+func (c *OFSwitch) GetStatsUpdatedAt() time.Time {
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+	return c.stats.updatedAt
+}
+
+// HandleFlowStatsReply stores flow counters
+func (c *OFSwitch) HandleFlowStatsReply(msg *ofp13.OfpMultipartReply, dp *gofc.Datapath) {
+	if dp != c.dp {
+		return
+	}
+
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	for _, mp := range msg.Body {
+		if obj, ok := mp.(*ofp13.OfpFlowStats); ok {
+			c.stats.pendingFlowStats = append(c.stats.pendingFlowStats, NewFlowStat(obj))
+		}
+	}
+
+	if msg.Flags&ofp13.OFPMPF_REPLY_MORE != 0 {
+		return
+	}
+
+	c.stats.flowStats = c.stats.pendingFlowStats
+	c.stats.pendingFlowStats = []*FlowStat{}
+	c.stats.updatedAt = time.Now()
+}
+
+// HandlePortStatsReply stores port counters
+func (c *OFSwitch) HandlePortStatsReply(msg *ofp13.OfpMultipartReply, dp *gofc.Datapath) {
+	if dp != c.dp {
+		return
+	}
+
+	c.stats.mu.Lock()
+	defer c.stats.mu.Unlock()
+
+	for _, mp := range msg.Body {
+		if obj, ok := mp.(*ofp13.OfpPortStats); ok {
+			c.stats.pendingPortStats[obj.PortNo] = NewPortStat(obj)
+		}
+	}
+
+	if msg.Flags&ofp13.OFPMPF_REPLY_MORE != 0 {
+		return
+	}
+
+	c.stats.portStats = c.stats.pendingPortStats
+	c.stats.pendingPortStats = map[uint32]*PortStat{}
+}
+
+// HandleAggregateStatsReply stores switch-wide counters
+func (c *OFSwitch) HandleAggregateStatsReply(msg *ofp13.OfpMultipartReply, dp *gofc.Datapath) {
+	if dp != c.dp {
+		return
+	}
+
+	for _, mp := range msg.Body {
+		if obj, ok := mp.(*ofp13.OfpAggregateStats); ok {
+			c.stats.aggregate.Push(TrafficSample{
+				Timestamp:   time.Now(),
+				PacketCount: obj.PacketCount,
+				ByteCount:   obj.ByteCount,
+			})
+		}
+	}
+}
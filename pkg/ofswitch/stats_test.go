@@ -0,0 +1,62 @@
+package ofswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrafficRing(t *testing.T) {
+	ring := NewTrafficRing(3)
+
+	_, ok := ring.Latest()
+	assert.False(t, ok)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		ring.Push(TrafficSample{
+			Timestamp:   now.Add(time.Duration(i) * time.Second),
+			PacketCount: uint64(i * 10),
+			ByteCount:   uint64(i * 1000),
+		})
+	}
+
+	samples := ring.GetAll()
+	assert.Equal(t, 3, len(samples))
+	assert.Equal(t, uint64(20), samples[0].PacketCount)
+	assert.Equal(t, uint64(30), samples[1].PacketCount)
+	assert.Equal(t, uint64(40), samples[2].PacketCount)
+
+	latest, ok := ring.Latest()
+	assert.True(t, ok)
+	assert.Equal(t, uint64(4000), latest.ByteCount)
+
+	packetRate, byteRate := ring.Rate()
+	assert.Equal(t, float64(10), packetRate)
+	assert.Equal(t, float64(1000), byteRate)
+}
+
+func TestTrafficRingCounterReset(t *testing.T) {
+	ring := NewTrafficRing(3)
+
+	now := time.Now()
+	ring.Push(TrafficSample{Timestamp: now, PacketCount: 100, ByteCount: 10000})
+	ring.Push(TrafficSample{Timestamp: now.Add(time.Second), PacketCount: 5, ByteCount: 500})
+
+	packetRate, byteRate := ring.Rate()
+	assert.Equal(t, float64(0), packetRate)
+	assert.Equal(t, float64(0), byteRate)
+}
+
+func TestNewCookie(t *testing.T) {
+	id := []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}
+	cookie := NewCookie(CookieTypeCapability, id)
+
+	assert.Equal(t, uint64(0x0101020304050607), cookie)
+	assert.Equal(t, CookieTypeCapability, GetCookieType(cookie))
+
+	cookie = NewCookie(CookieTypeDevice, []byte{0xaa, 0xbb})
+	assert.Equal(t, uint64(0x020000000000aabb), cookie)
+	assert.Equal(t, CookieTypeDevice, GetCookieType(cookie))
+}
@@ -75,7 +75,7 @@ func (c *OFSwitch) addHostAggregatedARPFlowHost(link DeviceLink) error {
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -98,7 +98,7 @@ func (c *OFSwitch) deleteHostAggregatedARPFlowHost(link DeviceLink) error {
 	fm := ofp13.NewOfpFlowModDelete(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		0,
@@ -147,7 +147,7 @@ func (c *OFSwitch) addHostAggregatedARPFlowClientBroadcast(link DeviceLink) erro
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -170,7 +170,7 @@ func (c *OFSwitch) deleteHostAggregatedARPFlowClientBroadcast(link DeviceLink) e
 	fm := ofp13.NewOfpFlowModDelete(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		0,
@@ -219,7 +219,7 @@ func (c *OFSwitch) addHostAggregatedARPFlowClientUnicast(link DeviceLink) error
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		match,
@@ -242,7 +242,7 @@ func (c *OFSwitch) deleteHostAggregatedARPFlowClientUnicast(link DeviceLink) err
 	fm := ofp13.NewOfpFlowModDelete(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		0,
@@ -338,7 +338,7 @@ func (c *OFSwitch) addHostAggregatedDHCPFlowHost(link DeviceLink) error {
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		200,
 		0,
 		match,
@@ -361,7 +361,7 @@ func (c *OFSwitch) deleteHostAggregatedDHCPFlowHost(link DeviceLink) error {
 	fm := ofp13.NewOfpFlowModDelete(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		0,
@@ -423,7 +423,7 @@ func (c *OFSwitch) addHostAggregatedDHCPFlowClientBroadcast(link DeviceLink) err
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		200,
 		0,
 		match,
@@ -446,7 +446,7 @@ func (c *OFSwitch) deleteHostAggregatedDHCPFlowClientBroadcast(link DeviceLink)
 	fm := ofp13.NewOfpFlowModDelete(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		0,
@@ -508,7 +508,7 @@ func (c *OFSwitch) addHostAggregatedDHCPFlowClientUnicast(link DeviceLink) error
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		200,
 		0,
 		match,
@@ -531,7 +531,7 @@ func (c *OFSwitch) deleteHostAggregatedDHCPFlowClientUnicast(link DeviceLink) er
 	fm := ofp13.NewOfpFlowModDelete(
 		0,
 		0,
-		0,
+		TableAdmission,
 		0,
 		0,
 		0,
@@ -547,15 +547,21 @@ func (c *OFSwitch) deleteHostAggregatedDHCPFlowClientUnicast(link DeviceLink) er
 }
 
 func (c *OFSwitch) SendFlowModAddOutput(match *ofp13.OfpMatch, outport uint32, priority uint16) error {
+	return c.SendFlowModAddOutputWithCookie(match, outport, priority, 0)
+}
+
+// SendFlowModAddOutputWithCookie sends flow tagged with cookie to count its traffic
+func (c *OFSwitch) SendFlowModAddOutputWithCookie(match *ofp13.OfpMatch, outport uint32, priority uint16, cookie uint64) error {
 	instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
 	instruction.Append(ofp13.NewOfpActionOutput(outport, OFPCML_NO_BUFFER))
 	instructions := make([]ofp13.OfpInstruction, 0)
 	instructions = append(instructions, instruction)
+	instructions = c.appendMeterInstruction(instructions, cookie)
 
 	fm := ofp13.NewOfpFlowModAdd(
+		cookie,
 		0,
-		0,
-		0,
+		TableAdmission,
 		priority,
 		0,
 		match,
@@ -678,6 +684,8 @@ func (c *OFSwitch) AddDeviceAppIPFlow(deviceLink DeviceLink, appLink DeviceLink)
 }
 
 func (c *OFSwitch) AddDeviceAppTunnelFlow(deviceLink DeviceLink, appLink DeviceLink) error {
+	cookie := DeviceCookie(deviceLink.GetHWAddress())
+
 	match := ofp13.NewOfpMatch()
 
 	inport := ofp13.NewOxmInPort(deviceLink.GetOfPort())
@@ -689,23 +697,9 @@ func (c *OFSwitch) AddDeviceAppTunnelFlow(deviceLink DeviceLink, appLink DeviceL
 	}
 	match.Append(ethsrc)
 
-	instruction := ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
-	instruction.Append(ofp13.NewOfpActionOutput(appLink.GetOfPort(), OFPCML_NO_BUFFER))
-	instructions := make([]ofp13.OfpInstruction, 0)
-	instructions = append(instructions, instruction)
-
-	fm := ofp13.NewOfpFlowModAdd(
-		0,
-		0,
-		0,
-		10,
-		0,
-		match,
-		instructions,
-	)
-
-	if !c.dp.Send(fm) {
-		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
+	err = c.SendFlowModAddOutputWithCookie(match, appLink.GetOfPort(), 10, cookie)
+	if err != nil {
+		return err
 	}
 
 	match = ofp13.NewOfpMatch()
@@ -719,23 +713,9 @@ func (c *OFSwitch) AddDeviceAppTunnelFlow(deviceLink DeviceLink, appLink DeviceL
 	}
 	match.Append(ethdst)
 
-	instruction = ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
-	instruction.Append(ofp13.NewOfpActionOutput(deviceLink.GetOfPort(), OFPCML_NO_BUFFER))
-	instructions = make([]ofp13.OfpInstruction, 0)
-	instructions = append(instructions, instruction)
-
-	fm = ofp13.NewOfpFlowModAdd(
-		0,
-		0,
-		0,
-		10,
-		0,
-		match,
-		instructions,
-	)
-
-	if !c.dp.Send(fm) {
-		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
+	err = c.SendFlowModAddOutputWithCookie(match, deviceLink.GetOfPort(), 10, cookie)
+	if err != nil {
+		return err
 	}
 
 	match = ofp13.NewOfpMatch()
@@ -749,25 +729,17 @@ func (c *OFSwitch) AddDeviceAppTunnelFlow(deviceLink DeviceLink, appLink DeviceL
 	}
 	match.Append(ethdst)
 
-	instruction = ofp13.NewOfpInstructionActions(ofp13.OFPIT_APPLY_ACTIONS)
-	instruction.Append(ofp13.NewOfpActionOutput(deviceLink.GetOfPort(), OFPCML_NO_BUFFER))
-	instructions = make([]ofp13.OfpInstruction, 0)
-	instructions = append(instructions, instruction)
-
-	fm = ofp13.NewOfpFlowModAdd(
-		0,
-		0,
-		0,
-		10,
-		0,
-		match,
-		instructions,
-	)
+	err = c.SendFlowModAddOutputWithCookie(match, deviceLink.GetOfPort(), 10, cookie)
+	if err != nil {
+		return err
+	}
 
-	if !c.dp.Send(fm) {
-		return fmt.Errorf("failed to send flow to switch(%v)", c.Name)
+	match, err = c.getMulticastTunnelMatch(appLink.GetOfPort())
+	if err != nil {
+		return err
 	}
-	return nil
+
+	return c.SendFlowModAddOutputWithCookie(match, deviceLink.GetOfPort(), 10, cookie)
 }
 
 func (c *OFSwitch) AddAppsTunnel(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink) error {
@@ -790,7 +762,7 @@ func (c *OFSwitch) AddAppsTunnel(deviceLinkA DeviceLink, appLinkA DeviceLink, de
 	fm := ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		10,
 		0,
 		match,
@@ -820,7 +792,7 @@ func (c *OFSwitch) AddAppsTunnel(deviceLinkA DeviceLink, appLinkA DeviceLink, de
 	fm = ofp13.NewOfpFlowModAdd(
 		0,
 		0,
-		0,
+		TableAdmission,
 		10,
 		0,
 		match,
@@ -863,7 +835,7 @@ func (c *OFSwitch) AddAppsARPFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, d
 	if err != nil {
 		return err
 	}
-	err = c.SendFlowModAddOutput(matchA, appLinkB.GetOfPort(), 90)
+	err = c.SendPolicyFlowOutput(matchA, appLinkB.GetOfPort(), 90, 0)
 	if err != nil {
 		return err
 	}
@@ -871,7 +843,7 @@ func (c *OFSwitch) AddAppsARPFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, d
 	if err != nil {
 		return err
 	}
-	err = c.SendFlowModAddOutput(matchB, appLinkA.GetOfPort(), 90)
+	err = c.SendPolicyFlowOutput(matchB, appLinkA.GetOfPort(), 90, 0)
 	if err != nil {
 		return err
 	}
@@ -910,7 +882,7 @@ func (c *OFSwitch) AddAppsICMPFlow(deviceLinkA DeviceLink, appLinkA DeviceLink,
 	if err != nil {
 		return err
 	}
-	err = c.SendFlowModAddOutput(matchA, appLinkB.GetOfPort(), 90)
+	err = c.SendPolicyFlowOutput(matchA, appLinkB.GetOfPort(), 90, 0)
 	if err != nil {
 		return err
 	}
@@ -918,21 +890,28 @@ func (c *OFSwitch) AddAppsICMPFlow(deviceLinkA DeviceLink, appLinkA DeviceLink,
 	if err != nil {
 		return err
 	}
-	err = c.SendFlowModAddOutput(matchB, appLinkA.GetOfPort(), 90)
+	err = c.SendPolicyFlowOutput(matchB, appLinkA.GetOfPort(), 90, 0)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	if deviceLinkA.GetIP6Address() == nil || deviceLinkB.GetIP6Address() == nil {
+		return nil
+	}
 
-func (c *OFSwitch) AddAppsUnicastUDPDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, dstPort uint16) error {
-	err := c.addAppsUnicastTransportDstFlow(deviceLinkA, appLinkA, deviceLinkB, appLinkB, 17, dstPort)
+	matchA, err = c.getAppsICMPv6Match(deviceLinkA, appLinkA, deviceLinkB, appLinkB)
 	if err != nil {
 		return err
 	}
-
-	err = c.addAppsUnicastTransportSrcFlow(deviceLinkB, appLinkB, deviceLinkA, appLinkA, 17, dstPort)
+	err = c.SendPolicyFlowOutput(matchA, appLinkB.GetOfPort(), 90, 0)
+	if err != nil {
+		return err
+	}
+	matchB, err = c.getAppsICMPv6Match(deviceLinkB, appLinkB, deviceLinkA, appLinkA)
+	if err != nil {
+		return err
+	}
+	err = c.SendPolicyFlowOutput(matchB, appLinkA.GetOfPort(), 90, 0)
 	if err != nil {
 		return err
 	}
@@ -940,68 +919,187 @@ func (c *OFSwitch) AddAppsUnicastUDPDstFlow(deviceLinkA DeviceLink, appLinkA Dev
 	return nil
 }
 
-func (c *OFSwitch) addAppsUnicastTransportDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, protoType uint16, dstPort uint16) error {
+func (c *OFSwitch) getAppsICMPv6Match(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink) (*ofp13.OfpMatch, error) {
 	match := ofp13.NewOfpMatch()
 
 	inport := ofp13.NewOxmInPort(appLinkA.GetOfPort())
 	match.Append(inport)
 
-	ethsrc, err := ofp13.NewOxmEthSrc(deviceLinkA.GetHWAddress().String())
+	err := appendIPMatch(match, deviceLinkA.GetIP6Address().IP, deviceLinkB.GetIP6Address().IP)
+	if err != nil {
+		return nil, err
+	}
+
+	ipProto := ofp13.NewOxmIpProto(ipProtoICMPv6)
+	match.Append(ipProto)
+
+	return match, nil
+}
+
+// IP protocol numbers of transport flows
+const (
+	IPProtoTCP  uint8 = 6
+	IPProtoUDP  uint8 = 17
+	IPProtoSCTP uint8 = 132
+)
+
+// AnyPort matches all ports of the transport protocol
+const AnyPort uint16 = 0
+
+func appendTransportPortMatch(match *ofp13.OfpMatch, protoType uint8, port uint16, isDst bool) error {
+	switch protoType {
+	case IPProtoTCP, IPProtoUDP, IPProtoSCTP:
+		match.Append(ofp13.NewOxmIpProto(protoType))
+	default:
+		return fmt.Errorf("invalid protocol type:%d", protoType)
+	}
+
+	if port == AnyPort {
+		return nil
+	}
+
+	switch protoType {
+	case IPProtoTCP:
+		if isDst {
+			match.Append(ofp13.NewOxmTcpDst(port))
+		} else {
+			match.Append(ofp13.NewOxmTcpSrc(port))
+		}
+	case IPProtoUDP:
+		if isDst {
+			match.Append(ofp13.NewOxmUdpDst(port))
+		} else {
+			match.Append(ofp13.NewOxmUdpSrc(port))
+		}
+	case IPProtoSCTP:
+		if isDst {
+			match.Append(ofp13.NewOxmSctpDst(port))
+		} else {
+			match.Append(ofp13.NewOxmSctpSrc(port))
+		}
+	}
+
+	return nil
+}
+
+// AddAppsUnicastTransportFlow allows A to connect B's dstPort and its return traffic
+func (c *OFSwitch) AddAppsUnicastTransportFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, protoType uint8, dstPort uint16, cookie uint64) error {
+	err := c.addAppsUnicastTransportDstFlow(deviceLinkA, appLinkA, deviceLinkB, appLinkB, protoType, dstPort, cookie)
 	if err != nil {
 		return err
 	}
-	match.Append(ethsrc)
 
-	ethdst, err := ofp13.NewOxmEthDst(deviceLinkB.GetHWAddress().String())
+	err = c.addAppsUnicastTransportSrcFlow(deviceLinkB, appLinkB, deviceLinkA, appLinkA, protoType, dstPort, cookie)
 	if err != nil {
 		return err
 	}
-	match.Append(ethdst)
 
-	ethType := ofp13.NewOxmEthType(0x800)
+	return nil
+}
+
+// AddAppsBroadcastTransportFlow allows A to broadcast to dstPort and B to reply it.
+// Broadcasts from A are copied by the group whose buckets are all receivers authorized for A.
+func (c *OFSwitch) AddAppsBroadcastTransportFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, protoType uint8, dstPort uint16, cookie uint64) error {
+	group, created, err := c.AddGroupPort(getAppsBroadcastGroupKey(deviceLinkA, appLinkA, protoType, dstPort), appLinkB.GetOfPort(), cookie)
 	if err != nil {
 		return err
 	}
-	match.Append(ethType)
 
-	ipSrc, err := ofp13.NewOxmIpv4Src(deviceLinkA.GetIPAddress().IP.String())
+	if created {
+		err = c.addAppsBroadcastTransportDstFlow(deviceLinkA, appLinkA, protoType, dstPort, group.ID, group.Cookie)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.addAppsUnicastTransportSrcFlow(deviceLinkB, appLinkB, deviceLinkA, appLinkA, protoType, dstPort, cookie)
 	if err != nil {
 		return err
 	}
-	match.Append(ipSrc)
 
-	ipDst, err := ofp13.NewOxmIpv4Dst(deviceLinkB.GetIPAddress().IP.String())
+	return nil
+}
+
+// DeleteAppsBroadcastTransportFlow removes B from the receivers of A's broadcast
+// and deletes B's reply flows tagged with cookie.
+// A's broadcast flows tagged with cookie are handed over to the capability still authorizing the group.
+func (c *OFSwitch) DeleteAppsBroadcastTransportFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, protoType uint8, dstPort uint16, cookie uint64) error {
+	group, handedOver, err := c.RemoveGroupPort(getAppsBroadcastGroupKey(deviceLinkA, appLinkA, protoType, dstPort), appLinkB.GetOfPort(), cookie)
 	if err != nil {
 		return err
 	}
-	match.Append(ipDst)
 
-	if protoType == 6 {
-		ipProto := ofp13.NewOxmIpProto(6)
-		match.Append(ipProto)
+	if cookie == 0 {
+		return nil
+	}
 
-		tcpSrc := ofp13.NewOxmTcpDst(dstPort)
-		match.Append(tcpSrc)
+	err = c.DeleteFlowsByCookie(cookie)
+	if err != nil {
+		return err
+	}
 
-	} else if protoType == 17 {
-		ipProto := ofp13.NewOxmIpProto(17)
-		match.Append(ipProto)
+	if !handedOver {
+		return nil
+	}
 
-		udpSrc := ofp13.NewOxmUdpDst(dstPort)
-		match.Append(udpSrc)
-	} else {
-		return fmt.Errorf("invalid protocol type:%d", protoType)
+	return c.addAppsBroadcastTransportDstFlow(deviceLinkA, appLinkA, protoType, dstPort, group.ID, group.Cookie)
+}
+
+func (c *OFSwitch) AddAppsUnicastUDPDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, dstPort uint16, cookie uint64) error {
+	return c.AddAppsUnicastTransportFlow(deviceLinkA, appLinkA, deviceLinkB, appLinkB, IPProtoUDP, dstPort, cookie)
+}
+
+func (c *OFSwitch) AddAppsUnicastTCPDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, dstPort uint16, cookie uint64) error {
+	return c.AddAppsUnicastTransportFlow(deviceLinkA, appLinkA, deviceLinkB, appLinkB, IPProtoTCP, dstPort, cookie)
+}
+
+func (c *OFSwitch) AddAppsUnicastSCTPDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, dstPort uint16, cookie uint64) error {
+	return c.AddAppsUnicastTransportFlow(deviceLinkA, appLinkA, deviceLinkB, appLinkB, IPProtoSCTP, dstPort, cookie)
+}
+
+func appendIPMatch(match *ofp13.OfpMatch, ipSrc net.IP, ipDst net.IP) error {
+	if ipSrc.To4() != nil {
+		ethType := ofp13.NewOxmEthType(0x800)
+		match.Append(ethType)
+
+		ipsrc, err := ofp13.NewOxmIpv4Src(ipSrc.String())
+		if err != nil {
+			return err
+		}
+		match.Append(ipsrc)
+
+		if ipDst != nil {
+			ipdst, err := ofp13.NewOxmIpv4Dst(ipDst.String())
+			if err != nil {
+				return err
+			}
+			match.Append(ipdst)
+		}
+
+		return nil
 	}
 
-	err = c.SendFlowModAddOutput(match, appLinkB.GetOfPort(), 90)
+	ethType := ofp13.NewOxmEthType(0x86dd)
+	match.Append(ethType)
+
+	ipsrc, err := ofp13.NewOxmIpv6Src(ipSrc.String())
 	if err != nil {
 		return err
 	}
+	match.Append(ipsrc)
+
+	if ipDst != nil {
+		ipdst, err := ofp13.NewOxmIpv6Dst(ipDst.String())
+		if err != nil {
+			return err
+		}
+		match.Append(ipdst)
+	}
 
 	return nil
 }
 
-func (c *OFSwitch) addAppsUnicastTransportSrcFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, protoType uint16, srcPort uint16) error {
+func (c *OFSwitch) getAppsTransportMatch(deviceLinkA DeviceLink, appLinkA DeviceLink, ethDst string, ipSrc net.IP, ipDst net.IP) (*ofp13.OfpMatch, error) {
 	match := ofp13.NewOfpMatch()
 
 	inport := ofp13.NewOxmInPort(appLinkA.GetOfPort())
@@ -1009,124 +1107,116 @@ func (c *OFSwitch) addAppsUnicastTransportSrcFlow(deviceLinkA DeviceLink, appLin
 
 	ethsrc, err := ofp13.NewOxmEthSrc(deviceLinkA.GetHWAddress().String())
 	if err != nil {
-		return err
+		return nil, err
 	}
 	match.Append(ethsrc)
 
-	ethdst, err := ofp13.NewOxmEthDst(deviceLinkB.GetHWAddress().String())
+	ethdst, err := ofp13.NewOxmEthDst(ethDst)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	match.Append(ethdst)
 
-	ethType := ofp13.NewOxmEthType(0x800)
+	err = appendIPMatch(match, ipSrc, ipDst)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	match.Append(ethType)
 
-	ipSrc, err := ofp13.NewOxmIpv4Src(deviceLinkA.GetIPAddress().IP.String())
-	if err != nil {
-		return err
-	}
-	match.Append(ipSrc)
+	return match, nil
+}
 
-	ipDst, err := ofp13.NewOxmIpv4Dst(deviceLinkB.GetIPAddress().IP.String())
-	if err != nil {
-		return err
+// getAppsIPPairs returns IPv4 pair and IPv6 pair if both links have IPv6 address
+func getAppsIPPairs(deviceLinkA DeviceLink, deviceLinkB DeviceLink) [][2]net.IP {
+	pairs := [][2]net.IP{
+		{deviceLinkA.GetIPAddress().IP, deviceLinkB.GetIPAddress().IP},
+	}
+	if deviceLinkA.GetIP6Address() != nil && deviceLinkB.GetIP6Address() != nil {
+		pairs = append(pairs, [2]net.IP{deviceLinkA.GetIP6Address().IP, deviceLinkB.GetIP6Address().IP})
 	}
-	match.Append(ipDst)
-
-	if protoType == 6 {
-		ipProto := ofp13.NewOxmIpProto(6)
-		match.Append(ipProto)
 
-		tcpSrc := ofp13.NewOxmTcpSrc(srcPort)
-		match.Append(tcpSrc)
+	return pairs
+}
 
-	} else if protoType == 17 {
-		ipProto := ofp13.NewOxmIpProto(17)
-		match.Append(ipProto)
+func (c *OFSwitch) addAppsUnicastTransportDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, protoType uint8, dstPort uint16, cookie uint64) error {
+	for _, pair := range getAppsIPPairs(deviceLinkA, deviceLinkB) {
+		match, err := c.getAppsTransportMatch(deviceLinkA, appLinkA, deviceLinkB.GetHWAddress().String(), pair[0], pair[1])
+		if err != nil {
+			return err
+		}
 
-		udpSrc := ofp13.NewOxmUdpSrc(srcPort)
-		match.Append(udpSrc)
-	} else {
-		return fmt.Errorf("invalid protocol type:%d", protoType)
-	}
+		err = appendTransportPortMatch(match, protoType, dstPort, true)
+		if err != nil {
+			return err
+		}
 
-	err = c.SendFlowModAddOutput(match, appLinkB.GetOfPort(), 90)
-	if err != nil {
-		return err
+		err = c.SendPolicyFlowOutput(match, appLinkB.GetOfPort(), 90, cookie)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (c *OFSwitch) AddAppsBroadcastUDPDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, dstPort uint16) error {
-	err := c.addAppsBroadcastTransportDstFlow(deviceLinkA, appLinkA, deviceLinkB, appLinkB, 17, dstPort)
-	if err != nil {
-		return err
-	}
+func (c *OFSwitch) addAppsUnicastTransportSrcFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, protoType uint8, srcPort uint16, cookie uint64) error {
+	for _, pair := range getAppsIPPairs(deviceLinkA, deviceLinkB) {
+		match, err := c.getAppsTransportMatch(deviceLinkA, appLinkA, deviceLinkB.GetHWAddress().String(), pair[0], pair[1])
+		if err != nil {
+			return err
+		}
 
-	err = c.addAppsUnicastTransportSrcFlow(deviceLinkB, appLinkB, deviceLinkA, appLinkA, 17, dstPort)
-	if err != nil {
-		return err
+		err = appendTransportPortMatch(match, protoType, srcPort, false)
+		if err != nil {
+			return err
+		}
+
+		err = c.SendPolicyFlowOutput(match, appLinkB.GetOfPort(), 90, cookie)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
-func (c *OFSwitch) addAppsBroadcastTransportDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, protoType uint16, dstPort uint16) error {
-	match := ofp13.NewOfpMatch()
+func (c *OFSwitch) AddAppsBroadcastUDPDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, deviceLinkB DeviceLink, appLinkB DeviceLink, dstPort uint16, cookie uint64) error {
+	return c.AddAppsBroadcastTransportFlow(deviceLinkA, appLinkA, deviceLinkB, appLinkB, IPProtoUDP, dstPort, cookie)
+}
 
-	inport := ofp13.NewOxmInPort(appLinkA.GetOfPort())
-	match.Append(inport)
+func getAppsBroadcastGroupKey(deviceLinkA DeviceLink, appLinkA DeviceLink, protoType uint8, dstPort uint16) string {
+	return fmt.Sprintf("broadcast/%v/%v/%v/%v", appLinkA.GetOfPort(), deviceLinkA.GetHWAddress().String(), protoType, dstPort)
+}
 
-	ethsrc, err := ofp13.NewOxmEthSrc(deviceLinkA.GetHWAddress().String())
+func (c *OFSwitch) addAppsBroadcastTransportDstFlow(deviceLinkA DeviceLink, appLinkA DeviceLink, protoType uint8, dstPort uint16, groupID uint32, cookie uint64) error {
+	match, err := c.getAppsTransportMatch(deviceLinkA, appLinkA, "FF:FF:FF:FF:FF:FF", deviceLinkA.GetIPAddress().IP, nil)
 	if err != nil {
 		return err
 	}
-	match.Append(ethsrc)
 
-	ethdst, err := ofp13.NewOxmEthDst("FF:FF:FF:FF:FF:FF")
+	err = appendTransportPortMatch(match, protoType, dstPort, true)
 	if err != nil {
 		return err
 	}
-	match.Append(ethdst)
 
-	ethType := ofp13.NewOxmEthType(0x800)
+	err = c.SendPolicyFlowGroup(match, groupID, 90, cookie)
 	if err != nil {
 		return err
 	}
-	match.Append(ethType)
 
-	ipSrc, err := ofp13.NewOxmIpv4Src(deviceLinkA.GetIPAddress().IP.String())
-	if err != nil {
-		return err
+	if deviceLinkA.GetIP6Address() == nil {
+		return nil
 	}
-	match.Append(ipSrc)
-
-	if protoType == 6 {
-		ipProto := ofp13.NewOxmIpProto(6)
-		match.Append(ipProto)
 
-		tcpSrc := ofp13.NewOxmTcpDst(dstPort)
-		match.Append(tcpSrc)
-
-	} else if protoType == 17 {
-		ipProto := ofp13.NewOxmIpProto(17)
-		match.Append(ipProto)
-
-		udpSrc := ofp13.NewOxmUdpDst(dstPort)
-		match.Append(udpSrc)
-	} else {
-		return fmt.Errorf("invalid protocol type:%d", protoType)
+	// IPv6 has no broadcast, all-nodes multicast is used instead
+	match, err = c.getAppsTransportMatch(deviceLinkA, appLinkA, IPv6AllNodesHWAddr, deviceLinkA.GetIP6Address().IP, net.IPv6linklocalallnodes)
+	if err != nil {
+		return err
 	}
 
-	err = c.SendFlowModAddOutput(match, appLinkB.GetOfPort(), 90)
+	err = appendTransportPortMatch(match, protoType, dstPort, true)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return c.SendPolicyFlowGroup(match, groupID, 90, cookie)
 }
@@ -0,0 +1,192 @@
+package resolver
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultMaxTTL is the longest duration a response is cached
+const DefaultMaxTTL = time.Hour
+
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+	do     bool
+}
+
+type cacheEntry struct {
+	response *dns.Msg
+	stored   time.Time
+	expiry   time.Time
+}
+
+// Cache holds responses until the TTL of their records expires
+type Cache struct {
+	mu         sync.Mutex
+	entries    map[cacheKey]*cacheEntry
+	maxEntries int
+	// TTL of records longer than MaxTTL is shortened to it
+	MaxTTL time.Duration
+}
+
+// NewCache creates cache holding up to maxEntries responses
+func NewCache(maxEntries int) *Cache {
+	return &Cache{
+		entries:    map[cacheKey]*cacheEntry{},
+		maxEntries: maxEntries,
+		MaxTTL:     DefaultMaxTTL,
+	}
+}
+
+func newCacheKey(r *dns.Msg) (cacheKey, bool) {
+	if len(r.Question) != 1 {
+		return cacheKey{}, false
+	}
+	question := r.Question[0]
+	key := cacheKey{
+		name:   strings.ToLower(question.Name),
+		qtype:  question.Qtype,
+		qclass: question.Qclass,
+	}
+	if opt := r.IsEdns0(); opt != nil {
+		key.do = opt.Do()
+	}
+	return key, true
+}
+
+// getResponseTTL returns the duration response can be cached.
+// Negative responses are cached for the TTL of SOA in authority section as RFC 2308.
+func getResponseTTL(response *dns.Msg) (uint32, bool) {
+	if response.Truncated {
+		return 0, false
+	}
+	if response.Rcode != dns.RcodeSuccess && response.Rcode != dns.RcodeNameError {
+		return 0, false
+	}
+
+	if response.Rcode == dns.RcodeNameError || len(response.Answer) == 0 {
+		for _, rr := range response.Ns {
+			soa, ok := rr.(*dns.SOA)
+			if !ok {
+				continue
+			}
+			if soa.Minttl < soa.Hdr.Ttl {
+				return soa.Minttl, true
+			}
+			return soa.Hdr.Ttl, true
+		}
+		return 0, false
+	}
+
+	ttl := uint32(0)
+	found := false
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if !found || rr.Header().Ttl < ttl {
+				ttl = rr.Header().Ttl
+				found = true
+			}
+		}
+	}
+	return ttl, found
+}
+
+// Get returns copy of response cached for query whose TTLs are decreased by the elapsed time
+func (c *Cache) Get(r *dns.Msg, now time.Time) *dns.Msg {
+	key, ok := newCacheKey(r)
+	if !ok {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if !entry.expiry.After(now) {
+		delete(c.entries, key)
+		return nil
+	}
+
+	response := entry.response.Copy()
+	response.Id = r.Id
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+	for _, section := range [][]dns.RR{response.Answer, response.Ns, response.Extra} {
+		for _, rr := range section {
+			if rr.Header().Rrtype == dns.TypeOPT {
+				continue
+			}
+			if rr.Header().Ttl > elapsed {
+				rr.Header().Ttl -= elapsed
+			} else {
+				rr.Header().Ttl = 0
+			}
+		}
+	}
+	return response
+}
+
+// evict removes expired entries and the one expiring first if the cache is still full
+func (c *Cache) evict(now time.Time) {
+	var firstKey *cacheKey
+	var firstExpiry time.Time
+	for key, entry := range c.entries {
+		if !entry.expiry.After(now) {
+			delete(c.entries, key)
+			continue
+		}
+		if firstKey == nil || entry.expiry.Before(firstExpiry) {
+			copied := key
+			firstKey = &copied
+			firstExpiry = entry.expiry
+		}
+	}
+	if len(c.entries) >= c.maxEntries && firstKey != nil {
+		delete(c.entries, *firstKey)
+	}
+}
+
+// Set caches response for query unless it is an error or has no TTL
+func (c *Cache) Set(r *dns.Msg, response *dns.Msg, now time.Time) {
+	key, ok := newCacheKey(r)
+	if !ok || c.maxEntries <= 0 {
+		return
+	}
+	ttl, ok := getResponseTTL(response)
+	if !ok || ttl == 0 {
+		return
+	}
+	duration := time.Duration(ttl) * time.Second
+	if duration > c.MaxTTL {
+		duration = c.MaxTTL
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[key] = &cacheEntry{
+		response: response.Copy(),
+		stored:   now,
+		expiry:   now.Add(duration),
+	}
+}
+
+// Len returns the number of cached responses
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries)
+}
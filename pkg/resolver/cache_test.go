@@ -0,0 +1,112 @@
+package resolver
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestResponse(t *testing.T, r *dns.Msg, rcode int, records ...string) *dns.Msg {
+	response := &dns.Msg{}
+	response.SetRcode(r, rcode)
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		if rr.Header().Rrtype == dns.TypeSOA {
+			response.Ns = append(response.Ns, rr)
+		} else {
+			response.Answer = append(response.Answer, rr)
+		}
+	}
+	return response
+}
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	cache := NewCache(2)
+
+	query := newQuery("www.example.com.")
+	cache.Set(query, newTestResponse(t, query, dns.RcodeSuccess,
+		"www.example.com. 300 IN CNAME cdn.example.com.",
+		"cdn.example.com. 60 IN A 192.0.2.1"), now)
+
+	// names are case insensitive
+	other := newQuery("WWW.example.com.")
+	response := cache.Get(other, now.Add(10*time.Second))
+	if response == nil {
+		t.Fatalf("Failed response is not cached")
+	}
+	assert.Equal(t, other.Id, response.Id)
+	assert.Equal(t, uint32(290), response.Answer[0].Header().Ttl)
+	assert.Equal(t, uint32(50), response.Answer[1].Header().Ttl)
+
+	// the cached response is not changed by the caller
+	response.Answer = nil
+	assert.Equal(t, 2, len(cache.Get(query, now).Answer))
+
+	// expired by the shortest TTL
+	assert.Nil(t, cache.Get(query, now.Add(60*time.Second)))
+	assert.Equal(t, 0, cache.Len())
+
+	// negative response is cached for SOA minimum
+	query = newQuery("missing.example.com.")
+	cache.Set(query, newTestResponse(t, query, dns.RcodeNameError,
+		"example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 7200 900 1209600 30"), now)
+	assert.NotNil(t, cache.Get(query, now.Add(29*time.Second)))
+	assert.Nil(t, cache.Get(query, now.Add(30*time.Second)))
+
+	// errors and responses without TTL are not cached
+	query = newQuery("error.example.com.")
+	cache.Set(query, newTestResponse(t, query, dns.RcodeServerFailure), now)
+	assert.Nil(t, cache.Get(query, now))
+	cache.Set(query, newTestResponse(t, query, dns.RcodeSuccess, "error.example.com. 0 IN A 192.0.2.2"), now)
+	assert.Nil(t, cache.Get(query, now))
+
+	// TTL is limited to MaxTTL
+	cache.MaxTTL = time.Minute
+	query = newQuery("long.example.com.")
+	cache.Set(query, newTestResponse(t, query, dns.RcodeSuccess, "long.example.com. 86400 IN A 192.0.2.3"), now)
+	assert.Nil(t, cache.Get(query, now.Add(time.Minute)))
+
+	// the entry expiring first is evicted when full
+	first := newQuery("first.example.com.")
+	cache.Set(first, newTestResponse(t, first, dns.RcodeSuccess, "first.example.com. 10 IN A 192.0.2.4"), now)
+	second := newQuery("second.example.com.")
+	cache.Set(second, newTestResponse(t, second, dns.RcodeSuccess, "second.example.com. 20 IN A 192.0.2.5"), now)
+	third := newQuery("third.example.com.")
+	cache.Set(third, newTestResponse(t, third, dns.RcodeSuccess, "third.example.com. 30 IN A 192.0.2.6"), now)
+	assert.Equal(t, 2, cache.Len())
+	assert.Nil(t, cache.Get(first, now))
+	assert.NotNil(t, cache.Get(second, now))
+	assert.NotNil(t, cache.Get(third, now))
+}
+
+func TestResolverCache(t *testing.T) {
+	var queries int32
+	counting := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		atomic.AddInt32(&queries, 1)
+		testHandler("192.0.2.7")(w, r)
+	})
+	addr, shutdown := startTestServer(t, "udp", nil, counting)
+	defer shutdown()
+
+	resolver, err := NewResolverFromURLs([]string{addr}, nil, time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	resolver.Cache = NewCache(16)
+
+	for i := 0; i < 3; i++ {
+		response, err := resolver.Exchange(newQuery("example.com."))
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		assert.Equal(t, "192.0.2.7", getAnswer(t, response))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&queries))
+}
@@ -0,0 +1,112 @@
+package resolver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultRetryInterval is the duration a failed upstream is skipped
+const DefaultRetryInterval = 30 * time.Second
+
+// Resolver forwards queries to upstreams in order and fails over to the next one
+type Resolver struct {
+	mu        sync.Mutex
+	upstreams []Upstream
+	failedAt  map[Upstream]time.Time
+	// duration a failed upstream is tried after the others
+	RetryInterval time.Duration
+	// responses are not cached if nil
+	Cache *Cache
+}
+
+// NewResolver creates resolver forwarding queries to upstreams in order
+func NewResolver(upstreams ...Upstream) *Resolver {
+	return &Resolver{
+		upstreams:     upstreams,
+		failedAt:      map[Upstream]time.Time{},
+		RetryInterval: DefaultRetryInterval,
+	}
+}
+
+// NewResolverFromURLs creates resolver from upstream URLs accepted by NewUpstream
+func NewResolverFromURLs(urls []string, tlsConfig *tls.Config, timeout time.Duration) (*Resolver, error) {
+	upstreams := []Upstream{}
+	for _, upstreamURL := range urls {
+		upstream, err := NewUpstream(upstreamURL, tlsConfig, timeout)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream")
+	}
+
+	return NewResolver(upstreams...), nil
+}
+
+// ordered returns healthy upstreams followed by the failed ones
+func (r *Resolver) ordered(now time.Time) []Upstream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	healthy := []Upstream{}
+	failed := []Upstream{}
+	for _, upstream := range r.upstreams {
+		failedAt, ok := r.failedAt[upstream]
+		if ok && now.Sub(failedAt) < r.RetryInterval {
+			failed = append(failed, upstream)
+			continue
+		}
+		healthy = append(healthy, upstream)
+	}
+
+	return append(healthy, failed...)
+}
+
+func (r *Resolver) setFailed(upstream Upstream, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if failed {
+		r.failedAt[upstream] = time.Now()
+	} else {
+		delete(r.failedAt, upstream)
+	}
+}
+
+// Exchange answers query from cache or sends it to the first upstream answering it
+func (r *Resolver) Exchange(m *dns.Msg) (*dns.Msg, error) {
+	if r.Cache != nil {
+		if response := r.Cache.Get(m, time.Now()); response != nil {
+			return response, nil
+		}
+	}
+
+	var lastErr error
+	for _, upstream := range r.ordered(time.Now()) {
+		response, err := upstream.Exchange(m)
+		if err == nil && response.Rcode == dns.RcodeServerFailure {
+			err = fmt.Errorf("upstream %v returned SERVFAIL", upstream)
+		}
+		if err != nil {
+			log.Printf("warning: query to %v failed %v", upstream, err)
+			r.setFailed(upstream, true)
+			lastErr = err
+			continue
+		}
+
+		r.setFailed(upstream, false)
+		if r.Cache != nil {
+			r.Cache.Set(m, response, time.Now())
+		}
+		return response, nil
+	}
+
+	return nil, fmt.Errorf("all upstreams failed: %v", lastErr)
+}
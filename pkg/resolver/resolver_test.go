@@ -0,0 +1,237 @@
+package resolver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func testHandler(addr string) dns.HandlerFunc {
+	return func(w dns.ResponseWriter, r *dns.Msg) {
+		response := &dns.Msg{}
+		response.SetReply(r)
+		rr, _ := dns.NewRR(r.Question[0].Name + " 300 IN A " + addr)
+		response.Answer = append(response.Answer, rr)
+		w.WriteMsg(response)
+	}
+}
+
+// startTestServer starts stand-in resolver serving handler on loopback
+func startTestServer(t *testing.T, network string, tlsConfig *tls.Config, handler dns.Handler) (string, func()) {
+	server := &dns.Server{Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+
+	switch network {
+	case "udp":
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		server.PacketConn = conn
+	case "tcp", "tcp-tls":
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed %v", err)
+		}
+		if tlsConfig != nil {
+			listener = tls.NewListener(listener, tlsConfig)
+		}
+		server.Listener = listener
+	}
+	go server.ActivateAndServe()
+	<-started
+
+	addr := ""
+	if server.PacketConn != nil {
+		addr = server.PacketConn.LocalAddr().String()
+	} else {
+		addr = server.Listener.Addr().String()
+	}
+	return addr, func() { server.Shutdown() }
+}
+
+func newQuery(name string) *dns.Msg {
+	query := &dns.Msg{}
+	query.SetQuestion(name, dns.TypeA)
+	return query
+}
+
+func getAnswer(t *testing.T, response *dns.Msg) string {
+	if len(response.Answer) != 1 {
+		t.Fatalf("Failed unexpected answers %v", response.Answer)
+	}
+	return response.Answer[0].(*dns.A).A.String()
+}
+
+func TestPlainUpstream(t *testing.T) {
+	udpAddr, shutdownUDP := startTestServer(t, "udp", nil, testHandler("192.0.2.1"))
+	defer shutdownUDP()
+	tcpAddr, shutdownTCP := startTestServer(t, "tcp", nil, testHandler("192.0.2.2"))
+	defer shutdownTCP()
+
+	upstream, err := NewUpstream(udpAddr, nil, time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "udp://"+udpAddr, upstream.String())
+	response, err := upstream.Exchange(newQuery("example.com."))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "192.0.2.1", getAnswer(t, response))
+
+	upstream, err = NewUpstream("tcp://"+tcpAddr, nil, time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	response, err = upstream.Exchange(newQuery("example.com."))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "192.0.2.2", getAnswer(t, response))
+
+	_, err = NewUpstream("quic://"+tcpAddr, nil, time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestTruncatedUpstream(t *testing.T) {
+	truncating := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			response := &dns.Msg{}
+			response.SetReply(r)
+			response.Truncated = true
+			w.WriteMsg(response)
+			return
+		}
+		testHandler("192.0.2.3")(w, r)
+	})
+	udpAddr, shutdownUDP := startTestServer(t, "udp", nil, truncating)
+	defer shutdownUDP()
+
+	// TCP server on the same port as UDP one
+	listener, err := net.Listen("tcp", udpAddr)
+	if err != nil {
+		t.Skipf("port %v is not available for TCP %v", udpAddr, err)
+	}
+	server := &dns.Server{Listener: listener, Handler: truncating}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	upstream, err := NewUpstream(udpAddr, nil, time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	response, err := upstream.Exchange(newQuery("example.com."))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.False(t, response.Truncated)
+	assert.Equal(t, "192.0.2.3", getAnswer(t, response))
+}
+
+func TestEncryptedUpstream(t *testing.T) {
+	doh := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != dohMediaType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		query := &dns.Msg{}
+		err := query.Unpack(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		response := &dns.Msg{}
+		response.SetReply(query)
+		rr, _ := dns.NewRR(query.Question[0].Name + " 300 IN A 192.0.2.4")
+		response.Answer = append(response.Answer, rr)
+		packed, _ := response.Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		w.Write(packed)
+	}))
+	defer doh.Close()
+
+	// the stand-ins share the certificate issued for 127.0.0.1
+	roots := x509.NewCertPool()
+	roots.AddCert(doh.Certificate())
+	clientConfig := &tls.Config{RootCAs: roots}
+	serverConfig := &tls.Config{Certificates: doh.TLS.Certificates}
+
+	dotAddr, shutdownDoT := startTestServer(t, "tcp-tls", serverConfig, testHandler("192.0.2.5"))
+	defer shutdownDoT()
+
+	upstream, err := NewUpstream("tls://"+dotAddr, clientConfig, time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	response, err := upstream.Exchange(newQuery("example.com."))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "192.0.2.5", getAnswer(t, response))
+
+	// server is not trusted without the roots
+	upstream, err = NewUpstream("tls://"+dotAddr, nil, time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	_, err = upstream.Exchange(newQuery("example.com."))
+	assert.NotNil(t, err)
+
+	upstream, err = NewUpstream(doh.URL+"/dns-query", clientConfig, time.Second)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	query := newQuery("example.com.")
+	response, err = upstream.Exchange(query)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, query.Id, response.Id)
+	assert.Equal(t, "192.0.2.4", getAnswer(t, response))
+}
+
+func TestResolverFailover(t *testing.T) {
+	servfail := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		response := &dns.Msg{}
+		response.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(response)
+	})
+	failingAddr, shutdownFailing := startTestServer(t, "udp", nil, servfail)
+	defer shutdownFailing()
+	workingAddr, shutdownWorking := startTestServer(t, "udp", nil, testHandler("192.0.2.6"))
+	defer shutdownWorking()
+
+	_, err := NewResolverFromURLs([]string{}, nil, time.Second)
+	assert.NotNil(t, err)
+
+	resolver, err := NewResolverFromURLs([]string{"127.0.0.1:1", failingAddr, workingAddr}, nil, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	response, err := resolver.Exchange(newQuery("example.com."))
+	if err != nil {
+		t.Fatalf("Failed %v", err)
+	}
+	assert.Equal(t, "192.0.2.6", getAnswer(t, response))
+
+	// failed upstreams are tried after the working one
+	ordered := resolver.ordered(time.Now())
+	assert.Equal(t, "udp://"+workingAddr, ordered[0].String())
+	ordered = resolver.ordered(time.Now().Add(resolver.RetryInterval))
+	assert.Equal(t, "udp://127.0.0.1:1", ordered[0].String())
+
+	shutdownWorking()
+	_, err = resolver.Exchange(newQuery("example.com."))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,184 @@
+package resolver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultTimeout is the timeout of a query to an upstream
+const DefaultTimeout = 3 * time.Second
+
+// dohMediaType is the media type of DNS-over-HTTPS messages defined in RFC 8484
+const dohMediaType = "application/dns-message"
+
+// Upstream is a DNS server queries are forwarded to
+type Upstream interface {
+	Exchange(r *dns.Msg) (*dns.Msg, error)
+	String() string
+}
+
+// plainUpstream sends queries over UDP and retries truncated ones over TCP
+type plainUpstream struct {
+	addr    string
+	net     string
+	timeout time.Duration
+}
+
+func (u *plainUpstream) Exchange(r *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{
+		Net:     u.net,
+		Timeout: u.timeout,
+	}
+	response, _, err := client.Exchange(r, u.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Truncated && u.net == "udp" {
+		client.Net = "tcp"
+		response, _, err = client.Exchange(r, u.addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return response, nil
+}
+
+func (u *plainUpstream) String() string {
+	return u.net + "://" + u.addr
+}
+
+// tlsUpstream sends queries over DNS-over-TLS
+type tlsUpstream struct {
+	addr      string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+}
+
+func (u *tlsUpstream) Exchange(r *dns.Msg) (*dns.Msg, error) {
+	client := &dns.Client{
+		Net:       "tcp-tls",
+		TLSConfig: u.tlsConfig,
+		Timeout:   u.timeout,
+	}
+	response, _, err := client.Exchange(r, u.addr)
+	return response, err
+}
+
+func (u *tlsUpstream) String() string {
+	return "tls://" + u.addr
+}
+
+// httpsUpstream sends queries over DNS-over-HTTPS
+type httpsUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u *httpsUpstream) Exchange(r *dns.Msg) (*dns.Msg, error) {
+	packed, err := r.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u.url, bytes.NewReader(packed))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("upstream %v returned %v", u.url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &dns.Msg{}
+	err = response.Unpack(body)
+	if err != nil {
+		return nil, err
+	}
+	response.Id = r.Id
+
+	return response, nil
+}
+
+func (u *httpsUpstream) String() string {
+	return u.url
+}
+
+// NewUpstream creates upstream from URL.
+// "udp://host:port" and "host:port" are plain DNS, "tcp://host:port" is plain DNS over TCP,
+// "tls://host:port" is DNS-over-TLS and "https://host/path" is DNS-over-HTTPS.
+// tlsConfig verifies the server of encrypted upstreams and may be nil to use the system roots.
+func NewUpstream(upstreamURL string, tlsConfig *tls.Config, timeout time.Duration) (Upstream, error) {
+	if !strings.Contains(upstreamURL, "://") {
+		upstreamURL = "udp://" + upstreamURL
+	}
+	parsed, err := url.Parse(upstreamURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch parsed.Scheme {
+	case "udp", "tcp":
+		return &plainUpstream{
+			addr:    withDefaultPort(parsed.Host, "53"),
+			net:     parsed.Scheme,
+			timeout: timeout,
+		}, nil
+	case "tls":
+		config := &tls.Config{}
+		if tlsConfig != nil {
+			config = tlsConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = parsed.Hostname()
+		}
+		return &tlsUpstream{
+			addr:      withDefaultPort(parsed.Host, "853"),
+			tlsConfig: config,
+			timeout:   timeout,
+		}, nil
+	case "https":
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if tlsConfig != nil {
+			transport.TLSClientConfig = tlsConfig.Clone()
+		}
+		return &httpsUpstream{
+			url: upstreamURL,
+			client: &http.Client{
+				Transport: transport,
+				Timeout:   timeout,
+			},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported upstream %v", upstreamURL)
+}
+
+func withDefaultPort(host string, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}